@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,7 +13,10 @@ import (
 
 	"hubfly-builder/internal/allowlist"
 	"hubfly-builder/internal/api"
+	"hubfly-builder/internal/driver"
+	"hubfly-builder/internal/events"
 	"hubfly-builder/internal/executor"
+	"hubfly-builder/internal/gitauth"
 	"hubfly-builder/internal/logs"
 	"hubfly-builder/internal/offline"
 	"hubfly-builder/internal/server"
@@ -35,35 +39,104 @@ const (
 	defaultConcurrentBuilds = 3
 	defaultLogRetentionDays = 7
 	defaultUpdateLockfile   = "/run/hubfly-builder-update.lock"
+	defaultMaxGoVersion     = "1.25"
+	defaultPackCLIPath      = "pack"
+	defaultGitCLIPath       = "git"
+	defaultAffinityDeferSec = 60
+	defaultClaimLeaseSec    = 120
+	defaultDebugTTLSeconds  = 1800
 )
 
 var version = "dev"
 
 type EnvConfig struct {
-	HubcellBaseURL      string `json:"HUBCELL_BASE_URL"`
-	HubcellCLIPath      string `json:"HUBCELL_CLI_PATH"`
-	CallbackURL         string `json:"CALLBACK_URL"`
-	ServerAddr          string `json:"SERVER_ADDR"`
-	UploadAddr          string `json:"UPLOAD_ADDR"`
-	DataDir             string `json:"DATA_DIR"`
-	LogDir              string `json:"LOG_DIR"`
-	MaxConcurrentBuilds int    `json:"MAX_CONCURRENT_BUILDS"`
-	LogRetentionDays    int    `json:"LOG_RETENTION_DAYS"`
-	UpdateLockfile      string `json:"UPDATE_LOCKFILE"`
+	HubcellBaseURL           string            `json:"HUBCELL_BASE_URL"`
+	HubcellCLIPath           string            `json:"HUBCELL_CLI_PATH"`
+	CallbackURL              string            `json:"CALLBACK_URL"`
+	ServerAddr               string            `json:"SERVER_ADDR"`
+	UploadAddr               string            `json:"UPLOAD_ADDR"`
+	DataDir                  string            `json:"DATA_DIR"`
+	LogDir                   string            `json:"LOG_DIR"`
+	MaxConcurrentBuilds      int               `json:"MAX_CONCURRENT_BUILDS"`
+	LogRetentionDays         int               `json:"LOG_RETENTION_DAYS"`
+	UpdateLockfile           string            `json:"UPDATE_LOCKFILE"`
+	MaxGoVersion             string            `json:"MAX_GO_VERSION"`
+	DockerfileRequireCMD     bool              `json:"DOCKERFILE_REQUIRE_CMD,omitempty"`
+	PreBuildHooks            []string          `json:"PRE_BUILD_HOOKS,omitempty"`
+	PostBuildHooks           []string          `json:"POST_BUILD_HOOKS,omitempty"`
+	PostBuildHooksFatal      bool              `json:"POST_BUILD_HOOKS_FATAL,omitempty"`
+	BuildHookAllowlist       []string          `json:"BUILD_HOOK_ALLOWLIST,omitempty"`
+	CallbackAllowedHosts     []string          `json:"CALLBACK_ALLOWED_HOSTS,omitempty"`
+	CallbackProgress         bool              `json:"CALLBACK_PROGRESS,omitempty"`
+	BuildOptAllowlist        []string          `json:"BUILD_OPT_ALLOWLIST,omitempty"`
+	PackCLIPath              string            `json:"PACK_CLI_PATH"`
+	GitCLIPath               string            `json:"GIT_CLI_PATH"`
+	BuildpacksBuilder        string            `json:"BUILDPACKS_BUILDER"`
+	BuildpacksPublish        bool              `json:"BUILDPACKS_PUBLISH,omitempty"`
+	CloneTimeoutSeconds      int               `json:"CLONE_TIMEOUT_SECONDS,omitempty"`
+	PrebuildTimeoutSeconds   int               `json:"PREBUILD_TIMEOUT_SECONDS,omitempty"`
+	BuildPhaseTimeoutSeconds int               `json:"BUILD_PHASE_TIMEOUT_SECONDS,omitempty"`
+	MaxBuildWallClockSeconds int               `json:"BUILD_MAX_WALL_CLOCK_SECONDS,omitempty"`
+	InstanceID               string            `json:"INSTANCE_ID,omitempty"`
+	AffinityMaxDeferSeconds  int               `json:"BUILD_AFFINITY_MAX_DEFER_SECONDS,omitempty"`
+	APIKeys                  []server.APIKey   `json:"API_KEYS,omitempty"`
+	BuildProfiles            server.Profiles   `json:"BUILD_PROFILES,omitempty"`
+	BuildContextDedup        bool              `json:"BUILD_CONTEXT_DEDUP,omitempty"`
+	BuildContextPrune        bool              `json:"BUILD_CONTEXT_PRUNE,omitempty"`
+	BuildContextScope        bool              `json:"BUILD_CONTEXT_SCOPE_WORKSPACES,omitempty"`
+	MovingImageTag           string            `json:"MOVING_IMAGE_TAG,omitempty"`
+	ImageTagTemplate         string            `json:"IMAGE_TAG_TEMPLATE,omitempty"`
+	BuildCacheRegistry       string            `json:"BUILD_CACHE_REGISTRY,omitempty"`
+	BuildDNSServers          []string          `json:"BUILD_DNS_SERVERS,omitempty"`
+	MaxConcurrentImageBuilds int               `json:"MAX_CONCURRENT_IMAGE_BUILDS,omitempty"`
+	MaxEnvKeys               int               `json:"MAX_ENV_KEYS,omitempty"`
+	MaxEnvBytes              int               `json:"MAX_ENV_BYTES,omitempty"`
+	BuildLogVerbosity        string            `json:"BUILD_LOG_VERBOSITY,omitempty"`
+	BuildLogFormat           string            `json:"BUILD_LOG_FORMAT,omitempty"`
+	SecretScan               string            `json:"SECRET_SCAN,omitempty"`
+	SecretScanAllowlist      []string          `json:"SECRET_SCAN_ALLOWLIST,omitempty"`
+	RegistryRetrySeconds     int               `json:"REGISTRY_RATE_LIMIT_RETRY_SECONDS,omitempty"`
+	BuildMaxRetries          int               `json:"BUILD_MAX_RETRIES,omitempty"`
+	BuildRetryDelaySeconds   int               `json:"BUILD_RETRY_BASE_DELAY_SECONDS,omitempty"`
+	DebugWorkspaceTTLSeconds int               `json:"DEBUG_WORKSPACE_TTL_SECONDS,omitempty"`
+	ResumeTTLSeconds         int               `json:"RESUME_WORKSPACE_TTL_SECONDS,omitempty"`
+	ImageReconcileSeconds    int               `json:"IMAGE_RECONCILE_INTERVAL_SECONDS,omitempty"`
+	JobLogMaxSizeMB          int               `json:"JOB_LOG_MAX_SIZE_MB,omitempty"`
+	LogCompressAfterHours    int               `json:"LOG_COMPRESS_AFTER_HOURS,omitempty"`
+	OutboundUserAgent        string            `json:"OUTBOUND_USER_AGENT,omitempty"`
+	OutboundHeaders          map[string]string `json:"OUTBOUND_HEADERS,omitempty"`
+	OutboundGitHeaderHosts   []string          `json:"OUTBOUND_GIT_HEADER_HOSTS,omitempty"`
+	CredentialVaultDir       string            `json:"CREDENTIAL_VAULT_DIR,omitempty"`
+	DatabaseURL              string            `json:"DATABASE_URL,omitempty"`
+	ClaimLeaseSeconds        int               `json:"CLAIM_LEASE_SECONDS,omitempty"`
+	SQLiteJournalMode        string            `json:"SQLITE_JOURNAL_MODE,omitempty"`
+	SQLiteSynchronous        string            `json:"SQLITE_SYNCHRONOUS,omitempty"`
+	SQLiteBusyTimeoutSeconds int               `json:"SQLITE_BUSY_TIMEOUT_SECONDS,omitempty"`
+	SQLiteForeignKeys        *bool             `json:"SQLITE_FOREIGN_KEYS,omitempty"`
+
+	// RegistryCredentials are keyed by registry host, e.g. "ghcr.io".
+	RegistryCredentials map[string]executor.RegistryCredential `json:"REGISTRY_CREDENTIALS,omitempty"`
 }
 
 func defaultEnvConfig() EnvConfig {
 	return EnvConfig{
-		HubcellBaseURL:      defaultHubcellBaseURL,
-		HubcellCLIPath:      defaultHubcellCLIPath,
-		CallbackURL:         defaultCallbackURL,
-		ServerAddr:          defaultServerAddr,
-		UploadAddr:          defaultUploadAddr,
-		DataDir:             defaultDataDir,
-		LogDir:              defaultLogDir,
-		MaxConcurrentBuilds: defaultConcurrentBuilds,
-		LogRetentionDays:    defaultLogRetentionDays,
-		UpdateLockfile:      "./hubfly-builder-update.lock",
+		HubcellBaseURL:           defaultHubcellBaseURL,
+		HubcellCLIPath:           defaultHubcellCLIPath,
+		CallbackURL:              defaultCallbackURL,
+		ServerAddr:               defaultServerAddr,
+		UploadAddr:               defaultUploadAddr,
+		DataDir:                  defaultDataDir,
+		LogDir:                   defaultLogDir,
+		MaxConcurrentBuilds:      defaultConcurrentBuilds,
+		LogRetentionDays:         defaultLogRetentionDays,
+		UpdateLockfile:           "./hubfly-builder-update.lock",
+		MaxGoVersion:             defaultMaxGoVersion,
+		PackCLIPath:              defaultPackCLIPath,
+		GitCLIPath:               defaultGitCLIPath,
+		BuildpacksBuilder:        driver.DefaultBuildpacksBuilder,
+		DebugWorkspaceTTLSeconds: defaultDebugTTLSeconds,
+		MaxEnvKeys:               server.DefaultMaxEnvKeys,
+		MaxEnvBytes:              server.DefaultMaxEnvBytes,
 	}
 }
 
@@ -174,6 +247,168 @@ func mergeEnvConfig(dst *EnvConfig, src EnvConfig) {
 	if src.UpdateLockfile != "" {
 		dst.UpdateLockfile = src.UpdateLockfile
 	}
+	if src.MaxGoVersion != "" {
+		dst.MaxGoVersion = src.MaxGoVersion
+	}
+	if src.DockerfileRequireCMD {
+		dst.DockerfileRequireCMD = true
+	}
+	if len(src.PreBuildHooks) > 0 {
+		dst.PreBuildHooks = src.PreBuildHooks
+	}
+	if len(src.PostBuildHooks) > 0 {
+		dst.PostBuildHooks = src.PostBuildHooks
+	}
+	if src.PostBuildHooksFatal {
+		dst.PostBuildHooksFatal = true
+	}
+	if len(src.BuildHookAllowlist) > 0 {
+		dst.BuildHookAllowlist = src.BuildHookAllowlist
+	}
+	if len(src.CallbackAllowedHosts) > 0 {
+		dst.CallbackAllowedHosts = src.CallbackAllowedHosts
+	}
+	if src.CallbackProgress {
+		dst.CallbackProgress = true
+	}
+	if len(src.BuildOptAllowlist) > 0 {
+		dst.BuildOptAllowlist = src.BuildOptAllowlist
+	}
+	if src.PackCLIPath != "" {
+		dst.PackCLIPath = src.PackCLIPath
+	}
+	if src.GitCLIPath != "" {
+		dst.GitCLIPath = src.GitCLIPath
+	}
+	if src.BuildpacksBuilder != "" {
+		dst.BuildpacksBuilder = src.BuildpacksBuilder
+	}
+	if src.BuildpacksPublish {
+		dst.BuildpacksPublish = true
+	}
+	if src.BuildContextDedup {
+		dst.BuildContextDedup = true
+	}
+	if src.BuildContextPrune {
+		dst.BuildContextPrune = true
+	}
+	if src.BuildContextScope {
+		dst.BuildContextScope = true
+	}
+	if src.MovingImageTag != "" {
+		dst.MovingImageTag = src.MovingImageTag
+	}
+	if src.ImageTagTemplate != "" {
+		dst.ImageTagTemplate = src.ImageTagTemplate
+	}
+	if src.BuildCacheRegistry != "" {
+		dst.BuildCacheRegistry = src.BuildCacheRegistry
+	}
+	if len(src.BuildDNSServers) > 0 {
+		dst.BuildDNSServers = src.BuildDNSServers
+	}
+	if src.MaxConcurrentImageBuilds > 0 {
+		dst.MaxConcurrentImageBuilds = src.MaxConcurrentImageBuilds
+	}
+	if src.MaxEnvKeys > 0 {
+		dst.MaxEnvKeys = src.MaxEnvKeys
+	}
+	if src.MaxEnvBytes > 0 {
+		dst.MaxEnvBytes = src.MaxEnvBytes
+	}
+	if src.BuildLogVerbosity != "" {
+		dst.BuildLogVerbosity = src.BuildLogVerbosity
+	}
+	if src.BuildLogFormat != "" {
+		dst.BuildLogFormat = src.BuildLogFormat
+	}
+	if src.SecretScan != "" {
+		dst.SecretScan = src.SecretScan
+	}
+	if len(src.SecretScanAllowlist) > 0 {
+		dst.SecretScanAllowlist = src.SecretScanAllowlist
+	}
+	if src.RegistryRetrySeconds > 0 {
+		dst.RegistryRetrySeconds = src.RegistryRetrySeconds
+	}
+	if src.BuildMaxRetries > 0 {
+		dst.BuildMaxRetries = src.BuildMaxRetries
+	}
+	if src.BuildRetryDelaySeconds > 0 {
+		dst.BuildRetryDelaySeconds = src.BuildRetryDelaySeconds
+	}
+	if src.DebugWorkspaceTTLSeconds > 0 {
+		dst.DebugWorkspaceTTLSeconds = src.DebugWorkspaceTTLSeconds
+	}
+	if src.ResumeTTLSeconds > 0 {
+		dst.ResumeTTLSeconds = src.ResumeTTLSeconds
+	}
+	if src.ImageReconcileSeconds > 0 {
+		dst.ImageReconcileSeconds = src.ImageReconcileSeconds
+	}
+	if src.JobLogMaxSizeMB > 0 {
+		dst.JobLogMaxSizeMB = src.JobLogMaxSizeMB
+	}
+	if src.LogCompressAfterHours > 0 {
+		dst.LogCompressAfterHours = src.LogCompressAfterHours
+	}
+	if src.OutboundUserAgent != "" {
+		dst.OutboundUserAgent = src.OutboundUserAgent
+	}
+	if len(src.OutboundHeaders) > 0 {
+		dst.OutboundHeaders = src.OutboundHeaders
+	}
+	if len(src.OutboundGitHeaderHosts) > 0 {
+		dst.OutboundGitHeaderHosts = src.OutboundGitHeaderHosts
+	}
+	if len(src.RegistryCredentials) > 0 {
+		dst.RegistryCredentials = src.RegistryCredentials
+	}
+	if src.CredentialVaultDir != "" {
+		dst.CredentialVaultDir = src.CredentialVaultDir
+	}
+	if src.DatabaseURL != "" {
+		dst.DatabaseURL = src.DatabaseURL
+	}
+	if src.ClaimLeaseSeconds > 0 {
+		dst.ClaimLeaseSeconds = src.ClaimLeaseSeconds
+	}
+	if src.SQLiteJournalMode != "" {
+		dst.SQLiteJournalMode = src.SQLiteJournalMode
+	}
+	if src.SQLiteSynchronous != "" {
+		dst.SQLiteSynchronous = src.SQLiteSynchronous
+	}
+	if src.SQLiteBusyTimeoutSeconds > 0 {
+		dst.SQLiteBusyTimeoutSeconds = src.SQLiteBusyTimeoutSeconds
+	}
+	if src.SQLiteForeignKeys != nil {
+		dst.SQLiteForeignKeys = src.SQLiteForeignKeys
+	}
+	if src.CloneTimeoutSeconds > 0 {
+		dst.CloneTimeoutSeconds = src.CloneTimeoutSeconds
+	}
+	if src.PrebuildTimeoutSeconds > 0 {
+		dst.PrebuildTimeoutSeconds = src.PrebuildTimeoutSeconds
+	}
+	if src.BuildPhaseTimeoutSeconds > 0 {
+		dst.BuildPhaseTimeoutSeconds = src.BuildPhaseTimeoutSeconds
+	}
+	if src.MaxBuildWallClockSeconds > 0 {
+		dst.MaxBuildWallClockSeconds = src.MaxBuildWallClockSeconds
+	}
+	if src.InstanceID != "" {
+		dst.InstanceID = src.InstanceID
+	}
+	if len(src.APIKeys) > 0 {
+		dst.APIKeys = src.APIKeys
+	}
+	if len(src.BuildProfiles) > 0 {
+		dst.BuildProfiles = src.BuildProfiles
+	}
+	if src.AffinityMaxDeferSeconds > 0 {
+		dst.AffinityMaxDeferSeconds = src.AffinityMaxDeferSeconds
+	}
 }
 
 func applyEnvironmentOverrides(config *EnvConfig) {
@@ -215,12 +450,266 @@ func applyEnvironmentOverrides(config *EnvConfig) {
 	if value := os.Getenv("UPDATE_LOCKFILE"); value != "" {
 		config.UpdateLockfile = value
 	}
+	if value := os.Getenv("MAX_GO_VERSION"); value != "" {
+		config.MaxGoVersion = value
+	}
+	if value := os.Getenv("DOCKERFILE_REQUIRE_CMD"); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			config.DockerfileRequireCMD = parsed
+		} else {
+			log.Printf("WARN: ignoring invalid DOCKERFILE_REQUIRE_CMD=%q", value)
+		}
+	}
+	applyEnvListOverride("PRE_BUILD_HOOKS", &config.PreBuildHooks)
+	applyEnvListOverride("POST_BUILD_HOOKS", &config.PostBuildHooks)
+	applyEnvListOverride("BUILD_HOOK_ALLOWLIST", &config.BuildHookAllowlist)
+	applyEnvListOverride("CALLBACK_ALLOWED_HOSTS", &config.CallbackAllowedHosts)
+	if value := os.Getenv("CALLBACK_PROGRESS"); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			config.CallbackProgress = parsed
+		} else {
+			log.Printf("WARN: ignoring invalid CALLBACK_PROGRESS=%q", value)
+		}
+	}
+	applyEnvListOverride("BUILD_OPT_ALLOWLIST", &config.BuildOptAllowlist)
+	if value := os.Getenv("API_KEYS"); value != "" {
+		var keys []server.APIKey
+		if err := json.Unmarshal([]byte(value), &keys); err != nil {
+			log.Printf("WARN: ignoring invalid API_KEYS (expected JSON array of objects): %v", err)
+		} else {
+			config.APIKeys = keys
+		}
+	}
+	if value := os.Getenv("BUILD_PROFILES"); value != "" {
+		var profiles server.Profiles
+		if err := json.Unmarshal([]byte(value), &profiles); err != nil {
+			log.Printf("WARN: ignoring invalid BUILD_PROFILES (expected JSON object of profiles): %v", err)
+		} else {
+			config.BuildProfiles = profiles
+		}
+	}
+	if value := os.Getenv("OUTBOUND_USER_AGENT"); value != "" {
+		config.OutboundUserAgent = value
+	}
+	if value := os.Getenv("OUTBOUND_HEADERS"); value != "" {
+		var headers map[string]string
+		if err := json.Unmarshal([]byte(value), &headers); err != nil {
+			log.Printf("WARN: ignoring invalid OUTBOUND_HEADERS (expected JSON object): %v", err)
+		} else {
+			config.OutboundHeaders = headers
+		}
+	}
+	applyEnvListOverride("OUTBOUND_GIT_HEADER_HOSTS", &config.OutboundGitHeaderHosts)
+	if value := os.Getenv("REGISTRY_CREDENTIALS"); value != "" {
+		var credentials map[string]executor.RegistryCredential
+		if err := json.Unmarshal([]byte(value), &credentials); err != nil {
+			log.Printf("WARN: ignoring invalid REGISTRY_CREDENTIALS (expected JSON object of registry hosts): %v", err)
+		} else {
+			config.RegistryCredentials = credentials
+		}
+	}
+	if value := os.Getenv("POST_BUILD_HOOKS_FATAL"); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			config.PostBuildHooksFatal = parsed
+		} else {
+			log.Printf("WARN: ignoring invalid POST_BUILD_HOOKS_FATAL=%q", value)
+		}
+	}
+	if value := os.Getenv("PACK_CLI_PATH"); value != "" {
+		config.PackCLIPath = value
+	}
+	if value := os.Getenv("GIT_CLI_PATH"); value != "" {
+		config.GitCLIPath = value
+	}
+	if value := os.Getenv("BUILDPACKS_BUILDER"); value != "" {
+		config.BuildpacksBuilder = value
+	}
+	if value := os.Getenv("BUILDPACKS_PUBLISH"); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			config.BuildpacksPublish = parsed
+		} else {
+			log.Printf("WARN: ignoring invalid BUILDPACKS_PUBLISH=%q", value)
+		}
+	}
+	if value := os.Getenv("BUILD_CONTEXT_DEDUP"); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			config.BuildContextDedup = parsed
+		} else {
+			log.Printf("WARN: ignoring invalid BUILD_CONTEXT_DEDUP=%q", value)
+		}
+	}
+	if value := os.Getenv("BUILD_CONTEXT_PRUNE"); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			config.BuildContextPrune = parsed
+		} else {
+			log.Printf("WARN: ignoring invalid BUILD_CONTEXT_PRUNE=%q", value)
+		}
+	}
+	if value := os.Getenv("BUILD_CONTEXT_SCOPE_WORKSPACES"); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			config.BuildContextScope = parsed
+		} else {
+			log.Printf("WARN: ignoring invalid BUILD_CONTEXT_SCOPE_WORKSPACES=%q", value)
+		}
+	}
+	if value := os.Getenv("MOVING_IMAGE_TAG"); value != "" {
+		config.MovingImageTag = value
+	}
+	if value := os.Getenv("IMAGE_TAG_TEMPLATE"); value != "" {
+		config.ImageTagTemplate = value
+	}
+	if value := os.Getenv("BUILD_CACHE_REGISTRY"); value != "" {
+		config.BuildCacheRegistry = value
+	}
+	applyEnvListOverride("BUILD_DNS_SERVERS", &config.BuildDNSServers)
+	applyEnvNonNegativeIntOverride("MAX_CONCURRENT_IMAGE_BUILDS", &config.MaxConcurrentImageBuilds)
+	applyEnvPositiveIntOverride("MAX_ENV_KEYS", &config.MaxEnvKeys)
+	applyEnvPositiveIntOverride("MAX_ENV_BYTES", &config.MaxEnvBytes)
+	if value := os.Getenv("BUILD_LOG_VERBOSITY"); value != "" {
+		config.BuildLogVerbosity = value
+	}
+	if value := os.Getenv("BUILD_LOG_FORMAT"); value != "" {
+		config.BuildLogFormat = value
+	}
+	if value := os.Getenv("SECRET_SCAN"); value != "" {
+		config.SecretScan = value
+	}
+	applyEnvListOverride("SECRET_SCAN_ALLOWLIST", &config.SecretScanAllowlist)
+	applyEnvPositiveIntOverride("CLONE_TIMEOUT_SECONDS", &config.CloneTimeoutSeconds)
+	applyEnvNonNegativeIntOverride("DEBUG_WORKSPACE_TTL_SECONDS", &config.DebugWorkspaceTTLSeconds)
+	applyEnvNonNegativeIntOverride("RESUME_WORKSPACE_TTL_SECONDS", &config.ResumeTTLSeconds)
+	applyEnvNonNegativeIntOverride("IMAGE_RECONCILE_INTERVAL_SECONDS", &config.ImageReconcileSeconds)
+	applyEnvPositiveIntOverride("JOB_LOG_MAX_SIZE_MB", &config.JobLogMaxSizeMB)
+	applyEnvPositiveIntOverride("LOG_COMPRESS_AFTER_HOURS", &config.LogCompressAfterHours)
+	applyEnvPositiveIntOverride("PREBUILD_TIMEOUT_SECONDS", &config.PrebuildTimeoutSeconds)
+	applyEnvPositiveIntOverride("BUILD_PHASE_TIMEOUT_SECONDS", &config.BuildPhaseTimeoutSeconds)
+	applyEnvPositiveIntOverride("BUILD_MAX_WALL_CLOCK_SECONDS", &config.MaxBuildWallClockSeconds)
+	applyEnvNonNegativeIntOverride("REGISTRY_RATE_LIMIT_RETRY_SECONDS", &config.RegistryRetrySeconds)
+	applyEnvNonNegativeIntOverride("BUILD_MAX_RETRIES", &config.BuildMaxRetries)
+	applyEnvPositiveIntOverride("BUILD_RETRY_BASE_DELAY_SECONDS", &config.BuildRetryDelaySeconds)
+	if value := os.Getenv("INSTANCE_ID"); value != "" {
+		config.InstanceID = value
+	}
+	applyEnvPositiveIntOverride("BUILD_AFFINITY_MAX_DEFER_SECONDS", &config.AffinityMaxDeferSeconds)
+	if value := os.Getenv("CREDENTIAL_VAULT_DIR"); value != "" {
+		config.CredentialVaultDir = value
+	}
+	if value := os.Getenv("DATABASE_URL"); value != "" {
+		config.DatabaseURL = value
+	}
+	applyEnvPositiveIntOverride("CLAIM_LEASE_SECONDS", &config.ClaimLeaseSeconds)
+	if value := os.Getenv("SQLITE_JOURNAL_MODE"); value != "" {
+		config.SQLiteJournalMode = value
+	}
+	if value := os.Getenv("SQLITE_SYNCHRONOUS"); value != "" {
+		config.SQLiteSynchronous = value
+	}
+	applyEnvPositiveIntOverride("SQLITE_BUSY_TIMEOUT_SECONDS", &config.SQLiteBusyTimeoutSeconds)
+	if value := os.Getenv("SQLITE_FOREIGN_KEYS"); value != "" {
+		if parsed, err := strconv.ParseBool(value); err == nil {
+			config.SQLiteForeignKeys = &parsed
+		} else {
+			log.Printf("WARN: ignoring invalid SQLITE_FOREIGN_KEYS=%q", value)
+		}
+	}
+}
+
+// storageOptions maps the SQLITE_* settings onto the storage defaults.
+func storageOptions(config EnvConfig) storage.Options {
+	opts := storage.DefaultOptions()
+	if config.SQLiteJournalMode != "" {
+		opts.JournalMode = config.SQLiteJournalMode
+	}
+	if config.SQLiteSynchronous != "" {
+		opts.Synchronous = config.SQLiteSynchronous
+	}
+	if config.SQLiteBusyTimeoutSeconds > 0 {
+		opts.BusyTimeout = time.Duration(config.SQLiteBusyTimeoutSeconds) * time.Second
+	}
+	if config.SQLiteForeignKeys != nil {
+		opts.ForeignKeys = *config.SQLiteForeignKeys
+	}
+	return opts
+}
+
+// applyEnvPositiveIntOverride reads an integer setting, such as a limit or a
+// timeout in seconds, for which zero means nothing; zero is ignored as
+// invalid.
+func applyEnvPositiveIntOverride(key string, dst *int) {
+	applyEnvIntOverride(key, dst, 1)
+}
+
+// applyEnvNonNegativeIntOverride reads an integer setting for which zero is
+// meaningful, typically turning the feature off, so that the environment can
+// set zero over a default or the config file.
+func applyEnvNonNegativeIntOverride(key string, dst *int) {
+	applyEnvIntOverride(key, dst, 0)
+}
+
+func applyEnvIntOverride(key string, dst *int, min int) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	if parsed, err := strconv.Atoi(value); err == nil && parsed >= min {
+		*dst = parsed
+	} else {
+		log.Printf("WARN: ignoring invalid %s=%q", key, value)
+	}
+}
+
+// applyEnvListOverride reads list settings from the environment as a JSON array,
+// since hook commands may legitimately contain commas and spaces.
+func applyEnvListOverride(key string, dst *[]string) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	var parsed []string
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		log.Printf("WARN: ignoring invalid %s (expected JSON array): %v", key, err)
+		return
+	}
+	*dst = parsed
 }
 
 func applyEnvConfig(config EnvConfig) {
 	os.Setenv("HUBCELL_BASE_URL", config.HubcellBaseURL)
 	os.Setenv("HUBCELL_CLI_PATH", config.HubcellCLIPath)
 	os.Setenv("CALLBACK_URL", config.CallbackURL)
+	os.Setenv("MAX_GO_VERSION", config.MaxGoVersion)
+	os.Setenv("DOCKERFILE_REQUIRE_CMD", strconv.FormatBool(config.DockerfileRequireCMD))
+	os.Setenv("PACK_CLI_PATH", config.PackCLIPath)
+	os.Setenv("GIT_CLI_PATH", config.GitCLIPath)
+	os.Setenv("BUILDPACKS_BUILDER", config.BuildpacksBuilder)
+	os.Setenv("BUILDPACKS_PUBLISH", strconv.FormatBool(config.BuildpacksPublish))
+	os.Setenv("MOVING_IMAGE_TAG", config.MovingImageTag)
+	os.Setenv("IMAGE_TAG_TEMPLATE", config.ImageTagTemplate)
+	os.Setenv("OUTBOUND_USER_AGENT", config.OutboundUserAgent)
+	if headers, err := json.Marshal(config.OutboundHeaders); err == nil && len(config.OutboundHeaders) > 0 {
+		os.Setenv("OUTBOUND_HEADERS", string(headers))
+	} else {
+		os.Unsetenv("OUTBOUND_HEADERS")
+	}
+	if hosts, err := json.Marshal(config.OutboundGitHeaderHosts); err == nil && len(config.OutboundGitHeaderHosts) > 0 {
+		os.Setenv("OUTBOUND_GIT_HEADER_HOSTS", string(hosts))
+	} else {
+		os.Unsetenv("OUTBOUND_GIT_HEADER_HOSTS")
+	}
+}
+
+// claimOwner names this instance in the job claims it takes: INSTANCE_ID when
+// set, otherwise the host name and process ID, which a restart changes so the
+// claims of the previous process are left to lapse.
+func claimOwner(instanceID string) string {
+	if instanceID != "" {
+		return instanceID
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "builder"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
 }
 
 func main() {
@@ -243,24 +732,37 @@ func main() {
 
 	callbackURL := config.CallbackURL // e.g., "http://localhost:3000/api/builds/callback"
 	allowedCommands := allowlist.DefaultAllowedCommands()
+	allowedCommands.Hooks = config.BuildHookAllowlist
 
 	if err := os.MkdirAll(config.DataDir, 0o755); err != nil {
 		log.Fatalf("could not create data directory: %s\n", err)
 	}
 
-	storage, err := storage.NewStorage(filepath.Join(config.DataDir, "hubfly-builder.sqlite"))
+	databasePath := config.DatabaseURL
+	if databasePath == "" {
+		databasePath = filepath.Join(config.DataDir, "hubfly-builder.sqlite")
+	}
+	storage, err := storage.NewStorageWithOptions(databasePath, storageOptions(config))
 	if err != nil {
 		log.Fatalf("could not create storage: %s\n", err)
 	}
 
-	if err := storage.ResetInProgressJobs(); err != nil {
-		log.Fatalf("could not reset in-progress jobs: %s\n", err)
+	// A shared Postgres database holds the in-progress jobs of the other
+	// instances too, so only a private SQLite database is reset on startup.
+	// Shared jobs are requeued once their claim lease lapses instead.
+	sharedDatabase := storage.Backend() == "postgres"
+	if !sharedDatabase {
+		if err := storage.ResetInProgressJobs(); err != nil {
+			log.Fatalf("could not reset in-progress jobs: %s\n", err)
+		}
 	}
 
 	logManager, err := logs.NewLogManager(config.LogDir)
 	if err != nil {
 		log.Fatalf("could not create log manager: %s\n", err)
 	}
+	logManager.SetMaxLogSize(int64(config.JobLogMaxSizeMB) * 1024 * 1024)
+	logManager.SetCompressAfter(time.Duration(config.LogCompressAfterHours) * time.Hour)
 
 	systemLogPath, systemLogFile, err := logManager.CreateSystemLogFile()
 	if err != nil {
@@ -271,7 +773,7 @@ func main() {
 	log.SetFlags(log.LstdFlags | log.LUTC)
 	log.Printf("System log file: %s", systemLogPath)
 	log.Printf(
-		"Config: HUBCELL_BASE_URL=%q HUBCELL_CLI_PATH=%q CALLBACK_URL=%q SERVER_ADDR=%q UPLOAD_ADDR=%q DATA_DIR=%q LOG_DIR=%q MAX_CONCURRENT_BUILDS=%d LOG_RETENTION_DAYS=%d",
+		"Config: HUBCELL_BASE_URL=%q HUBCELL_CLI_PATH=%q CALLBACK_URL=%q SERVER_ADDR=%q UPLOAD_ADDR=%q DATA_DIR=%q LOG_DIR=%q MAX_CONCURRENT_BUILDS=%d LOG_RETENTION_DAYS=%d UPDATE_LOCKFILE=%q MAX_GO_VERSION=%q",
 		config.HubcellBaseURL,
 		config.HubcellCLIPath,
 		config.CallbackURL,
@@ -282,8 +784,12 @@ func main() {
 		config.MaxConcurrentBuilds,
 		config.LogRetentionDays,
 		config.UpdateLockfile,
+		config.MaxGoVersion,
 	)
 	log.Printf("Effective: CALLBACK_URL=%q", callbackURL)
+	log.Printf("Buildpacks: PACK_CLI_PATH=%q BUILDPACKS_BUILDER=%q BUILDPACKS_PUBLISH=%t", config.PackCLIPath, config.BuildpacksBuilder, config.BuildpacksPublish)
+	log.Printf("Phase timeouts: CLONE_TIMEOUT_SECONDS=%d PREBUILD_TIMEOUT_SECONDS=%d BUILD_PHASE_TIMEOUT_SECONDS=%d BUILD_MAX_WALL_CLOCK_SECONDS=%d", config.CloneTimeoutSeconds, config.PrebuildTimeoutSeconds, config.BuildPhaseTimeoutSeconds, config.MaxBuildWallClockSeconds)
+	log.Printf("Build hooks: pre=%d post=%d postFatal=%t", len(config.PreBuildHooks), len(config.PostBuildHooks), config.PostBuildHooksFatal)
 
 	// Start log cleanup routine
 	go func() {
@@ -297,8 +803,136 @@ func main() {
 		}
 	}()
 
-	apiClient := api.NewClient(callbackURL)
+	apiClient := api.NewClient(callbackURL,
+		api.WithAllowedCallbackHosts(config.CallbackAllowedHosts...),
+		api.WithUserAgent(config.OutboundUserAgent),
+		api.WithHeaders(config.OutboundHeaders),
+		api.WithProgressReports(config.CallbackProgress),
+	)
+	if config.OutboundUserAgent != "" || len(config.OutboundHeaders) > 0 {
+		log.Printf("Outbound identity: OUTBOUND_USER_AGENT=%q OUTBOUND_HEADERS=%d", config.OutboundUserAgent, len(config.OutboundHeaders))
+	}
 	manager := executor.NewManager(storage, logManager, allowedCommands, apiClient, config.MaxConcurrentBuilds, config.UpdateLockfile)
+	logManager.SetActiveJobs(manager.GetActiveBuilds)
+	manager.SetPhaseTimeouts(executor.PhaseTimeouts{
+		Clone:    time.Duration(config.CloneTimeoutSeconds) * time.Second,
+		Prebuild: time.Duration(config.PrebuildTimeoutSeconds) * time.Second,
+		Build:    time.Duration(config.BuildPhaseTimeoutSeconds) * time.Second,
+	})
+	manager.SetMaxBuildDuration(time.Duration(config.MaxBuildWallClockSeconds) * time.Second)
+	manager.SetBuildCacheRegistry(config.BuildCacheRegistry)
+	if err := manager.SetBuildDNSServers(config.BuildDNSServers); err != nil {
+		log.Printf("WARN: ignoring BUILD_DNS_SERVERS: %v", err)
+	}
+	manager.SetMaxConcurrentImageBuilds(config.MaxConcurrentImageBuilds)
+	if config.InstanceID != "" {
+		maxDefer := config.AffinityMaxDeferSeconds
+		if maxDefer <= 0 {
+			maxDefer = defaultAffinityDeferSec
+		}
+		manager.SetBuildAffinity(executor.BuildAffinity{
+			InstanceID: config.InstanceID,
+			MaxDefer:   time.Duration(maxDefer) * time.Second,
+		})
+		log.Printf("Build affinity: INSTANCE_ID=%q BUILD_AFFINITY_MAX_DEFER_SECONDS=%d", config.InstanceID, maxDefer)
+	}
+	if sharedDatabase {
+		leaseSeconds := config.ClaimLeaseSeconds
+		if leaseSeconds <= 0 {
+			leaseSeconds = defaultClaimLeaseSec
+		}
+		lease := executor.ClaimLease{Owner: claimOwner(config.InstanceID), Duration: time.Duration(leaseSeconds) * time.Second}
+		manager.SetClaimLease(lease)
+		log.Printf("Job claim leases: owner=%q CLAIM_LEASE_SECONDS=%d", lease.Owner, leaseSeconds)
+		go func() {
+			// Renewing three times per lease lets a renewal fail or run late
+			// without this instance's builds being requeued under it.
+			ticker := time.NewTicker(lease.Duration / 3)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				manager.RenewClaimLeases(now)
+				if requeued := manager.RequeueExpiredClaims(now); requeued > 0 {
+					log.Printf("Requeued %d jobs whose claim lease lapsed", requeued)
+				}
+			}
+		}()
+	}
+	manager.SetBuildOptAllowlist(config.BuildOptAllowlist)
+	eventBus := events.NewBus()
+	manager.SetEventBus(eventBus)
+	manager.SetContextDedup(config.BuildContextDedup)
+	manager.SetContextPrune(config.BuildContextPrune)
+	manager.SetWorkspaceContextScope(config.BuildContextScope)
+	manager.SetRegistryRateLimitRetry(time.Duration(config.RegistryRetrySeconds) * time.Second)
+	manager.SetRetryPolicy(executor.RetryPolicy{
+		MaxRetries: config.BuildMaxRetries,
+		BaseDelay:  time.Duration(config.BuildRetryDelaySeconds) * time.Second,
+	})
+	logVerbosity, err := executor.ParseLogVerbosity(config.BuildLogVerbosity)
+	if err != nil {
+		log.Printf("WARN: ignoring BUILD_LOG_VERBOSITY: %v", err)
+	}
+	manager.SetLogVerbosity(logVerbosity)
+	logFormat, err := executor.ParseLogFormat(config.BuildLogFormat)
+	if err != nil {
+		log.Printf("WARN: ignoring BUILD_LOG_FORMAT: %v", err)
+	}
+	manager.SetLogFormat(logFormat)
+	secretScan, err := executor.ParseSecretScanMode(config.SecretScan)
+	if err != nil {
+		log.Printf("WARN: ignoring SECRET_SCAN: %v", err)
+	}
+	manager.SetSecretScan(secretScan, config.SecretScanAllowlist)
+	manager.SetDebugWorkspaceTTL(time.Duration(config.DebugWorkspaceTTLSeconds) * time.Second)
+	manager.SetResumeWorkspaceTTL(time.Duration(config.ResumeTTLSeconds) * time.Second)
+	manager.SetRegistryCredentials(config.RegistryCredentials)
+	var secretResolver gitauth.SecretResolver
+	if config.CredentialVaultDir != "" {
+		secretResolver = gitauth.FileResolver{Dir: config.CredentialVaultDir}
+		log.Printf("Git credential references enabled: CREDENTIAL_VAULT_DIR=%s", config.CredentialVaultDir)
+	}
+	manager.SetSecretResolver(secretResolver)
+	go func() {
+		manager.ReapDebugWorkspaces(time.Now())
+		manager.ReapGitCredentials(time.Now())
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if reaped := manager.ReapDebugWorkspaces(now); reaped > 0 {
+				log.Printf("Reaped %d expired debug workspaces", reaped)
+			}
+			if cleared := manager.ReapGitCredentials(now); cleared > 0 {
+				log.Printf("Cleared the git credentials of %d jobs past their resume TTL", cleared)
+			}
+		}
+	}()
+	manager.SetImageChecker(executor.HubcellImageChecker{HubcellPath: config.HubcellCLIPath})
+	if config.ImageReconcileSeconds > 0 {
+		interval := time.Duration(config.ImageReconcileSeconds) * time.Second
+		log.Printf("Image reconciliation enabled: IMAGE_RECONCILE_INTERVAL_SECONDS=%d", config.ImageReconcileSeconds)
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for now := range ticker.C {
+				if missing := manager.ReconcileImages(context.Background(), now.Add(-interval)); missing > 0 {
+					log.Printf("WARN: image reconciliation found %d missing images", missing)
+				}
+			}
+		}()
+	}
+	if config.BuildContextDedup {
+		log.Printf("Build context dedup enabled: identical contexts are retagged instead of rebuilt")
+	}
+	manager.SetBuildHooks(executor.BuildHooks{
+		PreBuild:             config.PreBuildHooks,
+		PostBuild:            config.PostBuildHooks,
+		FailOnPostBuildError: config.PostBuildHooksFatal,
+	})
+	for _, hook := range append(append([]string{}, config.PreBuildHooks...), config.PostBuildHooks...) {
+		if !allowlist.IsCommandAllowed(hook, allowedCommands.Hooks) {
+			log.Printf("WARN: build hook %q is not in BUILD_HOOK_ALLOWLIST; builds using it will fail", hook)
+		}
+	}
 	go manager.Start()
 
 	uploadServer := uploadserver.NewServer(callbackURL)
@@ -309,7 +943,19 @@ func main() {
 		}
 	}()
 
-	server := server.NewServer(storage, logManager, manager, allowedCommands)
+	envLimits := server.EnvLimits{MaxKeys: config.MaxEnvKeys, MaxBytes: config.MaxEnvBytes}
+	server := server.NewServer(storage, logManager, manager, allowedCommands, apiClient)
+	server.SetAPIKeys(config.APIKeys)
+	server.SetEventBus(eventBus)
+	if len(config.APIKeys) > 0 {
+		log.Printf("API key auth enabled for job read endpoints: keys=%d", len(config.APIKeys))
+	}
+	server.SetBuildProfiles(config.BuildProfiles)
+	server.SetEnvLimits(envLimits)
+	server.SetSecretResolver(secretResolver)
+	if len(config.BuildProfiles) > 0 {
+		log.Printf("Build profiles: %d", len(config.BuildProfiles))
+	}
 
 	log.Printf("Server listening on %s", config.ServerAddr)
 	if err := server.Start(config.ServerAddr); err != nil {
@@ -4,6 +4,8 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"hubfly-builder/internal/server"
 )
 
 func clearConfigEnv(t *testing.T) {
@@ -18,6 +20,60 @@ func clearConfigEnv(t *testing.T) {
 		"LOG_DIR",
 		"MAX_CONCURRENT_BUILDS",
 		"LOG_RETENTION_DAYS",
+		"MAX_GO_VERSION",
+		"DOCKERFILE_REQUIRE_CMD",
+		"PRE_BUILD_HOOKS",
+		"POST_BUILD_HOOKS",
+		"POST_BUILD_HOOKS_FATAL",
+		"BUILD_HOOK_ALLOWLIST",
+		"CALLBACK_ALLOWED_HOSTS",
+		"CALLBACK_PROGRESS",
+		"BUILD_DNS_SERVERS",
+		"BUILD_OPT_ALLOWLIST",
+		"PACK_CLI_PATH",
+		"IMAGE_TAG_TEMPLATE",
+		"BUILD_LOG_VERBOSITY",
+		"BUILD_LOG_FORMAT",
+		"BUILD_MAX_RETRIES",
+		"BUILD_RETRY_BASE_DELAY_SECONDS",
+		"GIT_CLI_PATH",
+		"BUILDPACKS_BUILDER",
+		"BUILDPACKS_PUBLISH",
+		"CLONE_TIMEOUT_SECONDS",
+		"PREBUILD_TIMEOUT_SECONDS",
+		"BUILD_PHASE_TIMEOUT_SECONDS",
+		"BUILD_MAX_WALL_CLOCK_SECONDS",
+		"BUILD_CACHE_REGISTRY",
+		"MAX_CONCURRENT_IMAGE_BUILDS",
+		"MAX_ENV_KEYS",
+		"MAX_ENV_BYTES",
+		"INSTANCE_ID",
+		"BUILD_AFFINITY_MAX_DEFER_SECONDS",
+		"CLAIM_LEASE_SECONDS",
+		"API_KEYS",
+		"BUILD_PROFILES",
+		"BUILD_CONTEXT_DEDUP",
+		"BUILD_CONTEXT_PRUNE",
+		"BUILD_CONTEXT_SCOPE_WORKSPACES",
+		"MOVING_IMAGE_TAG",
+		"REGISTRY_RATE_LIMIT_RETRY_SECONDS",
+		"DEBUG_WORKSPACE_TTL_SECONDS",
+		"RESUME_WORKSPACE_TTL_SECONDS",
+		"REGISTRY_CREDENTIALS",
+		"IMAGE_RECONCILE_INTERVAL_SECONDS",
+		"JOB_LOG_MAX_SIZE_MB",
+		"LOG_COMPRESS_AFTER_HOURS",
+		"SECRET_SCAN",
+		"SECRET_SCAN_ALLOWLIST",
+		"OUTBOUND_USER_AGENT",
+		"OUTBOUND_HEADERS",
+		"OUTBOUND_GIT_HEADER_HOSTS",
+		"CREDENTIAL_VAULT_DIR",
+		"DATABASE_URL",
+		"SQLITE_JOURNAL_MODE",
+		"SQLITE_SYNCHRONOUS",
+		"SQLITE_BUSY_TIMEOUT_SECONDS",
+		"SQLITE_FOREIGN_KEYS",
 	} {
 		t.Setenv(key, "")
 	}
@@ -71,3 +127,19 @@ func TestLoadEnvConfigEnvironmentOverridesFile(t *testing.T) {
 		t.Fatalf("expected env max concurrent override, got %d", config.MaxConcurrentBuilds)
 	}
 }
+
+func TestLoadEnvConfigAcceptsZeroOnlyWhereItIsMeaningful(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv("HUBFLY_BUILDER_CONFIG", filepath.Join(t.TempDir(), "config.json"))
+	t.Setenv("DEBUG_WORKSPACE_TTL_SECONDS", "0")
+	t.Setenv("MAX_ENV_KEYS", "0")
+
+	config := loadEnvConfig()
+
+	if config.DebugWorkspaceTTLSeconds != 0 {
+		t.Fatalf("expected DEBUG_WORKSPACE_TTL_SECONDS=0 to disable debug retention, got %d", config.DebugWorkspaceTTLSeconds)
+	}
+	if config.MaxEnvKeys != server.DefaultMaxEnvKeys {
+		t.Fatalf("expected MAX_ENV_KEYS=0 to be ignored, got %d", config.MaxEnvKeys)
+	}
+}
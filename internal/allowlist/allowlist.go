@@ -11,6 +11,7 @@ type AllowedCommands struct {
 	Prebuild []string `json:"prebuild"`
 	Build    []string `json:"build"`
 	Run      []string `json:"run"`
+	Hooks    []string `json:"hooks,omitempty"` // Operator build hooks; empty by default.
 }
 
 func DefaultAllowedCommands() *AllowedCommands {
@@ -39,6 +40,7 @@ func DefaultAllowedCommands() *AllowedCommands {
 			"cargo fetch",
 			"cargo chef prepare --recipe-path recipe.json",
 			"dotnet restore",
+			"dotnet restore *.csproj",
 			"bun install",
 			"bun install --frozen-lockfile",
 			"mix local.hex --force",
@@ -51,6 +53,10 @@ func DefaultAllowedCommands() *AllowedCommands {
 			"gradle dependencies",
 			"./gradlew dependencies",
 			"chmod +x gradlew",
+			"bundle install",
+			"gem install jekyll",
+			"dart pub get",
+			"flutter pub get",
 		},
 		Build: []string{
 			"npm run build",
@@ -93,6 +99,7 @@ func DefaultAllowedCommands() *AllowedCommands {
 			"touch rel/config.exs",
 			"python setup.py build",
 			"dotnet publish -c Release -o out",
+			"dotnet publish *.csproj -c Release -o out",
 			"composer dump-autoload --optimize",
 			"composer run-script build",
 			"composer run-script compile",
@@ -106,6 +113,12 @@ func DefaultAllowedCommands() *AllowedCommands {
 			"./mvnw install -DskipTests",
 			"gradle build -x test",
 			"./gradlew build -x test",
+			"hugo --minify",
+			"JEKYLL_ENV=production bundle exec jekyll build",
+			"JEKYLL_ENV=production jekyll build",
+			"flutter build web --release",
+			"dart compile exe bin/*.dart -o app",
+			"RAILS_ENV=production SECRET_KEY_BASE_DUMMY=1 bundle exec rails assets:precompile",
 		},
 		Run: []string{
 			"npm start",
@@ -159,6 +172,7 @@ func DefaultAllowedCommands() *AllowedCommands {
 			"MIX_ENV=prod mix run --no-halt",
 			"mix ecto.setup && _build/prod/rel/*/bin/* foreground",
 			"bun run start",
+			"bun start",
 			"bun run *",
 			"apache2-foreground",
 			"php-fpm -D && exec nginx -g 'daemon off;'",
@@ -181,6 +195,10 @@ func DefaultAllowedCommands() *AllowedCommands {
 			"java -jar app.jar",
 			"java -jar build/libs/*-all.jar",
 			"dotnet *.dll",
+			"bundle exec rails server -b 0.0.0.0 -p ${PORT:-3000}",
+			"bundle exec rackup -o 0.0.0.0 -p ${PORT:-3000}",
+			"bundle exec ruby *.rb",
+			"bundle exec ruby *.rb -o 0.0.0.0 -p ${PORT:-3000}",
 		},
 	}
 }
@@ -2,65 +2,254 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"hubfly-builder/internal/storage"
 )
 
+const (
+	defaultRequestTimeout      = 30 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultMaxIdleConnsPerHost = 16
+	defaultRetryBaseDelay      = 2 * time.Second
+	maxCallbackRetries         = 5
+	// maxRetryAfter caps the wait a 429 response may ask for.
+	maxRetryAfter = 5 * time.Minute
+	// progressRequestTimeout bounds a progress report, which is sent once
+	// and never retried so a slow backend cannot hold up the build.
+	progressRequestTimeout = 5 * time.Second
+)
+
 type Client struct {
-	httpClient  *http.Client
-	callbackURL string
+	httpClient           *http.Client
+	callbackURL          string
+	allowedCallbackHosts []string
+	requestTimeout       time.Duration
+	idleConnTimeout      time.Duration
+	maxIdleConnsPerHost  int
+	retryBaseDelay       time.Duration
+	userAgent            string
+	headers              map[string]string
+	progressReports      bool
+}
+
+type Option func(*Client)
+
+// WithAllowedCallbackHosts restricts per-job callback URL overrides to the given
+// hosts. Entries may use a leading "*." to allow any subdomain.
+func WithAllowedCallbackHosts(hosts ...string) Option {
+	return func(c *Client) {
+		c.allowedCallbackHosts = append(c.allowedCallbackHosts, hosts...)
+	}
+}
+
+// WithHTTPClient replaces the HTTP client used for callbacks.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		if httpClient != nil {
+			c.httpClient = httpClient
+		}
+	}
+}
+
+// WithRequestTimeout bounds each callback attempt. Retries get a fresh deadline.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		if timeout > 0 {
+			c.requestTimeout = timeout
+		}
+	}
+}
+
+// WithRetryBaseDelay sets the wait before the first callback retry. Each
+// further retry waits twice as long.
+func WithRetryBaseDelay(delay time.Duration) Option {
+	return func(c *Client) {
+		if delay > 0 {
+			c.retryBaseDelay = delay
+		}
+	}
 }
 
-func NewClient(callbackURL string) *Client {
-	return &Client{
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+// WithProgressReports makes ReportProgress post lifecycle updates to the
+// callback URL of each job. Without it ReportProgress does nothing, so
+// backends that only expect results are not sent anything else.
+func WithProgressReports(enabled bool) Option {
+	return func(c *Client) {
+		c.progressReports = enabled
+	}
+}
+
+// WithIdleConnTimeout sets how long pooled keep-alive connections stay open.
+func WithIdleConnTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		if timeout > 0 {
+			c.idleConnTimeout = timeout
+		}
+	}
+}
+
+// WithMaxIdleConnsPerHost sets how many keep-alive connections are pooled per
+// callback host. Many builds finishing together reuse these instead of dialing.
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.maxIdleConnsPerHost = n
+		}
+	}
+}
+
+// WithUserAgent overrides the User-Agent sent on callback requests.
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = strings.TrimSpace(userAgent)
+	}
+}
+
+// WithHeaders adds headers to callback requests sent to the configured
+// callback URL's origin; per-job callback URLs elsewhere do not get them.
+// Content-Type and User-Agent are managed by the client and cannot be set here.
+func WithHeaders(headers map[string]string) Option {
+	return func(c *Client) {
+		if c.headers == nil {
+			c.headers = make(map[string]string, len(headers))
+		}
+		for name, value := range headers {
+			if name = strings.TrimSpace(name); name != "" {
+				c.headers[name] = value
+			}
+		}
+	}
+}
+
+func NewClient(callbackURL string, opts ...Option) *Client {
+	c := &Client{
+		callbackURL:         callbackURL,
+		requestTimeout:      defaultRequestTimeout,
+		idleConnTimeout:     defaultIdleConnTimeout,
+		maxIdleConnsPerHost: defaultMaxIdleConnsPerHost,
+		retryBaseDelay:      defaultRetryBaseDelay,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.httpClient == nil {
+		// No client-wide Timeout: deadlines come from the per-request context so
+		// callers can cancel an in-flight report.
+		c.httpClient = &http.Client{Transport: c.newTransport()}
+	} else {
+		httpClient := *c.httpClient
+		c.httpClient = &httpClient
+	}
+	// A redirect could send a validated callback to an internal host.
+	c.httpClient.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return c
+}
+
+func (c *Client) newTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:        10 * time.Second,
+		KeepAlive:      30 * time.Second,
+		ControlContext: checkRestrictedDial,
+	}
+	return &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			// Restricted callbacks dial directly so the check sees the
+			// target's address rather than the proxy's.
+			if restrictedDial(req.Context()) {
+				return nil, nil
+			}
+			return http.ProxyFromEnvironment(req)
 		},
-		callbackURL: callbackURL,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          c.maxIdleConnsPerHost * 4,
+		MaxIdleConnsPerHost:   c.maxIdleConnsPerHost,
+		IdleConnTimeout:       c.idleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: c.requestTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
 	}
 }
 
+// Callback payload types, so a backend can tell progress from results on the
+// same URL.
+const (
+	PayloadTypeResult   = "result"
+	PayloadTypeProgress = "progress"
+)
+
 type ReportPayload struct {
-	ID              string    `json:"id"`
-	ProjectID       string    `json:"projectId"`
-	UserID          string    `json:"userId"`
-	Status          string    `json:"status"`
-	ImageTag        string    `json:"imageTag,omitempty"`
-	ExposePort      string    `json:"exposePort,omitempty"`
-	StartedAt       time.Time `json:"startedAt"`
-	FinishedAt      time.Time `json:"finishedAt"`
-	DurationSeconds float64   `json:"durationSeconds"`
-	LogPath         string    `json:"logPath"`
-	Error           string    `json:"error,omitempty"`
+	Type            string                   `json:"type"`
+	ID              string                   `json:"id"`
+	ProjectID       string                   `json:"projectId"`
+	UserID          string                   `json:"userId"`
+	Status          string                   `json:"status"`
+	ImageTag        string                   `json:"imageTag,omitempty"`
+	ExposePort      string                   `json:"exposePort,omitempty"`
+	Runtime         string                   `json:"runtime,omitempty"`
+	Version         string                   `json:"version,omitempty"`
+	StartedAt       time.Time                `json:"startedAt"`
+	FinishedAt      time.Time                `json:"finishedAt"`
+	DurationSeconds float64                  `json:"durationSeconds"`
+	LogPath         string                   `json:"logPath"`
+	Error           string                   `json:"error,omitempty"`
 	ResolvedEnvPlan []storage.ResolvedEnvVar `json:"resolvedEnvPlan,omitempty"`
 	RuntimeEnvKeys  []string                 `json:"runtimeEnvKeys,omitempty"`
+	BuildCache      *storage.BuildCacheStats `json:"buildCache,omitempty"`
 }
 
 func (c *Client) ReportResult(job *storage.BuildJob, status, errorMsg string) error {
-	if c.callbackURL == "" {
+	return c.ReportResultContext(context.Background(), job, status, errorMsg)
+}
+
+// ReportResultContext is ReportResult with cancellation: once ctx is done the
+// in-flight attempt is aborted and no further retries are made.
+//
+// Network errors, 5xx and 429 responses are retried with exponential backoff;
+// a 429 with a Retry-After header waits as long as the header asks instead.
+// Other non-2xx responses fail at once. The error of the last attempt is
+// returned once the retries are spent.
+func (c *Client) ReportResultContext(ctx context.Context, job *storage.BuildJob, status, errorMsg string) error {
+	callbackURL, err := c.callbackURLForJob(job)
+	if err != nil {
+		return err
+	}
+	if callbackURL == "" {
 		return nil // No callback URL configured
 	}
 
 	payload := ReportPayload{
-		ID:         job.ID,
-		ProjectID:  job.ProjectID,
-		UserID:     job.UserID,
-		Status:     status,
-		ImageTag:   job.ImageTag,
-		ExposePort: callbackExposePort(job.BuildConfig),
-		LogPath:    job.LogPath,
-		Error:      errorMsg,
+		Type:            PayloadTypeResult,
+		ID:              job.ID,
+		ProjectID:       job.ProjectID,
+		UserID:          job.UserID,
+		Status:          status,
+		ImageTag:        job.ImageTag,
+		ExposePort:      callbackExposePort(job.BuildConfig),
+		Runtime:         job.BuildConfig.Runtime,
+		Version:         job.BuildConfig.Version,
+		LogPath:         job.LogPath,
+		Error:           errorMsg,
 		ResolvedEnvPlan: job.BuildConfig.ResolvedEnvPlan,
 		RuntimeEnvKeys:  runtimeEnvKeys(job.BuildConfig.ResolvedEnvPlan),
+		BuildCache:      job.BuildCache,
 	}
 	if !job.StartedAt.Time.IsZero() {
 		payload.StartedAt = job.StartedAt.Time
@@ -74,46 +263,289 @@ func (c *Client) ReportResult(job *storage.BuildJob, status, errorMsg string) er
 	}
 	log.Printf("Callback payload for job %s: %s", job.ID, string(body))
 
-	const maxRetries = 5
-	const baseDelay = 2 * time.Second
-
 	var lastErr error
+	var retryAfter time.Duration
+	hasRetryAfter := false
 
-	for i := 0; i <= maxRetries; i++ {
+	for i := 0; i <= maxCallbackRetries; i++ {
 		if i > 0 {
-			// Exponential backoff: 2s, 4s, 8s, 16s, 32s
-			backoff := float64(baseDelay) * math.Pow(2, float64(i-1))
-			// Add jitter: +/- 20%
-			jitter := (rand.Float64() * 0.4) - 0.2
-			sleepDuration := time.Duration(backoff * (1 + jitter))
-			log.Printf("Retrying callback for job %s in %v (attempt %d/%d)", job.ID, sleepDuration, i, maxRetries)
-			time.Sleep(sleepDuration)
-		}
-
-		req, err := http.NewRequest("POST", c.callbackURL, bytes.NewBuffer(body))
-		if err != nil {
-			return err
+			sleepDuration := c.retryDelay(i)
+			if hasRetryAfter {
+				sleepDuration = retryAfter
+			}
+			log.Printf("Retrying callback for job %s in %v (attempt %d/%d)", job.ID, sleepDuration, i, maxCallbackRetries)
+			if err := sleepContext(ctx, sleepDuration); err != nil {
+				return fmt.Errorf("callback for job %s cancelled: %w", job.ID, err)
+			}
 		}
-		req.Header.Set("Content-Type", "application/json")
 
-		resp, err := c.httpClient.Do(req)
+		resp, err := c.post(ctx, callbackURL, body)
 		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return fmt.Errorf("callback for job %s cancelled: %w", job.ID, ctxErr)
+			}
 			lastErr = err
+			hasRetryAfter = false
 			log.Printf("WARN: callback request failed for job %s: %v", job.ID, err)
 			continue
 		}
 
-		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-			resp.Body.Close()
+		if resp.statusCode >= 200 && resp.statusCode < 300 {
 			return nil
 		}
 
-		lastErr = fmt.Errorf("backend returned non-2xx status: %d", resp.StatusCode)
+		lastErr = fmt.Errorf("backend returned non-2xx status: %d", resp.statusCode)
+		if resp.statusCode < 500 && resp.statusCode != http.StatusTooManyRequests {
+			return fmt.Errorf("callback for job %s rejected: %w", job.ID, lastErr)
+		}
+		retryAfter, hasRetryAfter = resp.retryAfter, resp.hasRetryAfter
 		log.Printf("WARN: callback request returned error for job %s: %v", job.ID, lastErr)
-		resp.Body.Close()
 	}
 
-	return fmt.Errorf("failed to report result after %d attempts: %w", maxRetries, lastErr)
+	return fmt.Errorf("failed to report result after %d attempts: %w", maxCallbackRetries+1, lastErr)
+}
+
+// ProgressPayload is posted by ReportProgress.
+type ProgressPayload struct {
+	Type      string    `json:"type"`
+	ID        string    `json:"id"`
+	ProjectID string    `json:"projectId"`
+	UserID    string    `json:"userId"`
+	Phase     string    `json:"phase"`
+	Message   string    `json:"message,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// ReportProgress posts a lifecycle update of job, such as "claimed" or the
+// start of a build phase, to the job's callback URL. It is best-effort: the
+// update is sent once, without retries, and only when progress reports are
+// enabled with WithProgressReports.
+func (c *Client) ReportProgress(job *storage.BuildJob, phase, message string) error {
+	if c == nil || !c.progressReports {
+		return nil
+	}
+	callbackURL, err := c.callbackURLForJob(job)
+	if err != nil {
+		return err
+	}
+	if callbackURL == "" {
+		return nil
+	}
+	body, err := json.Marshal(ProgressPayload{
+		Type:      PayloadTypeProgress,
+		ID:        job.ID,
+		ProjectID: job.ProjectID,
+		UserID:    job.UserID,
+		Phase:     phase,
+		Message:   message,
+		Time:      time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), progressRequestTimeout)
+	defer cancel()
+	resp, err := c.post(ctx, callbackURL, body)
+	if err != nil {
+		return err
+	}
+	if resp.statusCode < 200 || resp.statusCode >= 300 {
+		return fmt.Errorf("backend returned non-2xx status: %d", resp.statusCode)
+	}
+	return nil
+}
+
+// retryDelay is the backoff before retry attempt, doubling from the base
+// delay (2s, 4s, 8s, 16s, 32s by default) with +/- 20% jitter.
+func (c *Client) retryDelay(attempt int) time.Duration {
+	backoff := float64(c.retryBaseDelay) * math.Pow(2, float64(attempt-1))
+	jitter := (rand.Float64() * 0.4) - 0.2
+	return time.Duration(backoff * (1 + jitter))
+}
+
+// callbackResponse is what a callback attempt needs from the response.
+type callbackResponse struct {
+	statusCode    int
+	retryAfter    time.Duration
+	hasRetryAfter bool
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date, capped at maxRetryAfter.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		delay = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(value); err == nil {
+		delay = max(at.Sub(now), 0)
+	} else {
+		return 0, false
+	}
+	return min(delay, maxRetryAfter), true
+}
+
+func (c *Client) post(ctx context.Context, callbackURL string, body []byte) (callbackResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return callbackResponse{}, err
+	}
+	if sameOrigin(req.URL, c.callbackURL) {
+		for name, value := range c.headers {
+			req.Header.Set(name, value)
+		}
+	} else if len(c.allowedCallbackHosts) == 0 {
+		// A per-job URL that passed ValidateCallbackURL may still resolve to
+		// an internal address; the dialer checks the resolved IP.
+		req = req.WithContext(context.WithValue(req.Context(), restrictedDialKey{}, true))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return callbackResponse{}, err
+	}
+	defer resp.Body.Close()
+	// Drain so the keep-alive connection can go back to the pool.
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	result := callbackResponse{statusCode: resp.StatusCode}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		result.retryAfter, result.hasRetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return result, nil
+}
+
+// sameOrigin reports whether target has the scheme and host of the configured
+// CALLBACK_URL. Operator headers may carry credentials for that backend, so
+// per-job callback URLs on other hosts never receive them.
+func sameOrigin(target *url.URL, configured string) bool {
+	base, err := url.Parse(configured)
+	if err != nil || target == nil {
+		return false
+	}
+	return strings.EqualFold(target.Scheme, base.Scheme) && strings.EqualFold(target.Host, base.Host)
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) callbackURLForJob(job *storage.BuildJob) (string, error) {
+	override := strings.TrimSpace(job.CallbackURL)
+	if override == "" {
+		return c.callbackURL, nil
+	}
+	if err := c.ValidateCallbackURL(override); err != nil {
+		return "", fmt.Errorf("invalid callbackUrl for job %s: %w", job.ID, err)
+	}
+	return override, nil
+}
+
+// ValidateCallbackURL checks a per-job callback override. Overrides must use
+// HTTPS and, when an allowlist is configured, target one of its hosts.
+// Without an allowlist, internal addresses are refused so a job payload cannot
+// make the builder call into internal services; checkRestrictedDial repeats
+// the check on the resolved address when the callback is sent.
+func (c *Client) ValidateCallbackURL(raw string) error {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return fmt.Errorf("callback URL is not a valid URL: %w", err)
+	}
+	if !strings.EqualFold(parsed.Scheme, "https") {
+		return fmt.Errorf("callback URL must use https")
+	}
+	if parsed.User != nil {
+		return fmt.Errorf("callback URL must not contain credentials")
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if host == "" {
+		return fmt.Errorf("callback URL must include a host")
+	}
+
+	if len(c.allowedCallbackHosts) > 0 {
+		for _, allowed := range c.allowedCallbackHosts {
+			if callbackHostMatches(host, allowed) {
+				return nil
+			}
+		}
+		return fmt.Errorf("callback URL host %q is not allowed", host)
+	}
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("callback URL host %q is not allowed", host)
+	}
+	if ip := net.ParseIP(host); ip != nil && isInternalIP(ip) {
+		return fmt.Errorf("callback URL host %q is not allowed", host)
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which cloud
+// providers also use for internal services.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isInternalIP reports whether ip is loopback, private, link-local (including
+// the 169.254.169.254 metadata service), shared or unspecified.
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+// restrictedDialKey marks a request context whose connections must not reach
+// internal addresses.
+type restrictedDialKey struct{}
+
+func restrictedDial(ctx context.Context) bool {
+	restricted, _ := ctx.Value(restrictedDialKey{}).(bool)
+	return restricted
+}
+
+// checkRestrictedDial refuses to connect a restricted request to an internal
+// address. It runs after DNS resolution, so a public name that resolves to an
+// internal IP is caught too.
+func checkRestrictedDial(ctx context.Context, network, address string, _ syscall.RawConn) error {
+	if !restrictedDial(ctx) {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isInternalIP(ip) {
+		return fmt.Errorf("callback URL resolves to disallowed address %s", host)
+	}
+	return nil
+}
+
+func callbackHostMatches(host, pattern string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "" {
+		return false
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
 }
 
 func runtimeEnvKeys(plan []storage.ResolvedEnvVar) []string {
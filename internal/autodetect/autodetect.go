@@ -32,12 +32,22 @@ type BuildConfig struct {
 	UseStaticRuntime   bool     `json:"useStaticRuntime,omitempty"`
 	StaticOutputDir    string   `json:"staticOutputDir,omitempty"`
 	DockerfileContent  []byte   `json:"dockerfileContent"`
+	// BuildTool is the detected package manager or build tool, e.g. "pnpm",
+	// "maven" or "gradle".
+	BuildTool string `json:"buildTool,omitempty"`
+	// Confidence scores the detection from 0 to 1, and Signals lists the
+	// repository files and findings it rests on, e.g. "pnpm-lock.yaml" or
+	// "framework:next". Both are empty for configs that were not detected.
+	Confidence float64  `json:"confidence,omitempty"`
+	Signals    []string `json:"signals,omitempty"`
 }
 
 type nodePackageJSON struct {
 	Scripts         map[string]string `json:"scripts"`
 	Engines         map[string]string `json:"engines"`
 	PackageManager  string            `json:"packageManager"`
+	Main            string            `json:"main"`
+	Module          string            `json:"module"`
 	Dependencies    map[string]string `json:"dependencies"`
 	DevDependencies map[string]string `json:"devDependencies"`
 	Workspaces      interface{}       `json:"workspaces"`
@@ -46,6 +56,55 @@ type nodePackageJSON struct {
 type AutoDetectOptions struct {
 	RepoRoot   string
 	WorkingDir string
+	// BaseImage, when set, is used verbatim in place of the runtime-derived
+	// FROM images of the generated Dockerfile.
+	BaseImage string
+	// RunAs, when set, makes the generated image run as this user instead of
+	// the base image's default.
+	RunAs *ContainerUser
+	// Shell selects the shell the generated RUN steps use: "sh", the
+	// default, or "bash".
+	Shell string
+	// RuntimeEnvKeys are the env keys the job supplies at run time. When
+	// PORT is among them the generated Dockerfile does not set ENV PORT.
+	RuntimeEnvKeys []string
+	// Overrides replace single detected commands.
+	Overrides CommandOverrides
+}
+
+// CommandOverrides replace individual detected commands of an auto-build
+// while the rest stay detected. Empty fields keep the detected command.
+type CommandOverrides struct {
+	Prebuild string
+	Build    string
+	Run      string
+}
+
+// IsZero reports whether no command is overridden.
+func (o CommandOverrides) IsZero() bool {
+	return strings.TrimSpace(o.Prebuild) == "" && strings.TrimSpace(o.Build) == "" && strings.TrimSpace(o.Run) == ""
+}
+
+// ValidateCommandOverrides checks the overridden commands against the same
+// allowlist detected commands must pass.
+func ValidateCommandOverrides(overrides CommandOverrides, allowed *allowlist.AllowedCommands) error {
+	return validateBuildPlanCommands(buildPlan{
+		InstallCommand: strings.TrimSpace(overrides.Prebuild),
+		BuildCommand:   strings.TrimSpace(overrides.Build),
+		RunCommand:     strings.TrimSpace(overrides.Run),
+	}, allowed)
+}
+
+func applyCommandOverrides(plan *buildPlan, overrides CommandOverrides) {
+	if command := strings.TrimSpace(overrides.Prebuild); command != "" {
+		plan.InstallCommand = command
+	}
+	if command := strings.TrimSpace(overrides.Build); command != "" {
+		plan.BuildCommand = command
+	}
+	if command := strings.TrimSpace(overrides.Run); command != "" {
+		plan.RunCommand = command
+	}
 }
 
 func (c *BuildConfig) NormalizePhaseAliases() {
@@ -72,9 +131,7 @@ func detectCommandsWithPath(repoPath string, runtime string, allowed *allowlist.
 	case "node":
 		return detectNodeCommands(repoPath, allowed)
 	case "bun":
-		return pickAllowed("bun install", allowed.Prebuild),
-			pickAllowed("bun run build", allowed.Build),
-			pickAllowed("bun run start", allowed.Run)
+		return detectBunCommands(repoPath, allowed)
 	case "python":
 		return detectPythonCommands(repoPath, allowed)
 	case "elixir":
@@ -224,20 +281,6 @@ func detectGoCommands(repoPath string, allowed *allowlist.AllowedCommands) (stri
 		pickFirstAllowed(runCandidates, allowed.Run)
 }
 
-func detectDotnetCommands(repoPath string, allowed *allowlist.AllowedCommands) (string, string, string) {
-	projectName := detectDotnetProjectName(repoPath)
-	prebuildCandidates := []string{"dotnet restore"}
-	buildCandidates := []string{"dotnet publish -c Release -o out"}
-	runCandidates := []string{}
-	if projectName != "" {
-		runCandidates = append(runCandidates, "dotnet "+projectName+".dll")
-	}
-	runCandidates = append(runCandidates, "dotnet App.dll")
-	return pickFirstAllowed(prebuildCandidates, allowed.Prebuild),
-		pickFirstAllowed(buildCandidates, allowed.Build),
-		pickFirstAllowed(runCandidates, allowed.Run)
-}
-
 func detectGoEntrypoint(repoPath string) string {
 	if repoPath == "" {
 		return ""
@@ -344,30 +387,6 @@ func detectGoDependencyFiles(repoPath string) []string {
 	return files
 }
 
-func detectDotnetProjectName(repoPath string) string {
-	for _, path := range findFilesWithExtension(repoPath, ".csproj") {
-		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
-		name = strings.TrimSpace(name)
-		if name != "" {
-			return name
-		}
-	}
-	return ""
-}
-
-func detectDotnetDependencyFiles(repoPath string) []string {
-	files := make([]string, 0, 3)
-	for _, path := range findFilesWithExtension(repoPath, ".csproj") {
-		files = append(files, filepath.Base(path))
-	}
-	for _, name := range []string{"Directory.Build.props", "Directory.Build.targets", "global.json", "NuGet.Config"} {
-		if fileExists(filepath.Join(repoPath, name)) {
-			files = append(files, name)
-		}
-	}
-	return files
-}
-
 func detectGoFramework(repoRoot, appPath string) string {
 	beegoTokens := []string{"github.com/beego/beego/v2", "github.com/astaxie/beego"}
 	ginTokens := []string{"github.com/gin-gonic/gin"}
@@ -1044,6 +1063,34 @@ func detectNodeCommands(repoPath string, allowed *allowlist.AllowedCommands) (st
 		pickFirstAllowed(runCandidates, allowed.Run)
 }
 
+// detectBunCommands resolves Bun's commands against the allowlist like
+// detectNodeCommands. Without a package.json to read, a build and start
+// script are assumed.
+func detectBunCommands(repoPath string, allowed *allowlist.AllowedCommands) (string, string, string) {
+	prebuildCandidates := []string{"bun install"}
+	for _, lockfile := range []string{"bun.lockb", "bun.lock"} {
+		if repoPath != "" && fileExists(filepath.Join(repoPath, lockfile)) {
+			prebuildCandidates = []string{"bun install --frozen-lockfile", "bun install"}
+			break
+		}
+	}
+	scripts := map[string]string{"build": "", "start": ""}
+	if metadata := loadNodePackageJSON(repoPath); metadata != nil {
+		scripts = metadata.Scripts
+	}
+	var buildCandidates, runCandidates []string
+	if _, ok := scripts["build"]; ok {
+		buildCandidates = []string{"bun run build"}
+	}
+	if _, ok := scripts["start"]; ok {
+		runCandidates = []string{"bun run start", "bun start"}
+	}
+
+	return pickFirstAllowed(prebuildCandidates, allowed.Prebuild),
+		pickFirstAllowed(buildCandidates, allowed.Build),
+		pickFirstAllowed(runCandidates, allowed.Run)
+}
+
 func loadNodePackageJSON(repoPath string) *nodePackageJSON {
 	if repoPath == "" {
 		return nil
@@ -1346,16 +1393,6 @@ func hasBuildFileTokens(repoPath string, tokens []string) bool {
 	return false
 }
 
-func pickAllowed(preferred string, allowed []string) string {
-	if allowlist.IsCommandAllowed(preferred, allowed) {
-		return preferred
-	}
-	if len(allowed) > 0 {
-		return allowed[0]
-	}
-	return ""
-}
-
 func pickFirstAllowed(candidates []string, allowed []string) string {
 	for _, candidate := range candidates {
 		if allowlist.IsCommandAllowed(candidate, allowed) {
@@ -1378,6 +1415,21 @@ func AutoDetectBuildConfigWithEnvOptions(opts AutoDetectOptions, allowed *allowl
 	if err != nil {
 		return BuildConfig{}, err
 	}
+	if plan, err = withBaseImage(plan, opts.BaseImage); err != nil {
+		return BuildConfig{}, err
+	}
+	plan = withContainerUser(plan, opts.RunAs)
+	if plan, err = withBuildShell(plan, opts.Shell); err != nil {
+		return BuildConfig{}, err
+	}
+	plan = withJobRuntimeEnv(plan, opts.RuntimeEnvKeys)
+	if !opts.Overrides.IsZero() {
+		if err := ValidateCommandOverrides(opts.Overrides, allowed); err != nil {
+			return BuildConfig{}, err
+		}
+		applyCommandOverrides(&plan, opts.Overrides)
+	}
+	describeDetection(&plan, strings.TrimSpace(opts.RepoRoot))
 	return buildConfigFromPlan(plan, true, buildArgKeys, secretBuildKeys)
 }
 
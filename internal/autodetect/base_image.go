@@ -0,0 +1,50 @@
+package autodetect
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// imageReferencePattern follows the distribution reference grammar:
+// [registry[:port]/]name[/name...][:tag][@digest], with lowercase path
+// components.
+var imageReferencePattern = regexp.MustCompile(
+	`^(?:(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)(?:\.(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?))*(?::[0-9]+)?/)?` +
+		`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+		`(?::[A-Za-z0-9_][A-Za-z0-9_.-]{0,127})?` +
+		`(?:@[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,})?$`,
+)
+
+// ValidateBaseImage checks that image is a plain image reference such as
+// "registry.internal:5000/tools/node:20" or "node@sha256:...", so it can be
+// written into a FROM line verbatim.
+func ValidateBaseImage(image string) error {
+	if len(image) > 255 || !imageReferencePattern.MatchString(image) {
+		return fmt.Errorf("baseImage %q is not a valid image reference", image)
+	}
+	return nil
+}
+
+// withBaseImage replaces the runtime-derived images of plan with an explicit
+// base image. The file server of a static site is not derived from the
+// runtime, so static builds only change their build stage, if they have one.
+func withBaseImage(plan buildPlan, image string) (buildPlan, error) {
+	image = strings.TrimSpace(image)
+	if image == "" {
+		return plan, nil
+	}
+	if err := ValidateBaseImage(image); err != nil {
+		return plan, err
+	}
+	switch {
+	case !plan.UseStaticRuntime:
+		plan.BuilderImage = image
+		plan.RuntimeImage = image
+	case strings.TrimSpace(plan.BuilderImage) != "":
+		plan.BuilderImage = image
+	default:
+		plan.ValidationWarnings = appendUniqueString(plan.ValidationWarnings, "baseImage is ignored for static sites without a build step")
+	}
+	return plan, nil
+}
@@ -0,0 +1,71 @@
+package autodetect
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Shells the build steps of a generated Dockerfile can run with. sh is the
+// default of every base image; bash is for scripts that need arrays or [[ ]].
+const (
+	BuildShellSh   = "sh"
+	BuildShellBash = "bash"
+)
+
+// bashShellLines make sure bash exists, installing it on Alpine images, and
+// switch the stage's RUN steps to it.
+const bashShellLines = `RUN command -v bash >/dev/null 2>&1 || apk add --no-cache bash >/dev/null 2>&1 || { echo "buildConfig.shell is bash but the base image has no bash" >&2; exit 1; }
+SHELL ["/bin/bash", "-c"]`
+
+// ValidateBuildShell accepts an empty shell, which means sh, "sh" and "bash".
+func ValidateBuildShell(shell string) error {
+	switch strings.TrimSpace(shell) {
+	case "", BuildShellSh, BuildShellBash:
+		return nil
+	}
+	return fmt.Errorf("shell %q is not supported; use %q or %q", shell, BuildShellSh, BuildShellBash)
+}
+
+// withBuildShell makes plan run its build steps with shell. Static sites
+// without a build step have nothing to run.
+func withBuildShell(plan buildPlan, shell string) (buildPlan, error) {
+	shell = strings.TrimSpace(shell)
+	if err := ValidateBuildShell(shell); err != nil {
+		return plan, err
+	}
+	if shell != BuildShellBash {
+		return plan, nil
+	}
+	if plan.UseStaticRuntime && strings.TrimSpace(plan.BuilderImage) == "" {
+		plan.ValidationWarnings = appendUniqueString(plan.ValidationWarnings, "shell is ignored for static sites without a build step")
+		return plan, nil
+	}
+	plan.Shell = shell
+	return plan, nil
+}
+
+// addBuildShell switches every build stage of dockerfile to bash right after
+// its FROM line. The last stage of a multi-stage build only runs the app, so
+// it keeps the runtime image's shell.
+func addBuildShell(dockerfile []byte) []byte {
+	lines := strings.Split(string(dockerfile), "\n")
+	var stages []int
+	for i, line := range lines {
+		if fields := strings.Fields(line); len(fields) > 0 && strings.EqualFold(fields[0], "FROM") {
+			stages = append(stages, i)
+		}
+	}
+	if len(stages) > 1 {
+		stages = stages[:len(stages)-1]
+	}
+	out := make([]string, 0, len(lines)+2*len(stages))
+	next := 0
+	for i, line := range lines {
+		out = append(out, line)
+		if next < len(stages) && stages[next] == i {
+			out = append(out, bashShellLines)
+			next++
+		}
+	}
+	return []byte(strings.Join(out, "\n"))
+}
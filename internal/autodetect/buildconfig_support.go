@@ -41,14 +41,34 @@ func FinalizeBuildConfigWithEnvOptions(opts AutoDetectOptions, cfg BuildConfig,
 	if err != nil {
 		return BuildConfig{}, err
 	}
+	if plan, err = withBaseImage(plan, opts.BaseImage); err != nil {
+		return BuildConfig{}, err
+	}
+	plan = withContainerUser(plan, opts.RunAs)
+	if plan, err = withBuildShell(plan, opts.Shell); err != nil {
+		return BuildConfig{}, err
+	}
+	plan = withJobRuntimeEnv(plan, opts.RuntimeEnvKeys)
 	return buildConfigFromPlan(plan, false, buildArgKeys, secretBuildKeys)
 }
 
 func buildConfigFromPlan(plan buildPlan, isAutoBuild bool, buildArgKeys, secretBuildKeys []string) (BuildConfig, error) {
+	buildArgKeys, secretBuildKeys, keyWarnings := classifyBuildEnvKeys(buildArgKeys, secretBuildKeys)
+	for _, warning := range keyWarnings {
+		plan.ValidationWarnings = appendUniqueString(plan.ValidationWarnings, warning)
+	}
 	dockerfile, err := generateDockerfileForPlan(plan, buildArgKeys, secretBuildKeys)
 	if err != nil {
 		return BuildConfig{}, err
 	}
+	lint := LintDockerfile(dockerfile)
+	if len(lint.Errors) > 0 {
+		return BuildConfig{}, fmt.Errorf("generated Dockerfile failed validation: %s", strings.Join(lint.Errors, "; "))
+	}
+	warnings := cloneStringSlice(plan.ValidationWarnings)
+	for _, warning := range lint.Warnings {
+		warnings = appendUniqueString(warnings, warning)
+	}
 
 	cfg := BuildConfig{
 		IsAutoBuild:        isAutoBuild,
@@ -65,10 +85,13 @@ func buildConfigFromPlan(plan buildPlan, isAutoBuild bool, buildArgKeys, secretB
 		ExposePort:         strings.TrimSpace(plan.ExposePort),
 		BuildContextDir:    normalizePlanDirOrDefault(plan.BuildContextDir, "."),
 		AppDir:             normalizePlanDirOrDefault(plan.AppDir, "."),
-		ValidationWarnings: cloneStringSlice(plan.ValidationWarnings),
+		ValidationWarnings: warnings,
 		UseStaticRuntime:   plan.UseStaticRuntime,
 		StaticOutputDir:    strings.TrimSpace(plan.StaticOutputDir),
 		DockerfileContent:  dockerfile,
+		BuildTool:          plan.BuildTool,
+		Confidence:         detectionConfidence(plan),
+		Signals:            cloneStringSlice(plan.Signals),
 	}
 	cfg.NormalizePhaseAliases()
 	return cfg, nil
@@ -137,6 +160,7 @@ func manualBuildPlanFromConfig(opts AutoDetectOptions, cfg BuildConfig) (buildPl
 }
 
 func manualJavaScriptBuildPlan(repoRoot, appDir, appPath, runtime, version, buildContextDir string, cfg BuildConfig) (buildPlan, error) {
+	version, versionWarning := resolveJavaScriptImageVersion(runtime, version)
 	ctx := newJSProjectContext(repoRoot, appDir, appPath, runtime, version)
 	if buildContextDir != "" {
 		ctx.BuildContextDir = buildContextDir
@@ -216,6 +240,9 @@ func manualJavaScriptBuildPlan(repoRoot, appDir, appPath, runtime, version, buil
 		AptPackages: nil,
 		appWorkDir:  ctx.appWorkDir,
 	}
+	if versionWarning != "" {
+		plan.ValidationWarnings = appendUniqueString(plan.ValidationWarnings, versionWarning)
+	}
 	if canInstallWithoutFullSource(ctx) {
 		plan.DependencyFiles = detectJavaScriptDependencyFiles(ctx)
 	}
@@ -315,6 +342,10 @@ func manualRuntimeBuildPlan(appPath, runtime, version, appDir, buildContextDir s
 		if err := applyPHPPlanDefaults(appPath, &plan); err != nil {
 			return buildPlan{}, err
 		}
+	case "go":
+		for _, warning := range goVersionWarnings(plan.Version) {
+			plan.ValidationWarnings = appendUniqueString(plan.ValidationWarnings, warning)
+		}
 	}
 
 	if strings.TrimSpace(cfg.ExposePort) != "" {
@@ -447,14 +478,7 @@ func detectCommandsWithoutAllowlist(repoPath, runtime string) (string, string, s
 		runCandidates := []string{"./app"}
 		return pickFirstNonEmpty(prebuildCandidates), pickFirstNonEmpty(buildCandidates), pickFirstNonEmpty(runCandidates)
 	case "dotnet":
-		projectName := detectDotnetProjectName(repoPath)
-		prebuildCandidates := []string{"dotnet restore"}
-		buildCandidates := []string{"dotnet publish -c Release -o out"}
-		runCandidates := []string{}
-		if projectName != "" {
-			runCandidates = append(runCandidates, "dotnet "+projectName+".dll")
-		}
-		runCandidates = append(runCandidates, "dotnet App.dll")
+		prebuildCandidates, buildCandidates, runCandidates := dotnetCommandCandidates(repoPath)
 		return pickFirstNonEmpty(prebuildCandidates), pickFirstNonEmpty(buildCandidates), pickFirstNonEmpty(runCandidates)
 	case "java":
 		isGradle := fileExists(filepath.Join(repoPath, "build.gradle")) || fileExists(filepath.Join(repoPath, "build.gradle.kts"))
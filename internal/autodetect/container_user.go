@@ -0,0 +1,78 @@
+package autodetect
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ContainerUser is the numeric user and group the generated image runs as.
+// The app directory is owned by the group and group-writable, so a runtime
+// that starts the container with an arbitrary UID in that group, as OpenShift
+// does with GID 0, can still write to it.
+type ContainerUser struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+// withContainerUser makes plan run as user. Static sites are served by nginx
+// as it ships, so they keep its default user.
+func withContainerUser(plan buildPlan, user *ContainerUser) buildPlan {
+	if user == nil {
+		return plan
+	}
+	if plan.UseStaticRuntime {
+		plan.ValidationWarnings = appendUniqueString(plan.ValidationWarnings, "runAs is ignored for static sites served by nginx")
+		return plan
+	}
+	if port, err := strconv.Atoi(strings.TrimSpace(plan.ExposePort)); err == nil && port > 0 && port < 1024 {
+		plan.ValidationWarnings = appendUniqueString(plan.ValidationWarnings, fmt.Sprintf("the app listens on port %d, which a non-root user may not be allowed to bind", port))
+	}
+	plan.RunAs = &ContainerUser{UID: user.UID, GID: user.GID}
+	return plan
+}
+
+// renderContainerUserLines creates the group and an "app" user with the
+// requested IDs unless the image already has them, using the shadow tools on
+// Debian-based images and BusyBox on Alpine, and hands /app to the group.
+func renderContainerUserLines(user ContainerUser) string {
+	uid, gid := strconv.Itoa(user.UID), strconv.Itoa(user.GID)
+	var builder strings.Builder
+	builder.WriteString("RUN if ! getent group " + gid + " >/dev/null; then groupadd -g " + gid + " app 2>/dev/null || addgroup -S -g " + gid + " app; fi \\\n")
+	builder.WriteString("    && if ! getent passwd " + uid + " >/dev/null; then useradd -u " + uid + " -g " + gid + " -d /app -M -s /sbin/nologin app 2>/dev/null || adduser -S -D -H -h /app -u " + uid + " -G \"$(getent group " + gid + " | cut -d: -f1)\" app; fi \\\n")
+	builder.WriteString("    && chgrp -R " + gid + " /app && chmod -R g=u /app\n")
+	builder.WriteString("USER " + uid + ":" + gid + "\n")
+	return builder.String()
+}
+
+// addContainerUser inserts the user setup into the final stage of dockerfile,
+// after its last build step and before the EXPOSE, HEALTHCHECK, ENTRYPOINT
+// and CMD lines that end it.
+func addContainerUser(dockerfile []byte, user ContainerUser) []byte {
+	lines := strings.Split(strings.TrimRight(string(dockerfile), "\n"), "\n")
+	at := len(lines)
+	for at > 0 && isDockerfileTailLine(lines[at-1]) {
+		at--
+	}
+	var builder strings.Builder
+	builder.WriteString(strings.Join(lines[:at], "\n"))
+	builder.WriteString("\n\n")
+	builder.WriteString(renderContainerUserLines(user))
+	if at < len(lines) {
+		builder.WriteString(strings.Join(lines[at:], "\n"))
+		builder.WriteString("\n")
+	}
+	return []byte(builder.String())
+}
+
+func isDockerfileTailLine(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return true
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "EXPOSE", "HEALTHCHECK", "ENTRYPOINT", "CMD":
+		return true
+	}
+	return false
+}
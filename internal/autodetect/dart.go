@@ -0,0 +1,125 @@
+package autodetect
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// flutterBuilderImage carries the Flutter SDK; its Dart version follows the
+// Flutter release rather than the pubspec SDK constraint.
+const flutterBuilderImage = "ghcr.io/cirruslabs/flutter:stable"
+
+var (
+	pubspecNamePattern       = regexp.MustCompile(`(?m)^name:\s*['"]?([A-Za-z0-9_]+)`)
+	pubspecDartSDKPattern    = regexp.MustCompile(`(?m)^\s+sdk:\s*['"]?[\^>=~ ]*(\d+\.\d+)`)
+	pubspecFlutterSDKPattern = regexp.MustCompile(`(?m)^\s+flutter:\s*['"]?[\^<>=~ ]*\d`)
+	pubspecFlutterDepPattern = regexp.MustCompile(`(?m)^\s+sdk:\s*flutter\b`)
+	pubspecShelfPattern      = regexp.MustCompile(`(?m)^\s+shelf:`)
+)
+
+func isDartProject(appPath string) bool {
+	return appPath != "" && fileExists(filepath.Join(appPath, "pubspec.yaml"))
+}
+
+// isFlutterProject reports whether pubspec.yaml constrains the Flutter SDK,
+// either under environment or through a "sdk: flutter" dependency.
+func isFlutterProject(appPath string) bool {
+	pubspec := readFileLimited(filepath.Join(appPath, "pubspec.yaml"))
+	return pubspecFlutterSDKPattern.MatchString(pubspec) || pubspecFlutterDepPattern.MatchString(pubspec)
+}
+
+// detectDartVersion reads the lower bound of the Dart SDK constraint in
+// pubspec.yaml, e.g. "3.3" for "sdk: ^3.3.0" or ">=3.3.0 <4.0.0".
+func detectDartVersion(repoRoot, appPath string) string {
+	for _, base := range versionSearchPaths(appPath, repoRoot) {
+		pubspec := readFileLimited(filepath.Join(base, "pubspec.yaml"))
+		if match := pubspecDartSDKPattern.FindStringSubmatch(pubspec); len(match) == 2 {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// detectDartBuildPlan builds Flutter apps for the web and serves build/web
+// with nginx. Other Dart projects compile their bin/ entrypoint to a native
+// executable.
+func detectDartBuildPlan(appDir, appPath, version string) (buildPlan, error) {
+	dependencyFiles := []string{"pubspec.yaml"}
+	if fileExists(filepath.Join(appPath, "pubspec.lock")) {
+		dependencyFiles = append(dependencyFiles, "pubspec.lock")
+	}
+
+	if isFlutterProject(appPath) {
+		if !fileExists(filepath.Join(appPath, "web", "index.html")) {
+			return buildPlan{}, fmt.Errorf("Flutter project has no web/index.html; only Flutter web apps can be built, run `flutter create --platforms web .` to add web support")
+		}
+		return buildPlan{
+			Runtime:          "dart",
+			Framework:        "flutter",
+			Version:          version,
+			InstallCommand:   "flutter pub get",
+			DependencyFiles:  dependencyFiles,
+			BuildCommand:     "flutter build web --release",
+			ExposePort:       "8080",
+			BuildContextDir:  appDir,
+			AppDir:           appDir,
+			BuilderImage:     flutterBuilderImage,
+			RuntimeImage:     "nginx:alpine",
+			StaticOutputDir:  "build/web",
+			UseStaticRuntime: true,
+			BuildTool:        "flutter",
+		}, nil
+	}
+
+	entrypoint := detectDartEntrypoint(appPath)
+	if entrypoint == "" {
+		return buildPlan{}, fmt.Errorf("Dart project has no bin/*.dart entrypoint to compile")
+	}
+	plan := buildPlan{
+		Runtime:         "dart",
+		Framework:       detectDartFramework(appPath),
+		Version:         version,
+		InstallCommand:  "dart pub get",
+		DependencyFiles: dependencyFiles,
+		BuildCommand:    "dart compile exe " + entrypoint + " -o app",
+		RunCommand:      "./app",
+		ExposePort:      "8080",
+		BuildContextDir: appDir,
+		AppDir:          appDir,
+		BuilderImage:    "dart:" + version,
+		RuntimeImage:    "debian:bookworm-slim",
+		AptPackages:     []string{"ca-certificates"},
+		RuntimeEnv:      map[string]string{"PORT": "8080"},
+		BuildTool:       "dart",
+	}
+	return plan, nil
+}
+
+// detectDartEntrypoint picks bin/server.dart, then bin/<package name>.dart,
+// then the first other file in bin/.
+func detectDartEntrypoint(appPath string) string {
+	candidates := []string{"server.dart"}
+	if match := pubspecNamePattern.FindStringSubmatch(readFileLimited(filepath.Join(appPath, "pubspec.yaml"))); len(match) == 2 {
+		candidates = append(candidates, match[1]+".dart")
+	}
+	for _, name := range candidates {
+		if fileExists(filepath.Join(appPath, "bin", name)) {
+			return "bin/" + name
+		}
+	}
+	files := findFilesWithExtension(filepath.Join(appPath, "bin"), ".dart")
+	if len(files) == 0 {
+		return ""
+	}
+	sort.Strings(files)
+	return "bin/" + filepath.Base(files[0])
+}
+
+func detectDartFramework(appPath string) string {
+	if pubspecShelfPattern.MatchString(readFileLimited(filepath.Join(appPath, "pubspec.yaml"))) {
+		return "shelf"
+	}
+	return ""
+}
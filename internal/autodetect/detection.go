@@ -0,0 +1,179 @@
+package autodetect
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// detectionSignalFiles are the repository files, per runtime, that identify
+// the runtime and its build tool. They are reported in this order.
+var detectionSignalFiles = map[string][]string{
+	"node":   {"package.json", "package-lock.json", "npm-shrinkwrap.json", "pnpm-lock.yaml", "pnpm-workspace.yaml", "yarn.lock", ".nvmrc", ".node-version"},
+	"bun":    {"package.json", "bun.lock", "bun.lockb", "bunfig.toml"},
+	"python": {"requirements.txt", "requirements.in", "pyproject.toml", "poetry.lock", "uv.lock", "Pipfile", "Pipfile.lock", "setup.py", ".python-version", "runtime.txt"},
+	"go":     {"go.mod", "go.sum"},
+	"rust":   {"Cargo.toml", "Cargo.lock"},
+	"java":   {"pom.xml", "mvnw", "build.gradle", "build.gradle.kts", "gradlew", "settings.gradle", "settings.gradle.kts"},
+	"php":    {"composer.json", "composer.lock", "artisan"},
+	"elixir": {"mix.exs", "mix.lock"},
+	"dart":   {"pubspec.yaml", "pubspec.lock"},
+	"ruby":   {"Gemfile", "Gemfile.lock", ".ruby-version"},
+	"dotnet": {"global.json"},
+	"static": {"index.html"},
+}
+
+// buildToolPinFiles are lockfiles and wrappers that settle the build tool
+// rather than leaving it to a default.
+var buildToolPinFiles = map[string]bool{
+	"package-lock.json":   true,
+	"npm-shrinkwrap.json": true,
+	"pnpm-lock.yaml":      true,
+	"yarn.lock":           true,
+	"bun.lock":            true,
+	"bun.lockb":           true,
+	"poetry.lock":         true,
+	"uv.lock":             true,
+	"Pipfile.lock":        true,
+	"go.sum":              true,
+	"Cargo.lock":          true,
+	"mvnw":                true,
+	"gradlew":             true,
+	"composer.lock":       true,
+	"mix.lock":            true,
+	"pubspec.lock":        true,
+	"Gemfile.lock":        true,
+}
+
+// describeDetection records on plan the build tool it uses and the
+// repository signals it was detected from.
+func describeDetection(plan *buildPlan, repoRoot string) {
+	appDir := normalizePlanDirOrDefault(plan.AppDir, ".")
+	appPath := filepath.Join(repoRoot, filepath.FromSlash(appDir))
+
+	if plan.BuildTool == "" {
+		plan.BuildTool = detectBuildTool(*plan, appPath)
+	}
+
+	seen := make(map[string]bool)
+	addSignal := func(signal string) {
+		if signal != "" && !seen[signal] {
+			seen[signal] = true
+			plan.Signals = append(plan.Signals, signal)
+		}
+	}
+	for _, name := range detectionSignalFiles[plan.Runtime] {
+		if fileExists(filepath.Join(appPath, name)) {
+			addSignal(filepath.ToSlash(filepath.Join(appDir, name)))
+		} else if appPath != repoRoot && fileExists(filepath.Join(repoRoot, name)) {
+			addSignal(name)
+		}
+	}
+	if plan.Runtime == "dotnet" {
+		addSignal(firstFileWithExtension(appPath, appDir, ".csproj"))
+		addSignal(firstFileWithExtension(appPath, appDir, ".sln"))
+	}
+	if plan.BuildTool != "" {
+		addSignal("buildTool:" + plan.BuildTool)
+	}
+	if plan.Framework != "" {
+		addSignal("framework:" + plan.Framework)
+	}
+}
+
+// detectBuildTool names the package manager or build tool of plan. Node and
+// Bun plans set it themselves.
+func detectBuildTool(plan buildPlan, appPath string) string {
+	switch plan.Runtime {
+	case "java":
+		if isGradleJavaProject(appPath) {
+			return "gradle"
+		}
+		return "maven"
+	case "python":
+		install := plan.InstallCommand
+		switch {
+		case strings.Contains(install, "poetry"):
+			return "poetry"
+		case strings.Contains(install, "pipenv"):
+			return "pipenv"
+		case strings.Contains(install, "uv "):
+			return "uv"
+		case strings.Contains(install, "pip-compile"):
+			return "pip-tools"
+		case strings.Contains(install, "pip "):
+			return "pip"
+		}
+	case "go":
+		return "go"
+	case "rust":
+		return "cargo"
+	case "dotnet":
+		return "dotnet"
+	case "elixir":
+		return "mix"
+	case "php":
+		if fileExists(filepath.Join(appPath, "composer.json")) {
+			return "composer"
+		}
+	case "static":
+		if plan.Framework != "static-site" {
+			return plan.Framework
+		}
+	}
+	return ""
+}
+
+// detectionConfidence scores a detection from 0 to 1. A runtime manifest
+// counts for half; a lockfile or wrapper that pins the build tool, a
+// recognised framework and a known start command make up the rest. Plans
+// without signals were not detected and score 0.
+func detectionConfidence(plan buildPlan) float64 {
+	var manifest, pinned bool
+	for _, signal := range plan.Signals {
+		switch {
+		case strings.HasPrefix(signal, "buildTool:"), strings.HasPrefix(signal, "framework:"):
+		case buildToolPinFiles[filepath.Base(signal)]:
+			pinned = true
+		default:
+			manifest = true
+		}
+	}
+	if !manifest && !pinned {
+		return 0
+	}
+
+	percent := 0
+	if manifest {
+		percent += 50
+	}
+	if pinned {
+		percent += 20
+	}
+	if plan.Framework != "" && plan.Framework != "static-site" {
+		percent += 20
+	}
+	if strings.TrimSpace(plan.RunCommand) != "" || plan.UseStaticRuntime {
+		percent += 10
+	}
+	return float64(percent) / 100
+}
+
+func firstFileWithExtension(dir, relDir, ext string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ext) {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return filepath.ToSlash(filepath.Join(relDir, names[0]))
+}
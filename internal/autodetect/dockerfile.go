@@ -3,6 +3,7 @@ package autodetect
 import (
 	"encoding/json"
 	"fmt"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -75,7 +76,7 @@ func defaultBuildPlan(runtime, version, installCommand, buildCommand, runCommand
 			BuildCommand:   buildCommand,
 			RunCommand:     runCommand,
 			ExposePort:     "8080",
-			BuilderImage:   "golang:" + version + "-alpine",
+			BuilderImage:   selectGoBuilderImage(version),
 			RuntimeImage:   "alpine:3.20",
 		}, nil
 	case "rust":
@@ -142,6 +143,36 @@ func defaultBuildPlan(runtime, version, installCommand, buildCommand, runCommand
 				"PORT":    "8080",
 			},
 		}, nil
+	case "ruby":
+		return buildPlan{
+			Runtime:        "ruby",
+			Version:        version,
+			InstallCommand: installCommand,
+			BuildCommand:   buildCommand,
+			RunCommand:     runCommand,
+			ExposePort:     "3000",
+			BuilderImage:   "ruby:" + version + "-slim",
+			AptPackages:    []string{"build-essential"},
+			RuntimeEnv: map[string]string{
+				"RACK_ENV": "production",
+				"PORT":     "3000",
+			},
+		}, nil
+	case "dart":
+		return buildPlan{
+			Runtime:        "dart",
+			Version:        version,
+			InstallCommand: installCommand,
+			BuildCommand:   buildCommand,
+			RunCommand:     runCommand,
+			ExposePort:     "8080",
+			BuilderImage:   "dart:" + version,
+			RuntimeImage:   "debian:bookworm-slim",
+			AptPackages:    []string{"ca-certificates"},
+			RuntimeEnv: map[string]string{
+				"PORT": "8080",
+			},
+		}, nil
 	case "static":
 		return buildPlan{
 			Runtime:          "static",
@@ -182,8 +213,23 @@ func selectJavaRuntimeImage(version string) string {
 }
 
 func generateDockerfileForPlan(plan buildPlan, buildArgKeys, secretBuildKeys []string) ([]byte, error) {
-	buildArgKeys = normalizeKeys(buildArgKeys)
-	secretBuildKeys = normalizeKeys(secretBuildKeys)
+	dockerfile, err := renderDockerfileForPlan(plan, buildArgKeys, secretBuildKeys)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Shell == BuildShellBash {
+		dockerfile = addBuildShell(dockerfile)
+	}
+	if plan.RunAs != nil {
+		dockerfile = addContainerUser(dockerfile, *plan.RunAs)
+	}
+	return dockerfile, nil
+}
+
+func renderDockerfileForPlan(plan buildPlan, buildArgKeys, secretBuildKeys []string) ([]byte, error) {
+	buildArgKeys, secretBuildKeys, _ = classifyBuildEnvKeys(buildArgKeys, secretBuildKeys)
+	plan = withRunCommandPortDefault(plan)
+	plan = withoutJobPort(plan)
 
 	switch {
 	case plan.UseStaticRuntime:
@@ -510,7 +556,7 @@ func runtimeAptPackages(plan buildPlan) []string {
 	switch strings.TrimSpace(strings.ToLower(plan.Runtime)) {
 	case "python":
 		return plan.AptPackages
-	case "rust":
+	case "rust", "dart":
 		return plan.AptPackages
 	default:
 		return nil
@@ -519,7 +565,7 @@ func runtimeAptPackages(plan buildPlan) []string {
 
 func shouldUseMultiStage(plan buildPlan) bool {
 	switch strings.TrimSpace(strings.ToLower(plan.Runtime)) {
-	case "php":
+	case "php", "ruby":
 		return false
 	default:
 		return true
@@ -662,7 +708,6 @@ func renderSimpleFlaskDockerfile(plan buildPlan, buildArgKeys, secretBuildKeys [
 		builderImage = "python:3-slim"
 	}
 	fmt.Fprintf(&builder, "FROM %s\n\n", builderImage)
-	builder.WriteString("ENV PYTHONUNBUFFERED=1\n\n")
 	builder.WriteString("WORKDIR /app\n\n")
 
 	if argLines := renderArgLines(buildArgKeys); argLines != "" {
@@ -678,6 +723,10 @@ func renderSimpleFlaskDockerfile(plan buildPlan, buildArgKeys, secretBuildKeys [
 	if runLine := renderRunLine(plan.InstallCommand, secretBuildKeys); runLine != "" {
 		builder.WriteString(runLine)
 	}
+	if envLines := renderEnvLines(plan.RuntimeEnv); envLines != "" {
+		builder.WriteString("\n")
+		builder.WriteString(envLines)
+	}
 	if strings.TrimSpace(plan.ExposePort) != "" {
 		fmt.Fprintf(&builder, "\nEXPOSE %s\n", strings.TrimSpace(plan.ExposePort))
 	}
@@ -750,9 +799,22 @@ func renderDotnetDockerfile(plan buildPlan, buildArgKeys, secretBuildKeys []stri
 		builder.WriteString(argLines)
 	}
 	if depFiles := normalizeDependencyFiles(plan.DependencyFiles); len(depFiles) > 0 {
-		builder.WriteString("COPY ")
-		builder.WriteString(strings.Join(depFiles, " "))
-		builder.WriteString(" ./\n\n")
+		// Projects listed by a solution keep their directories so the
+		// solution and project references still resolve during restore.
+		rootFiles := make([]string, 0, len(depFiles))
+		nestedLines := make([]string, 0)
+		for _, file := range depFiles {
+			if dir := path.Dir(file); dir != "." {
+				nestedLines = append(nestedLines, fmt.Sprintf("COPY %s %s/\n", file, dir))
+				continue
+			}
+			rootFiles = append(rootFiles, file)
+		}
+		if len(rootFiles) > 0 {
+			fmt.Fprintf(&builder, "COPY %s ./\n", strings.Join(rootFiles, " "))
+		}
+		builder.WriteString(strings.Join(nestedLines, ""))
+		builder.WriteString("\n")
 	}
 	if runLine := renderRunLine(plan.InstallCommand, secretBuildKeys); runLine != "" {
 		builder.WriteString(runLine)
@@ -940,7 +1002,10 @@ func renderStaticDockerfile(plan buildPlan, buildArgKeys, secretBuildKeys []stri
 	builder.WriteString("}\n")
 	builder.WriteString("EOF\n")
 
-	fmt.Fprintf(&builder, "\nENV PORT=%s\n\n", exposePort)
+	builder.WriteString("\n")
+	if !plan.JobSetsPort {
+		fmt.Fprintf(&builder, "ENV PORT=%s\n\n", exposePort)
+	}
 	builder.WriteString("EXPOSE 80\n")
 	if exposePort != "80" {
 		fmt.Fprintf(&builder, "EXPOSE %s\n", exposePort)
@@ -968,7 +1033,18 @@ func renderRunLine(command string, secretBuildKeys []string) string {
 		return ""
 	}
 
-	return fmt.Sprintf("RUN %s\n", command)
+	return fmt.Sprintf("RUN %s%s\n", secretMountFlags(secretBuildKeys), command)
+}
+
+// secretMountFlags mounts each build secret into the RUN step as an
+// environment variable of the same name, so commands read secrets the way
+// they read build args without the value landing in an image layer.
+func secretMountFlags(secretBuildKeys []string) string {
+	var builder strings.Builder
+	for _, key := range secretBuildKeys {
+		fmt.Fprintf(&builder, "--mount=type=secret,id=%s,env=%s ", key, key)
+	}
+	return builder.String()
 }
 
 type cacheMount struct {
@@ -982,7 +1058,7 @@ func renderRunLineWithCaches(command string, caches []cacheMount, secretBuildKey
 		return ""
 	}
 
-	return fmt.Sprintf("RUN %s\n", command)
+	return fmt.Sprintf("RUN %s%s\n", secretMountFlags(secretBuildKeys), command)
 }
 
 func buildCacheMounts(plan buildPlan) []cacheMount {
@@ -1288,6 +1364,22 @@ func normalizeKeys(keys []string) []string {
 	return out
 }
 
+// classifyBuildEnvKeys normalizes the build arg and secret keys so each key is
+// exactly one of the two. A key listed as both is kept only as a secret, so
+// its value never lands in an ARG, and a warning names it.
+func classifyBuildEnvKeys(buildArgKeys, secretBuildKeys []string) ([]string, []string, []string) {
+	secretBuildKeys = normalizeKeys(secretBuildKeys)
+	var args, warnings []string
+	for _, key := range normalizeKeys(buildArgKeys) {
+		if containsKey(secretBuildKeys, key) {
+			warnings = append(warnings, fmt.Sprintf("build env key %q is marked both as a build arg and a secret; passing it only as a secret", key))
+			continue
+		}
+		args = append(args, key)
+	}
+	return args, secretBuildKeys, warnings
+}
+
 func containsKey(keys []string, needle string) bool {
 	needle = strings.TrimSpace(needle)
 	if needle == "" || len(keys) == 0 {
@@ -34,7 +34,7 @@ func AuditDockerfileWithOptions(opts AutoDetectOptions, dockerfilePath string) D
 	}
 
 	runtime, version := DetectRuntimeWithContext(repoRoot, appPath)
-	result := DockerfileAuditResult{}
+	result := LintDockerfile(data)
 	lower := strings.ToLower(string(data))
 
 	for _, marker := range []string{
@@ -0,0 +1,339 @@
+package autodetect
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type dockerfileInstruction struct {
+	line    int
+	keyword string
+	args    string
+}
+
+type dockerfileStage struct {
+	name         string
+	base         string
+	line         int
+	startCommand bool
+}
+
+type declaredArg struct {
+	name     string
+	line     int
+	stage    int
+	consumed bool
+}
+
+var (
+	dockerfileHeredocPattern = regexp.MustCompile(`<<-?["']?([A-Za-z_][A-Za-z0-9_]*)["']?`)
+	dockerfileVarRefPattern  = regexp.MustCompile(`\$(?:\{([A-Za-z_][A-Za-z0-9_]*)[^}]*\}|([A-Za-z_][A-Za-z0-9_]*))`)
+	secretValuePatterns      = []*regexp.Regexp{
+		regexp.MustCompile(`^AKIA[0-9A-Z]{16}$`),
+		regexp.MustCompile(`^gh[pousr]_[A-Za-z0-9]{20,}$`),
+		regexp.MustCompile(`^(sk|rk)_(live|test)_[A-Za-z0-9]{10,}$`),
+		regexp.MustCompile(`^xox[abpr]-[A-Za-z0-9-]{10,}$`),
+		regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+	}
+	secretEnvKeyMarkers = []string{
+		"SECRET",
+		"TOKEN",
+		"PASSWORD",
+		"PASSWD",
+		"PRIVATE_KEY",
+		"API_KEY",
+		"ACCESS_KEY",
+		"CREDENTIAL",
+	}
+	publicEnvKeyPrefixes = []string{
+		"NEXT_PUBLIC_",
+		"VITE_",
+		"REACT_APP_",
+		"NUXT_PUBLIC_",
+		"PUBLIC_",
+		"EXPO_PUBLIC_",
+		"GATSBY_",
+	}
+)
+
+// LintDockerfile performs static checks that need no build: a missing FROM is
+// an error, while ARGs nothing consumes, ENV instructions that bake a
+// plaintext secret into the image and a final stage without CMD or
+// ENTRYPOINT are warnings. DOCKERFILE_REQUIRE_CMD makes the last one an error.
+func LintDockerfile(content []byte) DockerfileAuditResult {
+	instructions := parseDockerfileInstructions(string(content))
+	result := DockerfileAuditResult{}
+
+	stage := -1
+	var stages []dockerfileStage
+	var args []*declaredArg
+	for _, instruction := range instructions {
+		if instruction.keyword == "FROM" {
+			markArgReferences(args, instruction.args, stage, true)
+			stage++
+			stages = append(stages, parseFromStage(instruction))
+			continue
+		}
+		if stage < 0 && instruction.keyword != "ARG" {
+			result.Errors = append(result.Errors, fmt.Sprintf("Dockerfile line %d: %s appears before the first FROM instruction", instruction.line, instruction.keyword))
+			continue
+		}
+
+		switch instruction.keyword {
+		case "ARG":
+			for _, name := range argNames(instruction.args) {
+				// Redeclaring a global ARG inside a stage is how it is consumed.
+				for _, arg := range args {
+					if arg.stage == -1 && arg.name == name {
+						arg.consumed = true
+					}
+				}
+				args = append(args, &declaredArg{name: name, line: instruction.line, stage: stage})
+			}
+		case "RUN":
+			// ARGs are exported to RUN commands, which may read them implicitly.
+			for _, arg := range args {
+				if arg.stage == stage {
+					arg.consumed = true
+				}
+			}
+		case "ENV":
+			result.Warnings = append(result.Warnings, plaintextSecretEnvWarnings(instruction)...)
+		case "CMD", "ENTRYPOINT":
+			stages[stage].startCommand = true
+		}
+		markArgReferences(args, instruction.args, stage, false)
+	}
+
+	if stage < 0 {
+		result.Errors = append(result.Errors, "Dockerfile has no FROM instruction")
+	} else if !stageHasStartCommand(stages, stage) {
+		message := fmt.Sprintf("Dockerfile line %d: the final stage sets no CMD or ENTRYPOINT, so the container exits immediately unless its base image defines one", stages[stage].line)
+		if requireStartCommandFromEnv() {
+			result.Errors = append(result.Errors, message)
+		} else {
+			result.Warnings = append(result.Warnings, message)
+		}
+	}
+	for _, arg := range args {
+		if !arg.consumed {
+			result.Warnings = append(result.Warnings, fmt.Sprintf("Dockerfile line %d: ARG %s is declared but never referenced", arg.line, arg.name))
+		}
+	}
+
+	result.Warnings = uniqueSortedStrings(result.Warnings)
+	result.Errors = uniqueSortedStrings(result.Errors)
+	return result
+}
+
+func parseDockerfileInstructions(content string) []dockerfileInstruction {
+	var instructions []dockerfileInstruction
+	var current strings.Builder
+	startLine := 0
+	heredocTerminator := ""
+
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for index, raw := range lines {
+		line := strings.TrimSpace(raw)
+		if heredocTerminator != "" {
+			// Heredoc bodies belong to the preceding instruction.
+			if line == heredocTerminator {
+				heredocTerminator = ""
+				continue
+			}
+			last := &instructions[len(instructions)-1]
+			last.args += "\n" + line
+			continue
+		}
+		if strings.HasPrefix(line, "#") || (line == "" && current.Len() == 0) {
+			continue
+		}
+		if current.Len() == 0 {
+			startLine = index + 1
+		}
+		if strings.HasSuffix(line, "\\") {
+			current.WriteString(strings.TrimSuffix(line, "\\"))
+			current.WriteString(" ")
+			continue
+		}
+		current.WriteString(line)
+
+		fields := strings.SplitN(strings.TrimSpace(current.String()), " ", 2)
+		instruction := dockerfileInstruction{line: startLine, keyword: strings.ToUpper(fields[0])}
+		if len(fields) == 2 {
+			instruction.args = strings.TrimSpace(fields[1])
+		}
+		instructions = append(instructions, instruction)
+		current.Reset()
+		if match := dockerfileHeredocPattern.FindStringSubmatch(instruction.args); match != nil && (instruction.keyword == "RUN" || instruction.keyword == "COPY") {
+			heredocTerminator = match[1]
+		}
+	}
+	return instructions
+}
+
+func parseFromStage(instruction dockerfileInstruction) dockerfileStage {
+	stage := dockerfileStage{line: instruction.line}
+	var fields []string
+	for _, field := range strings.Fields(instruction.args) {
+		if !strings.HasPrefix(field, "--") {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) > 0 {
+		stage.base = strings.ToLower(fields[0])
+	}
+	if len(fields) >= 3 && strings.EqualFold(fields[1], "AS") {
+		stage.name = strings.ToLower(fields[2])
+	}
+	return stage
+}
+
+// stageHasStartCommand reports whether stage sets CMD or ENTRYPOINT itself or
+// inherits one from an earlier stage it is built FROM.
+func stageHasStartCommand(stages []dockerfileStage, index int) bool {
+	for index >= 0 {
+		current := stages[index]
+		if current.startCommand {
+			return true
+		}
+		parent := -1
+		for earlier := index - 1; earlier >= 0; earlier-- {
+			if stages[earlier].name != "" && stages[earlier].name == current.base {
+				parent = earlier
+				break
+			}
+		}
+		index = parent
+	}
+	return false
+}
+
+func requireStartCommandFromEnv() bool {
+	required, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("DOCKERFILE_REQUIRE_CMD")))
+	return required
+}
+
+func argNames(args string) []string {
+	var names []string
+	for _, field := range strings.Fields(args) {
+		name := strings.SplitN(field, "=", 2)[0]
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// markArgReferences marks ARGs referenced by $NAME or ${NAME}. Global ARGs
+// (declared before the first FROM) are only visible to FROM lines.
+func markArgReferences(args []*declaredArg, text string, stage int, isFrom bool) {
+	for _, match := range dockerfileVarRefPattern.FindAllStringSubmatch(text, -1) {
+		name := match[1]
+		if name == "" {
+			name = match[2]
+		}
+		for _, arg := range args {
+			if arg.name != name {
+				continue
+			}
+			if (isFrom && arg.stage == -1) || (!isFrom && arg.stage == stage) {
+				arg.consumed = true
+			}
+		}
+	}
+}
+
+func plaintextSecretEnvWarnings(instruction dockerfileInstruction) []string {
+	var warnings []string
+	for key, value := range envAssignments(instruction.args) {
+		if value == "" || strings.Contains(value, "$") {
+			continue
+		}
+		if looksLikeSecretEnvKey(key) || looksLikeSecretValue(value) {
+			warnings = append(warnings, fmt.Sprintf("Dockerfile line %d: ENV %s sets a plaintext secret-looking value that will be baked into the image", instruction.line, key))
+		}
+	}
+	return warnings
+}
+
+func envAssignments(args string) map[string]string {
+	tokens := splitDockerfileWords(args)
+	values := map[string]string{}
+	if len(tokens) == 0 {
+		return values
+	}
+	if !strings.Contains(tokens[0], "=") {
+		// Legacy form: ENV KEY value with spaces
+		values[tokens[0]] = strings.Join(tokens[1:], " ")
+		return values
+	}
+	for _, token := range tokens {
+		parts := strings.SplitN(token, "=", 2)
+		if len(parts) == 2 {
+			values[parts[0]] = parts[1]
+		}
+	}
+	return values
+}
+
+// splitDockerfileWords splits on whitespace outside quotes and strips the quotes.
+func splitDockerfileWords(input string) []string {
+	var words []string
+	var current strings.Builder
+	var quote rune
+	inWord := false
+	for _, r := range input {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+				continue
+			}
+			current.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, current.String())
+	}
+	return words
+}
+
+func looksLikeSecretEnvKey(key string) bool {
+	upper := strings.ToUpper(key)
+	for _, prefix := range publicEnvKeyPrefixes {
+		if strings.HasPrefix(upper, prefix) {
+			return false
+		}
+	}
+	for _, marker := range secretEnvKeyMarkers {
+		if strings.Contains(upper, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func looksLikeSecretValue(value string) bool {
+	for _, pattern := range secretValuePatterns {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package autodetect
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"hubfly-builder/internal/allowlist"
+)
+
+var (
+	slnProjectPattern          = regexp.MustCompile(`(?m)^Project\("\{[^}]+\}"\)\s*=\s*"[^"]*",\s*"([^"]+\.csproj)"`)
+	csprojAssemblyNamePattern  = regexp.MustCompile(`<AssemblyName>\s*([^<\s]+)\s*</AssemblyName>`)
+	csprojTestProjectPattern   = regexp.MustCompile(`<IsTestProject>\s*true\s*</IsTestProject>|Microsoft\.NET\.Test\.Sdk`)
+	csprojWebSDKProjectPattern = regexp.MustCompile(`Sdk="Microsoft\.NET\.Sdk\.Web"`)
+)
+
+// dotnetProject is the project a .NET build publishes. Path is relative to
+// the app directory. Explicit is set when a solution file or another project
+// sits beside it, so restore and publish must be told which one to use.
+// Files are the project and solution files restore needs.
+type dotnetProject struct {
+	Path         string
+	AssemblyName string
+	Explicit     bool
+	Files        []string
+}
+
+func isDotnetProject(appPath string) bool {
+	return hasFileWithExtension(appPath, ".csproj") || hasFileWithExtension(appPath, ".sln")
+}
+
+// detectDotnetProject picks a project file in the app directory or, when
+// there is none, one listed by the solution file. Web projects win over
+// others and test projects are only picked when nothing else is left.
+func detectDotnetProject(appPath string) dotnetProject {
+	var project dotnetProject
+	projects := relativeDotnetFiles(appPath, findFilesWithExtension(appPath, ".csproj"))
+	solutions := relativeDotnetFiles(appPath, findFilesWithExtension(appPath, ".sln"))
+	project.Files = append(project.Files, projects...)
+	if len(projects) == 0 {
+		project.Files = append(project.Files, solutions...)
+		for _, solution := range solutions {
+			for _, listed := range dotnetSolutionProjects(appPath, solution) {
+				projects = append(projects, listed)
+				project.Files = append(project.Files, listed)
+			}
+		}
+	}
+	project.Explicit = len(solutions) > 0 || len(projects) > 1
+
+	project.Path = pickDotnetProject(appPath, projects)
+	if project.Path == "" {
+		return project
+	}
+	content := readFileLimited(filepath.Join(appPath, filepath.FromSlash(project.Path)))
+	if match := csprojAssemblyNamePattern.FindStringSubmatch(content); len(match) == 2 {
+		project.AssemblyName = match[1]
+	} else {
+		project.AssemblyName = strings.TrimSuffix(filepath.Base(project.Path), ".csproj")
+	}
+	return project
+}
+
+// dotnetSolutionProjects lists the C# projects of a solution that exist in
+// the repository, as slash-separated paths relative to appPath.
+func dotnetSolutionProjects(appPath, solution string) []string {
+	solutionDir := filepath.Dir(filepath.FromSlash(solution))
+	var projects []string
+	for _, match := range slnProjectPattern.FindAllStringSubmatch(readFileLimited(filepath.Join(appPath, filepath.FromSlash(solution))), -1) {
+		rel := filepath.Join(solutionDir, filepath.FromSlash(strings.ReplaceAll(match[1], `\`, "/")))
+		if strings.HasPrefix(rel, "..") || !fileExists(filepath.Join(appPath, rel)) {
+			continue
+		}
+		projects = append(projects, filepath.ToSlash(rel))
+	}
+	return projects
+}
+
+func pickDotnetProject(appPath string, projects []string) string {
+	fallback := ""
+	for _, path := range projects {
+		content := readFileLimited(filepath.Join(appPath, filepath.FromSlash(path)))
+		if csprojTestProjectPattern.MatchString(content) {
+			continue
+		}
+		if csprojWebSDKProjectPattern.MatchString(content) {
+			return path
+		}
+		if fallback == "" {
+			fallback = path
+		}
+	}
+	if fallback == "" && len(projects) > 0 {
+		return projects[0]
+	}
+	return fallback
+}
+
+func relativeDotnetFiles(appPath string, paths []string) []string {
+	files := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if rel, err := filepath.Rel(appPath, path); err == nil {
+			files = append(files, filepath.ToSlash(rel))
+		}
+	}
+	return files
+}
+
+// dotnetCommandCandidates restores and publishes the detected project and
+// runs the assembly it builds.
+func dotnetCommandCandidates(repoPath string) ([]string, []string, []string) {
+	project := detectDotnetProject(repoPath)
+	prebuildCandidates := []string{"dotnet restore"}
+	buildCandidates := []string{"dotnet publish -c Release -o out"}
+	if project.Explicit && project.Path != "" {
+		prebuildCandidates = []string{"dotnet restore " + project.Path}
+		buildCandidates = []string{"dotnet publish " + project.Path + " -c Release -o out"}
+	}
+	runCandidates := []string{}
+	if project.AssemblyName != "" {
+		runCandidates = append(runCandidates, "dotnet "+project.AssemblyName+".dll")
+	}
+	runCandidates = append(runCandidates, "dotnet App.dll")
+	return prebuildCandidates, buildCandidates, runCandidates
+}
+
+func detectDotnetCommands(repoPath string, allowed *allowlist.AllowedCommands) (string, string, string) {
+	prebuildCandidates, buildCandidates, runCandidates := dotnetCommandCandidates(repoPath)
+	return pickFirstAllowed(prebuildCandidates, allowed.Prebuild),
+		pickFirstAllowed(buildCandidates, allowed.Build),
+		pickFirstAllowed(runCandidates, allowed.Run)
+}
+
+func detectDotnetDependencyFiles(repoPath string) []string {
+	files := append([]string(nil), detectDotnetProject(repoPath).Files...)
+	for _, name := range []string{"Directory.Build.props", "Directory.Build.targets", "Directory.Packages.props", "global.json", "NuGet.Config"} {
+		if fileExists(filepath.Join(repoPath, name)) {
+			files = append(files, name)
+		}
+	}
+	return files
+}
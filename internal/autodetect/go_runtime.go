@@ -0,0 +1,61 @@
+package autodetect
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const defaultMaxGoVersion = "1.25"
+
+func selectGoBuilderImage(version string) string {
+	version = strings.TrimSpace(version)
+	if version == "" {
+		version = defaultDetectedVersionForRuntime("go")
+	}
+	return "golang:" + version + "-alpine"
+}
+
+func maxGoVersionFromEnv() string {
+	if value := strings.TrimSpace(os.Getenv("MAX_GO_VERSION")); value != "" {
+		return normalizeGoVersion(value)
+	}
+	return defaultMaxGoVersion
+}
+
+// goVersionWarnings flags go.mod directives newer than the newest Go image the
+// operator has made available, since the generated golang:<version>-alpine base
+// would otherwise fail to pull with an unhelpful error.
+func goVersionWarnings(version string) []string {
+	version = strings.TrimSpace(version)
+	if extractSemverish(version) == "" {
+		return nil
+	}
+	maxVersion := maxGoVersionFromEnv()
+	if extractSemverish(maxVersion) == "" {
+		return nil
+	}
+	if compareVersionPrefix(version, maxVersion) <= 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("go.mod requires Go %s but the newest configured Go image is %s; the build may fail to pull golang:%s-alpine", version, maxVersion, version)}
+}
+
+// compareVersionPrefix compares dotted versions on the components both sides
+// declare, so "1.22.3" is treated as within a "1.22" ceiling.
+func compareVersionPrefix(a, b string) int {
+	left := strings.Split(extractSemverish(a), ".")
+	right := strings.Split(extractSemverish(b), ".")
+	for i := 0; i < len(left) && i < len(right); i++ {
+		l, _ := strconv.Atoi(left[i])
+		r, _ := strconv.Atoi(right[i])
+		switch {
+		case l < r:
+			return -1
+		case l > r:
+			return 1
+		}
+	}
+	return 0
+}
@@ -38,7 +38,20 @@ type buildPlan struct {
 	PHPIniPath        string
 	StaticOutputDir   string
 	UseStaticRuntime  bool
-	appWorkDir        string
+	// BuildTool is the package manager or build tool the plan installs and
+	// builds with; Signals are the repository files and findings it was
+	// detected from.
+	BuildTool  string
+	Signals    []string
+	appWorkDir string
+	// RunAs is the user the final stage runs as; nil keeps the image's
+	// default user.
+	RunAs *ContainerUser
+	// Shell runs the build steps; empty keeps the image's /bin/sh.
+	Shell string
+	// JobSetsPort is set when the job supplies PORT at run time, so the
+	// generated Dockerfile leaves it out instead of pinning the expose port.
+	JobSetsPort bool
 }
 
 type jsProjectContext struct {
@@ -96,6 +109,7 @@ func detectBuildPlan(opts AutoDetectOptions, allowed *allowlist.AllowedCommands)
 		plan.DependencyFiles = detectGoDependencyFiles(appPath)
 		plan.ExposePort = inferExposePort(defaultExposePort(runtime), run)
 		plan.RuntimeEnv = mergeRuntimeEnv(plan.RuntimeEnv, ipv4BindRuntimeEnv(runtime, plan.Framework, plan.ExposePort))
+		plan.ValidationWarnings = append(plan.ValidationWarnings, goVersionWarnings(plan.Version)...)
 		if err := validateBuildPlanCommands(plan, allowed); err != nil {
 			return buildPlan{}, err
 		}
@@ -143,7 +157,32 @@ func detectBuildPlan(opts AutoDetectOptions, allowed *allowlist.AllowedCommands)
 			return buildPlan{}, err
 		}
 		return plan, nil
+	case "ruby":
+		plan, err := detectRubyBuildPlan(appDir, appPath, version)
+		if err != nil {
+			return buildPlan{}, err
+		}
+		if err := validateBuildPlanCommands(plan, allowed); err != nil {
+			return buildPlan{}, err
+		}
+		return plan, nil
+	case "dart":
+		plan, err := detectDartBuildPlan(appDir, appPath, version)
+		if err != nil {
+			return buildPlan{}, err
+		}
+		if err := validateBuildPlanCommands(plan, allowed); err != nil {
+			return buildPlan{}, err
+		}
+		return plan, nil
 	case "static":
+		if generator := detectStaticSiteGenerator(appPath); generator != "" {
+			plan := staticSiteGeneratorPlan(generator, appDir, appPath)
+			if err := validateBuildPlanCommands(plan, allowed); err != nil {
+				return buildPlan{}, err
+			}
+			return plan, nil
+		}
 		return buildPlan{
 			Runtime:          "static",
 			Framework:        "static-site",
@@ -190,6 +229,7 @@ func detectBuildPlan(opts AutoDetectOptions, allowed *allowlist.AllowedCommands)
 }
 
 func detectJavaScriptBuildPlan(repoRoot, appDir, appPath, runtime, version string) (buildPlan, error) {
+	version, versionWarning := resolveJavaScriptImageVersion(runtime, version)
 	ctx := newJSProjectContext(repoRoot, appDir, appPath, runtime, version)
 	framework := detectJSFramework(ctx)
 	if framework == "sveltekit" {
@@ -217,8 +257,12 @@ func detectJavaScriptBuildPlan(repoRoot, appDir, appPath, runtime, version strin
 		BuildContextDir: ctx.BuildContextDir,
 		AppDir:          ctx.AppDir,
 		BuilderImage:    selectJavaScriptBuilderImage(ctx.Runtime, ctx.Version),
+		BuildTool:       ctx.PackageManager,
 		appWorkDir:      ctx.appWorkDir,
 	}
+	if versionWarning != "" {
+		plan.ValidationWarnings = append(plan.ValidationWarnings, versionWarning)
+	}
 	if canInstallWithoutFullSource(ctx) {
 		plan.DependencyFiles = detectJavaScriptDependencyFiles(ctx)
 	}
@@ -380,7 +424,7 @@ func defaultExposePort(runtime string) string {
 	switch runtime {
 	case "python":
 		return "8000"
-	case "go", "java", "php", "rust", "dotnet":
+	case "go", "java", "php", "rust", "dotnet", "dart":
 		return "8080"
 	case "elixir":
 		return "4000"
@@ -550,6 +594,11 @@ func detectJavaScriptBootstrapCommands(ctx jsProjectContext) []string {
 func detectJavaScriptInstallCommand(ctx jsProjectContext) string {
 	switch ctx.PackageManager {
 	case "bun":
+		for _, lockfile := range []string{"bun.lockb", "bun.lock"} {
+			if fileExists(filepath.Join(ctx.BuildContextPath, lockfile)) || fileExists(filepath.Join(ctx.AppPath, lockfile)) {
+				return "bun install --frozen-lockfile"
+			}
+		}
 		return "bun install"
 	case "pnpm":
 		if fileExists(filepath.Join(ctx.BuildContextPath, "pnpm-lock.yaml")) || fileExists(filepath.Join(ctx.AppPath, "pnpm-lock.yaml")) {
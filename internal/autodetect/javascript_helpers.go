@@ -2,20 +2,21 @@ package autodetect
 
 import (
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 )
 
+var bunEntryPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_./-]*\.(ts|tsx|js|mjs|cjs)$`)
+
 func detectJavaScriptRunCommand(ctx jsProjectContext, runScript string) string {
 	if runScript != "" {
 		return prefixCommand(ctx.appWorkDir, packageManagerScriptCommand(ctx.PackageManager, runScript))
 	}
 
 	if ctx.Runtime == "bun" {
-		for _, fileName := range []string{"server.ts", "server.js", "app.ts", "app.js"} {
-			if fileExists(filepath.Join(ctx.AppPath, fileName)) {
-				return prefixCommand(ctx.appWorkDir, "bun "+fileName)
-			}
+		if entry := detectBunEntry(ctx); entry != "" {
+			return prefixCommand(ctx.appWorkDir, "bun run "+entry)
 		}
 		return ""
 	}
@@ -28,6 +29,26 @@ func detectJavaScriptRunCommand(ctx jsProjectContext, runScript string) string {
 	return ""
 }
 
+// detectBunEntry picks the file Bun should run when package.json has no start
+// script: the declared module or main entry first, then conventional names.
+func detectBunEntry(ctx jsProjectContext) string {
+	candidates := []string{}
+	if ctx.AppMetadata != nil {
+		candidates = append(candidates, ctx.AppMetadata.Module, ctx.AppMetadata.Main)
+	}
+	candidates = append(candidates, "server.ts", "server.js", "app.ts", "app.js", "index.ts", "index.js")
+	for _, candidate := range candidates {
+		entry := strings.TrimPrefix(filepath.ToSlash(strings.TrimSpace(candidate)), "./")
+		if !bunEntryPattern.MatchString(entry) || strings.Contains(entry, "..") {
+			continue
+		}
+		if fileExists(filepath.Join(ctx.AppPath, filepath.FromSlash(entry))) {
+			return entry
+		}
+	}
+	return ""
+}
+
 func packageManagerScriptCommand(packageManager, script string) string {
 	script = strings.TrimSpace(script)
 	if script == "" {
@@ -0,0 +1,103 @@
+package autodetect
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// nodeLTSMajor is the Node.js release line "lts" resolves to, and the
+	// default detected Node.js version.
+	nodeLTSMajor = "22"
+	// nodeCurrentMajor is the Node.js release line "stable", "current",
+	// "latest" and "node" resolve to.
+	nodeCurrentMajor = "24"
+)
+
+var (
+	exactVersionPattern = regexp.MustCompile(`^\d+(?:\.\d+){0,2}$`)
+	majorVersionPattern = regexp.MustCompile(`\d+`)
+)
+
+// nodeLTSCodenames maps the codenames nvm accepts after "lts/" to their
+// release lines.
+var nodeLTSCodenames = map[string]string{
+	"argon":    "4",
+	"boron":    "6",
+	"carbon":   "8",
+	"dubnium":  "10",
+	"erbium":   "12",
+	"fermium":  "14",
+	"gallium":  "16",
+	"hydrogen": "18",
+	"iron":     "20",
+	"jod":      "22",
+	"krypton":  "24",
+}
+
+// resolveJavaScriptImageVersion turns the version spec of a Node.js or Bun
+// project into a tag of the builder image. Exact versions are kept; ranges
+// such as "^18.0.0" or ">=18 <21", prereleases and aliases such as "lts" are
+// reduced to a release line the image registry publishes. The returned
+// warning is empty unless the version had to be guessed.
+func resolveJavaScriptImageVersion(runtime, spec string) (string, string) {
+	spec = normalizeVersionValue(spec)
+	version := resolveJavaScriptVersionSpec(runtime, spec)
+	if version == "" {
+		version = defaultDetectedVersionForRuntime(runtime)
+	}
+	if spec == "" || version == strings.TrimPrefix(spec, "v") {
+		return version, ""
+	}
+	return version, fmt.Sprintf("%s version %q is not an exact release; building with %s", runtime, spec, selectJavaScriptBuilderImage(runtime, version))
+}
+
+func resolveJavaScriptVersionSpec(runtime, spec string) string {
+	lower := strings.ToLower(strings.TrimSpace(spec))
+	if lower == "" {
+		return ""
+	}
+	if alias, ok := javaScriptVersionAlias(runtime, lower); ok {
+		return alias
+	}
+
+	// Of several alternatives ("^18 || ^20") the last is usually the newest.
+	alternatives := strings.Split(lower, "||")
+	candidate := strings.TrimSpace(alternatives[len(alternatives)-1])
+	if candidate == "" || candidate == "*" || candidate == "x" {
+		return ""
+	}
+	candidate = strings.TrimPrefix(candidate, "v")
+	if exactVersionPattern.MatchString(candidate) {
+		return candidate
+	}
+	// Ranges, wildcards and prereleases fall back to their major version,
+	// which is always published as a tag.
+	return majorVersionPattern.FindString(candidate)
+}
+
+func javaScriptVersionAlias(runtime, spec string) (string, bool) {
+	if runtime == "bun" {
+		switch spec {
+		case "latest", "canary":
+			return spec, true
+		case "lts", "stable", "current":
+			return "latest", true
+		}
+		return "", false
+	}
+
+	switch {
+	case spec == "lts" || spec == "lts/*":
+		return nodeLTSMajor, true
+	case strings.HasPrefix(spec, "lts/"):
+		if major, ok := nodeLTSCodenames[strings.TrimPrefix(spec, "lts/")]; ok {
+			return major, true
+		}
+		return nodeLTSMajor, true
+	case spec == "stable" || spec == "current" || spec == "latest" || spec == "node":
+		return nodeCurrentMajor, true
+	}
+	return "", false
+}
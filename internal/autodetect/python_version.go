@@ -0,0 +1,118 @@
+package autodetect
+
+import (
+	"strconv"
+	"strings"
+)
+
+// pythonStableMinors are the Python releases with a maintained python image,
+// oldest first. Version constraints resolve to the oldest one they allow.
+var pythonStableMinors = []string{"3.10", "3.11", "3.12", "3.13", "3.14"}
+
+// isPythonVersionConstraint reports whether raw is a range such as ">=3.11",
+// "^3.11" or "3.11.*" rather than a version.
+func isPythonVersionConstraint(raw string) bool {
+	return strings.ContainsAny(raw, "<>=~^!,*")
+}
+
+// resolvePythonVersionConstraint picks the oldest stable minor that satisfies
+// every clause of a PEP 440 or Poetry constraint, e.g. "3.11" for ">=3.11" or
+// ">=3.9,<3.13". An exact "==3.11.4" keeps its patch release. It returns ""
+// when no stable minor fits.
+func resolvePythonVersionConstraint(raw string) string {
+	var clauses []string
+	for _, clause := range strings.Split(raw, ",") {
+		if clause = strings.TrimSpace(clause); clause != "" {
+			clauses = append(clauses, clause)
+		}
+	}
+	if len(clauses) == 1 && strings.HasPrefix(clauses[0], "==") && !strings.HasSuffix(clauses[0], "*") {
+		if version := extractSemverish(clauses[0]); strings.Count(version, ".") == 2 {
+			return version
+		}
+	}
+	for _, minor := range pythonStableMinors {
+		candidate := parsePythonVersion(minor)
+		satisfied := true
+		for _, clause := range clauses {
+			if !pythonMinorSatisfies(candidate, clause) {
+				satisfied = false
+				break
+			}
+		}
+		if satisfied {
+			return minor
+		}
+	}
+	return ""
+}
+
+// pythonMinorSatisfies reports whether the latest patch release of minor
+// satisfies clause. Patch levels in lower bounds are ignored, since the
+// minor's image tag follows its newest patch release.
+func pythonMinorSatisfies(minor []int, clause string) bool {
+	operator := strings.TrimSpace(clause[:len(clause)-len(strings.TrimLeft(clause, "<>=~^! "))])
+	bound := parsePythonVersion(extractSemverish(clause))
+	if len(bound) == 0 {
+		return true
+	}
+	sameMinor := comparePythonMinor(minor, bound) == 0
+	switch operator {
+	case ">=", ">":
+		return comparePythonMinor(minor, bound) >= 0
+	case "<":
+		if len(bound) > 2 && bound[2] > 0 {
+			return comparePythonMinor(minor, bound) <= 0
+		}
+		return comparePythonMinor(minor, bound) < 0
+	case "<=":
+		return comparePythonMinor(minor, bound) <= 0
+	case "!=":
+		// Only "!=3.11.*" rules out a whole minor.
+		return !sameMinor || !strings.HasSuffix(clause, "*")
+	case "~":
+		// Poetry's ~3.11 allows 3.11.x only.
+		return sameMinor
+	case "^", "~=":
+		// ^3.11 and ~=3.11 allow any later 3.x; ~=3.11.2 stays on 3.11.
+		if operator == "~=" && len(bound) > 2 {
+			return sameMinor
+		}
+		return minor[0] == bound[0] && comparePythonMinor(minor, bound) >= 0
+	case "==", "":
+		return sameMinor
+	}
+	return true
+}
+
+// comparePythonMinor compares the major and minor components of a and b.
+func comparePythonMinor(a, b []int) int {
+	for i := 0; i < 2; i++ {
+		left, right := 0, 0
+		if i < len(a) {
+			left = a[i]
+		}
+		if i < len(b) {
+			right = b[i]
+		}
+		switch {
+		case left < right:
+			return -1
+		case left > right:
+			return 1
+		}
+	}
+	return 0
+}
+
+func parsePythonVersion(version string) []int {
+	var parts []int
+	for _, part := range strings.Split(version, ".") {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return parts
+		}
+		parts = append(parts, value)
+	}
+	return parts
+}
@@ -0,0 +1,125 @@
+package autodetect
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	gemfileRubyPattern = regexp.MustCompile(`(?m)^\s*ruby\s+['"]([^'"]+)['"]`)
+	gemfileGemPattern  = regexp.MustCompile(`(?m)^\s*gem\s+['"]([A-Za-z0-9_\-]+)['"]`)
+)
+
+// rubyNativeGemPackages are the apt packages the native extensions of common
+// database gems compile against on a slim Ruby image.
+var rubyNativeGemPackages = map[string][]string{
+	"pg":      {"libpq-dev"},
+	"mysql2":  {"default-libmysqlclient-dev"},
+	"sqlite3": {"libsqlite3-dev"},
+}
+
+func isRubyProject(appPath string) bool {
+	return appPath != "" && fileExists(filepath.Join(appPath, "Gemfile"))
+}
+
+func detectRubyVersion(repoRoot, appPath string) string {
+	for _, base := range versionSearchPaths(appPath, repoRoot) {
+		if v := readFirstNonEmptyLine(filepath.Join(base, ".ruby-version")); v != "" {
+			return normalizeRubyVersion(v)
+		}
+		if v := readToolVersion(filepath.Join(base, ".tool-versions"), "ruby"); v != "" {
+			return normalizeRubyVersion(v)
+		}
+		if match := gemfileRubyPattern.FindStringSubmatch(readFileLimited(filepath.Join(base, "Gemfile"))); len(match) == 2 {
+			return normalizeRubyVersion(match[1])
+		}
+	}
+	return ""
+}
+
+// normalizeRubyVersion keeps the numeric part of a version such as
+// "ruby-3.3.0" or "~> 3.2", which the ruby image tags use.
+func normalizeRubyVersion(version string) string {
+	return semverishPattern.FindString(strings.TrimSpace(version))
+}
+
+// rubyGems lists the gems the Gemfile declares.
+func rubyGems(appPath string) map[string]bool {
+	gems := make(map[string]bool)
+	for _, match := range gemfileGemPattern.FindAllStringSubmatch(readFileLimited(filepath.Join(appPath, "Gemfile")), -1) {
+		gems[match[1]] = true
+	}
+	return gems
+}
+
+func detectRubyFramework(appPath string, gems map[string]bool) string {
+	switch {
+	case fileExists(filepath.Join(appPath, "bin", "rails")) || gems["rails"]:
+		return "rails"
+	case gems["sinatra"]:
+		return "sinatra"
+	case fileExists(filepath.Join(appPath, "config.ru")):
+		return "rack"
+	}
+	return ""
+}
+
+// detectRubyBuildPlan runs Rails with rails server, Rack apps with rackup and
+// other apps with their app.rb, after a bundle install.
+func detectRubyBuildPlan(appDir, appPath, version string) (buildPlan, error) {
+	gems := rubyGems(appPath)
+	framework := detectRubyFramework(appPath, gems)
+
+	plan, err := defaultBuildPlan("ruby", version, "bundle install", "", "")
+	if err != nil {
+		return buildPlan{}, err
+	}
+	plan.Framework = framework
+	plan.BuildContextDir = appDir
+	plan.AppDir = appDir
+	plan.BuildTool = "bundler"
+	plan.DependencyFiles = []string{"Gemfile"}
+	if fileExists(filepath.Join(appPath, "Gemfile.lock")) {
+		plan.DependencyFiles = append(plan.DependencyFiles, "Gemfile.lock")
+	}
+	for _, gem := range []string{"pg", "mysql2", "sqlite3"} {
+		if gems[gem] {
+			plan.AptPackages = append(plan.AptPackages, rubyNativeGemPackages[gem]...)
+		}
+	}
+
+	switch framework {
+	case "rails":
+		plan.RunCommand = "bundle exec rails server -b 0.0.0.0 -p ${PORT:-3000}"
+		if fileExists(filepath.Join(appPath, "app", "assets")) || fileExists(filepath.Join(appPath, "app", "javascript")) {
+			plan.BuildCommand = "RAILS_ENV=production SECRET_KEY_BASE_DUMMY=1 bundle exec rails assets:precompile"
+		}
+		plan.RuntimeEnv["RAILS_ENV"] = "production"
+		plan.RuntimeEnv["RAILS_LOG_TO_STDOUT"] = "1"
+		plan.RuntimeEnv["RAILS_SERVE_STATIC_FILES"] = "1"
+	case "rack":
+		plan.RunCommand = "bundle exec rackup -o 0.0.0.0 -p ${PORT:-3000}"
+	default:
+		entry := ""
+		for _, name := range []string{"app.rb", "server.rb", "main.rb"} {
+			if fileExists(filepath.Join(appPath, name)) {
+				entry = name
+				break
+			}
+		}
+		if entry == "" {
+			if fileExists(filepath.Join(appPath, "config.ru")) {
+				plan.RunCommand = "bundle exec rackup -o 0.0.0.0 -p ${PORT:-3000}"
+				break
+			}
+			return buildPlan{}, fmt.Errorf("Ruby project has no config.ru, bin/rails or app.rb to start")
+		}
+		plan.RunCommand = "bundle exec ruby " + entry
+		if framework == "sinatra" {
+			plan.RunCommand += " -o 0.0.0.0 -p ${PORT:-3000}"
+		}
+	}
+	return plan, nil
+}
@@ -0,0 +1,62 @@
+package autodetect
+
+import (
+	"regexp"
+	"strings"
+)
+
+var portDefaultPattern = regexp.MustCompile(`\$\{PORT:?-([0-9]+)\}`)
+
+// withRunCommandPortDefault pins ENV PORT to the default a run command spells
+// out with ${PORT:-N}, so the shell substitution resolves to the same port the
+// image exposes instead of depending on whatever the platform injects.
+func withRunCommandPortDefault(plan buildPlan) buildPlan {
+	match := portDefaultPattern.FindStringSubmatch(plan.RunCommand)
+	if match == nil {
+		return plan
+	}
+	if strings.TrimSpace(plan.RuntimeEnv["PORT"]) != "" {
+		return plan
+	}
+
+	env := make(map[string]string, len(plan.RuntimeEnv)+1)
+	for key, value := range plan.RuntimeEnv {
+		env[key] = value
+	}
+	env["PORT"] = match[1]
+	plan.RuntimeEnv = env
+	if strings.TrimSpace(plan.ExposePort) == "" {
+		plan.ExposePort = match[1]
+	}
+	return plan
+}
+
+// withJobRuntimeEnv records whether the job supplies PORT itself.
+func withJobRuntimeEnv(plan buildPlan, keys []string) buildPlan {
+	for _, key := range keys {
+		if strings.TrimSpace(key) == "PORT" {
+			plan.JobSetsPort = true
+			break
+		}
+	}
+	return plan
+}
+
+// withoutJobPort drops the detected PORT from the runtime env of a plan whose
+// job sets PORT, so the job's value is not shadowed by the expose port.
+func withoutJobPort(plan buildPlan) buildPlan {
+	if !plan.JobSetsPort {
+		return plan
+	}
+	if _, ok := plan.RuntimeEnv["PORT"]; !ok {
+		return plan
+	}
+	env := make(map[string]string, len(plan.RuntimeEnv))
+	for key, value := range plan.RuntimeEnv {
+		if key != "PORT" {
+			env[key] = value
+		}
+	}
+	plan.RuntimeEnv = env
+	return plan
+}
@@ -32,7 +32,7 @@ var (
 	trustedAngularSSRPattern  = regexp.MustCompile(`^if \[ -f '[^']+' \]; then HOST=0\.0\.0\.0 PORT=\$\{PORT:-\d{2,5}\} node '[^']+'; elif \[ -f '[^']+' \]; then HOST=0\.0\.0\.0 PORT=\$\{PORT:-\d{2,5}\} node '[^']+'; elif \[ -f '[^']+' \]; then HOST=0\.0\.0\.0 PORT=\$\{PORT:-\d{2,5}\} node '[^']+'; else HOST=0\.0\.0\.0 PORT=\$\{PORT:-\d{2,5}\} node '[^']+'; fi$`)
 	trustedNodeFileRunPattern = regexp.MustCompile(`^node (server|app|main)\.js$`)
 	trustedNodeDistRunPattern = regexp.MustCompile(`^node (dist/server|dist/main|build/server|build/index|build/handler)\.js$`)
-	trustedBunFileRunPattern  = regexp.MustCompile(`^bun (server|app)\.(ts|js)$`)
+	trustedBunFileRunPattern  = regexp.MustCompile(`^bun run [A-Za-z0-9_][A-Za-z0-9_./-]*\.(ts|tsx|js|mjs|cjs)$`)
 	trustedRustSelectPattern  = regexp.MustCompile(`^set -e; .*cp "\$[^"]+" /app/app.*$`)
 	trustedPHPIniPattern      = regexp.MustCompile(`^if \[ -f "\$PHP_INI_DIR/php\.ini-production" \]; then cp "\$PHP_INI_DIR/php\.ini-production" "\$PHP_INI_DIR/php\.ini"; fi$`)
 	trustedPHPExtPattern      = regexp.MustCompile(`^docker-php-ext-install(?: [a-z0-9_]+)+$`)
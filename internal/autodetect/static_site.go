@@ -0,0 +1,72 @@
+package autodetect
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const (
+	hugoBuilderImage   = "hugomods/hugo:exts"
+	jekyllBuilderImage = "ruby:3.3"
+)
+
+// detectStaticSiteGenerator reports which site generator, if any, a static
+// repository needs run before its output can be served: "hugo" for a Hugo
+// config (hugo.* or config.* next to content/) and "jekyll" for _config.yml.
+func detectStaticSiteGenerator(appPath string) string {
+	if appPath == "" {
+		return ""
+	}
+	for _, name := range []string{"hugo.toml", "hugo.yaml", "hugo.yml", "hugo.json"} {
+		if fileExists(filepath.Join(appPath, name)) {
+			return "hugo"
+		}
+	}
+	if info, err := os.Stat(filepath.Join(appPath, "content")); err == nil && info.IsDir() {
+		for _, name := range []string{"config.toml", "config.yaml", "config.yml"} {
+			if fileExists(filepath.Join(appPath, name)) {
+				return "hugo"
+			}
+		}
+	}
+	if fileExists(filepath.Join(appPath, "_config.yml")) || fileExists(filepath.Join(appPath, "_config.yaml")) {
+		return "jekyll"
+	}
+	return ""
+}
+
+// staticSiteGeneratorPlan builds the site with its generator in a builder
+// stage and serves the generated directory with nginx.
+func staticSiteGeneratorPlan(generator, appDir, appPath string) buildPlan {
+	plan := buildPlan{
+		Runtime:          "static",
+		Framework:        generator,
+		Version:          "latest",
+		ExposePort:       "8080",
+		BuildContextDir:  appDir,
+		AppDir:           appDir,
+		RuntimeImage:     "nginx:alpine",
+		UseStaticRuntime: true,
+	}
+	switch generator {
+	case "hugo":
+		plan.BuilderImage = hugoBuilderImage
+		plan.BuildCommand = "hugo --minify"
+		plan.StaticOutputDir = "public"
+	case "jekyll":
+		plan.BuilderImage = jekyllBuilderImage
+		if fileExists(filepath.Join(appPath, "Gemfile")) {
+			plan.DependencyFiles = []string{"Gemfile"}
+			if fileExists(filepath.Join(appPath, "Gemfile.lock")) {
+				plan.DependencyFiles = append(plan.DependencyFiles, "Gemfile.lock")
+			}
+			plan.InstallCommand = "bundle install"
+			plan.BuildCommand = "JEKYLL_ENV=production bundle exec jekyll build"
+		} else {
+			plan.InstallCommand = "gem install jekyll"
+			plan.BuildCommand = "JEKYLL_ENV=production jekyll build"
+		}
+		plan.StaticOutputDir = "_site"
+	}
+	return plan
+}
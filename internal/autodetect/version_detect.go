@@ -40,12 +40,17 @@ func DetectRuntimeWithContext(repoRoot, appPath string) (string, string) {
 }
 
 func detectRuntimeByFiles(repoPath string) string {
-	if fileExists(filepath.Join(repoPath, "bun.lock")) { // new version of bun is bun.lock
-		return "bun"
+	for _, fileName := range []string{"bun.lock", "bun.lockb", "bunfig.toml"} { // bun.lock replaced the binary bun.lockb
+		if fileExists(filepath.Join(repoPath, fileName)) {
+			return "bun"
+		}
 	}
 	if isPythonProject(repoPath) {
 		return "python"
 	}
+	if detectStaticSiteGenerator(repoPath) != "" {
+		return "static"
+	}
 	if fileExists(filepath.Join(repoPath, "mix.exs")) {
 		return "elixir"
 	}
@@ -58,10 +63,16 @@ func detectRuntimeByFiles(repoPath string) string {
 	if isPHPProject(repoPath) {
 		return "php"
 	}
+	if isRubyProject(repoPath) {
+		return "ruby"
+	}
+	if isDartProject(repoPath) {
+		return "dart"
+	}
 	if fileExists(filepath.Join(repoPath, "package.json")) {
 		return "node"
 	}
-	if hasFileWithExtension(repoPath, ".csproj") {
+	if isDotnetProject(repoPath) {
 		return "dotnet"
 	}
 	if fileExists(filepath.Join(repoPath, "pom.xml")) || fileExists(filepath.Join(repoPath, "build.gradle")) || fileExists(filepath.Join(repoPath, "build.gradle.kts")) {
@@ -112,6 +123,10 @@ func detectVersionForRuntime(runtime, repoRoot, appPath string) string {
 		return detectRustVersion(repoRoot, appPath)
 	case "php":
 		return detectPHPVersion(repoRoot, appPath)
+	case "ruby":
+		return detectRubyVersion(repoRoot, appPath)
+	case "dart":
+		return detectDartVersion(repoRoot, appPath)
 	case "dotnet":
 		return detectDotnetVersion(repoRoot, appPath)
 	case "java":
@@ -315,7 +330,7 @@ func defaultDetectedVersionForRuntime(runtime string) string {
 	case "bun":
 		return "1.2"
 	case "node":
-		return "22"
+		return nodeLTSMajor
 	case "python":
 		return "3.14.4"
 	case "elixir":
@@ -326,6 +341,10 @@ func defaultDetectedVersionForRuntime(runtime string) string {
 		return "stable"
 	case "php":
 		return "8.3"
+	case "ruby":
+		return "3.3"
+	case "dart":
+		return "stable"
 	case "dotnet":
 		return "9.0"
 	case "java":
@@ -392,8 +411,17 @@ func dotnetVersionFromGlobalJSON(path string) string {
 }
 
 func dotnetVersionFromCsproj(repoPath string) string {
-	for _, path := range findFilesWithExtension(repoPath, ".csproj") {
-		data := readFileLimited(path)
+	project := detectDotnetProject(repoPath)
+	paths := make([]string, 0, len(project.Files)+1)
+	if project.Path != "" {
+		paths = append(paths, project.Path)
+	}
+	paths = append(paths, project.Files...)
+	for _, path := range paths {
+		if !strings.HasSuffix(path, ".csproj") {
+			continue
+		}
+		data := readFileLimited(filepath.Join(repoPath, filepath.FromSlash(path)))
 		if data == "" {
 			continue
 		}
@@ -510,37 +538,15 @@ func readFileLimited(path string) string {
 	return string(data)
 }
 
+// normalizeNodeVersion and normalizeBunVersion keep the spec as written, so
+// resolveJavaScriptImageVersion can tell exact versions from ranges and
+// aliases when it picks the builder image.
 func normalizeNodeVersion(raw string) string {
-	raw = normalizeVersionValue(raw)
-	lower := strings.ToLower(raw)
-	switch {
-	case strings.HasPrefix(lower, "lts"):
-		return "lts"
-	case strings.HasPrefix(lower, "current"):
-		return "current"
-	case strings.HasPrefix(lower, "latest"):
-		return "latest"
-	case strings.HasPrefix(lower, "stable"):
-		return "current"
-	}
-	if strings.HasPrefix(raw, "v") && len(raw) > 1 && raw[1] >= '0' && raw[1] <= '9' {
-		raw = raw[1:]
-	}
-	if v := extractSemverish(raw); v != "" {
-		return v
-	}
-	return raw
+	return normalizeVersionValue(raw)
 }
 
 func normalizeBunVersion(raw string) string {
-	raw = normalizeVersionValue(raw)
-	if strings.HasPrefix(raw, "v") && len(raw) > 1 && raw[1] >= '0' && raw[1] <= '9' {
-		raw = raw[1:]
-	}
-	if v := extractSemverish(raw); v != "" {
-		return v
-	}
-	return raw
+	return normalizeVersionValue(raw)
 }
 
 func normalizeGoVersion(raw string) string {
@@ -561,6 +567,11 @@ func normalizeGoVersion(raw string) string {
 func normalizePythonVersion(raw string) string {
 	raw = normalizeVersionValue(raw)
 	raw = strings.TrimPrefix(strings.ToLower(raw), "python-")
+	if isPythonVersionConstraint(raw) {
+		if v := resolvePythonVersionConstraint(raw); v != "" {
+			return v
+		}
+	}
 	if v := extractSemverish(raw); v != "" {
 		return v
 	}
@@ -0,0 +1,12 @@
+package driver
+
+import "strings"
+
+// ResolveGitCLIPath returns the git executable to run: raw when set,
+// otherwise "git" looked up on PATH.
+func ResolveGitCLIPath(raw string) string {
+	if path := strings.TrimSpace(raw); path != "" {
+		return path
+	}
+	return "git"
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -18,17 +19,48 @@ var hubcellBuildCapabilities = []string{
 	"SETGID",
 }
 
+// HubcellSecret is a build secret that RUN steps mount by ID. Its value is
+// read from the file at Path so it never appears on the command line.
+type HubcellSecret struct {
+	ID   string
+	Path string
+}
+
 type HubcellBuildOpts struct {
-	HubcellPath       string
-	WorkDir           string
-	ContextPath       string
-	ImageTag          string
-	Envs              []string
+	HubcellPath string
+	WorkDir     string
+	ContextPath string
+	ImageTag    string
+	// Dockerfile is passed as -f, for a Dockerfile not named "Dockerfile" in
+	// ContextPath. It is relative to WorkDir, or absolute for a Dockerfile
+	// outside the context.
+	Dockerfile string
+	// ExtraTags are applied to the same image in addition to ImageTag.
+	ExtraTags []string
+	Envs      []string
+	// Secrets are passed as --secret id=<ID>,src=<Path>.
+	Secrets           []HubcellSecret
 	Network           string
 	MemoryBytes       int64
 	CPUPeriod         int64
 	CPUQuota          int64
 	RootfsInitialSize string
+	// BuildOpts are extra hubcell build flags, passed as --<key>=<value>.
+	// Callers must restrict the keys; see executor.ValidateBuildOpts.
+	BuildOpts map[string]string
+	// ExtraHosts are "host:ip" entries passed as --add-host.
+	ExtraHosts []string
+	// DNSServers are resolver IPs passed as --dns.
+	DNSServers []string
+	// Platforms are the target platforms, passed as one --platform list.
+	// Empty builds for the host platform.
+	Platforms []string
+	// CacheRef is the registry image holding the build cache. When set, the
+	// build imports its cache from there and exports the new cache back.
+	CacheRef string
+	// NoCacheImport only exports the cache, for a CacheRef that does not
+	// exist yet.
+	NoCacheImport bool
 }
 
 func HubcellBuildCommand(opts HubcellBuildOpts) *exec.Cmd {
@@ -43,7 +75,15 @@ func HubcellBuildCommandContext(ctx context.Context, opts HubcellBuildOpts) *exe
 		args = append(args, "--cap-add", capability)
 	}
 
+	if dockerfile := strings.TrimSpace(opts.Dockerfile); dockerfile != "" {
+		args = append(args, "-f", dockerfile)
+	}
 	args = append(args, "-t", opts.ImageTag)
+	for _, tag := range opts.ExtraTags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			args = append(args, "-t", tag)
+		}
+	}
 
 	for _, envEntry := range opts.Envs {
 		envEntry = strings.TrimSpace(envEntry)
@@ -53,9 +93,26 @@ func HubcellBuildCommandContext(ctx context.Context, opts HubcellBuildOpts) *exe
 		args = append(args, "-e", envEntry)
 	}
 
+	for _, secret := range opts.Secrets {
+		args = append(args, "--secret", "id="+secret.ID+",src="+secret.Path)
+	}
+
 	if network := strings.TrimSpace(opts.Network); network != "" {
 		args = append(args, "--network", network)
 	}
+	for _, host := range opts.ExtraHosts {
+		if host = strings.TrimSpace(host); host != "" {
+			args = append(args, "--add-host", host)
+		}
+	}
+	for _, server := range opts.DNSServers {
+		if server = strings.TrimSpace(server); server != "" {
+			args = append(args, "--dns", server)
+		}
+	}
+	if len(opts.Platforms) > 0 {
+		args = append(args, "--platform", strings.Join(opts.Platforms, ","))
+	}
 	if opts.MemoryBytes > 0 {
 		args = append(args, "-m", strconv.FormatInt(opts.MemoryBytes, 10))
 	}
@@ -68,6 +125,13 @@ func HubcellBuildCommandContext(ctx context.Context, opts HubcellBuildOpts) *exe
 	if size := strings.TrimSpace(opts.RootfsInitialSize); size != "" {
 		args = append(args, "--rootfs-initial-size", size)
 	}
+	if cacheRef := strings.TrimSpace(opts.CacheRef); cacheRef != "" {
+		if !opts.NoCacheImport {
+			args = append(args, "--import-cache", "type=registry,ref="+cacheRef)
+		}
+		args = append(args, "--export-cache", "type=registry,ref="+cacheRef+",mode=max")
+	}
+	args = append(args, HubcellBuildOptArgs(opts.BuildOpts)...)
 
 	args = append(args, opts.ContextPath)
 	cmd := exec.CommandContext(ctx, "sudo", args...)
@@ -77,6 +141,50 @@ func HubcellBuildCommandContext(ctx context.Context, opts HubcellBuildOpts) *exe
 	return cmd
 }
 
+// HubcellBuildOptArgs renders build opts as --<key>=<value> flags in key
+// order, so the same opts always produce the same command.
+func HubcellBuildOptArgs(buildOpts map[string]string) []string {
+	keys := make([]string, 0, len(buildOpts))
+	for key := range buildOpts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	args := make([]string, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, "--"+key+"="+buildOpts[key])
+	}
+	return args
+}
+
+// HubcellTagCommandContext points target at the image already tagged source.
+func HubcellTagCommandContext(ctx context.Context, hubcellPath, source, target string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sudo", ResolveHubcellCLIPath(hubcellPath), "tag", source, target)
+}
+
+// HubcellImageInspectCommandContext looks up image in Hubcell's local image
+// store; it exits non-zero when the image does not exist.
+func HubcellImageInspectCommandContext(ctx context.Context, hubcellPath, image string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sudo", ResolveHubcellCLIPath(hubcellPath), "image", "inspect", image)
+}
+
+// HubcellPullCommandContext pulls image from its registry into Hubcell's
+// local image store.
+func HubcellPullCommandContext(ctx context.Context, hubcellPath, image string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sudo", ResolveHubcellCLIPath(hubcellPath), "pull", image)
+}
+
+// HubcellPushCommandContext pushes image from Hubcell's local image store to
+// the registry its name points at.
+func HubcellPushCommandContext(ctx context.Context, hubcellPath, image string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sudo", ResolveHubcellCLIPath(hubcellPath), "push", image)
+}
+
+// HubcellLoginCommandContext stores credentials for registry. The password is
+// read from the command's stdin so that it never shows up in process lists.
+func HubcellLoginCommandContext(ctx context.Context, hubcellPath, registry, username string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sudo", ResolveHubcellCLIPath(hubcellPath), "login", "--username", username, "--password-stdin", registry)
+}
+
 func ResolveHubcellCLIPath(raw string) string {
 	path := strings.TrimSpace(raw)
 	if path == "" {
@@ -53,3 +53,135 @@ func TestHubcellBuildCommandUsesSudoAndResourceFlags(t *testing.T) {
 		}
 	}
 }
+
+func TestHubcellBuildCommandAddsExtraTags(t *testing.T) {
+	cmd := HubcellBuildCommand(HubcellBuildOpts{
+		ContextPath: ".",
+		ImageTag:    "hubcell.local/user/project:abc-b1-v1",
+		ExtraTags:   []string{"hubcell.local/user/project:latest", " "},
+	})
+
+	got := strings.Join(cmd.Args, " ")
+	if !strings.Contains(got, "-t hubcell.local/user/project:abc-b1-v1 -t hubcell.local/user/project:latest ") {
+		t.Fatalf("expected immutable and moving tags, got %q", got)
+	}
+	if strings.Count(got, "-t ") != 2 {
+		t.Fatalf("expected exactly two tags, got %q", got)
+	}
+}
+
+func TestHubcellBuildCommandAddsBuildOptsBeforeContext(t *testing.T) {
+	cmd := HubcellBuildCommand(HubcellBuildOpts{
+		ContextPath: "/tmp/context",
+		ImageTag:    "hubcell.local/user/project:tag",
+		BuildOpts:   map[string]string{"squash": "true", "label": "team=web"},
+	})
+
+	got := strings.Join(cmd.Args, " ")
+	if !strings.HasSuffix(got, " --label=team=web --squash=true /tmp/context") {
+		t.Fatalf("expected sorted build opts before the context path, got %q", got)
+	}
+}
+
+func TestHubcellBuildCommandAddsExtraHosts(t *testing.T) {
+	cmd := HubcellBuildCommand(HubcellBuildOpts{
+		ContextPath: ".",
+		ImageTag:    "hubcell.local/user/project:tag",
+		ExtraHosts:  []string{"mirror.internal:10.0.0.5", " ", "registry:fd00::10"},
+	})
+
+	got := strings.Join(cmd.Args, " ")
+	if !strings.Contains(got, " --add-host mirror.internal:10.0.0.5 --add-host registry:fd00::10 ") {
+		t.Fatalf("expected an --add-host flag per entry, got %q", got)
+	}
+	if strings.Count(got, "--add-host") != 2 {
+		t.Fatalf("expected blank entries to be skipped, got %q", got)
+	}
+}
+
+func TestHubcellBuildCommandAddsDNSServers(t *testing.T) {
+	cmd := HubcellBuildCommand(HubcellBuildOpts{
+		ContextPath: ".",
+		ImageTag:    "hubcell.local/user/project:tag",
+		DNSServers:  []string{"10.0.0.2", "", "fd00::53"},
+	})
+
+	got := strings.Join(cmd.Args, " ")
+	if !strings.Contains(got, " --dns 10.0.0.2 --dns fd00::53 ") {
+		t.Fatalf("expected a --dns flag per server, got %q", got)
+	}
+	if strings.Count(got, "--dns") != 2 {
+		t.Fatalf("expected blank entries to be skipped, got %q", got)
+	}
+}
+
+func TestHubcellBuildCommandJoinsPlatforms(t *testing.T) {
+	cmd := HubcellBuildCommand(HubcellBuildOpts{
+		ContextPath: ".",
+		ImageTag:    "hubcell.local/user/project:tag",
+		Platforms:   []string{"linux/amd64", "linux/arm64"},
+	})
+
+	got := strings.Join(cmd.Args, " ")
+	if !strings.Contains(got, " --platform linux/amd64,linux/arm64 ") {
+		t.Fatalf("expected one --platform list, got %q", got)
+	}
+	if strings.Contains(strings.Join(HubcellBuildCommand(HubcellBuildOpts{ContextPath: ".", ImageTag: "t"}).Args, " "), "--platform") {
+		t.Fatal("expected no --platform flag without platforms")
+	}
+}
+
+func TestHubcellBuildCommandImportsAndExportsCache(t *testing.T) {
+	opts := HubcellBuildOpts{
+		ContextPath: ".",
+		ImageTag:    "hubcell.local/user/project:tag",
+		CacheRef:    "registry.example.com/cache/project:buildcache",
+	}
+	got := strings.Join(HubcellBuildCommand(opts).Args, " ")
+	if !strings.Contains(got, " --import-cache type=registry,ref=registry.example.com/cache/project:buildcache ") {
+		t.Fatalf("expected a registry cache import, got %q", got)
+	}
+	if !strings.Contains(got, " --export-cache type=registry,ref=registry.example.com/cache/project:buildcache,mode=max ") {
+		t.Fatalf("expected a registry cache export, got %q", got)
+	}
+
+	opts.NoCacheImport = true
+	got = strings.Join(HubcellBuildCommand(opts).Args, " ")
+	if strings.Contains(got, "--import-cache") || !strings.Contains(got, "--export-cache") {
+		t.Fatalf("expected only a cache export, got %q", got)
+	}
+	if strings.Contains(strings.Join(HubcellBuildCommand(HubcellBuildOpts{ContextPath: ".", ImageTag: "t"}).Args, " "), "-cache") {
+		t.Fatal("expected no cache flags without a cache ref")
+	}
+}
+
+func TestHubcellBuildCommandPassesSecretsByFile(t *testing.T) {
+	cmd := HubcellBuildCommand(HubcellBuildOpts{
+		ContextPath: ".",
+		ImageTag:    "hubcell.local/user/project:tag",
+		Envs:        []string{`APP_ENV="production"`},
+		Secrets:     []HubcellSecret{{ID: "NPM_TOKEN", Path: "/tmp/secrets/NPM_TOKEN"}},
+	})
+
+	got := strings.Join(cmd.Args, " ")
+	if !strings.Contains(got, " --secret id=NPM_TOKEN,src=/tmp/secrets/NPM_TOKEN ") {
+		t.Fatalf("expected the secret to be mounted from its file, got %q", got)
+	}
+	if !strings.Contains(got, ` -e APP_ENV="production" `) {
+		t.Fatalf("expected the build arg to stay an env entry, got %q", got)
+	}
+}
+
+func TestHubcellBuildCommandNamesDockerfile(t *testing.T) {
+	got := strings.Join(HubcellBuildCommand(HubcellBuildOpts{
+		ContextPath: "deploy",
+		ImageTag:    "hubcell.local/user/project:tag",
+		Dockerfile:  "deploy/Dockerfile.prod",
+	}).Args, " ")
+	if !strings.Contains(got, " -f deploy/Dockerfile.prod ") || !strings.HasSuffix(got, " deploy") {
+		t.Fatalf("expected the Dockerfile to be named with -f, got %q", got)
+	}
+	if strings.Contains(strings.Join(HubcellBuildCommand(HubcellBuildOpts{ContextPath: ".", ImageTag: "t"}).Args, " "), " -f ") {
+		t.Fatal("expected no -f flag for the default Dockerfile")
+	}
+}
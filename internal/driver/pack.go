@@ -0,0 +1,86 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const DefaultBuildpacksBuilder = "paketobuildpacks/builder-jammy-base"
+
+type PackBuildOpts struct {
+	PackPath    string
+	WorkDir     string
+	ContextPath string
+	ImageTag    string
+	// ExtraTags are applied to the same image in addition to ImageTag.
+	ExtraTags []string
+	Builder   string
+	Envs      []string
+	// EnvFile is passed as --env-file, for values that must stay off the
+	// command line.
+	EnvFile string
+	Network string
+	Publish bool
+}
+
+func PackBuildCommand(opts PackBuildOpts) *exec.Cmd {
+	return PackBuildCommandContext(context.Background(), opts)
+}
+
+func PackBuildCommandContext(ctx context.Context, opts PackBuildOpts) *exec.Cmd {
+	builder := strings.TrimSpace(opts.Builder)
+	if builder == "" {
+		builder = DefaultBuildpacksBuilder
+	}
+	args := []string{"build", opts.ImageTag, "--builder", builder, "--path", opts.ContextPath}
+	for _, tag := range opts.ExtraTags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			args = append(args, "--tag", tag)
+		}
+	}
+
+	if envFile := strings.TrimSpace(opts.EnvFile); envFile != "" {
+		args = append(args, "--env-file", envFile)
+	}
+	// pack passes --env values through verbatim, so entries are KEY=VALUE
+	// without the quoting Hubcell expects.
+	for _, envEntry := range opts.Envs {
+		envEntry = strings.TrimSpace(envEntry)
+		if envEntry == "" {
+			continue
+		}
+		args = append(args, "--env", envEntry)
+	}
+
+	if network := strings.TrimSpace(opts.Network); network != "" {
+		args = append(args, "--network", network)
+	}
+	if opts.Publish {
+		args = append(args, "--publish")
+	}
+
+	cmd := exec.CommandContext(ctx, ResolvePackCLIPath(opts.PackPath), args...)
+	if workDir := strings.TrimSpace(opts.WorkDir); workDir != "" {
+		cmd.Dir = workDir
+	}
+	return cmd
+}
+
+func ResolvePackCLIPath(raw string) string {
+	if path := strings.TrimSpace(raw); path != "" {
+		return path
+	}
+	return "pack"
+}
+
+func ValidatePackBuildOpts(opts PackBuildOpts) error {
+	if strings.TrimSpace(opts.ImageTag) == "" {
+		return fmt.Errorf("image tag is required")
+	}
+	if strings.TrimSpace(opts.ContextPath) == "" {
+		return fmt.Errorf("build context path is required")
+	}
+	return nil
+}
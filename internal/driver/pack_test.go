@@ -0,0 +1,79 @@
+package driver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPackBuildCommandUsesTagBuilderAndEnv(t *testing.T) {
+	cmd := PackBuildCommand(PackBuildOpts{
+		PackPath:    "/usr/local/bin/pack",
+		WorkDir:     "/tmp/repo",
+		ContextPath: "/tmp/repo/app",
+		ImageTag:    "hubcell.local/user/project:tag",
+		Builder:     "paketobuildpacks/builder-jammy-full",
+		Envs:        []string{"APP_ENV=production", " ", "DATABASE_URL=postgres://db/app"},
+		EnvFile:     "/tmp/hubfly-pack-env-1/env",
+		Network:     "project-net",
+		Publish:     true,
+	})
+
+	want := []string{
+		"/usr/local/bin/pack", "build", "hubcell.local/user/project:tag",
+		"--builder", "paketobuildpacks/builder-jammy-full",
+		"--path", "/tmp/repo/app",
+		"--env-file", "/tmp/hubfly-pack-env-1/env",
+		"--env", "APP_ENV=production",
+		"--env", "DATABASE_URL=postgres://db/app",
+		"--network", "project-net",
+		"--publish",
+	}
+	if got := strings.Join(cmd.Args, " "); got != strings.Join(want, " ") {
+		t.Fatalf("unexpected pack command:\nwant %q\ngot  %q", strings.Join(want, " "), got)
+	}
+	if cmd.Dir != "/tmp/repo" {
+		t.Fatalf("expected command dir to be set, got %q", cmd.Dir)
+	}
+}
+
+func TestPackBuildCommandDefaultsBuilderAndBinary(t *testing.T) {
+	cmd := PackBuildCommand(PackBuildOpts{
+		ContextPath: "/tmp/context",
+		ImageTag:    "hubcell.local/user/project:tag",
+	})
+
+	got := strings.Join(cmd.Args, " ")
+	if !strings.HasPrefix(got, "pack build hubcell.local/user/project:tag --builder "+DefaultBuildpacksBuilder+" ") {
+		t.Fatalf("expected default pack binary and builder, got %q", got)
+	}
+	for _, forbidden := range []string{"sudo", "--publish", "--network", "--env-file"} {
+		if strings.Contains(got, forbidden) {
+			t.Fatalf("did not expect %q in command: %q", forbidden, got)
+		}
+	}
+}
+
+func TestValidatePackBuildOptsRequiresTagAndContext(t *testing.T) {
+	if err := ValidatePackBuildOpts(PackBuildOpts{ContextPath: "/tmp/context"}); err == nil {
+		t.Fatal("expected missing image tag to be rejected")
+	}
+	if err := ValidatePackBuildOpts(PackBuildOpts{ImageTag: "hubcell.local/user/project:tag"}); err == nil {
+		t.Fatal("expected missing context path to be rejected")
+	}
+}
+
+func TestPackBuildCommandAddsExtraTags(t *testing.T) {
+	cmd := PackBuildCommand(PackBuildOpts{
+		ContextPath: "/tmp/context",
+		ImageTag:    "hubcell.local/user/project:abc-b1-v1",
+		ExtraTags:   []string{"hubcell.local/user/project:latest"},
+	})
+
+	got := strings.Join(cmd.Args, " ")
+	if !strings.HasPrefix(got, "pack build hubcell.local/user/project:abc-b1-v1 ") {
+		t.Fatalf("expected the immutable tag as the build target, got %q", got)
+	}
+	if !strings.Contains(got, "--tag hubcell.local/user/project:latest") {
+		t.Fatalf("expected the moving tag, got %q", got)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	"hubfly-builder/internal/storage"
@@ -12,6 +13,11 @@ import (
 
 const maxHintFileSize = 1 << 20 // 1 MiB
 
+// envKeyPattern matches the keys that survive as build args, secrets and
+// shell variables: a letter or underscore followed by letters, digits and
+// underscores.
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 type Result struct {
 	BuildArgs    map[string]string
 	BuildSecrets map[string]string
@@ -48,13 +54,17 @@ func (r Result) RuntimeKeys() []string {
 	return keys
 }
 
-func Resolve(buildContext string, env map[string]string, envOverrides map[string]storage.EnvOverride) Result {
-	return ResolveForPaths([]string{buildContext}, env, envOverrides)
+func Resolve(buildContext string, env map[string]string, envOverrides map[string]storage.EnvOverride, runtimeOnly []string) Result {
+	return ResolveForPaths([]string{buildContext}, env, envOverrides, runtimeOnly)
 }
 
-func ResolveForPaths(buildContexts []string, env map[string]string, envOverrides map[string]storage.EnvOverride) Result {
+// ResolveForPaths classifies each env key from the build hints found in
+// buildContexts, then applies envOverrides. Keys in runtimeOnly are always
+// resolved to the runtime scope so their values never reach the build.
+func ResolveForPaths(buildContexts []string, env map[string]string, envOverrides map[string]storage.EnvOverride, runtimeOnly []string) Result {
 	hints := collectBuildHintsForPaths(buildContexts)
 	normalizedOverrides := normalizeOverrides(envOverrides)
+	runtimeOnlyKeys := normalizeKeySet(runtimeOnly)
 	warnings := detectMissingBuildEnvWarnings(hints, env)
 
 	if len(env) == 0 {
@@ -66,13 +76,22 @@ func ResolveForPaths(buildContexts []string, env map[string]string, envOverrides
 	buildSecrets := make(map[string]string)
 
 	normalizedEnv := make(map[string]string, len(env))
+	var rejectedKeys []string
 	for key, value := range env {
 		trimmed := strings.TrimSpace(key)
 		if trimmed == "" {
 			continue
 		}
+		if !envKeyPattern.MatchString(trimmed) {
+			rejectedKeys = append(rejectedKeys, trimmed)
+			continue
+		}
 		normalizedEnv[trimmed] = value
 	}
+	sort.Strings(rejectedKeys)
+	for _, key := range rejectedKeys {
+		warnings = append(warnings, "ignoring env key "+strconv.Quote(key)+": keys must start with a letter or underscore and contain only letters, digits and underscores")
+	}
 
 	keys := make([]string, 0, len(normalizedEnv))
 	for key := range normalizedEnv {
@@ -100,6 +119,13 @@ func ResolveForPaths(buildContexts []string, env map[string]string, envOverrides
 				reason = appendReason(reason, "override-secret")
 			}
 		}
+		if runtimeOnlyKeys[key] || runtimeOnlyKeys[upperKey] {
+			if scope != "runtime" && strings.HasPrefix(reason, "dockerfile-") {
+				warnings = append(warnings, "env "+key+" is referenced by the Dockerfile but runtimeOnly keeps it out of the build")
+			}
+			scope = "runtime"
+			reason = appendReason(reason, "runtime-only")
+		}
 
 		entry := storage.ResolvedEnvVar{
 			Key:    key,
@@ -130,6 +156,30 @@ func ResolveForPaths(buildContexts []string, env map[string]string, envOverrides
 	}
 }
 
+// SecretValues returns the values of the env keys classified as secret by
+// name or by envOverrides, whatever their scope. Unlike ResolveForPaths it
+// needs no build context, so callers can mask these values in output written
+// before the context is known.
+func SecretValues(env map[string]string, envOverrides map[string]storage.EnvOverride) map[string]string {
+	normalizedOverrides := normalizeOverrides(envOverrides)
+	secrets := make(map[string]string)
+	for key, value := range env {
+		trimmed := strings.TrimSpace(key)
+		if trimmed == "" {
+			continue
+		}
+		upperKey := strings.ToUpper(trimmed)
+		secret := classifySecret(upperKey)
+		if override, ok := lookupOverride(trimmed, upperKey, normalizedOverrides); ok && override.Secret != nil {
+			secret = *override.Secret
+		}
+		if secret {
+			secrets[trimmed] = value
+		}
+	}
+	return secrets
+}
+
 func normalizeOverrides(overrides map[string]storage.EnvOverride) map[string]storage.EnvOverride {
 	if len(overrides) == 0 {
 		return nil
@@ -147,6 +197,22 @@ func normalizeOverrides(overrides map[string]storage.EnvOverride) map[string]sto
 	return normalized
 }
 
+// normalizeKeySet indexes keys by their trimmed and upper-cased forms, like
+// normalizeOverrides.
+func normalizeKeySet(keys []string) map[string]bool {
+	if len(keys) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(keys)*2)
+	for _, key := range keys {
+		if trimmed := strings.TrimSpace(key); trimmed != "" {
+			set[trimmed] = true
+			set[strings.ToUpper(trimmed)] = true
+		}
+	}
+	return set
+}
+
 func lookupOverride(key, upperKey string, overrides map[string]storage.EnvOverride) (storage.EnvOverride, bool) {
 	if len(overrides) == 0 {
 		return storage.EnvOverride{}, false
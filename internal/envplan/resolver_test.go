@@ -16,7 +16,7 @@ func boolPtr(v bool) *bool {
 func TestResolve_AutoDetectsWhenNoOverrides(t *testing.T) {
 	result := Resolve("", map[string]string{
 		"NEXT_PUBLIC_API_URL": "http://backend:8080",
-	}, nil)
+	}, nil, nil)
 
 	entry := findEntry(result.Entries, "NEXT_PUBLIC_API_URL")
 	if entry == nil {
@@ -45,7 +45,7 @@ func TestResolve_AppliesOverrides(t *testing.T) {
 			Scope:  "build",
 			Secret: boolPtr(true),
 		},
-	})
+	}, nil)
 
 	entry := findEntry(result.Entries, "NEXT_PUBLIC_API_URL")
 	if entry == nil {
@@ -85,7 +85,7 @@ func TestResolve_OverrideCanForceSecretOnDockerfileArg(t *testing.T) {
 		"API_TOKEN": {
 			Secret: boolPtr(true),
 		},
-	})
+	}, nil)
 
 	entry := findEntry(result.Entries, "API_TOKEN")
 	if entry == nil {
@@ -109,13 +109,47 @@ func TestResolve_OverrideCanForceSecretOnDockerfileArg(t *testing.T) {
 	}
 }
 
+func TestResolve_RuntimeOnlyWinsOverDockerfileReference(t *testing.T) {
+	dir := t.TempDir()
+	dockerfile := "FROM scratch\nARG API_URL\nRUN echo $SENTRY_AUTH_TOKEN\n"
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfile), 0644); err != nil {
+		t.Fatalf("failed to write dockerfile: %v", err)
+	}
+
+	result := Resolve(dir, map[string]string{
+		"API_URL":           "https://api.example.com",
+		"SENTRY_AUTH_TOKEN": "abc123",
+	}, map[string]storage.EnvOverride{
+		"API_URL": {Scope: "build"},
+	}, []string{"API_URL", " sentry_auth_token "})
+
+	for _, key := range []string{"API_URL", "SENTRY_AUTH_TOKEN"} {
+		entry := findEntry(result.Entries, key)
+		if entry == nil {
+			t.Fatalf("expected resolved entry for %s", key)
+		}
+		if entry.Scope != "runtime" || !strings.HasSuffix(entry.Reason, "+runtime-only") {
+			t.Fatalf("expected %s to be forced runtime-only, got scope %q reason %q", key, entry.Scope, entry.Reason)
+		}
+		if _, ok := result.BuildArgs[key]; ok {
+			t.Fatalf("did not expect %s in build args", key)
+		}
+		if _, ok := result.BuildSecrets[key]; ok {
+			t.Fatalf("did not expect %s in build secrets", key)
+		}
+	}
+	if len(result.Warnings) != 2 {
+		t.Fatalf("expected a warning for each key the Dockerfile references, got %v", result.Warnings)
+	}
+}
+
 func TestResolve_WarnsWhenPublicBuildEnvIsReferencedButMissing(t *testing.T) {
 	dir := t.TempDir()
 	if err := os.WriteFile(filepath.Join(dir, "vite.config.ts"), []byte("const value = import.meta.env.VITE_API_URL\n"), 0o644); err != nil {
 		t.Fatalf("failed to write vite config: %v", err)
 	}
 
-	result := Resolve(dir, nil, nil)
+	result := Resolve(dir, nil, nil, nil)
 	if len(result.Warnings) == 0 {
 		t.Fatalf("expected missing build env warnings")
 	}
@@ -132,7 +166,7 @@ func TestResolve_DoesNotWarnWhenReferencedPublicBuildEnvIsProvided(t *testing.T)
 
 	result := Resolve(dir, map[string]string{
 		"NEXT_PUBLIC_API_URL": "https://api.example.com",
-	}, nil)
+	}, nil, nil)
 	if len(result.Warnings) != 0 {
 		t.Fatalf("expected no missing build env warnings, got %#v", result.Warnings)
 	}
@@ -146,3 +180,61 @@ func findEntry(entries []storage.ResolvedEnvVar, key string) *storage.ResolvedEn
 	}
 	return nil
 }
+
+func TestResolve_RejectsInvalidEnvKeysWithWarning(t *testing.T) {
+	result := Resolve("", map[string]string{
+		"API-URL":      "https://api.example.com",
+		"1ST_VALUE":    "one",
+		"HAS SPACE":    "x",
+		"  _PRIVATE  ": "ok",
+		"DATABASE_URL": "postgres://db",
+	}, nil, nil)
+
+	for _, key := range []string{"API-URL", "1ST_VALUE", "HAS SPACE"} {
+		if findEntry(result.Entries, key) != nil {
+			t.Fatalf("expected invalid key %q to be dropped", key)
+		}
+		if _, ok := result.BuildArgs[key]; ok {
+			t.Fatalf("expected invalid key %q to be excluded from build args", key)
+		}
+		found := false
+		for _, warning := range result.Warnings {
+			if strings.Contains(warning, `"`+key+`"`) {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("expected a warning for %q, got %#v", key, result.Warnings)
+		}
+	}
+	for _, key := range []string{"_PRIVATE", "DATABASE_URL"} {
+		if findEntry(result.Entries, key) == nil {
+			t.Fatalf("expected valid key %q to be resolved", key)
+		}
+	}
+	if len(result.Warnings) != 3 {
+		t.Fatalf("expected exactly one warning per invalid key, got %#v", result.Warnings)
+	}
+}
+
+func TestSecretValuesClassifiesKeysWithoutBuildContext(t *testing.T) {
+	secrets := SecretValues(map[string]string{
+		"NEXT_PUBLIC_API_URL": "http://backend:8080",
+		"STRIPE_SECRET_KEY":   "sk_live_123",
+		"SENTRY_DSN":          "https://key@sentry.example.com/1",
+		"FEATURE_FLAG":        "on",
+	}, map[string]storage.EnvOverride{
+		"sentry_dsn":   {Secret: boolPtr(false)},
+		"FEATURE_FLAG": {Secret: boolPtr(true)},
+	})
+
+	want := map[string]string{"STRIPE_SECRET_KEY": "sk_live_123", "FEATURE_FLAG": "on"}
+	if len(secrets) != len(want) {
+		t.Fatalf("expected secrets %v, got %v", want, secrets)
+	}
+	for key, value := range want {
+		if secrets[key] != value {
+			t.Fatalf("expected secrets %v, got %v", want, secrets)
+		}
+	}
+}
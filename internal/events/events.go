@@ -0,0 +1,126 @@
+// Package events is an in-process feed of job lifecycle events. The manager,
+// workers and HTTP handlers publish to a Bus; subscribers such as the
+// /api/v1/events stream receive the events that match their filter.
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Type string
+
+const (
+	// JobCreated is published when a job is accepted into the queue.
+	JobCreated Type = "job.created"
+	// JobStatus is published after every job status transition.
+	JobStatus Type = "job.status"
+	// JobPhase is published when a running job enters a build phase.
+	JobPhase Type = "job.phase"
+	// JobProgress is published when the estimated progress of a running job
+	// goes up.
+	JobProgress Type = "job.progress"
+	// Dropped tells a subscriber how many events it missed because it read
+	// too slowly.
+	Dropped Type = "dropped"
+)
+
+type Event struct {
+	Type      Type   `json:"type"`
+	JobID     string `json:"jobId,omitempty"`
+	ProjectID string `json:"projectId,omitempty"`
+	UserID    string `json:"userId,omitempty"`
+	Status    string `json:"status,omitempty"`
+	Phase     string `json:"phase,omitempty"`
+	// EstimatedProgress is a rough completion percentage of the build,
+	// derived from fixed phase weights; it is not a measurement.
+	EstimatedProgress int       `json:"estimatedProgress,omitempty"`
+	Dropped           int64     `json:"dropped,omitempty"`
+	Time              time.Time `json:"time"`
+}
+
+// Filter selects events by project and user; empty fields match everything.
+type Filter struct {
+	ProjectID string
+	UserID    string
+}
+
+func (f Filter) Matches(event Event) bool {
+	return (f.ProjectID == "" || f.ProjectID == event.ProjectID) &&
+		(f.UserID == "" || f.UserID == event.UserID)
+}
+
+// Bus fans published events out to subscribers. A nil *Bus accepts and
+// discards events, so publishers need no checks.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*Subscription]struct{})}
+}
+
+// Publish delivers event to every matching subscriber without blocking. A
+// subscriber whose buffer is full misses the event and is told how many it
+// missed on its next read.
+func (b *Bus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subscribers {
+		if !sub.filter.Matches(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe registers a subscriber that buffers up to buffer events.
+func (b *Bus) Subscribe(filter Filter, buffer int) *Subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	sub := &Subscription{bus: b, filter: filter, events: make(chan Event, buffer)}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+type Subscription struct {
+	bus     *Bus
+	filter  Filter
+	events  chan Event
+	dropped atomic.Int64
+	once    sync.Once
+}
+
+// Events is closed once the subscription is closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// TakeDropped returns how many events were dropped since the last call.
+func (s *Subscription) TakeDropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subscribers, s)
+		close(s.events)
+		s.bus.mu.Unlock()
+	})
+}
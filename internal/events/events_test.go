@@ -0,0 +1,61 @@
+package events
+
+import "testing"
+
+func TestBusDeliversMatchingEvents(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe(Filter{ProjectID: "proj_a"}, 4)
+	defer sub.Close()
+
+	bus.Publish(Event{Type: JobStatus, JobID: "build_b", ProjectID: "proj_b", Status: "claimed"})
+	bus.Publish(Event{Type: JobStatus, JobID: "build_a", ProjectID: "proj_a", Status: "claimed"})
+
+	select {
+	case event := <-sub.Events():
+		if event.JobID != "build_a" || event.Time.IsZero() {
+			t.Fatalf("expected the proj_a event with a timestamp, got %+v", event)
+		}
+	default:
+		t.Fatal("expected an event for proj_a")
+	}
+	select {
+	case event := <-sub.Events():
+		t.Fatalf("expected no other events, got %+v", event)
+	default:
+	}
+}
+
+func TestBusCountsEventsDroppedBySlowSubscribers(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe(Filter{}, 2)
+	defer sub.Close()
+
+	for i := 0; i < 5; i++ {
+		bus.Publish(Event{Type: JobPhase, JobID: "build_slow"})
+	}
+
+	if got := len(sub.Events()); got != 2 {
+		t.Fatalf("expected the buffer to hold 2 events, got %d", got)
+	}
+	if dropped := sub.TakeDropped(); dropped != 3 {
+		t.Fatalf("expected 3 dropped events, got %d", dropped)
+	}
+	if dropped := sub.TakeDropped(); dropped != 0 {
+		t.Fatalf("expected the dropped count to reset, got %d", dropped)
+	}
+}
+
+func TestClosedSubscriptionStopsReceiving(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe(Filter{}, 1)
+	sub.Close()
+	sub.Close()
+
+	bus.Publish(Event{Type: JobCreated, JobID: "build_after_close"})
+	if _, open := <-sub.Events(); open {
+		t.Fatal("expected the events channel to be closed")
+	}
+
+	var nilBus *Bus
+	nilBus.Publish(Event{Type: JobCreated})
+}
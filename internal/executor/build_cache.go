@@ -0,0 +1,127 @@
+package executor
+
+import (
+	"math"
+	"regexp"
+	"strings"
+
+	"hubfly-builder/internal/driver"
+	"hubfly-builder/internal/storage"
+)
+
+// buildCacheTag is the tag of a project's build cache image in the cache
+// registry.
+const buildCacheTag = "buildcache"
+
+// buildCacheImportMarkers are printed when the cache image cannot be
+// imported, most often because the project has not been built yet.
+var buildCacheImportMarkers = []string{
+	"failed to configure registry cache importer",
+	"error importing cache",
+	"failed to import cache",
+}
+
+var (
+	buildStepPattern  = regexp.MustCompile(`(?m)^#(\d+) \[[^\]]*\d+/\d+\]`)
+	cachedStepPattern = regexp.MustCompile(`(?m)^#(\d+) CACHED\s*$`)
+)
+
+// SetBuildCacheRegistry makes Hubcell builds import and export their layer
+// cache through registry, one cache image per project. An empty registry
+// disables the cache.
+func (m *Manager) SetBuildCacheRegistry(registry string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cacheRegistry = strings.TrimRight(strings.TrimSpace(registry), "/")
+}
+
+// buildCacheRef is the cache image of projectID in registry, or "" when
+// either is unset.
+func buildCacheRef(registry, projectID string) string {
+	project := sanitizeImageTagComponent(projectID)
+	if registry == "" || project == "" {
+		return ""
+	}
+	return registry + "/" + project + ":" + buildCacheTag
+}
+
+// applyBuildCache points opts at the project's build cache unless the job
+// disables it.
+func (w *Worker) applyBuildCache(opts *driver.HubcellBuildOpts) {
+	if w.job.BuildConfig.DisableCache {
+		if w.cacheRegistry != "" {
+			w.logInfo("Build cache disabled for this job")
+		}
+		return
+	}
+	opts.CacheRef = buildCacheRef(w.cacheRegistry, w.job.ProjectID)
+	w.buildCacheRef = opts.CacheRef
+}
+
+// inspectBuildCache logs how much of a build the cache served and records
+// whether the cache import failed.
+func (w *Worker) inspectBuildCache(output string, buildErr error) {
+	if w.buildCacheRef == "" {
+		return
+	}
+	w.buildCacheImportFailed = containsAnyMarker(output, buildCacheImportMarkers)
+	if buildErr != nil {
+		return
+	}
+	if w.buildCacheImportFailed {
+		w.logInfo("No build cache found at %s yet; this build creates it", w.buildCacheRef)
+		return
+	}
+	steps, cached := countCachedSteps(output)
+	w.logInfo("Build cache: %d of %d build steps reused from %s", cached, steps, w.buildCacheRef)
+}
+
+// countCachedSteps counts the build steps in BuildKit's plain progress output
+// and how many of them were served from the cache.
+func countCachedSteps(output string) (steps, cached int) {
+	seen := make(map[string]bool)
+	for _, match := range buildStepPattern.FindAllStringSubmatch(output, -1) {
+		seen[match[1]] = false
+	}
+	for _, match := range cachedStepPattern.FindAllStringSubmatch(output, -1) {
+		if _, ok := seen[match[1]]; ok {
+			seen[match[1]] = true
+		}
+	}
+	for _, isCached := range seen {
+		if isCached {
+			cached++
+		}
+	}
+	return len(seen), cached
+}
+
+// parseBuildCacheStats summarises countCachedSteps for the job record. It
+// returns nil when the output shows no build step.
+func parseBuildCacheStats(output string) *storage.BuildCacheStats {
+	steps, cached := countCachedSteps(output)
+	if steps == 0 {
+		return nil
+	}
+	return &storage.BuildCacheStats{
+		CachedSteps:   cached,
+		ExecutedSteps: steps - cached,
+		HitRatio:      math.Round(float64(cached)/float64(steps)*100) / 100,
+	}
+}
+
+// recordBuildCacheStats stores how many steps of the job's image build the
+// layer cache served, for the API and the callback.
+func (w *Worker) recordBuildCacheStats(output string) {
+	stats := parseBuildCacheStats(output)
+	w.job.BuildCache = stats
+	if stats != nil && w.buildCacheRef == "" {
+		w.logInfo("Build cache: %d of %d build steps reused", stats.CachedSteps, stats.CachedSteps+stats.ExecutedSteps)
+	}
+	if w.storage == nil {
+		return
+	}
+	if err := w.storage.SetJobBuildCacheStats(w.job.ID, stats); err != nil {
+		w.log("WARNING: could not record build cache stats: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package executor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"hubfly-builder/internal/storage"
+)
+
+func TestBuildCacheRef(t *testing.T) {
+	if got := buildCacheRef("registry.example.com/cache", "Proj_42"); got != "registry.example.com/cache/proj_42:buildcache" {
+		t.Fatalf("unexpected cache ref %q", got)
+	}
+	if got := buildCacheRef("", "proj"); got != "" {
+		t.Fatalf("expected no cache ref without a registry, got %q", got)
+	}
+}
+
+func TestCountCachedSteps(t *testing.T) {
+	output := strings.Join([]string{
+		"#1 [internal] load build definition from Dockerfile",
+		"#5 [1/3] FROM docker.io/library/node:20",
+		"#5 CACHED",
+		"#6 [2/3] COPY package.json .",
+		"#6 CACHED",
+		"#7 [3/3] RUN npm ci",
+		"#7 DONE 12.3s",
+	}, "\n")
+	steps, cached := countCachedSteps(output)
+	if steps != 3 || cached != 2 {
+		t.Fatalf("expected 2 of 3 steps cached, got %d of %d", cached, steps)
+	}
+}
+
+func TestParseBuildCacheStats(t *testing.T) {
+	output := `#1 [internal] load build definition from Dockerfile
+#1 transferring dockerfile: 412B done
+#1 DONE 0.0s
+#2 [internal] load metadata for docker.io/library/node:20-alpine
+#2 DONE 0.4s
+#3 [builder 1/4] FROM docker.io/library/node:20-alpine@sha256:0123
+#3 CACHED
+#4 [builder 2/4] COPY package.json package-lock.json ./
+#4 CACHED
+#5 [builder 3/4] RUN npm ci
+#5 CACHED
+#6 [builder 4/4] RUN npm run build
+#6 2.310 > vite build
+#6 DONE 8.2s
+#7 [stage-1 1/2] COPY --from=builder /app/dist /usr/share/nginx/html
+#7 DONE 0.1s
+#6 [builder 4/4] RUN npm run build
+#8 exporting to image
+#8 DONE 0.3s
+`
+	want := &storage.BuildCacheStats{CachedSteps: 3, ExecutedSteps: 2, HitRatio: 0.6}
+	if got := parseBuildCacheStats(output); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected cache stats: got %+v, want %+v", got, want)
+	}
+	if got := parseBuildCacheStats("#1 [internal] load build definition from Dockerfile\n"); got != nil {
+		t.Fatalf("expected no stats without build steps, got %+v", got)
+	}
+}
+
+func TestMissingBuildCacheDoesNotFailTheBuild(t *testing.T) {
+	calls := fakeCalls(installFakeBinary(t, "sudo", recordFakeCall+
+		"case \"$*\" in *--import-cache*)\n"+
+		"  echo 'error: failed to configure registry cache importer: registry.example.com/cache/proj:buildcache: not found' >&2\n"+
+		"  exit 1\n"+
+		"esac\n"))
+
+	worker := newTestWorker(t, nil)
+	worker.job.ProjectID = "proj"
+	worker.cacheRegistry = "registry.example.com/cache"
+	opts := registryTestOpts(t)
+	worker.applyBuildCache(&opts)
+
+	if err := worker.buildImageWithHubcell(opts); err != nil {
+		t.Fatalf("expected the build to succeed without the cache, got %v", err)
+	}
+	attempts := strings.Split(strings.TrimSpace(readSudoCalls(t, calls)), "\n")
+	if len(attempts) != 2 {
+		t.Fatalf("expected one rerun without the cache import, got %d attempts", len(attempts))
+	}
+	if strings.Contains(attempts[1], "--import-cache") || !strings.Contains(attempts[1], "--export-cache type=registry,ref=registry.example.com/cache/proj:buildcache") {
+		t.Fatalf("expected the rerun to only export the cache, got %q", attempts[1])
+	}
+}
+
+func TestDisableCacheSkipsBuildCache(t *testing.T) {
+	worker := newTestWorker(t, nil)
+	worker.job = &storage.BuildJob{ID: "build_cache", ProjectID: "proj", BuildConfig: storage.BuildConfig{DisableCache: true}}
+	worker.cacheRegistry = "registry.example.com/cache"
+	opts := registryTestOpts(t)
+	worker.applyBuildCache(&opts)
+	if opts.CacheRef != "" {
+		t.Fatalf("expected no cache ref for a job that disables the cache, got %q", opts.CacheRef)
+	}
+}
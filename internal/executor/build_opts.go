@@ -0,0 +1,82 @@
+package executor
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var buildOptKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// reservedBuildOpts are hubcell build flags the builder sets itself. They
+// cannot be passed through buildConfig.buildOpts even when allowlisted.
+var reservedBuildOpts = map[string]bool{
+	"add-host":            true,
+	"cap-add":             true,
+	"cpu-period":          true,
+	"cpu-quota":           true,
+	"env":                 true,
+	"export-cache":        true,
+	"e":                   true,
+	"file":                true,
+	"f":                   true,
+	"import-cache":        true,
+	"memory":              true,
+	"m":                   true,
+	"network":             true,
+	"platform":            true,
+	"rootfs-initial-size": true,
+	"tag":                 true,
+	"t":                   true,
+	"verbose":             true,
+}
+
+// ValidateBuildOpts checks buildConfig.buildOpts against the operator's
+// BUILD_OPT_ALLOWLIST. Every key must be a plain flag name, listed in allowed
+// and not one the builder already sets.
+func ValidateBuildOpts(buildOpts map[string]string, allowed []string) error {
+	if len(buildOpts) == 0 {
+		return nil
+	}
+	permitted := make(map[string]bool, len(allowed))
+	for _, key := range allowed {
+		permitted[strings.TrimSpace(key)] = true
+	}
+
+	keys := make([]string, 0, len(buildOpts))
+	for key := range buildOpts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch {
+		case !buildOptKeyPattern.MatchString(key):
+			return fmt.Errorf("buildOpts key %q is not a valid flag name", key)
+		case reservedBuildOpts[key]:
+			return fmt.Errorf("buildOpts key %q is set by the builder and cannot be overridden", key)
+		case !permitted[key]:
+			return fmt.Errorf("buildOpts key %q is not allowed on this builder", key)
+		case strings.ContainsAny(buildOpts[key], "\r\n"):
+			return fmt.Errorf("buildOpts value for %q must be a single line", key)
+		}
+	}
+	return nil
+}
+
+// SetBuildOptAllowlist sets which buildConfig.buildOpts keys jobs may pass to
+// hubcell build. Empty rejects every build opt.
+func (m *Manager) SetBuildOptAllowlist(keys []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buildOptAllowlist = append([]string(nil), keys...)
+}
+
+// ValidateBuildOpts checks buildOpts against the manager's allowlist, so jobs
+// can be rejected before they are queued.
+func (m *Manager) ValidateBuildOpts(buildOpts map[string]string) error {
+	m.mu.Lock()
+	allowed := m.buildOptAllowlist
+	m.mu.Unlock()
+	return ValidateBuildOpts(buildOpts, allowed)
+}
@@ -0,0 +1,35 @@
+package executor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateBuildOptsAllowsListedKeys(t *testing.T) {
+	if err := ValidateBuildOpts(map[string]string{"squash": "true"}, []string{"squash", "label"}); err != nil {
+		t.Fatalf("expected allowlisted opt to pass, got %v", err)
+	}
+	if err := ValidateBuildOpts(nil, nil); err != nil {
+		t.Fatalf("expected no opts to pass without an allowlist, got %v", err)
+	}
+}
+
+func TestValidateBuildOptsRejectsDisallowedKeys(t *testing.T) {
+	tests := map[string]struct {
+		opts    map[string]string
+		allowed []string
+		want    string
+	}{
+		"not allowlisted": {map[string]string{"privileged": "true"}, []string{"squash"}, `buildOpts key "privileged" is not allowed on this builder`},
+		"no allowlist":    {map[string]string{"squash": "true"}, nil, `buildOpts key "squash" is not allowed on this builder`},
+		"reserved":        {map[string]string{"cap-add": "SYS_ADMIN"}, []string{"cap-add"}, `buildOpts key "cap-add" is set by the builder`},
+		"flag injection":  {map[string]string{"squash --privileged": "x"}, []string{"squash --privileged"}, "is not a valid flag name"},
+		"multi-line":      {map[string]string{"label": "a\nb"}, []string{"label"}, "must be a single line"},
+	}
+	for name, test := range tests {
+		err := ValidateBuildOpts(test.opts, test.allowed)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: expected error containing %q, got %v", name, test.want, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"hubfly-builder/internal/driver"
+	"hubfly-builder/internal/envplan"
+)
+
+// writeBuildSecrets writes each build secret to its own file in a private
+// temporary directory outside the build context, for `hubcell build
+// --secret`. It returns the directory, which the caller removes once the
+// build is done, or "" when there are no secrets.
+func writeBuildSecrets(secrets map[string]string) (string, []driver.HubcellSecret, error) {
+	if len(secrets) == 0 {
+		return "", nil, nil
+	}
+	dir, err := os.MkdirTemp("", "hubfly-build-secrets-")
+	if err != nil {
+		return "", nil, fmt.Errorf("could not create build secret directory: %w", err)
+	}
+
+	keys := make([]string, 0, len(secrets))
+	for key := range secrets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	mounts := make([]driver.HubcellSecret, 0, len(keys))
+	for _, key := range keys {
+		path := filepath.Join(dir, key)
+		if err := os.WriteFile(path, []byte(secrets[key]), 0o600); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, fmt.Errorf("could not write build secret %s: %w", key, err)
+		}
+		mounts = append(mounts, driver.HubcellSecret{ID: key, Path: path})
+	}
+	return dir, mounts, nil
+}
+
+// writePackEnvFile writes the resolved build env, secrets included, to a 0600
+// file in a private temporary directory, for `pack build --env-file`, so no
+// value shows up on pack's command line. It returns the directory, which the
+// caller removes once the build is done, and the file, or "" for both when
+// there is no build env. pack reads one KEY=VALUE per line, so a value
+// spanning lines is rejected.
+func writePackEnvFile(result envplan.Result) (string, string, error) {
+	keys, values := resolvedBuildEnvValues(result)
+	if len(keys) == 0 {
+		return "", "", nil
+	}
+	var content strings.Builder
+	for _, key := range keys {
+		if strings.ContainsAny(values[key], "\r\n") {
+			return "", "", fmt.Errorf("env %s spans several lines, which buildpacks builds cannot pass", key)
+		}
+		content.WriteString(key + "=" + values[key] + "\n")
+	}
+
+	dir, err := os.MkdirTemp("", "hubfly-pack-env-")
+	if err != nil {
+		return "", "", fmt.Errorf("could not create pack env directory: %w", err)
+	}
+	path := filepath.Join(dir, "env")
+	if err := os.WriteFile(path, []byte(content.String()), 0o600); err != nil {
+		os.RemoveAll(dir)
+		return "", "", fmt.Errorf("could not write pack env file: %w", err)
+	}
+	return dir, path, nil
+}
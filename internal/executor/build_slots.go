@@ -0,0 +1,47 @@
+package executor
+
+import (
+	"context"
+	"time"
+)
+
+// SetMaxConcurrentImageBuilds limits how many image build commands (hubcell
+// build or pack build) run at once across all workers, below the number of
+// concurrent jobs if needed. The build step is the heavy part of a job, so a
+// host that can clone and generate for several jobs at once may only fit a
+// few builds. Workers past the limit wait for a slot. Zero removes the limit.
+func (m *Manager) SetMaxConcurrentImageBuilds(limit int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if limit <= 0 {
+		m.imageBuildSlots = nil
+		return
+	}
+	m.imageBuildSlots = make(chan struct{}, limit)
+}
+
+// acquireImageBuildSlot blocks until an image build slot is free or the job
+// is canceled. The returned function releases the slot.
+func (w *Worker) acquireImageBuildSlot() (func(), error) {
+	if w.imageBuildSlots == nil {
+		return func() {}, nil
+	}
+	ctx := w.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	select {
+	case w.imageBuildSlots <- struct{}{}:
+	default:
+		w.logInfo("All %d image build slots are busy; waiting for one", cap(w.imageBuildSlots))
+		started := time.Now()
+		select {
+		case w.imageBuildSlots <- struct{}{}:
+			w.logInfo("Got an image build slot after %s", time.Since(started).Round(time.Second))
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+	return func() { <-w.imageBuildSlots }, nil
+}
@@ -0,0 +1,78 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestImageBuildSlotsBlockPastTheLimit(t *testing.T) {
+	manager := &Manager{}
+	manager.SetMaxConcurrentImageBuilds(1)
+
+	first := newTestWorker(t, nil)
+	first.imageBuildSlots = manager.imageBuildSlots
+	second := newTestWorker(t, nil)
+	second.imageBuildSlots = manager.imageBuildSlots
+
+	releaseFirst, err := first.acquireImageBuildSlot()
+	if err != nil {
+		t.Fatalf("first build could not take a slot: %v", err)
+	}
+
+	acquired := make(chan func(), 1)
+	go func() {
+		release, err := second.acquireImageBuildSlot()
+		if err != nil {
+			t.Errorf("second build could not take a slot: %v", err)
+			return
+		}
+		acquired <- release
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second build started while the only slot was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	releaseFirst()
+	select {
+	case release := <-acquired:
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("second build did not start after the slot was freed")
+	}
+}
+
+func TestImageBuildSlotWaitStopsWhenTheJobIsCanceled(t *testing.T) {
+	manager := &Manager{}
+	manager.SetMaxConcurrentImageBuilds(1)
+	manager.imageBuildSlots <- struct{}{}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	worker := newTestWorker(t, nil)
+	worker.ctx = ctx
+	worker.imageBuildSlots = manager.imageBuildSlots
+
+	canceled := errors.New("canceled by user")
+	time.AfterFunc(20*time.Millisecond, func() { cancel(canceled) })
+	if _, err := worker.acquireImageBuildSlot(); !errors.Is(err, canceled) {
+		t.Fatalf("expected the wait to end with the cancel cause, got %v", err)
+	}
+}
+
+func TestImageBuildSlotsUnlimitedByDefault(t *testing.T) {
+	manager := &Manager{}
+	manager.SetMaxConcurrentImageBuilds(0)
+	if manager.imageBuildSlots != nil {
+		t.Fatal("expected no limit for zero")
+	}
+	worker := newTestWorker(t, nil)
+	for i := 0; i < 3; i++ {
+		if _, err := worker.acquireImageBuildSlot(); err != nil {
+			t.Fatalf("unexpected error without a limit: %v", err)
+		}
+	}
+}
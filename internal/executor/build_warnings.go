@@ -0,0 +1,85 @@
+package executor
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"hubfly-builder/internal/storage"
+)
+
+var (
+	// buildWarningLinePattern matches a warning printed while the build runs,
+	// e.g. "#1 WARN: SecretsUsedInArgOrEnv: Do not use ARG ... (line 4)".
+	buildWarningLinePattern = regexp.MustCompile(`^(?:#\d+\s+)?WARN(?:ING)?:\s+(.+)$`)
+	// buildWarningSummaryPattern starts the list of check warnings BuildKit
+	// prints at the end of a build, one " - Rule: message (line N)" per line.
+	buildWarningSummaryPattern = regexp.MustCompile(`^\d+ warnings? found\b`)
+	buildWarningItemPattern    = regexp.MustCompile(`^-\s+(.+)$`)
+	buildWarningRulePattern    = regexp.MustCompile(`^([A-Z][A-Za-z0-9]+):\s+(.+)$`)
+	buildWarningLineSuffix     = regexp.MustCompile(`\s*\(line (\d+)\)$`)
+)
+
+// parseBuildWarnings extracts the warnings an image build printed, in order
+// and without duplicates. Check warnings carry the rule name and Dockerfile
+// line when the builder reports them.
+func parseBuildWarnings(output string) storage.BuildWarnings {
+	var warnings storage.BuildWarnings
+	seen := map[storage.BuildWarning]bool{}
+	inSummary := false
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		var text string
+		switch {
+		case buildWarningSummaryPattern.MatchString(line):
+			inSummary = true
+			continue
+		case inSummary && buildWarningItemPattern.MatchString(line):
+			text = buildWarningItemPattern.FindStringSubmatch(line)[1]
+		case buildWarningLinePattern.MatchString(line):
+			inSummary = false
+			text = buildWarningLinePattern.FindStringSubmatch(line)[1]
+		default:
+			inSummary = false
+			continue
+		}
+
+		warning := parseBuildWarning(text)
+		if warning.Message == "" || seen[warning] {
+			continue
+		}
+		seen[warning] = true
+		warnings = append(warnings, warning)
+	}
+	return warnings
+}
+
+func parseBuildWarning(text string) storage.BuildWarning {
+	var warning storage.BuildWarning
+	text = strings.TrimSpace(text)
+	if match := buildWarningLineSuffix.FindStringSubmatch(text); match != nil {
+		warning.Line, _ = strconv.Atoi(match[1])
+		text = strings.TrimSpace(strings.TrimSuffix(text, match[0]))
+	}
+	if match := buildWarningRulePattern.FindStringSubmatch(text); match != nil {
+		warning.Rule = match[1]
+		text = match[2]
+	}
+	warning.Message = strings.TrimSpace(text)
+	return warning
+}
+
+// recordBuildWarnings stores the warnings in the output of the job's image
+// build so the API can show them apart from the full log.
+func (w *Worker) recordBuildWarnings(output string) {
+	warnings := parseBuildWarnings(output)
+	if len(warnings) > 0 {
+		w.logInfo("Image build reported %d warning(s)", len(warnings))
+	}
+	if w.storage == nil {
+		return
+	}
+	if err := w.storage.SetJobBuildWarnings(w.job.ID, warnings); err != nil {
+		w.log("WARNING: could not record build warnings: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package executor
+
+import (
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"hubfly-builder/internal/storage"
+)
+
+func TestParseBuildWarnings(t *testing.T) {
+	output := `#1 [internal] load build definition from Dockerfile
+#1 transferring dockerfile: 312B done
+#1 WARN: SecretsUsedInArgOrEnv: Do not use ARG or ENV instructions for sensitive data (ARG "API_TOKEN") (line 4)
+#1 WARN: FromAsCasing: 'as' and 'FROM' keywords' casing do not match (line 1)
+#1 DONE 0.0s
+#5 [2/3] RUN npm ci
+#5 12.40 npm WARN deprecated inflight@1.0.6: This module is not supported
+#5 DONE 14.1s
+WARNING: buildx: git was not found in the system. Current commit information was not captured by the build
+
+ 2 warnings found (use docker --debug to expand):
+ - SecretsUsedInArgOrEnv: Do not use ARG or ENV instructions for sensitive data (ARG "API_TOKEN") (line 4)
+ - JSONArgsRecommended: JSON arguments recommended for CMD to prevent unintended behavior related to OS signals (line 12)
+Successfully built image
+- not a warning
+`
+
+	want := storage.BuildWarnings{
+		{Rule: "SecretsUsedInArgOrEnv", Message: `Do not use ARG or ENV instructions for sensitive data (ARG "API_TOKEN")`, Line: 4},
+		{Rule: "FromAsCasing", Message: "'as' and 'FROM' keywords' casing do not match", Line: 1},
+		{Message: "buildx: git was not found in the system. Current commit information was not captured by the build"},
+		{Rule: "JSONArgsRecommended", Message: "JSON arguments recommended for CMD to prevent unintended behavior related to OS signals", Line: 12},
+	}
+	if got := parseBuildWarnings(output); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected warnings:\n got  %+v\n want %+v", got, want)
+	}
+}
+
+func TestParseBuildWarningsWithoutWarnings(t *testing.T) {
+	if got := parseBuildWarnings("#1 DONE 0.0s\nSuccessfully built image\n"); got != nil {
+		t.Fatalf("expected no warnings, got %+v", got)
+	}
+}
+
+func TestImageBuildRecordsWarnings(t *testing.T) {
+	store := newDedupTestStorage(t)
+	job := &storage.BuildJob{ID: "build_warnings", ProjectID: "proj", UserID: "user"}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	worker := newTestWorker(t, nil)
+	worker.job = job
+	worker.storage = store
+
+	err := worker.runImageBuild(func() *exec.Cmd {
+		return exec.Command("sh", "-c", `echo '#1 WARN: SecretsUsedInArgOrEnv: Do not use ARG or ENV instructions for sensitive data (ARG "KEY") (line 2)'`)
+	}, nil)
+	if err != nil {
+		t.Fatalf("runImageBuild returned error: %v", err)
+	}
+
+	warnings, err := store.GetJobBuildWarnings(job.ID)
+	if err != nil {
+		t.Fatalf("failed to load warnings: %v", err)
+	}
+	if len(warnings) != 1 || warnings[0].Rule != "SecretsUsedInArgOrEnv" || warnings[0].Line != 2 {
+		t.Fatalf("expected the secret warning to be stored, got %+v", warnings)
+	}
+}
@@ -0,0 +1,90 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	buildInfoFormatJSON = "json"
+	buildInfoFormatEnv  = "env"
+)
+
+type buildInfo struct {
+	CommitSHA string `json:"commitSha"`
+	Ref       string `json:"ref"`
+	BuildTime string `json:"buildTime"`
+	ImageTag  string `json:"imageTag"`
+}
+
+// stageBuildInfo writes the build info file requested by buildConfig.buildInfo
+// into contextDir so the build bakes it into the image. It is a no-op when the
+// job did not ask for one.
+func (w *Worker) stageBuildInfo(contextDir, imageTag string) error {
+	options := w.job.BuildConfig.BuildInfo
+	if options == nil {
+		return nil
+	}
+
+	format := strings.ToLower(strings.TrimSpace(options.Format))
+	if format == "" {
+		format = buildInfoFormatJSON
+	}
+	relPath := strings.TrimSpace(options.Path)
+	switch format {
+	case buildInfoFormatJSON:
+		if relPath == "" {
+			relPath = "version.json"
+		}
+	case buildInfoFormatEnv:
+		if relPath == "" {
+			relPath = "BUILD_INFO"
+		}
+	default:
+		return fmt.Errorf("unsupported buildInfo format %q (expected %q or %q)", options.Format, buildInfoFormatJSON, buildInfoFormatEnv)
+	}
+	cleaned := filepath.Clean(relPath)
+	if filepath.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("buildInfo path %q must stay inside the build context", relPath)
+	}
+
+	commitSHA := w.commitSHA
+	if commitSHA == "" {
+		commitSHA = w.job.SourceInfo.CommitSha
+	}
+	info := buildInfo{
+		CommitSHA: commitSHA,
+		Ref:       w.job.SourceInfo.Ref,
+		BuildTime: time.Now().UTC().Format(time.RFC3339),
+		ImageTag:  imageTag,
+	}
+
+	var content []byte
+	if format == buildInfoFormatJSON {
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+		content = append(data, '\n')
+	} else {
+		content = []byte(fmt.Sprintf("COMMIT_SHA=%s\nREF=%s\nBUILD_TIME=%s\nIMAGE_TAG=%s\n", info.CommitSHA, info.Ref, info.BuildTime, info.ImageTag))
+	}
+
+	target := filepath.Join(contextDir, cleaned)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(target, content, 0o644); err != nil {
+		return err
+	}
+	w.logInfo("Wrote build info to %s", filepath.ToSlash(cleaned))
+
+	if ignore, err := loadDockerignore(contextDir); err == nil && ignore.excludes(filepath.ToSlash(cleaned)) {
+		w.log("WARNING: .dockerignore excludes build info file %s; it will not reach the image", filepath.ToSlash(cleaned))
+	}
+	return nil
+}
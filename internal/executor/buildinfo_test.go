@@ -0,0 +1,98 @@
+package executor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hubfly-builder/internal/driver"
+	"hubfly-builder/internal/storage"
+)
+
+func newBuildInfoTestWorker(t *testing.T, options *storage.BuildInfoFile) *Worker {
+	t.Helper()
+	worker := newTestWorker(t, &storage.BuildJob{
+		ID:          "build_info",
+		SourceInfo:  storage.SourceInfo{Ref: "main"},
+		BuildConfig: storage.BuildConfig{BuildInfo: options},
+	})
+	worker.commitSHA = "0123456789abcdef0123456789abcdef01234567"
+	return worker
+}
+
+func TestStageBuildInfoWritesJSONByDefault(t *testing.T) {
+	worker := newBuildInfoTestWorker(t, &storage.BuildInfoFile{})
+
+	if err := worker.stageBuildInfo(worker.workDir, "hubcell.local/u/p:tag"); err != nil {
+		t.Fatalf("failed to stage build info: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(worker.workDir, "version.json"))
+	if err != nil {
+		t.Fatalf("expected version.json in the context: %v", err)
+	}
+	var info buildInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatalf("expected valid JSON, got %q: %v", data, err)
+	}
+	if info.CommitSHA != worker.commitSHA || info.Ref != "main" || info.ImageTag != "hubcell.local/u/p:tag" {
+		t.Fatalf("unexpected build info: %+v", info)
+	}
+	if info.BuildTime == "" {
+		t.Fatal("expected build time to be set")
+	}
+}
+
+func TestStageBuildInfoWritesEnvFormatToCustomPath(t *testing.T) {
+	worker := newBuildInfoTestWorker(t, &storage.BuildInfoFile{Path: "public/build-info.txt", Format: "env"})
+
+	if err := worker.stageBuildInfo(worker.workDir, "hubcell.local/u/p:tag"); err != nil {
+		t.Fatalf("failed to stage build info: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(worker.workDir, "public", "build-info.txt"))
+	if err != nil {
+		t.Fatalf("expected build info at the configured path: %v", err)
+	}
+	for _, want := range []string{"COMMIT_SHA=" + worker.commitSHA + "\n", "REF=main\n", "IMAGE_TAG=hubcell.local/u/p:tag\n", "BUILD_TIME="} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("expected %q in build info, got %q", want, data)
+		}
+	}
+}
+
+func TestStageBuildInfoRejectsPathOutsideContext(t *testing.T) {
+	for _, path := range []string{"../escape.json", "/etc/version.json"} {
+		worker := newBuildInfoTestWorker(t, &storage.BuildInfoFile{Path: path})
+		if err := worker.stageBuildInfo(worker.workDir, "tag"); err == nil {
+			t.Fatalf("expected %q to be rejected", path)
+		}
+	}
+}
+
+func TestStageBuildInfoIsIncludedInHubcellBuildContext(t *testing.T) {
+	// The fake sudo runs in the build working directory, as hubcell would.
+	seen := filepath.Join(installFakeBinary(t, "sudo", "cat version.json > \"$(dirname \"$0\")/seen\"\n"), "seen")
+
+	worker := newBuildInfoTestWorker(t, &storage.BuildInfoFile{})
+	opts := driver.HubcellBuildOpts{
+		HubcellPath: "hubcell",
+		WorkDir:     worker.workDir,
+		ContextPath: ".",
+		ImageTag:    "hubcell.local/u/p:tag",
+	}
+	if err := worker.stageBuildInfo(hubcellContextDir(opts), opts.ImageTag); err != nil {
+		t.Fatalf("failed to stage build info: %v", err)
+	}
+	if err := worker.buildOrReuseImage(opts); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	data, err := os.ReadFile(seen)
+	if err != nil {
+		t.Fatalf("expected the build to see version.json: %v", err)
+	}
+	if !strings.Contains(string(data), `"imageTag": "hubcell.local/u/p:tag"`) {
+		t.Fatalf("expected build to see staged build info, got %q", data)
+	}
+}
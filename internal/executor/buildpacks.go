@@ -0,0 +1,121 @@
+package executor
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"hubfly-builder/internal/driver"
+	"hubfly-builder/internal/envplan"
+)
+
+// buildWithBuildpacks builds the app directory with `pack build` instead of a
+// Dockerfile. Errors returned here have already been reported via failJob.
+func (w *Worker) buildWithBuildpacks(appPath, network string) error {
+	w.logInfo("Buildpacks build requested; skipping Dockerfile detection.")
+	if len(w.job.BuildConfig.CustomDockerfileBytes()) > 0 || hasStructuredBuildStrategy(w.job.BuildConfig) {
+		w.log("WARNING: customDockerfile and install/setup/build/run phases are ignored for buildpacks builds.")
+	}
+	if limits := w.job.BuildConfig.ResourceLimits; limits.CPU > 0 || limits.MemoryMB > 0 {
+		w.log("WARNING: buildConfig.resourceLimits is not supported by pack and is ignored for buildpacks builds.")
+	}
+	if len(w.job.BuildConfig.ExtraHosts) > 0 {
+		w.log("WARNING: buildConfig.extraHosts only apply to Hubcell builds and are ignored for buildpacks builds.")
+	}
+	if len(w.job.BuildConfig.DNSServers) > 0 {
+		w.log("WARNING: buildConfig.dnsServers only apply to Hubcell builds and are ignored for buildpacks builds.")
+	}
+	if len(w.job.BuildConfig.Platforms) > 0 {
+		w.log("WARNING: buildConfig.platforms only apply to Hubcell builds and are ignored for buildpacks builds.")
+	}
+
+	envResult := envplan.ResolveForPaths([]string{appPath}, w.job.BuildConfig.Env, w.job.BuildConfig.EnvOverrides, w.job.BuildConfig.RuntimeOnly)
+	w.job.BuildConfig.ResolvedEnvPlan = envResult.Entries
+	w.job.BuildConfig.ValidationWarnings = mergeWarnings(w.job.BuildConfig.ValidationWarnings, envResult.Warnings)
+	w.logResolvedEnvPlan(envResult.Entries)
+	for _, warning := range envResult.Warnings {
+		w.log("Env warning: %s", warning)
+	}
+	if err := w.storage.UpdateJobBuildConfig(w.job.ID, &w.job.BuildConfig); err != nil {
+		w.log("WARNING: could not persist resolved env plan: %v", err)
+	}
+
+	if err := w.scanContextSecrets(appPath); err != nil {
+		w.log("ERROR: %v", err)
+		return w.failJob(err.Error())
+	}
+
+	imageTag, err := w.generateImageTag()
+	if err != nil {
+		w.log("ERROR: %v", err)
+		return w.failJob(err.Error())
+	}
+	w.logInfo("Image tag: %s", imageTag)
+	envDir, envFile, err := writePackEnvFile(envResult)
+	if err != nil {
+		w.log("ERROR: %v", err)
+		return w.failJob(err.Error())
+	}
+	if envDir != "" {
+		defer os.RemoveAll(envDir)
+	}
+	opts := w.packBuildOpts(appPath, network, imageTag, envFile)
+	if err := driver.ValidatePackBuildOpts(opts); err != nil {
+		w.log("ERROR: invalid pack build options: %v", err)
+		return w.failJob(err.Error())
+	}
+	extraTags, err := w.extraImageTags(imageTag)
+	if err != nil {
+		w.log("ERROR: %v", err)
+		return w.failJob(err.Error())
+	}
+	opts.ExtraTags = extraTags
+	if err := w.stageBuildInfo(appPath, imageTag); err != nil {
+		w.log("ERROR: failed to write build info: %v", err)
+		return w.failJob(err.Error())
+	}
+
+	if err := w.buildImageWithPack(opts); err != nil {
+		w.log("ERROR: pack build failed: %v", err)
+		return w.failForStep(err, "failed to build image with buildpacks")
+	}
+	w.logInfo("Buildpacks build successful.")
+	w.job.ImageTag = imageTag
+	if err := w.storage.UpdateJobImageTag(w.job.ID, imageTag); err != nil {
+		w.log("ERROR: could not update image tag: %v", err)
+	}
+	return nil
+}
+
+func (w *Worker) buildImageWithPack(opts driver.PackBuildOpts) error {
+	w.logInfo("Running pack build with builder %s", opts.Builder)
+	release, err := w.acquireImageBuildSlot()
+	if err != nil {
+		return err
+	}
+	defer release()
+	return w.runPhase(phaseBuild, w.phases.Build, func() error {
+		return w.runImageBuild(func() *exec.Cmd {
+			return driver.PackBuildCommandContext(w.ctx, opts)
+		}, nil)
+	})
+}
+
+func (w *Worker) packBuildOpts(contextPath, network, imageTag, envFile string) driver.PackBuildOpts {
+	builder := strings.TrimSpace(os.Getenv("BUILDPACKS_BUILDER"))
+	if builder == "" {
+		builder = driver.DefaultBuildpacksBuilder
+	}
+	publish, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("BUILDPACKS_PUBLISH")))
+	return driver.PackBuildOpts{
+		PackPath:    strings.TrimSpace(os.Getenv("PACK_CLI_PATH")),
+		WorkDir:     w.workDir,
+		ContextPath: contextPath,
+		ImageTag:    imageTag,
+		Builder:     builder,
+		EnvFile:     envFile,
+		Network:     network,
+		Publish:     publish,
+	}
+}
@@ -0,0 +1,119 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"syscall"
+
+	"hubfly-builder/internal/jobstate"
+)
+
+// ErrJobCanceled is the cancellation cause of a build stopped through
+// Manager.CancelJob.
+var ErrJobCanceled = errors.New("job canceled")
+
+// ErrBuildCanceled is returned by Worker.Run when the build was stopped by a
+// cancellation request rather than failing.
+var ErrBuildCanceled = errors.New("build canceled")
+
+// canceledCallbackStatus is the status a canceled job reports to the
+// callback. Backends expect the "cancelled" spelling, while the job itself
+// is stored as jobstate.Canceled.
+const canceledCallbackStatus = "cancelled"
+
+// ErrJobNotCancelable reports a job that has already finished or is running
+// on another builder instance.
+var ErrJobNotCancelable = errors.New("job cannot be canceled")
+
+// CancelJob stops jobID. A pending job is canceled at once. A claimed or
+// building job moves to cancelling and its worker is interrupted; the worker
+// kills the running command, removes the workspace and records the job as
+// canceled. It returns the status the job was moved to. The callback of a
+// canceled pending job is sent in the background, since its retries could
+// otherwise hold up the caller for minutes.
+func (m *Manager) CancelJob(jobID string) (jobstate.Status, error) {
+	if ok, err := m.storage.TransitionJob(jobID, jobstate.Pending, jobstate.Cancel); err != nil {
+		return "", err
+	} else if ok {
+		log.Printf("Canceled pending job %s", jobID)
+		m.publishStatusByID(jobID, jobstate.Canceled)
+		go m.reportCanceled(jobID)
+		return jobstate.Canceled, nil
+	}
+
+	m.mu.Lock()
+	cancel, running := m.activeBuilds[jobID]
+	m.mu.Unlock()
+	if !running || cancel == nil {
+		return "", fmt.Errorf("%w: it is not pending or building on this builder", ErrJobNotCancelable)
+	}
+
+	for _, current := range []jobstate.Status{jobstate.Building, jobstate.Claimed} {
+		ok, err := m.storage.TransitionJob(jobID, current, jobstate.Interrupt)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			log.Printf("Interrupting job %s", jobID)
+			cancel(ErrJobCanceled)
+			m.publishStatusByID(jobID, jobstate.Cancelling)
+			return jobstate.Cancelling, nil
+		}
+	}
+	return "", fmt.Errorf("%w: it has already finished", ErrJobNotCancelable)
+}
+
+func (m *Manager) reportCanceled(jobID string) {
+	if m.apiClient == nil {
+		return
+	}
+	job, err := m.storage.GetJob(jobID)
+	if err != nil {
+		log.Printf("ERROR: could not load canceled job %s: %v", jobID, err)
+		return
+	}
+	if err := m.apiClient.ReportResult(job, canceledCallbackStatus, "canceled by request"); err != nil {
+		log.Printf("ERROR: could not report result to backend for job %s: %v", jobID, err)
+	}
+}
+
+// canceled reports whether the build context was cancelled by CancelJob.
+func (w *Worker) canceled() bool {
+	return w.ctx != nil && errors.Is(context.Cause(w.ctx), ErrJobCanceled)
+}
+
+func (w *Worker) cancelJob() error {
+	if !w.resolve() {
+		return w.abandonedByWatchdog()
+	}
+	log.Printf("Canceling job %s", w.job.ID)
+	w.log("Build canceled by request")
+	if ok, err := w.transitionStatus(jobstate.Cancel, jobstate.Cancelling, jobstate.Building, jobstate.Claimed); err != nil {
+		log.Printf("ERROR: could not update job status to 'canceled' for job %s: %v", w.job.ID, err)
+	} else if !ok {
+		log.Printf("WARN: job %s is no longer in progress; leaving its status unchanged", w.job.ID)
+	}
+	if err := w.apiClient.ReportResult(w.job, canceledCallbackStatus, "canceled by request"); err != nil {
+		log.Printf("ERROR: could not report result to backend for job %s: %v", w.job.ID, err)
+	}
+	return fmt.Errorf("%w: job %s", ErrBuildCanceled, w.job.ID)
+}
+
+// killProcessGroupOnCancel runs cmd in its own process group and makes
+// context cancellation kill the whole group, so processes started through
+// sudo and Hubcell stop with it instead of holding the output pipes open.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	if cmd.Cancel == nil {
+		return
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+}
@@ -0,0 +1,164 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"hubfly-builder/internal/api"
+	"hubfly-builder/internal/jobstate"
+	"hubfly-builder/internal/logs"
+	"hubfly-builder/internal/storage"
+)
+
+// installHangingSudo fakes sudo so that `hubcell build` blocks until it is
+// killed, while every other command succeeds at once.
+func installHangingSudo(t *testing.T) string {
+	t.Helper()
+	return fakeCalls(installFakeBinary(t, "sudo", recordFakeCall+"case \" $* \" in *\" build \"*) sleep 60 ;; esac\n"))
+}
+
+func writeGitRepository(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeContextFiles(t, dir, files)
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v: %s", args, err, output)
+		}
+	}
+	return dir
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCancelJobInterruptsRunningBuild(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	calls := installHangingSudo(t)
+	repo := writeGitRepository(t, map[string]string{"Dockerfile": "FROM alpine\nCMD [\"true\"]\n"})
+
+	var mu sync.Mutex
+	var reported []string
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload api.ReportPayload
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		reported = append(reported, payload.Status)
+		mu.Unlock()
+	}))
+	defer callback.Close()
+
+	store := newDedupTestStorage(t)
+	logManager, err := logs.NewLogManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	job := &storage.BuildJob{
+		ID:         "build_cancel",
+		ProjectID:  "proj",
+		UserID:     "user",
+		SourceInfo: storage.SourceInfo{GitRepository: repo},
+		BuildConfig: storage.BuildConfig{
+			Network: "proj-network",
+		},
+	}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	manager := NewManager(store, logManager, nil, api.NewClient(callback.URL), 1, "")
+
+	manager.tryToDispatchJob()
+	waitFor(t, "the image build to start", func() bool {
+		return strings.Contains(readSudoCalls(t, calls), " build ")
+	})
+
+	started := time.Now()
+	status, err := manager.CancelJob(job.ID)
+	if err != nil || status != jobstate.Cancelling {
+		t.Fatalf("expected the running job to move to cancelling, got %q (err %v)", status, err)
+	}
+	waitFor(t, "the worker to stop", func() bool {
+		return len(manager.GetActiveBuilds()) == 0
+	})
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Fatalf("expected the build to be killed promptly, took %s", elapsed)
+	}
+
+	stored, err := store.GetJob(job.ID)
+	if err != nil || stored.Status != string(jobstate.Canceled) {
+		t.Fatalf("expected the job to be canceled, got %+v (err %v)", stored, err)
+	}
+	workspaces, _ := filepath.Glob(filepath.Join(os.TempDir(), workspacePrefix+job.ID+"-*"))
+	if len(workspaces) != 0 {
+		t.Fatalf("expected the workspace to be removed, found %v", workspaces)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reported) != 1 || reported[0] != canceledCallbackStatus {
+		t.Fatalf("expected one canceled callback, got %v", reported)
+	}
+
+	if _, err := manager.CancelJob(job.ID); !errors.Is(err, ErrJobNotCancelable) {
+		t.Fatalf("expected a finished job to be rejected, got %v", err)
+	}
+}
+
+func TestCancelJobCancelsPendingJob(t *testing.T) {
+	release := make(chan struct{})
+	reported := make(chan string, 1)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload api.ReportPayload
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		<-release
+		reported <- payload.Status
+	}))
+	defer callback.Close()
+
+	store := newDedupTestStorage(t)
+	job := &storage.BuildJob{ID: "build_queued", ProjectID: "proj", UserID: "user"}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	manager := NewManager(store, nil, nil, api.NewClient(callback.URL), 1, "")
+
+	status, err := manager.CancelJob(job.ID)
+	close(release)
+	if err != nil || status != jobstate.Canceled {
+		t.Fatalf("expected a pending job to be canceled at once, got %q (err %v)", status, err)
+	}
+	if stored, err := store.GetJob(job.ID); err != nil || stored.Status != string(jobstate.Canceled) {
+		t.Fatalf("expected the job to be stored as canceled, got %+v (err %v)", stored, err)
+	}
+	select {
+	case got := <-reported:
+		if got != canceledCallbackStatus {
+			t.Fatalf("expected callback status %q, got %q", canceledCallbackStatus, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the canceled callback")
+	}
+}
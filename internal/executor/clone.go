@@ -0,0 +1,331 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"hubfly-builder/internal/gitauth"
+	"hubfly-builder/internal/storage"
+)
+
+const cloneMaxAttempts = 4
+
+// cloneRetryBaseDelay is a variable so tests can shorten the backoff.
+var cloneRetryBaseDelay = 2 * time.Second
+
+var permanentCloneFailureMarkers = []string{
+	"authentication failed",
+	"could not read username",
+	"could not read password",
+	"terminal prompts disabled",
+	"invalid username or password",
+	"repository not found",
+	"does not appear to be a git repository",
+	"does not exist",
+	"permission denied",
+	"access denied",
+	"returned error: 401",
+	"returned error: 403",
+	"returned error: 404",
+}
+
+var transientCloneFailureMarkers = []string{
+	"could not resolve host",
+	"temporary failure in name resolution",
+	"name or service not known",
+	"connection timed out",
+	"operation timed out",
+	"connection reset",
+	"connection refused",
+	"network is unreachable",
+	"the remote end hung up unexpectedly",
+	"early eof",
+	"rpc failed",
+	"gnutls_handshake",
+	"ssl_error",
+	"tls handshake timeout",
+	"returned error: 429",
+	"returned error: 500",
+	"returned error: 502",
+	"returned error: 503",
+	"returned error: 504",
+}
+
+// cloneRepository clones the job repository into the workspace, retrying with
+// exponential backoff only when git's output points at a network problem.
+func (w *Worker) cloneRepository() error {
+	cloneArgs, strategy := cloneStrategy(w.job.SourceInfo)
+	w.logDebug("Clone strategy: %s", strategy)
+
+	var lastErr error
+	for attempt := 1; attempt <= cloneMaxAttempts; attempt++ {
+		if attempt > 1 {
+			delay := cloneRetryBaseDelay * time.Duration(1<<(attempt-2))
+			w.log("Retrying clone in %s (attempt %d/%d)", delay, attempt, cloneMaxAttempts)
+			if err := w.sleep(delay); err != nil {
+				return err
+			}
+			if err := resetCloneDestination(w.workDir); err != nil {
+				return fmt.Errorf("could not reset workspace before clone retry: %w", err)
+			}
+		}
+
+		w.logInfo("Cloning repository (attempt %d/%d)", attempt, cloneMaxAttempts)
+		cmd := w.execCommand("git", append(cloneArgs, w.job.SourceInfo.GitRepository, w.workDir)...)
+		output, err := w.executeCommandCapturingOutput(cmd, true, &outputCapture{})
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if w.isTimeoutError(err) {
+			return err
+		}
+		if !isTransientCloneFailure(output) {
+			w.log("Clone failure is not transient; not retrying")
+			return err
+		}
+		w.log("WARNING: transient clone failure on attempt %d/%d: %v", attempt, cloneMaxAttempts, err)
+	}
+	return fmt.Errorf("%w: clone failed after %d attempts: %w", ErrTransientFailure, cloneMaxAttempts, lastErr)
+}
+
+// cloneStrategy returns the `git clone` arguments for source and a short
+// description of the strategy for the build log. Jobs pinned to a ref or
+// commit get a shallow clone unless CloneDepth asks for the full history, and
+// a branch or tag ref is cloned on its own unless SingleBranch is false.
+func cloneStrategy(source storage.SourceInfo) ([]string, string) {
+	depth := source.CloneDepth
+	if depth == 0 && (source.Ref != "" || source.CommitSha != "") {
+		depth = 1
+	}
+	branch := ""
+	if source.Ref != "" {
+		var ok bool
+		if branch, ok = cloneBranch(source.Ref); !ok {
+			return []string{"clone"}, fmt.Sprintf("full clone (ref %s cannot be cloned by name)", source.Ref)
+		}
+	}
+
+	args := []string{"clone"}
+	strategy := "full clone"
+	if depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(depth))
+		strategy = "shallow clone"
+	}
+	if branch != "" {
+		args = append(args, "--branch", branch)
+		strategy += " of " + branch
+	} else if depth > 0 {
+		strategy += " of the default branch"
+	}
+	switch singleBranch := source.SingleBranch; {
+	case singleBranch == nil && branch != "", singleBranch != nil && *singleBranch:
+		args = append(args, "--single-branch")
+		strategy += ", single branch"
+	case singleBranch != nil:
+		args = append(args, "--no-single-branch")
+		strategy += ", all branches"
+	}
+	if depth > 0 {
+		strategy += fmt.Sprintf(" (depth %d)", depth)
+	}
+	return args, strategy
+}
+
+// cloneBranch returns the name `git clone --branch` accepts for ref. Only
+// branch and tag names qualify; commit IDs and other refs such as pull
+// request heads need the full history to be checked out.
+func cloneBranch(ref string) (string, bool) {
+	switch {
+	case strings.HasPrefix(ref, "refs/heads/"):
+		return strings.TrimPrefix(ref, "refs/heads/"), true
+	case strings.HasPrefix(ref, "refs/tags/"):
+		return strings.TrimPrefix(ref, "refs/tags/"), true
+	case strings.HasPrefix(ref, "refs/"), commitIDPattern.MatchString(ref):
+		return "", false
+	}
+	return ref, true
+}
+
+var commitIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,64}$`)
+
+// allBranchesRefspec fetches every branch, including into a single-branch
+// clone whose remote is configured to fetch just one.
+const allBranchesRefspec = "+refs/heads/*:refs/remotes/origin/*"
+
+// checkoutRequestedSource checks out sourceInfo.ref and then
+// sourceInfo.commitSha, and verifies that HEAD is the requested commit: the
+// image tag names that commit, so building any other would mislabel it.
+func (w *Worker) checkoutRequestedSource() error {
+	ref := w.job.SourceInfo.Ref
+	sha := strings.TrimSpace(w.job.SourceInfo.CommitSha)
+	if ref != "" {
+		w.logInfo("Checking out ref: %s", ref)
+		if err := w.executeCommand(w.execCommand("git", "-C", w.workDir, "checkout", ref)); err != nil {
+			return fmt.Errorf("failed to checkout ref %s: %w", ref, err)
+		}
+	}
+	if sha != "" {
+		if err := w.ensureCommit(sha); err != nil {
+			return fmt.Errorf("failed to fetch commit %s: %w", sha, err)
+		}
+		w.logInfo("Checking out commit SHA: %s", sha)
+		if err := w.executeCommand(w.execCommand("git", "-C", w.workDir, "checkout", sha)); err != nil {
+			return fmt.Errorf("failed to checkout commit %s: %w", sha, err)
+		}
+	}
+
+	head, err := w.commandOutput(w.execCommand("git", "-C", w.workDir, "rev-parse", "HEAD"))
+	if err != nil || head == "" {
+		if sha != "" {
+			return fmt.Errorf("could not verify that commit %s is checked out: %v", sha, err)
+		}
+		return nil
+	}
+	w.logInfo("Checked out commit SHA: %s", head)
+	w.commitSHA = head
+	if sha != "" && !strings.HasPrefix(head, strings.ToLower(sha)) {
+		return fmt.Errorf("checked out commit %s does not match requested commit %s", head, sha)
+	}
+	return nil
+}
+
+// ensureCommit makes sha available in a shallow clone whose tip is not that
+// commit, fetching just the commit and, if the remote refuses that, the full
+// history.
+func (w *Worker) ensureCommit(sha string) error {
+	if err := w.execCommand("git", "-C", w.workDir, "cat-file", "-e", sha+"^{commit}").Run(); err == nil {
+		return nil
+	}
+	shallow, err := w.commandOutput(w.execCommand("git", "-C", w.workDir, "rev-parse", "--is-shallow-repository"))
+	if err != nil {
+		return nil
+	}
+	if shallow != "true" {
+		// A single-branch clone lacks the history of the other branches.
+		w.logInfo("Commit %s is not on the cloned branch; fetching all branches", sha)
+		return w.executeCommand(w.execCommand("git", "-C", w.workDir, "fetch", "--tags", "origin", allBranchesRefspec))
+	}
+
+	depth := w.job.SourceInfo.CloneDepth
+	if depth <= 0 {
+		depth = 1
+	}
+	w.logInfo("Commit %s is not in the shallow clone; fetching it", sha)
+	if err := w.executeCommand(w.execCommand("git", "-C", w.workDir, "fetch", "--depth", strconv.Itoa(depth), "origin", sha)); err == nil {
+		return nil
+	}
+	w.log("WARNING: remote did not serve commit %s directly; fetching the full history", sha)
+	return w.executeCommand(w.execCommand("git", "-C", w.workDir, "fetch", "--unshallow", "--tags", "origin", allBranchesRefspec))
+}
+
+func (w *Worker) sleep(delay time.Duration) error {
+	if w.ctx == nil {
+		time.Sleep(delay)
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+func isTransientCloneFailure(output string) bool {
+	lower := strings.ToLower(output)
+	for _, marker := range permanentCloneFailureMarkers {
+		if strings.Contains(lower, marker) {
+			return false
+		}
+	}
+	for _, marker := range transientCloneFailureMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func resetCloneDestination(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return os.MkdirAll(dir, 0o755)
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetSecretResolver lets workers resolve git credentials that jobs name by
+// reference. Without a resolver such jobs fail at their clone.
+func (m *Manager) SetSecretResolver(resolver gitauth.SecretResolver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.secretResolver = resolver
+}
+
+// prepareGitAuth writes the job's git credential, if it has one, where the
+// git commands of this worker pick it up.
+func (w *Worker) prepareGitAuth() error {
+	if w.storage == nil {
+		return nil
+	}
+	credential, err := w.storage.GetJobGitCredential(w.job.ID)
+	if err != nil || credential == nil {
+		return err
+	}
+	if credential.HasRef() {
+		w.logDebug("Resolving the job's git credential reference")
+	}
+	credential, err = gitauth.ResolveCredential(credential, w.secretResolver)
+	if err != nil {
+		return err
+	}
+	session, err := gitauth.Prepare(credential)
+	if err != nil {
+		return err
+	}
+	w.gitAuth = session
+	w.logDebug("Authenticating git with the job's %s credential", credential.Kind())
+	if isSSHRemote(w.job.SourceInfo.GitRepository) != (credential.Kind() == "ssh") {
+		w.log("WARNING: the %s credential does not match the remote's protocol and will likely be ignored", credential.Kind())
+	}
+	return nil
+}
+
+// scrubGitAuth removes the credential files once git no longer needs them.
+func (w *Worker) scrubGitAuth() {
+	if err := w.gitAuth.Close(); err != nil {
+		w.log("WARNING: could not remove git credential files: %v", err)
+		return
+	}
+	w.gitAuth = nil
+}
+
+func isSSHRemote(remote string) bool {
+	remote = strings.TrimSpace(remote)
+	if strings.HasPrefix(remote, "ssh://") {
+		return true
+	}
+	// scp-like syntax: [user@]host:path
+	if strings.Contains(remote, "://") {
+		return false
+	}
+	colon := strings.Index(remote, ":")
+	return colon > 0 && !strings.Contains(remote[:colon], "/")
+}
@@ -0,0 +1,146 @@
+package executor
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"hubfly-builder/internal/driver"
+)
+
+// contextPruneStats describes what pruneBuildContext left for Hubcell.
+type contextPruneStats struct {
+	KeptFiles    int
+	KeptBytes    int64
+	RemovedFiles int
+	RemovedBytes int64
+}
+
+// pruneBuildContext deletes everything .dockerignore excludes from contextDir
+// so Hubcell never reads it. The workspace is a throwaway clone, so this is
+// done in place. As Docker does, the Dockerfile and .dockerignore are always
+// kept, and so are dockerfile, the absolute path of the Dockerfile the build
+// uses when it lives in the context, and its <Dockerfile>.dockerignore.
+func pruneBuildContext(contextDir, dockerfile string) (contextPruneStats, error) {
+	var stats contextPruneStats
+	ignore, err := loadDockerignore(contextDir)
+	if err != nil {
+		return stats, err
+	}
+	kept := map[string]bool{"Dockerfile": true, ".dockerignore": true}
+	if dockerfile != "" {
+		if rel, err := filepath.Rel(contextDir, dockerfile); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			rel = filepath.ToSlash(rel)
+			kept[rel] = true
+			kept[rel+".dockerignore"] = true
+		}
+	}
+	holdsKept := func(dir string) bool {
+		for path := range kept {
+			if strings.HasPrefix(path, dir+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	var excluded []string
+	err = filepath.WalkDir(contextDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(contextDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if kept[rel] {
+			return countContextFile(entry, &stats.KeptFiles, &stats.KeptBytes)
+		}
+		if ignore.excludes(rel) && (!entry.IsDir() || !ignore.hasNegations && !holdsKept(rel)) {
+			// With negations a re-included file may live below an excluded
+			// directory, so directories are only removed whole without them
+			// and when they do not hold the Dockerfile.
+			excluded = append(excluded, path)
+			if entry.IsDir() {
+				if err := countContextTree(path, &stats.RemovedFiles, &stats.RemovedBytes); err != nil {
+					return err
+				}
+				return filepath.SkipDir
+			}
+			return countContextFile(entry, &stats.RemovedFiles, &stats.RemovedBytes)
+		}
+		return countContextFile(entry, &stats.KeptFiles, &stats.KeptBytes)
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	for _, path := range excluded {
+		if err := os.RemoveAll(path); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+func countContextTree(root string, files *int, bytes *int64) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return countContextFile(entry, files, bytes)
+	})
+}
+
+func countContextFile(entry fs.DirEntry, files *int, bytes *int64) error {
+	if entry.IsDir() {
+		return nil
+	}
+	info, err := entry.Info()
+	if err != nil {
+		return err
+	}
+	*files++
+	if info.Mode().IsRegular() {
+		*bytes += info.Size()
+	}
+	return nil
+}
+
+// pruneContext applies pruneBuildContext to the context of opts when
+// BUILD_CONTEXT_PRUNE is enabled and logs what Hubcell will read. Failures
+// only cost the optimisation.
+func (w *Worker) pruneContext(opts driver.HubcellBuildOpts) {
+	if !w.pruneContexts {
+		return
+	}
+	started := time.Now()
+	stats, err := pruneBuildContext(hubcellContextDir(opts), hubcellDockerfilePath(opts))
+	if err != nil {
+		w.log("WARNING: could not prune build context: %v", err)
+		return
+	}
+	w.logInfo("Build context: %d files (%d bytes) after removing %d files (%d bytes) excluded by .dockerignore in %s",
+		stats.KeptFiles,
+		stats.KeptBytes,
+		stats.RemovedFiles,
+		stats.RemovedBytes,
+		time.Since(started).Round(time.Millisecond),
+	)
+}
+
+// hubcellDockerfilePath returns the absolute path of the Dockerfile opts
+// builds, which is "Dockerfile" in the context unless opts.Dockerfile is set.
+func hubcellDockerfilePath(opts driver.HubcellBuildOpts) string {
+	switch {
+	case opts.Dockerfile == "":
+		return filepath.Join(hubcellContextDir(opts), "Dockerfile")
+	case filepath.IsAbs(opts.Dockerfile):
+		return opts.Dockerfile
+	default:
+		return filepath.Join(opts.WorkDir, opts.Dockerfile)
+	}
+}
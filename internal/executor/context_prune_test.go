@@ -0,0 +1,121 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"hubfly-builder/internal/driver"
+)
+
+func writeContextFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func listContextFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to list context: %v", err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestPruneBuildContextRemovesIgnoredFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		"Dockerfile":            "FROM alpine\n",
+		".dockerignore":         "Dockerfile\nnode_modules\n**/*.log\ndocs\n!docs/keep.md\n",
+		"index.js":              "console.log('hi')\n",
+		"debug.log":             "noise",
+		"node_modules/a/a.js":   "dependency",
+		"docs/guide.md":         "guide",
+		"docs/keep.md":          "keep",
+		"src/nested/trace.log":  "noise",
+		"src/nested/handler.js": "handler",
+	})
+
+	stats, err := pruneBuildContext(dir, "")
+	if err != nil {
+		t.Fatalf("pruneBuildContext returned error: %v", err)
+	}
+
+	want := []string{".dockerignore", "Dockerfile", "docs/keep.md", "index.js", "src/nested/handler.js"}
+	if got := listContextFiles(t, dir); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected context %v after pruning, got %v", want, got)
+	}
+	if stats.KeptFiles != len(want) || stats.RemovedFiles != 4 {
+		t.Fatalf("expected %d kept and 4 removed files, got %+v", len(want), stats)
+	}
+	if stats.RemovedBytes != int64(len("noise")*2+len("dependency")+len("guide")) {
+		t.Fatalf("unexpected removed byte count: %+v", stats)
+	}
+}
+
+func TestPruneBuildContextWithoutDockerignoreKeepsEverything(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		"Dockerfile": "FROM alpine\n",
+		"app.js":     "console.log('hi')\n",
+	})
+
+	stats, err := pruneBuildContext(dir, "")
+	if err != nil {
+		t.Fatalf("pruneBuildContext returned error: %v", err)
+	}
+	if stats.RemovedFiles != 0 || stats.KeptFiles != 2 {
+		t.Fatalf("expected nothing removed, got %+v", stats)
+	}
+}
+
+func TestPruneBuildContextKeepsTheBuildsDockerfile(t *testing.T) {
+	workDir := t.TempDir()
+	dir := filepath.Join(workDir, "app")
+	writeContextFiles(t, dir, map[string]string{
+		".dockerignore":                  "Dockerfile*\ndocker\n",
+		"Dockerfile.prod":                "FROM alpine\n",
+		"Dockerfile.dev":                 "FROM alpine\n",
+		"docker/Dockerfile":              "FROM alpine\n",
+		"docker/Dockerfile.dockerignore": "node_modules\n",
+		"docker/entrypoint.sh":           "#!/bin/sh\n",
+		"index.js":                       "console.log('hi')\n",
+	})
+
+	opts := driver.HubcellBuildOpts{WorkDir: workDir, ContextPath: "app", Dockerfile: "app/docker/Dockerfile"}
+	if _, err := pruneBuildContext(hubcellContextDir(opts), hubcellDockerfilePath(opts)); err != nil {
+		t.Fatalf("pruneBuildContext returned error: %v", err)
+	}
+	want := []string{".dockerignore", "docker/Dockerfile", "docker/Dockerfile.dockerignore", "index.js"}
+	if got := listContextFiles(t, dir); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected context %v after pruning, got %v", want, got)
+	}
+
+	writeContextFiles(t, dir, map[string]string{"Dockerfile.prod": "FROM alpine\n"})
+	opts.Dockerfile = "app/Dockerfile.prod"
+	if _, err := pruneBuildContext(hubcellContextDir(opts), hubcellDockerfilePath(opts)); err != nil {
+		t.Fatalf("pruneBuildContext returned error: %v", err)
+	}
+	if got := listContextFiles(t, dir); !strings.Contains(strings.Join(got, ","), "Dockerfile.prod") {
+		t.Fatalf("expected Dockerfile.prod to be kept, got %v", got)
+	}
+}
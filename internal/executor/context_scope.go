@@ -0,0 +1,263 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// workspaceMarkerFiles mark a JavaScript workspace root, matching what
+// autodetect treats as a monorepo that must build from the repository root.
+var workspaceMarkerFiles = []string{"pnpm-workspace.yaml", "turbo.json", "nx.json", "lerna.json"}
+
+// workspaceRootDirs are root directories a workspace install reads besides
+// the package manifests: Yarn releases and plugins, and patch-package or
+// pnpm patches.
+var workspaceRootDirs = map[string]bool{
+	".yarn":   true,
+	"patches": true,
+}
+
+type workspacePackage struct {
+	Name                 string            `json:"name"`
+	Scripts              map[string]string `json:"scripts"`
+	Dependencies         map[string]string `json:"dependencies"`
+	DevDependencies      map[string]string `json:"devDependencies"`
+	OptionalDependencies map[string]string `json:"optionalDependencies"`
+	PeerDependencies     map[string]string `json:"peerDependencies"`
+	Workspaces           interface{}       `json:"workspaces"`
+}
+
+// workspaceScopeBlocker returns why a workspace context at contextDir cannot
+// be scoped, or "" when it can. Root install lifecycle scripts may read any
+// file in the repository, so those workspaces keep their whole context.
+func workspaceScopeBlocker(contextDir string) string {
+	root, err := readWorkspacePackage(filepath.Join(contextDir, "package.json"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "root package.json could not be read"
+	}
+	isWorkspace := root != nil && root.Workspaces != nil
+	for _, name := range workspaceMarkerFiles {
+		if _, err := os.Stat(filepath.Join(contextDir, name)); err == nil {
+			isWorkspace = true
+		}
+	}
+	if !isWorkspace {
+		return "not a workspace repository"
+	}
+	if root != nil {
+		for _, script := range []string{"preinstall", "install", "postinstall", "prepare"} {
+			if strings.TrimSpace(root.Scripts[script]) != "" {
+				return "root package.json has a " + script + " script"
+			}
+		}
+	}
+	return ""
+}
+
+// scopeWorkspaceContext trims a workspace monorepo context to what a build of
+// appDir needs: root-level files (manifests, lockfiles, workspace config),
+// appDir itself and every workspace package it depends on, directly or
+// transitively. Other workspace packages keep only their package.json so
+// lockfile checks still see every member; everything else is deleted.
+func scopeWorkspaceContext(contextDir, appDir string) (contextPruneStats, error) {
+	var stats contextPruneStats
+	packages, err := findWorkspacePackages(contextDir)
+	if err != nil {
+		return stats, err
+	}
+
+	keepDirs := map[string]bool{}
+	queue := []string{appDir}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		if keepDirs[dir] {
+			continue
+		}
+		keepDirs[dir] = true
+		if pkg := packages.byDir[dir]; pkg != nil {
+			for _, deps := range []map[string]string{pkg.Dependencies, pkg.DevDependencies, pkg.OptionalDependencies, pkg.PeerDependencies} {
+				for name := range deps {
+					if depDir, ok := packages.dirByName[name]; ok {
+						queue = append(queue, depDir)
+					}
+				}
+			}
+		}
+	}
+
+	packageDirs := map[string]bool{}
+	for dir := range packages.byDir {
+		packageDirs[dir] = true
+	}
+
+	var removed []string
+	err = filepath.WalkDir(contextDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(contextDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == ".git" {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		keepTree := workspaceRootDirs[rel] || withinAnyDir(rel, keepDirs)
+		if !strings.Contains(rel, "/") && !entry.IsDir() {
+			keepTree = true
+		}
+		if keepTree {
+			if entry.IsDir() {
+				if err := countContextTree(path, &stats.KeptFiles, &stats.KeptBytes); err != nil {
+					return err
+				}
+				return filepath.SkipDir
+			}
+			return countContextFile(entry, &stats.KeptFiles, &stats.KeptBytes)
+		}
+		if entry.IsDir() && (packageDirs[rel] || ancestorOfAnyDir(rel, keepDirs) || ancestorOfAnyDir(rel, packageDirs)) {
+			return nil
+		}
+		if !entry.IsDir() && entry.Name() == "package.json" && packageDirs[filepath.ToSlash(filepath.Dir(rel))] {
+			return countContextFile(entry, &stats.KeptFiles, &stats.KeptBytes)
+		}
+
+		removed = append(removed, path)
+		if entry.IsDir() {
+			if err := countContextTree(path, &stats.RemovedFiles, &stats.RemovedBytes); err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		}
+		return countContextFile(entry, &stats.RemovedFiles, &stats.RemovedBytes)
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	for _, path := range removed {
+		if err := os.RemoveAll(path); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+type workspacePackages struct {
+	byDir     map[string]*workspacePackage
+	dirByName map[string]string
+}
+
+// findWorkspacePackages indexes every package.json below contextDir, outside
+// node_modules and .git, by directory and by package name.
+func findWorkspacePackages(contextDir string) (workspacePackages, error) {
+	packages := workspacePackages{byDir: map[string]*workspacePackage{}, dirByName: map[string]string{}}
+	var dirs []string
+	err := filepath.WalkDir(contextDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && (entry.Name() == "node_modules" || entry.Name() == ".git") {
+			return filepath.SkipDir
+		}
+		if entry.IsDir() || entry.Name() != "package.json" {
+			return nil
+		}
+		rel, err := filepath.Rel(contextDir, filepath.Dir(path))
+		if err != nil || rel == "." {
+			return err
+		}
+		pkg, err := readWorkspacePackage(path)
+		if err != nil {
+			return err
+		}
+		dir := filepath.ToSlash(rel)
+		packages.byDir[dir] = pkg
+		dirs = append(dirs, dir)
+		return nil
+	})
+	if err != nil {
+		return packages, err
+	}
+
+	// Index names in path order so a duplicate name resolves the same way on
+	// every run.
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		name := strings.TrimSpace(packages.byDir[dir].Name)
+		if _, seen := packages.dirByName[name]; name != "" && !seen {
+			packages.dirByName[name] = dir
+		}
+	}
+	return packages, nil
+}
+
+func readWorkspacePackage(path string) (*workspacePackage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pkg workspacePackage
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+func withinAnyDir(rel string, dirs map[string]bool) bool {
+	for dir := range dirs {
+		if rel == dir || strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func ancestorOfAnyDir(rel string, dirs map[string]bool) bool {
+	for dir := range dirs {
+		if strings.HasPrefix(dir, rel+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// scopeContext applies scopeWorkspaceContext to a generated-Dockerfile build
+// of a workspace subPath when BUILD_CONTEXT_SCOPE_WORKSPACES is enabled.
+// Repository Dockerfiles may COPY anything, so they are never scoped.
+// Failures only cost the optimisation.
+func (w *Worker) scopeContext(contextDir, appDir string) {
+	if !w.scopeContexts || appDir == "." {
+		return
+	}
+	if reason := workspaceScopeBlocker(contextDir); reason != "" {
+		w.logInfo("Build context: keeping the whole repository for %s: %s", appDir, reason)
+		return
+	}
+	started := time.Now()
+	stats, err := scopeWorkspaceContext(contextDir, appDir)
+	if err != nil {
+		w.log("WARNING: could not scope build context to %s: %v", appDir, err)
+		return
+	}
+	w.logInfo("Build context: scoped to %s and its workspace dependencies, %d files (%d bytes) kept, %d files (%d bytes) removed in %s",
+		appDir,
+		stats.KeptFiles,
+		stats.KeptBytes,
+		stats.RemovedFiles,
+		stats.RemovedBytes,
+		time.Since(started).Round(time.Millisecond),
+	)
+}
@@ -0,0 +1,111 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeWorkspaceMonorepo(t *testing.T, dir string, rootPackageJSON string) {
+	t.Helper()
+	large := strings.Repeat("x", 4096)
+	writeContextFiles(t, dir, map[string]string{
+		"package.json":                  rootPackageJSON,
+		"package-lock.json":             `{"lockfileVersion":3}`,
+		"Dockerfile":                    "FROM node:20\n",
+		".yarn/releases/yarn.cjs":       "yarn",
+		"docs/guide.md":                 large,
+		"apps/web/package.json":         `{"name":"web","dependencies":{"@acme/ui":"*","react":"18"}}`,
+		"apps/web/src/index.js":         "console.log('web')\n",
+		"apps/admin/package.json":       `{"name":"admin","dependencies":{"@acme/ui":"*"}}`,
+		"apps/admin/src/index.js":       large,
+		"packages/ui/package.json":      `{"name":"@acme/ui","dependencies":{"@acme/utils":"workspace:*"}}`,
+		"packages/ui/src/button.js":     "export {}\n",
+		"packages/utils/package.json":   `{"name":"@acme/utils"}`,
+		"packages/utils/src/index.js":   "export {}\n",
+		"packages/unused/package.json":  `{"name":"@acme/unused"}`,
+		"packages/unused/src/index.js":  large,
+		"packages/unused/assets/big.js": large,
+	})
+}
+
+func TestScopeWorkspaceContextKeepsSubPathAndItsWorkspaceDependencies(t *testing.T) {
+	dir := t.TempDir()
+	writeWorkspaceMonorepo(t, dir, `{"name":"acme","private":true,"workspaces":["apps/*","packages/*"]}`)
+
+	var fullFiles int
+	var fullBytes int64
+	if err := countContextTree(dir, &fullFiles, &fullBytes); err != nil {
+		t.Fatalf("failed to measure full context: %v", err)
+	}
+
+	if reason := workspaceScopeBlocker(dir); reason != "" {
+		t.Fatalf("expected workspace to be scopable, got %q", reason)
+	}
+	stats, err := scopeWorkspaceContext(dir, "apps/web")
+	if err != nil {
+		t.Fatalf("scopeWorkspaceContext returned error: %v", err)
+	}
+
+	want := []string{
+		".yarn/releases/yarn.cjs",
+		"Dockerfile",
+		"apps/admin/package.json",
+		"apps/web/package.json",
+		"apps/web/src/index.js",
+		"package-lock.json",
+		"package.json",
+		"packages/ui/package.json",
+		"packages/ui/src/button.js",
+		"packages/unused/package.json",
+		"packages/utils/package.json",
+		"packages/utils/src/index.js",
+	}
+	if got := listContextFiles(t, dir); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected scoped context %v, got %v", want, got)
+	}
+
+	var scopedFiles int
+	var scopedBytes int64
+	if err := countContextTree(dir, &scopedFiles, &scopedBytes); err != nil {
+		t.Fatalf("failed to measure scoped context: %v", err)
+	}
+	if scopedBytes >= fullBytes/4 {
+		t.Fatalf("expected scoped context to be far smaller than the full %d bytes, got %d", fullBytes, scopedBytes)
+	}
+	if stats.KeptFiles != scopedFiles || stats.KeptBytes != scopedBytes {
+		t.Fatalf("expected kept stats to match the scoped context (%d files, %d bytes), got %+v", scopedFiles, scopedBytes, stats)
+	}
+	if stats.KeptFiles+stats.RemovedFiles != fullFiles || stats.KeptBytes+stats.RemovedBytes != fullBytes {
+		t.Fatalf("expected kept and removed stats to add up to the full context (%d files, %d bytes), got %+v", fullFiles, fullBytes, stats)
+	}
+}
+
+func TestWorkspaceScopeBlockerKeepsRootForLifecycleScripts(t *testing.T) {
+	dir := t.TempDir()
+	writeWorkspaceMonorepo(t, dir, `{"name":"acme","workspaces":["apps/*","packages/*"],"scripts":{"postinstall":"node scripts/setup.js"}}`)
+
+	if reason := workspaceScopeBlocker(dir); !strings.Contains(reason, "postinstall") {
+		t.Fatalf("expected a postinstall blocker, got %q", reason)
+	}
+}
+
+func TestWorkspaceScopeBlockerRequiresWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		"package.json":      `{"name":"single"}`,
+		"api/package.json":  `{"name":"api"}`,
+		"api/src/server.js": "console.log('api')\n",
+	})
+	if reason := workspaceScopeBlocker(dir); reason == "" {
+		t.Fatalf("expected a non-workspace repository to be left alone")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "pnpm-workspace.yaml"), []byte("packages:\n  - api\n"), 0o644); err != nil {
+		t.Fatalf("failed to write pnpm-workspace.yaml: %v", err)
+	}
+	if reason := workspaceScopeBlocker(dir); reason != "" {
+		t.Fatalf("expected pnpm-workspace.yaml to mark a workspace, got %q", reason)
+	}
+}
@@ -0,0 +1,111 @@
+package executor
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"hubfly-builder/internal/storage"
+)
+
+// workspacePrefix names every job workspace; the reaper refuses to delete
+// anything else even if the database says otherwise.
+const workspacePrefix = "hubfly-builder-ws-"
+
+// retainDebugWorkspace keeps a failed job's workspace for inspection when the
+// job asked for buildConfig.debug. Hubcell does not expose its intermediate
+// build container, so the cloned source, staged Dockerfile and the failing
+// build command are what is retained.
+func (w *Worker) retainDebugWorkspace() {
+	if !w.job.BuildConfig.Debug || w.workDir == "" || w.keepWorkspace {
+		return
+	}
+	if w.debugTTL <= 0 {
+		w.log("WARNING: buildConfig.debug is set but debug workspace retention is disabled on this builder")
+		return
+	}
+
+	session := storage.DebugSession{
+		Workspace: w.workDir,
+		Command:   w.lastBuildCommand,
+		ExpiresAt: time.Now().Add(w.debugTTL),
+	}
+	if err := w.storage.SetJobDebugSession(w.job.ID, session); err != nil {
+		w.log("WARNING: could not record debug workspace: %v", err)
+		return
+	}
+	w.keepWorkspace = true
+	w.log("Debug: keeping workspace %s until %s", w.workDir, session.ExpiresAt.UTC().Format(time.RFC3339))
+	if session.Command != "" {
+		w.log("Debug: re-run the failed build with: cd %s && %s", w.workDir, session.Command)
+	}
+}
+
+// SetDebugWorkspaceTTL sets how long workspaces of failed buildConfig.debug
+// jobs are kept. Zero disables retention.
+func (m *Manager) SetDebugWorkspaceTTL(ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.debugTTL = ttl
+}
+
+func (w *Worker) cleanupWorkspace() {
+	w.scrubGitAuth()
+	if w.keepWorkspace {
+		return
+	}
+	os.RemoveAll(w.workDir)
+	w.workDir = ""
+}
+
+// ReapDebugWorkspaces deletes retained debug workspaces whose TTL has ended
+// and returns how many it removed.
+func (m *Manager) ReapDebugWorkspaces(now time.Time) int {
+	sessions, err := m.storage.ExpiredDebugSessions(now)
+	if err != nil {
+		log.Printf("ERROR: could not list expired debug workspaces: %v", err)
+		return 0
+	}
+
+	reaped := 0
+	for _, session := range sessions {
+		if isJobWorkspacePath(session.Workspace) {
+			if err := os.RemoveAll(session.Workspace); err != nil {
+				log.Printf("WARN: could not remove debug workspace %s for job %s: %v", session.Workspace, session.JobID, err)
+				continue
+			}
+		} else {
+			log.Printf("WARN: refusing to remove debug workspace %q for job %s: not a job workspace", session.Workspace, session.JobID)
+		}
+		if err := m.storage.ClearJobDebugSession(session.JobID); err != nil {
+			log.Printf("WARN: could not clear debug workspace for job %s: %v", session.JobID, err)
+			continue
+		}
+		reaped++
+	}
+	return reaped
+}
+
+// redactedBuildCommand renders a build command for debug sessions with every
+// -e/--env value replaced, since those carry build args and secrets.
+func redactedBuildCommand(cmd *exec.Cmd) string {
+	args := make([]string, 0, len(cmd.Args))
+	redactNext := false
+	for _, arg := range cmd.Args {
+		if redactNext {
+			key := strings.SplitN(arg, "=", 2)[0]
+			arg = key + "=<redacted>"
+		}
+		redactNext = arg == "-e" || arg == "--env"
+		args = append(args, redactBuildArg(arg))
+	}
+	return strings.Join(args, " ")
+}
+
+func isJobWorkspacePath(path string) bool {
+	cleaned := filepath.Clean(path)
+	return filepath.IsAbs(cleaned) && strings.HasPrefix(filepath.Base(cleaned), workspacePrefix)
+}
@@ -0,0 +1,133 @@
+package executor
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"hubfly-builder/internal/api"
+	"hubfly-builder/internal/storage"
+)
+
+func newDebugTestWorker(t *testing.T, store *storage.Storage, debug bool) *Worker {
+	t.Helper()
+	job := &storage.BuildJob{ID: "build_debug", ProjectID: "proj", UserID: "user", BuildConfig: storage.BuildConfig{Debug: debug}}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	workDir, err := os.MkdirTemp(t.TempDir(), workspacePrefix+job.ID+"-")
+	if err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+	worker := newTestWorker(t, job)
+	worker.storage = store
+	worker.apiClient = api.NewClient("")
+	worker.workDir = workDir
+	worker.debugTTL = time.Hour
+	worker.lastBuildCommand = "sudo hubcell build -t tag ."
+	return worker
+}
+
+func TestFailedDebugJobRetainsWorkspace(t *testing.T) {
+	store := newDedupTestStorage(t)
+	worker := newDebugTestWorker(t, store, true)
+
+	worker.failJob("failed to build image with hubcell")
+	worker.cleanupWorkspace()
+
+	if _, err := os.Stat(worker.workDir); err != nil {
+		t.Fatalf("expected debug workspace to be retained: %v", err)
+	}
+	session, err := store.GetJobDebugSession(worker.job.ID)
+	if err != nil || session == nil {
+		t.Fatalf("expected a recorded debug session, got %+v (err=%v)", session, err)
+	}
+	if session.Workspace != worker.workDir || session.Command != "sudo hubcell build -t tag ." {
+		t.Fatalf("unexpected debug session: %+v", session)
+	}
+	if remaining := time.Until(session.ExpiresAt); remaining <= 0 || remaining > time.Hour {
+		t.Fatalf("expected expiry within the TTL, got %s", remaining)
+	}
+}
+
+func TestFailedJobWithoutDebugRemovesWorkspace(t *testing.T) {
+	store := newDedupTestStorage(t)
+	worker := newDebugTestWorker(t, store, false)
+
+	worker.failJob("failed to build image with hubcell")
+	worker.cleanupWorkspace()
+
+	if _, err := os.Stat(worker.workDir); !os.IsNotExist(err) {
+		t.Fatalf("expected workspace to be removed, got %v", err)
+	}
+	if session, _ := store.GetJobDebugSession(worker.job.ID); session != nil {
+		t.Fatalf("expected no debug session, got %+v", session)
+	}
+}
+
+func TestReapDebugWorkspacesRemovesExpiredWorkspaces(t *testing.T) {
+	store := newDedupTestStorage(t)
+	worker := newDebugTestWorker(t, store, true)
+	worker.failJob("failed to build image with hubcell")
+	worker.cleanupWorkspace()
+	manager := &Manager{storage: store}
+
+	if reaped := manager.ReapDebugWorkspaces(time.Now()); reaped != 0 {
+		t.Fatalf("expected nothing reaped before the TTL, got %d", reaped)
+	}
+	if _, err := os.Stat(worker.workDir); err != nil {
+		t.Fatalf("expected workspace to survive until the TTL: %v", err)
+	}
+
+	if reaped := manager.ReapDebugWorkspaces(time.Now().Add(2 * time.Hour)); reaped != 1 {
+		t.Fatalf("expected one workspace reaped after the TTL, got %d", reaped)
+	}
+	if _, err := os.Stat(worker.workDir); !os.IsNotExist(err) {
+		t.Fatalf("expected workspace to be removed after the TTL, got %v", err)
+	}
+	if session, _ := store.GetJobDebugSession(worker.job.ID); session != nil {
+		t.Fatalf("expected debug session to be cleared, got %+v", session)
+	}
+}
+
+func TestReapDebugWorkspacesRefusesForeignPaths(t *testing.T) {
+	store := newDedupTestStorage(t)
+	if err := store.CreateJob(&storage.BuildJob{ID: "build_foreign", ProjectID: "proj", UserID: "user"}); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	foreign := t.TempDir()
+	marker := filepath.Join(foreign, "keep")
+	if err := os.WriteFile(marker, []byte("x"), 0o644); err != nil {
+		t.Fatalf("failed to write marker: %v", err)
+	}
+	if err := store.SetJobDebugSession("build_foreign", storage.DebugSession{Workspace: foreign, ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("failed to set debug session: %v", err)
+	}
+
+	(&Manager{storage: store}).ReapDebugWorkspaces(time.Now())
+
+	if _, err := os.Stat(marker); err != nil {
+		t.Fatalf("expected a non-workspace path to be left alone: %v", err)
+	}
+}
+
+func TestRedactedBuildCommandHidesEnvValues(t *testing.T) {
+	cmd := exec.Command("sudo", "hubcell", "build", "-t", "tag", "-e", `API_TOKEN="s3cret"`, "--network", "net", ".")
+	packCmd := exec.Command("pack", "build", "tag", "--env", "DB_PASSWORD=hunter2")
+
+	got := redactedBuildCommand(cmd) + "\n" + redactedBuildCommand(packCmd)
+
+	for _, secret := range []string{"s3cret", "hunter2"} {
+		if strings.Contains(got, secret) {
+			t.Fatalf("expected %q to be redacted, got %q", secret, got)
+		}
+	}
+	for _, want := range []string{"-e API_TOKEN=<redacted>", "--env DB_PASSWORD=<redacted>", "--network net"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in %q", want, got)
+		}
+	}
+}
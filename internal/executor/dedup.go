@@ -0,0 +1,224 @@
+package executor
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"hubfly-builder/internal/driver"
+	"hubfly-builder/internal/pathfilter"
+)
+
+// contextHashVersion is mixed into every hash so changing what is hashed
+// never matches images recorded under the old scheme.
+const contextHashVersion = "hubfly-context-v1"
+
+// buildOrReuseImage builds opts with Hubcell unless context dedup is enabled
+// and a previous successful job built a byte-identical context with the same
+// build env, in which case that image is retagged instead.
+func (w *Worker) buildOrReuseImage(opts driver.HubcellBuildOpts) error {
+	w.pruneContext(opts)
+	if !w.dedupContexts {
+		return w.buildImageWithHubcell(opts)
+	}
+
+	// Build opts, platforms and secrets change the image too; secrets are
+	// hashed by digest. Their --key=value form cannot collide with KEY=value
+	// env entries.
+	hashInputs := append(append([]string(nil), opts.Envs...), driver.HubcellBuildOptArgs(opts.BuildOpts)...)
+	if len(opts.Platforms) > 0 {
+		hashInputs = append(hashInputs, "--platform="+strings.Join(opts.Platforms, ","))
+	}
+	// A Dockerfile outside the context is not hashed with it.
+	if filepath.IsAbs(opts.Dockerfile) {
+		data, err := os.ReadFile(opts.Dockerfile)
+		if err != nil {
+			w.log("WARNING: could not read Dockerfile %s; building normally: %v", opts.Dockerfile, err)
+			return w.buildImageWithHubcell(opts)
+		}
+		digest := sha256.Sum256(data)
+		hashInputs = append(hashInputs, "--dockerfile="+hex.EncodeToString(digest[:]))
+	}
+	for _, secret := range opts.Secrets {
+		data, err := os.ReadFile(secret.Path)
+		if err != nil {
+			w.log("WARNING: could not read build secret %s; building normally: %v", secret.ID, err)
+			return w.buildImageWithHubcell(opts)
+		}
+		digest := sha256.Sum256(data)
+		hashInputs = append(hashInputs, "--secret="+secret.ID+"="+hex.EncodeToString(digest[:]))
+	}
+	hash, err := buildContextHash(hubcellContextDir(opts), hashInputs)
+	if err != nil {
+		w.log("WARNING: could not hash build context; building normally: %v", err)
+		return w.buildImageWithHubcell(opts)
+	}
+	w.logDebug("Build context hash: %s", hash)
+
+	existing, err := w.storage.FindImageByContextHash(hash, w.job.ID)
+	switch {
+	case err == nil:
+		w.logInfo("Build context matches image %s; retagging instead of rebuilding", existing)
+		var tagErr error
+		for _, target := range append([]string{opts.ImageTag}, opts.ExtraTags...) {
+			cmd := driver.HubcellTagCommandContext(w.ctx, opts.HubcellPath, existing, target)
+			if tagErr = w.executeCommandWithoutLogging(cmd); tagErr != nil {
+				break
+			}
+		}
+		if tagErr == nil {
+			w.recordContextHash(hash)
+			return nil
+		}
+		w.log("WARNING: could not retag %s; building normally: %v", existing, tagErr)
+	case !errors.Is(err, sql.ErrNoRows):
+		w.log("WARNING: could not look up build context hash: %v", err)
+	}
+
+	if err := w.buildImageWithHubcell(opts); err != nil {
+		return err
+	}
+	w.recordContextHash(hash)
+	return nil
+}
+
+func (w *Worker) recordContextHash(hash string) {
+	if err := w.storage.UpdateJobContextHash(w.job.ID, hash); err != nil {
+		w.log("WARNING: could not record build context hash: %v", err)
+	}
+}
+
+func hubcellContextDir(opts driver.HubcellBuildOpts) string {
+	if filepath.IsAbs(opts.ContextPath) {
+		return opts.ContextPath
+	}
+	return filepath.Join(opts.WorkDir, opts.ContextPath)
+}
+
+// buildContextHash hashes every file Hubcell would send for contextDir after
+// .dockerignore is applied, together with the build env entries. The .git
+// directory is skipped: its metadata differs between clones of the same tree.
+func buildContextHash(contextDir string, envs []string) (string, error) {
+	ignore, err := loadDockerignore(contextDir)
+	if err != nil {
+		return "", err
+	}
+
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "%s\x00", contextHashVersion)
+	sortedEnvs := append([]string(nil), envs...)
+	sort.Strings(sortedEnvs)
+	for _, entry := range sortedEnvs {
+		fmt.Fprintf(hasher, "env\x00%s\x00", entry)
+	}
+
+	err = filepath.WalkDir(contextDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(contextDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if entry.IsDir() && entry.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if ignore.excludes(rel) {
+			if entry.IsDir() && !ignore.hasNegations {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hasher, "path\x00%s\x00%o\x00", rel, info.Mode())
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hasher, "link\x00%s\x00", target)
+		case info.Mode().IsRegular():
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			fmt.Fprintf(hasher, "size\x00%d\x00", info.Size())
+			if _, err := io.Copy(hasher, file); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+type dockerignoreRule struct {
+	pattern *regexp.Regexp
+	negate  bool
+}
+
+type dockerignore struct {
+	rules        []dockerignoreRule
+	hasNegations bool
+}
+
+func loadDockerignore(contextDir string) (dockerignore, error) {
+	var ignore dockerignore
+	data, err := os.ReadFile(filepath.Join(contextDir, ".dockerignore"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ignore, nil
+		}
+		return ignore, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := dockerignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			ignore.hasNegations = true
+			line = strings.TrimSpace(line[1:])
+		}
+		line = strings.Trim(filepath.ToSlash(filepath.Clean(line)), "/")
+		if line == "" || line == "." {
+			continue
+		}
+		rule.pattern = pathfilter.Compile(line)
+		ignore.rules = append(ignore.rules, rule)
+	}
+	return ignore, nil
+}
+
+// excludes applies the rules in order, last match wins. A pattern that matches
+// a directory also excludes everything below it.
+func (d dockerignore) excludes(rel string) bool {
+	excluded := false
+	for _, rule := range d.rules {
+		if pathfilter.MatchesPathOrParent(rule.pattern, rel) {
+			excluded = !rule.negate
+		}
+	}
+	return excluded
+}
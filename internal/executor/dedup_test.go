@@ -0,0 +1,178 @@
+package executor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hubfly-builder/internal/driver"
+	"hubfly-builder/internal/storage"
+)
+
+// installFakeSudo records each sudo invocation so tests can see whether
+// Hubcell was asked to build or to tag.
+func installFakeSudo(t *testing.T) string {
+	t.Helper()
+	return fakeCalls(installFakeBinary(t, "sudo", recordFakeCall))
+}
+
+func newDedupTestWorker(t *testing.T, store *storage.Storage, id string) *Worker {
+	t.Helper()
+	job := &storage.BuildJob{ID: id, ProjectID: "proj_" + id, UserID: "user"}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	workDir := t.TempDir()
+	files := map[string]string{
+		"Dockerfile":        "FROM alpine\nCOPY . /app\n",
+		"index.js":          "console.log('hi')\n",
+		".dockerignore":     "node_modules\n",
+		"node_modules/a.js": id,
+	}
+	for name, content := range files {
+		path := filepath.Join(workDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	worker := newTestWorker(t, job)
+	worker.storage = store
+	worker.workDir = workDir
+	worker.dedupContexts = true
+	return worker
+}
+
+func dedupBuildOpts(worker *Worker) driver.HubcellBuildOpts {
+	return driver.HubcellBuildOpts{
+		HubcellPath: "hubcell",
+		WorkDir:     worker.workDir,
+		ContextPath: ".",
+		ImageTag:    "hubcell.local/user/" + worker.job.ProjectID + ":" + worker.job.ID,
+		Envs:        []string{`NODE_ENV="production"`},
+	}
+}
+
+func newDedupTestStorage(t *testing.T) *storage.Storage {
+	t.Helper()
+	store, err := storage.NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	return store
+}
+
+func readSudoCalls(t *testing.T, calls string) string {
+	t.Helper()
+	data, err := os.ReadFile(calls)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("failed to read fake sudo calls: %v", err)
+	}
+	return string(data)
+}
+
+func TestBuildOrReuseImageRetagsIdenticalContext(t *testing.T) {
+	calls := installFakeSudo(t)
+	store := newDedupTestStorage(t)
+
+	first := newDedupTestWorker(t, store, "build_first")
+	if err := first.buildOrReuseImage(dedupBuildOpts(first)); err != nil {
+		t.Fatalf("first build failed: %v", err)
+	}
+	firstTag := dedupBuildOpts(first).ImageTag
+	if err := store.UpdateJobImageTag(first.job.ID, firstTag); err != nil {
+		t.Fatalf("failed to update image tag: %v", err)
+	}
+	if err := store.UpdateJobStatus(first.job.ID, "success"); err != nil {
+		t.Fatalf("failed to update status: %v", err)
+	}
+
+	// Only ignored files and git metadata differ between the two contexts.
+	second := newDedupTestWorker(t, store, "build_second")
+	if err := os.MkdirAll(filepath.Join(second.workDir, ".git"), 0o755); err != nil {
+		t.Fatalf("failed to create .git: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(second.workDir, ".git", "FETCH_HEAD"), []byte(second.job.ID), 0o644); err != nil {
+		t.Fatalf("failed to write .git metadata: %v", err)
+	}
+	if err := second.buildOrReuseImage(dedupBuildOpts(second)); err != nil {
+		t.Fatalf("second build failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(readSudoCalls(t, calls)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected one build and one tag, got %q", lines)
+	}
+	if !strings.Contains(lines[0], " build ") {
+		t.Fatalf("expected first job to build, got %q", lines[0])
+	}
+	wantTag := "hubcell tag " + firstTag + " " + dedupBuildOpts(second).ImageTag
+	if lines[1] != wantTag {
+		t.Fatalf("expected second job to retag with %q, got %q", wantTag, lines[1])
+	}
+}
+
+func TestBuildOrReuseImageBuildsWhenContextDiffers(t *testing.T) {
+	calls := installFakeSudo(t)
+	store := newDedupTestStorage(t)
+
+	first := newDedupTestWorker(t, store, "build_first")
+	if err := first.buildOrReuseImage(dedupBuildOpts(first)); err != nil {
+		t.Fatalf("first build failed: %v", err)
+	}
+	store.UpdateJobImageTag(first.job.ID, dedupBuildOpts(first).ImageTag)
+	store.UpdateJobStatus(first.job.ID, "success")
+
+	second := newDedupTestWorker(t, store, "build_second")
+	if err := os.WriteFile(filepath.Join(second.workDir, "index.js"), []byte("console.log('changed')\n"), 0o644); err != nil {
+		t.Fatalf("failed to modify context: %v", err)
+	}
+	if err := second.buildOrReuseImage(dedupBuildOpts(second)); err != nil {
+		t.Fatalf("second build failed: %v", err)
+	}
+
+	output := readSudoCalls(t, calls)
+	if strings.Contains(output, " tag ") {
+		t.Fatalf("expected a changed context to build instead of retagging, got %q", output)
+	}
+	if strings.Count(output, " build ") != 2 {
+		t.Fatalf("expected both jobs to build, got %q", output)
+	}
+}
+
+func TestBuildContextHashHonorsDockerignore(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	write(".dockerignore", "**/*.log\ndist\n!dist/keep.txt\n")
+	write("app.go", "package main\n")
+	write("logs/debug.log", "one")
+	write("dist/bundle.js", "one")
+	write("dist/keep.txt", "one")
+
+	before, err := buildContextHash(dir, nil)
+	if err != nil {
+		t.Fatalf("failed to hash context: %v", err)
+	}
+	write("logs/debug.log", "two")
+	write("dist/bundle.js", "two")
+	if after, _ := buildContextHash(dir, nil); after != before {
+		t.Fatalf("expected ignored files not to change the hash")
+	}
+	write("dist/keep.txt", "two")
+	changed, _ := buildContextHash(dir, nil)
+	if changed == before {
+		t.Fatalf("expected re-included file to change the hash")
+	}
+	if withEnv, _ := buildContextHash(dir, []string{`A="1"`}); withEnv == changed {
+		t.Fatalf("expected build env to change the hash")
+	}
+}
@@ -0,0 +1,24 @@
+package executor
+
+import "hubfly-builder/internal/storage"
+
+// SetBuildDNSServers sets the resolvers of Hubcell image builds whose job
+// names none of its own. Empty keeps the resolvers Hubcell picks.
+func (m *Manager) SetBuildDNSServers(servers []string) error {
+	if err := storage.DNSServers(servers).Validate(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dnsServers = append([]string(nil), servers...)
+	return nil
+}
+
+// buildDNSServers are the resolvers of the job's image build: its own
+// buildConfig.dnsServers, or else the builder's.
+func (w *Worker) buildDNSServers() []string {
+	if len(w.job.BuildConfig.DNSServers) > 0 {
+		return w.job.BuildConfig.DNSServers
+	}
+	return w.dnsServers
+}
@@ -0,0 +1,26 @@
+package executor
+
+import (
+	"testing"
+
+	"hubfly-builder/internal/storage"
+)
+
+func TestBuildDNSServersPreferJobServers(t *testing.T) {
+	manager := &Manager{}
+	if err := manager.SetBuildDNSServers([]string{"10.0.0.2"}); err != nil {
+		t.Fatalf("SetBuildDNSServers returned error: %v", err)
+	}
+	if err := manager.SetBuildDNSServers([]string{"dns.internal"}); err == nil {
+		t.Fatal("expected a hostname to be rejected")
+	}
+
+	worker := &Worker{job: &storage.BuildJob{}, dnsServers: manager.dnsServers}
+	if got := worker.buildDNSServers(); len(got) != 1 || got[0] != "10.0.0.2" {
+		t.Fatalf("expected the builder's servers, got %v", got)
+	}
+	worker.job.BuildConfig.DNSServers = storage.DNSServers{"fd00::53"}
+	if got := worker.buildDNSServers(); len(got) != 1 || got[0] != "fd00::53" {
+		t.Fatalf("expected the job's servers to win, got %v", got)
+	}
+}
@@ -0,0 +1,147 @@
+package executor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// runtimeRequiredFiles lists the manifests a build of each runtime reads from
+// its context. Only the ones present in the repository are checked.
+var runtimeRequiredFiles = map[string][]string{
+	"node":   {"package.json", "package-lock.json", "yarn.lock", "pnpm-lock.yaml"},
+	"bun":    {"package.json", "bun.lock", "bun.lockb"},
+	"python": {"requirements.txt", "pyproject.toml", "Pipfile", "poetry.lock"},
+	"go":     {"go.mod", "go.sum"},
+	"rust":   {"Cargo.toml", "Cargo.lock"},
+	"elixir": {"mix.exs", "mix.lock"},
+	"php":    {"composer.json", "composer.lock"},
+	"ruby":   {"Gemfile", "Gemfile.lock"},
+	"java":   {"pom.xml", "build.gradle", "build.gradle.kts", "gradlew"},
+	"static": {"index.html"},
+}
+
+// conventionalSourceDirs are directories that hold application source in
+// most layouts; ignoring all of one is almost always a mistake.
+var conventionalSourceDirs = []string{"src", "app", "cmd", "lib", "internal", "pages", "public", "server"}
+
+// dockerignoreWarnings reports files and source directories under appRel that
+// the build likely needs but .dockerignore in contextDir excludes. An empty
+// runtime checks the manifests of every runtime.
+func dockerignoreWarnings(contextDir, appRel, runtime string) ([]string, error) {
+	ignore, err := loadDockerignore(contextDir)
+	if err != nil || len(ignore.rules) == 0 {
+		return nil, err
+	}
+	appRel = filepath.ToSlash(filepath.Clean(appRel))
+
+	var required []string
+	if files, ok := runtimeRequiredFiles[runtime]; ok {
+		required = files
+	} else {
+		seen := map[string]bool{}
+		for _, files := range runtimeRequiredFiles {
+			for _, name := range files {
+				if !seen[name] {
+					seen[name] = true
+					required = append(required, name)
+				}
+			}
+		}
+		sort.Strings(required)
+	}
+
+	var warnings []string
+	for _, name := range required {
+		rel := contextRelPath(appRel, name)
+		info, err := os.Stat(filepath.Join(contextDir, filepath.FromSlash(rel)))
+		if err != nil || info.IsDir() || !ignore.excludes(rel) {
+			continue
+		}
+		if runtime != "" {
+			warnings = append(warnings, fmt.Sprintf(".dockerignore excludes %s, which %s builds need", rel, runtime))
+		} else {
+			warnings = append(warnings, fmt.Sprintf(".dockerignore excludes %s, which the build likely needs", rel))
+		}
+	}
+
+	for _, dir := range conventionalSourceDirs {
+		rel := contextRelPath(appRel, dir)
+		if excluded, err := allFilesExcluded(contextDir, rel, ignore); err != nil {
+			return warnings, err
+		} else if excluded {
+			warnings = append(warnings, fmt.Sprintf(".dockerignore excludes every file in %s/; the build will not see that source", rel))
+		}
+	}
+	return warnings, nil
+}
+
+func contextRelPath(appRel, name string) string {
+	if appRel == "." || appRel == "" {
+		return name
+	}
+	return appRel + "/" + name
+}
+
+// allFilesExcluded reports whether dir exists, holds files, and all of them
+// are excluded by ignore.
+func allFilesExcluded(contextDir, dir string, ignore dockerignore) (bool, error) {
+	root := filepath.Join(contextDir, filepath.FromSlash(dir))
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return false, nil
+	}
+	files := 0
+	kept := false
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(contextDir, path)
+		if err != nil {
+			return err
+		}
+		files++
+		if !ignore.excludes(filepath.ToSlash(rel)) {
+			kept = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return files > 0 && !kept, nil
+}
+
+// checkDockerignore warns when the .dockerignore of the build context likely
+// excludes files the build needs. The check is advisory: the warnings are
+// logged and recorded on the job, and the build goes ahead.
+func (w *Worker) checkDockerignore(contextDir, appPath string) {
+	appRel, err := filepath.Rel(contextDir, appPath)
+	if err != nil || appRel == ".." || strings.HasPrefix(appRel, ".."+string(filepath.Separator)) {
+		appRel = "."
+	}
+	warnings, err := dockerignoreWarnings(contextDir, appRel, w.job.BuildConfig.Runtime)
+	if err != nil {
+		w.log("WARNING: could not check .dockerignore: %v", err)
+	}
+	if len(warnings) == 0 {
+		return
+	}
+	for _, warning := range warnings {
+		w.log("WARNING: %s", warning)
+	}
+	w.job.BuildConfig.ValidationWarnings = mergeWarnings(w.job.BuildConfig.ValidationWarnings, warnings)
+	if w.storage == nil {
+		return
+	}
+	if err := w.storage.UpdateJobBuildConfig(w.job.ID, &w.job.BuildConfig); err != nil {
+		w.log("WARNING: could not persist .dockerignore warnings: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package executor
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hubfly-builder/internal/storage"
+)
+
+func TestDockerignoreWarningsFlagsOverBroadIgnore(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		".dockerignore":  "node_modules\n*.json\nsrc/\n",
+		"package.json":   "{}",
+		"src/index.js":   "console.log('hi')\n",
+		"src/lib/a.js":   "module.exports = 1\n",
+		"README.md":      "docs\n",
+		"node_modules/x": "dep\n",
+	})
+
+	warnings, err := dockerignoreWarnings(dir, ".", "node")
+	if err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	want := []string{
+		".dockerignore excludes package.json, which node builds need",
+		".dockerignore excludes every file in src/; the build will not see that source",
+	}
+	if strings.Join(warnings, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected %q, got %q", want, warnings)
+	}
+}
+
+func TestDockerignoreWarningsAcceptsTypicalIgnore(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		".dockerignore":   "node_modules\n.git\nsrc/**/*.test.js\n",
+		"package.json":    "{}",
+		"src/index.js":    "console.log('hi')\n",
+		"src/app.test.js": "test\n",
+	})
+
+	warnings, err := dockerignoreWarnings(dir, ".", "node")
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("expected no warnings, got %q (err %v)", warnings, err)
+	}
+}
+
+func TestDockerignoreWarningsHonoursNegations(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		".dockerignore":            "services/api/src\n!services/api/src/main.go\n",
+		"services/api/go.mod":      "module api\n",
+		"services/api/src/main.go": "package main\n",
+		"services/api/src/util.go": "package main\n",
+	})
+
+	warnings, err := dockerignoreWarnings(dir, "services/api", "")
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("expected a re-included file to keep the directory, got %q (err %v)", warnings, err)
+	}
+}
+
+func TestCheckDockerignoreRecordsJobWarnings(t *testing.T) {
+	dir := t.TempDir()
+	writeContextFiles(t, dir, map[string]string{
+		".dockerignore": "go.mod\n",
+		"go.mod":        "module app\n",
+		"main.go":       "package main\n",
+	})
+	worker := &Worker{
+		job:       &storage.BuildJob{ID: "build_ignore", BuildConfig: storage.BuildConfig{Runtime: "go"}},
+		logWriter: io.Discard,
+	}
+
+	worker.checkDockerignore(dir, filepath.Join(dir, "."))
+
+	warnings := worker.job.BuildConfig.ValidationWarnings
+	if len(warnings) != 1 || warnings[0] != ".dockerignore excludes go.mod, which go builds need" {
+		t.Fatalf("expected a go.mod warning on the job, got %q", warnings)
+	}
+}
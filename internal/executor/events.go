@@ -0,0 +1,65 @@
+package executor
+
+import (
+	"log"
+
+	"hubfly-builder/internal/events"
+	"hubfly-builder/internal/jobstate"
+	"hubfly-builder/internal/storage"
+)
+
+// SetEventBus makes the manager and its workers publish job status and phase
+// changes to bus.
+func (m *Manager) SetEventBus(bus *events.Bus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.eventBus = bus
+}
+
+func (m *Manager) currentEventBus() *events.Bus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.eventBus
+}
+
+func publishStatus(bus *events.Bus, job *storage.BuildJob, status jobstate.Status) {
+	bus.Publish(events.Event{
+		Type:      events.JobStatus,
+		JobID:     job.ID,
+		ProjectID: job.ProjectID,
+		UserID:    job.UserID,
+		Status:    string(status),
+	})
+}
+
+// publishStatusByID is publishStatus for callers that only hold the job ID.
+func (m *Manager) publishStatusByID(jobID string, status jobstate.Status) {
+	bus := m.currentEventBus()
+	if bus == nil {
+		return
+	}
+	job, err := m.storage.GetJob(jobID)
+	if err != nil {
+		log.Printf("WARN: could not load job %s to publish its status: %v", jobID, err)
+		return
+	}
+	publishStatus(bus, job, status)
+}
+
+// reportProgress sends a lifecycle update to the job's callback URL. A report
+// that fails is logged and the build carries on.
+func (w *Worker) reportProgress(phase, message string) {
+	if err := w.apiClient.ReportProgress(w.job, phase, message); err != nil {
+		log.Printf("WARN: could not report %s progress for job %s: %v", phase, w.job.ID, err)
+	}
+}
+
+func (w *Worker) publishPhase(phase string) {
+	w.eventBus.Publish(events.Event{
+		Type:      events.JobPhase,
+		JobID:     w.job.ID,
+		ProjectID: w.job.ProjectID,
+		UserID:    w.job.UserID,
+		Phase:     phase,
+	})
+}
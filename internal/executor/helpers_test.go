@@ -0,0 +1,49 @@
+package executor
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"hubfly-builder/internal/storage"
+)
+
+// recordFakeCall is a fake binary script line that appends the arguments of
+// each invocation to the calls file next to the script.
+const recordFakeCall = "echo \"$@\" >> \"$(dirname \"$0\")/calls\"\n"
+
+// installFakeBinary puts a shell script called name, running script, first on
+// PATH and returns the directory it was written to. The script can keep
+// state, such as the calls file recordFakeCall writes, in "$(dirname "$0")".
+func installFakeBinary(t *testing.T, name, script string) string {
+	t.Helper()
+	binDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(binDir, name), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatalf("failed to write fake %s: %v", name, err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return binDir
+}
+
+// fakeCalls is the calls file of a fake binary installed in binDir.
+func fakeCalls(binDir string) string {
+	return filepath.Join(binDir, "calls")
+}
+
+// newTestWorker returns a worker for job, or for a job of user "user" and
+// project "proj" when job is nil, with a temporary workspace and a discarded
+// log. Tests set whatever else they need on it.
+func newTestWorker(t *testing.T, job *storage.BuildJob) *Worker {
+	t.Helper()
+	if job == nil {
+		job = &storage.BuildJob{ID: "build_test", ProjectID: "proj", UserID: "user"}
+	}
+	return &Worker{
+		job:       job,
+		logWriter: io.Discard,
+		workDir:   t.TempDir(),
+		ctx:       context.Background(),
+	}
+}
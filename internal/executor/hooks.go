@@ -0,0 +1,74 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"hubfly-builder/internal/allowlist"
+)
+
+type BuildHooks struct {
+	PreBuild             []string
+	PostBuild            []string
+	FailOnPostBuildError bool
+}
+
+func (w *Worker) runPreBuildHooks() error {
+	for _, hook := range w.hooks.PreBuild {
+		if err := w.runHook("pre-build", hook); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *Worker) runPostBuildHooks() error {
+	for _, hook := range w.hooks.PostBuild {
+		if err := w.runHook("post-build", hook); err != nil {
+			if w.hooks.FailOnPostBuildError {
+				return err
+			}
+			w.log("WARNING: %v (post-build hook failures are non-fatal)", err)
+		}
+	}
+	return nil
+}
+
+func (w *Worker) runHook(stage, hook string) error {
+	hook = strings.TrimSpace(hook)
+	if hook == "" {
+		return nil
+	}
+	var allowed []string
+	if w.allowlist != nil {
+		allowed = w.allowlist.Hooks
+	}
+	if !allowlist.IsCommandAllowed(hook, allowed) {
+		return fmt.Errorf("%s hook is not allowed: %s", stage, hook)
+	}
+
+	w.logInfo("Running %s hook: %s", stage, hook)
+	cmd := w.execCommand("sh", "-c", hook)
+	cmd.Dir = w.workDir
+	cmd.Env = append(os.Environ(), w.hookEnv(stage)...)
+	if err := w.executeCommandWithoutLogging(cmd); err != nil {
+		return fmt.Errorf("%s hook %q failed: %w", stage, hook, err)
+	}
+	return nil
+}
+
+func (w *Worker) hookEnv(stage string) []string {
+	return []string{
+		"HUBFLY_HOOK_STAGE=" + stage,
+		"HUBFLY_JOB_ID=" + w.job.ID,
+		"HUBFLY_PROJECT_ID=" + w.job.ProjectID,
+		"HUBFLY_USER_ID=" + w.job.UserID,
+		"HUBFLY_GIT_REPOSITORY=" + sanitizeGitRepositoryURL(w.job.SourceInfo.GitRepository),
+		"HUBFLY_GIT_REF=" + w.job.SourceInfo.Ref,
+		"HUBFLY_COMMIT_SHA=" + w.job.SourceInfo.CommitSha,
+		"HUBFLY_WORKING_DIR=" + w.job.SourceInfo.WorkingDir,
+		"HUBFLY_RUNTIME=" + w.job.BuildConfig.Runtime,
+		"HUBFLY_IMAGE_TAG=" + w.job.ImageTag,
+	}
+}
@@ -0,0 +1,106 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+
+	"hubfly-builder/internal/driver"
+	"hubfly-builder/internal/storage"
+)
+
+// imageCheckBatchSize bounds how many images one reconcile pass looks up.
+const imageCheckBatchSize = 50
+
+var (
+	// ErrImageCheckDisabled is returned when no ImageChecker is configured.
+	ErrImageCheckDisabled = errors.New("image verification is not configured")
+	// ErrNoImageToVerify is returned for jobs without a Hubcell-built image.
+	ErrNoImageToVerify = errors.New("job has no Hubcell-built image to verify")
+)
+
+// ImageChecker reports whether an image tag still exists in the image store.
+type ImageChecker interface {
+	ImageExists(ctx context.Context, imageTag string) (bool, error)
+}
+
+// HubcellImageChecker looks images up in Hubcell's local image store.
+type HubcellImageChecker struct {
+	HubcellPath string
+}
+
+// ImageExists treats a non-zero exit from hubcell image inspect as a missing
+// image; failing to run the command at all is an error.
+func (c HubcellImageChecker) ImageExists(ctx context.Context, imageTag string) (bool, error) {
+	output, err := driver.HubcellImageInspectCommandContext(ctx, c.HubcellPath, imageTag).CombinedOutput()
+	if err == nil {
+		return true, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		return false, nil
+	}
+	return false, fmt.Errorf("could not inspect image %s: %v: %s", imageTag, err, strings.TrimSpace(string(output)))
+}
+
+// SetImageChecker sets how successful jobs' images are verified. Nil disables
+// image verification.
+func (m *Manager) SetImageChecker(checker ImageChecker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.imageChecker = checker
+}
+
+// VerifyJobImage looks up a successful job's image tag now and records
+// whether it is missing.
+func (m *Manager) VerifyJobImage(ctx context.Context, job *storage.BuildJob) (storage.ImageCheck, error) {
+	m.mu.Lock()
+	checker := m.imageChecker
+	m.mu.Unlock()
+	if checker == nil {
+		return storage.ImageCheck{}, ErrImageCheckDisabled
+	}
+	if job.Status != "success" || strings.TrimSpace(job.ImageTag) == "" || job.BuildConfig.UseBuildpacks {
+		return storage.ImageCheck{}, ErrNoImageToVerify
+	}
+
+	exists, err := checker.ImageExists(ctx, job.ImageTag)
+	if err != nil {
+		return storage.ImageCheck{}, err
+	}
+	check := storage.ImageCheck{Missing: !exists, CheckedAt: time.Now()}
+	if err := m.storage.SetJobImageCheck(job.ID, check); err != nil {
+		return storage.ImageCheck{}, err
+	}
+	if check.Missing {
+		log.Printf("WARN: image %s of job %s no longer exists", job.ImageTag, job.ID)
+	}
+	return check, nil
+}
+
+// ReconcileImages verifies the images of successful jobs not checked since
+// checkedBefore, a batch at a time, and returns how many were found missing.
+func (m *Manager) ReconcileImages(ctx context.Context, checkedBefore time.Time) int {
+	jobs, err := m.storage.JobsForImageCheck(checkedBefore, imageCheckBatchSize)
+	if err != nil {
+		log.Printf("ERROR: could not list jobs for image verification: %v", err)
+		return 0
+	}
+
+	missing := 0
+	for _, job := range jobs {
+		check, err := m.VerifyJobImage(ctx, job)
+		if err != nil {
+			log.Printf("WARN: could not verify image of job %s: %v", job.ID, err)
+			continue
+		}
+		if check.Missing {
+			missing++
+		}
+	}
+	return missing
+}
@@ -0,0 +1,80 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"hubfly-builder/internal/storage"
+)
+
+type fakeImageChecker map[string]bool
+
+func (f fakeImageChecker) ImageExists(_ context.Context, imageTag string) (bool, error) {
+	return f[imageTag], nil
+}
+
+func seedSuccessfulImageJob(t *testing.T, store *storage.Storage, id, imageTag string) {
+	t.Helper()
+	if err := store.CreateJob(&storage.BuildJob{ID: id, ProjectID: "proj", UserID: "user"}); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	if err := store.UpdateJobImageTag(id, imageTag); err != nil {
+		t.Fatalf("failed to set image tag: %v", err)
+	}
+	if err := store.UpdateJobStatus(id, "success"); err != nil {
+		t.Fatalf("failed to set status: %v", err)
+	}
+}
+
+func TestReconcileImagesFlagsMissingImages(t *testing.T) {
+	store := newDedupTestStorage(t)
+	seedSuccessfulImageJob(t, store, "build_present", "hubcell.local/user/app:present")
+	seedSuccessfulImageJob(t, store, "build_gone", "hubcell.local/user/app:gone")
+	manager := &Manager{storage: store}
+	manager.SetImageChecker(fakeImageChecker{"hubcell.local/user/app:present": true})
+
+	if missing := manager.ReconcileImages(context.Background(), time.Now()); missing != 1 {
+		t.Fatalf("expected one missing image, got %d", missing)
+	}
+	for id, wantMissing := range map[string]bool{"build_present": false, "build_gone": true} {
+		check, err := store.GetJobImageCheck(id)
+		if err != nil || check == nil {
+			t.Fatalf("expected %s to be checked, got %+v, %v", id, check, err)
+		}
+		if check.Missing != wantMissing {
+			t.Fatalf("expected %s missing=%t, got %t", id, wantMissing, check.Missing)
+		}
+	}
+
+	if missing := manager.ReconcileImages(context.Background(), time.Now().Add(-time.Hour)); missing != 0 {
+		t.Fatalf("expected freshly checked images to be skipped, got %d missing", missing)
+	}
+}
+
+func TestVerifyJobImageRequiresCheckerAndImage(t *testing.T) {
+	store := newDedupTestStorage(t)
+	manager := &Manager{storage: store}
+	job := &storage.BuildJob{ID: "build_pending", Status: "pending"}
+
+	if _, err := manager.VerifyJobImage(context.Background(), job); !errors.Is(err, ErrImageCheckDisabled) {
+		t.Fatalf("expected ErrImageCheckDisabled, got %v", err)
+	}
+	manager.SetImageChecker(fakeImageChecker{})
+	if _, err := manager.VerifyJobImage(context.Background(), job); !errors.Is(err, ErrNoImageToVerify) {
+		t.Fatalf("expected ErrNoImageToVerify, got %v", err)
+	}
+}
+
+func TestHubcellImageCheckerTreatsFailedInspectAsMissing(t *testing.T) {
+	installFakeBinary(t, "sudo", "case \"$*\" in *present*) exit 0 ;; esac\necho 'image not found' >&2\nexit 1\n")
+	checker := HubcellImageChecker{HubcellPath: "hubcell"}
+
+	if exists, err := checker.ImageExists(context.Background(), "hubcell.local/user/app:present"); err != nil || !exists {
+		t.Fatalf("expected present image to exist, got %t, %v", exists, err)
+	}
+	if exists, err := checker.ImageExists(context.Background(), "hubcell.local/user/app:gone"); err != nil || exists {
+		t.Fatalf("expected gone image to be missing, got %t, %v", exists, err)
+	}
+}
@@ -0,0 +1,60 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultImageTagTemplate is the tag of a job image when IMAGE_TAG_TEMPLATE is
+// unset, e.g. "abc123-bbuild_1-v20260210T123000Z".
+const defaultImageTagTemplate = "{ref}-b{jobId}-v{timestamp}"
+
+// imageTagTemplate returns the IMAGE_TAG_TEMPLATE tag template. Its
+// placeholders are {ref} (the short commit SHA, or the ref), {jobId},
+// {timestamp} and {buildNumber}, the project's next build number.
+func imageTagTemplate() string {
+	if template := strings.TrimSpace(os.Getenv("IMAGE_TAG_TEMPLATE")); template != "" {
+		return template
+	}
+	return defaultImageTagTemplate
+}
+
+// generateImageTag renders the job's image tag. A build number is taken from
+// the project's counter only when the template uses it.
+func (w *Worker) generateImageTag() (string, error) {
+	shortSha := sanitizeImageTagComponent(w.job.SourceInfo.CommitSha)
+	if shortSha == "" {
+		shortSha = sanitizeImageTagComponent(w.job.SourceInfo.Ref)
+	}
+	if shortSha == "" {
+		shortSha = "latest"
+	}
+	if len(shortSha) > 12 {
+		shortSha = shortSha[:12]
+	}
+
+	template := imageTagTemplate()
+	replacements := []string{
+		"{ref}", shortSha,
+		"{jobId}", w.job.ID,
+		"{timestamp}", time.Now().UTC().Format("20060102T150405Z"),
+	}
+	if strings.Contains(template, "{buildNumber}") {
+		if w.storage == nil {
+			return "", fmt.Errorf("IMAGE_TAG_TEMPLATE uses {buildNumber} but no build counter is available")
+		}
+		number, err := w.storage.AssignJobBuildNumber(w.job.ID)
+		if err != nil {
+			return "", fmt.Errorf("could not assign a build number: %w", err)
+		}
+		replacements = append(replacements, "{buildNumber}", strconv.FormatInt(number, 10))
+	}
+	tag := strings.NewReplacer(replacements...).Replace(template)
+	if !imageTagNamePattern.MatchString(tag) {
+		return "", fmt.Errorf("IMAGE_TAG_TEMPLATE %q renders the invalid tag %q: tags may contain letters, digits, '_', '.' and '-', must not start with '.' or '-' and are at most 128 characters", template, tag)
+	}
+	return fmt.Sprintf("hubcell.local/%s/%s:%s", sanitize(w.job.UserID), sanitize(w.job.ProjectID), tag), nil
+}
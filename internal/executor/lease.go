@@ -0,0 +1,108 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"hubfly-builder/internal/jobstate"
+	"hubfly-builder/internal/storage"
+)
+
+// ErrClaimLost is the cause a build is stopped with once this instance's
+// claim on its job has lapsed, since the job may be built by another instance
+// by then.
+var ErrClaimLost = errors.New("job claim lost")
+
+// ClaimLease makes this instance's claims on jobs lapse unless renewed, so the
+// jobs of an instance that stopped are requeued by the others sharing its job
+// database. Owner names this instance in the claims; a zero Duration turns
+// leases off.
+type ClaimLease struct {
+	Owner    string
+	Duration time.Duration
+}
+
+// claim is the storage lease of a job claimed at now.
+func (l ClaimLease) claim(now time.Time) storage.ClaimLease {
+	if l.Duration <= 0 {
+		return storage.ClaimLease{Owner: l.Owner}
+	}
+	return storage.ClaimLease{Owner: l.Owner, ExpiresAt: now.Add(l.Duration)}
+}
+
+func (m *Manager) SetClaimLease(lease ClaimLease) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lease = lease
+}
+
+// RenewClaimLeases extends the leases of the builds running on this instance
+// and returns how many it renewed. It must run well within the lease duration.
+// A build whose claim has lapsed in the meantime is stopped.
+func (m *Manager) RenewClaimLeases(now time.Time) int {
+	m.mu.Lock()
+	lease := m.lease
+	builds := make(map[string]context.CancelCauseFunc, len(m.activeBuilds))
+	for id, cancel := range m.activeBuilds {
+		builds[id] = cancel
+	}
+	m.mu.Unlock()
+	if lease.Duration <= 0 {
+		return 0
+	}
+
+	renewed := 0
+	for id, cancel := range builds {
+		ok, err := m.storage.RenewClaimLease(id, lease.Owner, now.Add(lease.Duration))
+		if err != nil {
+			log.Printf("ERROR: could not renew the claim on job %s: %v", id, err)
+			continue
+		}
+		if ok {
+			renewed++
+			continue
+		}
+		// A job that has just finished is no longer in progress either; only
+		// one still queued or in progress was taken away.
+		job, err := m.storage.GetJob(id)
+		if err != nil {
+			log.Printf("ERROR: could not check the claim on job %s: %v", id, err)
+			continue
+		}
+		status := jobstate.Status(job.Status)
+		if status != jobstate.Failed && !jobstate.IsTerminal(status) {
+			log.Printf("WARN: the claim on job %s lapsed; stopping its build", id)
+			cancel(ErrClaimLost)
+		}
+	}
+	return renewed
+}
+
+// RequeueExpiredClaims puts jobs whose claim has lapsed back on the queue,
+// cancels those that were being cancelled, and returns how many it moved.
+func (m *Manager) RequeueExpiredClaims(now time.Time) int {
+	moved, err := m.storage.RequeueExpiredClaims(now)
+	if err != nil {
+		log.Printf("ERROR: could not requeue expired job claims: %v", err)
+	}
+	if moved > 0 {
+		m.SignalNewJob()
+	}
+	return int(moved)
+}
+
+// claimLost reports whether the build was stopped because its claim lapsed.
+func (w *Worker) claimLost() bool {
+	return w.ctx != nil && errors.Is(context.Cause(w.ctx), ErrClaimLost)
+}
+
+// abandonLostClaim ends a build whose claim lapsed without touching the job,
+// which belongs to whichever instance claimed it next, or reporting a result.
+func (w *Worker) abandonLostClaim() error {
+	log.Printf("Abandoning job %s: its claim lapsed", w.job.ID)
+	w.log("Build stopped: this builder's claim on the job lapsed and the job was requeued")
+	return fmt.Errorf("%w: job %s", ErrClaimLost, w.job.ID)
+}
@@ -0,0 +1,111 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// LogFormat selects how build log lines are written.
+type LogFormat int
+
+const (
+	// LogText writes "[<RFC3339 time>] <message>" lines.
+	LogText LogFormat = iota
+	// LogJSON writes one JSON record per line with the fields timestamp,
+	// level, phase and message.
+	LogJSON
+)
+
+func (f LogFormat) String() string {
+	if f == LogJSON {
+		return "json"
+	}
+	return "text"
+}
+
+// ParseLogFormat reads "text" or "json"; empty is text.
+func ParseLogFormat(raw string) (LogFormat, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", "text":
+		return LogText, nil
+	case "json":
+		return LogJSON, nil
+	}
+	return LogText, fmt.Errorf("unknown log format %q: use text or json", raw)
+}
+
+// SetLogFormat sets the format workers write build logs in.
+func (m *Manager) SetLogFormat(format LogFormat) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logFormat = format
+}
+
+// Levels of JSON log records.
+const (
+	logLevelDebug = "debug"
+	logLevelInfo  = "info"
+	logLevelWarn  = "warn"
+	logLevelError = "error"
+)
+
+// logRecord is a build log line in the JSON format.
+type logRecord struct {
+	Timestamp string `json:"timestamp"`
+	Level     string `json:"level"`
+	Phase     string `json:"phase,omitempty"`
+	Message   string `json:"message"`
+}
+
+// logLevel infers the level of a log line from the ERROR: and WARNING:
+// prefixes the builder writes, which command output may use too.
+func logLevel(message string) string {
+	switch {
+	case strings.HasPrefix(message, "ERROR"):
+		return logLevelError
+	case strings.HasPrefix(message, "WARN"):
+		return logLevelWarn
+	}
+	return logLevelInfo
+}
+
+// writeLog writes message to the job log in the worker's format, tagged with
+// the phase the build is in, with the secret values set with
+// redactSecretsFromLogs masked.
+func (w *Worker) writeLog(level, message string) {
+	message = w.redactSecrets(message)
+	w.logMu.Lock()
+	defer w.logMu.Unlock()
+	w.formatLog(w.logWriter, level, message)
+}
+
+// formatLog writes message to out as one line in the worker's format. The
+// caller holds logMu; the job log's truncation marker is written from inside
+// writeLog.
+func (w *Worker) formatLog(out io.Writer, level, message string) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if w.logFormat != LogJSON {
+		fmt.Fprintf(out, "[%s] %s\n", now, message)
+		return
+	}
+	encoder := json.NewEncoder(out)
+	// Keep phase markers such as "==> Phase: build" readable.
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(logRecord{Timestamp: now, Level: level, Phase: w.logPhase, Message: message})
+}
+
+// formatTruncationMarker writes the marker a capped job log ends with as a
+// warning in the worker's format, so a JSON log stays one record per line.
+func (w *Worker) formatTruncationMarker(out io.Writer, message string) {
+	w.formatLog(out, logLevelWarn, message)
+}
+
+// setLogPhase attaches phase to the log records written from now on.
+func (w *Worker) setLogPhase(phase string) {
+	w.logMu.Lock()
+	defer w.logMu.Unlock()
+	w.logPhase = phase
+}
@@ -0,0 +1,112 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"hubfly-builder/internal/allowlist"
+	"hubfly-builder/internal/logs"
+)
+
+func TestJSONLogFormatWritesRecordsWithPhase(t *testing.T) {
+	worker := newTestWorker(t, nil)
+	worker.hooks = BuildHooks{PreBuild: []string{"echo hello-from-hook"}}
+	worker.allowlist = &allowlist.AllowedCommands{Hooks: []string{"echo hello-from-hook"}}
+	var buf bytes.Buffer
+	worker.logWriter = &buf
+	worker.logFormat = LogJSON
+	worker.logVerbosity = LogVerbose
+
+	if err := worker.runPhase(phasePrebuild, 0, worker.runPreBuildHooks); err != nil {
+		t.Fatalf("prebuild phase failed: %v", err)
+	}
+	err := worker.runPhase(phaseBuild, 0, func() error {
+		worker.log("WARNING: base image is not pinned")
+		worker.log("ERROR: image build failed")
+		return worker.executeCommand(exec.Command("echo", "hello-from-command"))
+	})
+	if err != nil {
+		t.Fatalf("build phase failed: %v", err)
+	}
+
+	records := map[string]logRecord{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record logRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("expected every line to be a JSON record, got %q: %v", line, err)
+		}
+		if record.Timestamp == "" || record.Level == "" {
+			t.Fatalf("expected timestamp and level on %q", line)
+		}
+		records[record.Message] = record
+	}
+	for message, want := range map[string]logRecord{
+		"==> Phase: prebuild":                {Level: logLevelInfo, Phase: phasePrebuild},
+		"hello-from-hook":                    {Level: logLevelInfo, Phase: phasePrebuild},
+		"WARNING: base image is not pinned":  {Level: logLevelWarn, Phase: phaseBuild},
+		"ERROR: image build failed":          {Level: logLevelError, Phase: phaseBuild},
+		"hello-from-command":                 {Level: logLevelInfo, Phase: phaseBuild},
+		"Executing: echo hello-from-command": {Level: logLevelDebug, Phase: phaseBuild},
+	} {
+		got, ok := records[message]
+		if !ok {
+			t.Fatalf("expected a record for %q in:\n%s", message, buf.String())
+		}
+		if got.Level != want.Level || got.Phase != want.Phase {
+			t.Errorf("%q: expected level %s in phase %s, got %+v", message, want.Level, want.Phase, got)
+		}
+	}
+
+	phases := logs.PhaseOffsets(buf.Bytes())
+	if len(phases) != 2 || phases[0].Name != phasePrebuild || phases[1].Name != phaseBuild {
+		t.Fatalf("expected the phase markers of the JSON log to be found, got %+v", phases)
+	}
+}
+
+func TestTextLogFormatIsTheDefault(t *testing.T) {
+	worker := newTestWorker(t, nil)
+	var buf bytes.Buffer
+	worker.logWriter = &buf
+
+	worker.log("plain line")
+	if got := buf.String(); !strings.HasPrefix(got, "[") || !strings.HasSuffix(got, "] plain line\n") {
+		t.Fatalf("expected a text log line, got %q", got)
+	}
+}
+
+func TestJSONLogFormatWritesTheTruncationMarkerAsARecord(t *testing.T) {
+	worker := newTestWorker(t, nil)
+	var buf bytes.Buffer
+	worker.logWriter = logs.NewLimitedWriter(&buf, 300, worker.formatTruncationMarker)
+	worker.logFormat = LogJSON
+	worker.setLogPhase(phaseBuild)
+
+	for i := 0; i < 20; i++ {
+		worker.log("runaway build output")
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var last logRecord
+	for _, line := range lines {
+		if err := json.Unmarshal([]byte(line), &last); err != nil {
+			t.Fatalf("expected every line to be a JSON record, got %q: %v", line, err)
+		}
+	}
+	if !strings.HasPrefix(last.Message, logs.TruncatedMarkerPrefix) || last.Level != logLevelWarn || last.Phase != phaseBuild {
+		t.Fatalf("expected the log to end with a truncation warning, got %+v", last)
+	}
+}
+
+func TestParseLogFormat(t *testing.T) {
+	for raw, want := range map[string]LogFormat{"": LogText, "text": LogText, " JSON ": LogJSON} {
+		if got, err := ParseLogFormat(raw); err != nil || got != want {
+			t.Errorf("ParseLogFormat(%q) = %s, %v; want %s", raw, got, err, want)
+		}
+	}
+	if _, err := ParseLogFormat("yaml"); err == nil {
+		t.Fatal("expected an unknown format to be rejected")
+	}
+}
@@ -0,0 +1,45 @@
+package executor
+
+import (
+	"sort"
+	"strings"
+)
+
+// redactedSecret replaces secret values in build logs.
+const redactedSecret = "***"
+
+// minRedactedSecretLength keeps values too short to be credentials, such as
+// "1" or "yes", from masking unrelated output.
+const minRedactedSecretLength = 4
+
+// redactSecretsFromLogs makes every line written to the job log mask the
+// values of secrets, keyed by variable name. It must be called before the
+// commands that could print them run.
+func (w *Worker) redactSecretsFromLogs(secrets map[string]string) {
+	values := make([]string, 0, len(secrets))
+	for _, value := range secrets {
+		if len(value) >= minRedactedSecretLength {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		w.secretRedactor = nil
+		return
+	}
+	// Longer values first, so a secret containing another is masked whole.
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	pairs := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		pairs = append(pairs, value, redactedSecret)
+	}
+	w.secretRedactor = strings.NewReplacer(pairs...)
+}
+
+// redactSecrets masks the secret values set with redactSecretsFromLogs in a
+// log line.
+func (w *Worker) redactSecrets(line string) string {
+	if w.secretRedactor == nil {
+		return line
+	}
+	return w.secretRedactor.Replace(line)
+}
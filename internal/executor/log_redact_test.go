@@ -0,0 +1,79 @@
+package executor
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"hubfly-builder/internal/jobstate"
+)
+
+func TestStreamedCommandOutputRedactsBuildSecrets(t *testing.T) {
+	worker := newTestWorker(t, nil)
+	var buf bytes.Buffer
+	worker.logWriter = &buf
+	worker.redactSecretsFromLogs(map[string]string{
+		"API_TOKEN":   "tok_9f8e7d6c5b4a",
+		"API_TOKEN_2": "tok_9f8e7d6c5b4a-extended",
+		"DEBUG":       "1",
+	})
+
+	script := "echo 'curl -H \"Authorization: Bearer tok_9f8e7d6c5b4a\" https://api.example.com'\n" +
+		"echo 'retrying with tok_9f8e7d6c5b4a-extended' >&2\n" +
+		"echo 'step 1 of 2'\n"
+	if err := worker.executeCommand(exec.Command("sh", "-c", script)); err != nil {
+		t.Fatalf("command failed: %v", err)
+	}
+
+	got := buf.String()
+	if strings.Contains(got, "tok_9f8e7d6c5b4a") || strings.Contains(got, "extended") {
+		t.Fatalf("expected every secret value to be masked, got:\n%s", got)
+	}
+	for _, want := range []string{
+		`Authorization: Bearer ***" https://api.example.com`,
+		"retrying with ***\n",
+		"step 1 of 2\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in the log, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestRedactSecretsWithoutSecretsKeepsLines(t *testing.T) {
+	worker := &Worker{}
+	worker.redactSecretsFromLogs(map[string]string{"FLAG": "on"})
+	if got := worker.redactSecrets("feature on"); got != "feature on" {
+		t.Fatalf("expected short values to be left alone, got %q", got)
+	}
+}
+
+func TestBuildMasksSecretsFromPreBuildHookOutputAndErrors(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	installFakeSudo(t)
+	hook := "echo using tok_9f8e7d6c5b4a; exit 3"
+	manager, store, job := newResumeTestManager(t, BuildHooks{PreBuild: []string{hook}}, []string{hook})
+	job.BuildConfig.Env = map[string]string{"API_TOKEN": "tok_9f8e7d6c5b4a"}
+	if err := store.UpdateJobBuildConfig(job.ID, &job.BuildConfig); err != nil {
+		t.Fatalf("failed to update build config: %v", err)
+	}
+
+	failed := runResumeTestJob(t, manager, store, job.ID, jobstate.Failed)
+	logData, err := os.ReadFile(failed.LogPath)
+	if err != nil {
+		t.Fatalf("failed to read job log: %v", err)
+	}
+	got := string(logData)
+	if strings.Contains(got, "tok_9f8e7d6c5b4a") {
+		t.Fatalf("expected the secret to be masked in every log line, got:\n%s", got)
+	}
+	for _, want := range []string{"using ***\n", `pre-build hook "echo using ***; exit 3" failed`} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in the log, got:\n%s", want, got)
+		}
+	}
+}
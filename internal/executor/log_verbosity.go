@@ -0,0 +1,77 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LogVerbosity controls how much of the worker's own bookkeeping goes into a
+// build log. Command output, phase banners, warnings and errors are always
+// written.
+type LogVerbosity int
+
+const (
+	// LogQuiet writes only command output, phase banners, warnings and
+	// errors.
+	LogQuiet LogVerbosity = -1
+	// LogNormal adds build progress such as the checked-out commit, the
+	// detected runtime and the image tag.
+	LogNormal LogVerbosity = 0
+	// LogVerbose adds every executed command, workspace paths, hashes and
+	// per-key env resolution, for debugging the builder itself.
+	LogVerbose LogVerbosity = 1
+)
+
+func (v LogVerbosity) String() string {
+	switch {
+	case v < LogNormal:
+		return "quiet"
+	case v > LogNormal:
+		return "verbose"
+	default:
+		return "normal"
+	}
+}
+
+// ParseLogVerbosity reads "quiet", "normal" or "verbose"; empty is normal.
+func ParseLogVerbosity(raw string) (LogVerbosity, error) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", "normal":
+		return LogNormal, nil
+	case "quiet":
+		return LogQuiet, nil
+	case "verbose":
+		return LogVerbose, nil
+	}
+	return LogNormal, fmt.Errorf("unknown log verbosity %q: use quiet, normal or verbose", raw)
+}
+
+// SetLogVerbosity sets how much bookkeeping workers write to build logs. Jobs
+// with buildConfig.debug always log verbosely.
+func (m *Manager) SetLogVerbosity(verbosity LogVerbosity) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logVerbosity = verbosity
+}
+
+func (w *Worker) verbosity() LogVerbosity {
+	if w.job != nil && w.job.BuildConfig.Debug {
+		return LogVerbose
+	}
+	return w.logVerbosity
+}
+
+// logInfo writes build progress, which quiet builds leave out.
+func (w *Worker) logInfo(format string, args ...interface{}) {
+	if w.verbosity() >= LogNormal {
+		w.log(format, args...)
+	}
+}
+
+// logDebug writes the worker's internal bookkeeping, which only verbose
+// builds keep.
+func (w *Worker) logDebug(format string, args ...interface{}) {
+	if w.verbosity() >= LogVerbose {
+		w.writeLog(logLevelDebug, fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,64 @@
+package executor
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"hubfly-builder/internal/allowlist"
+)
+
+func TestLogVerbosityControlsBookkeepingLines(t *testing.T) {
+	for _, tc := range []struct {
+		verbosity LogVerbosity
+		debug     bool
+		present   []string
+		absent    []string
+	}{
+		{LogQuiet, false, []string{"hello-from-hook", "hello-from-command", "==> Phase: build"}, []string{"Running pre-build hook", "Executing:"}},
+		{LogNormal, false, []string{"hello-from-hook", "Running pre-build hook"}, []string{"Executing:"}},
+		{LogVerbose, false, []string{"hello-from-hook", "Running pre-build hook", "Executing:"}, nil},
+		{LogQuiet, true, []string{"hello-from-hook", "Running pre-build hook", "Executing:"}, nil},
+	} {
+		worker := newTestWorker(t, nil)
+		worker.hooks = BuildHooks{PreBuild: []string{"echo hello-from-hook"}}
+		worker.allowlist = &allowlist.AllowedCommands{Hooks: []string{"echo hello-from-hook"}}
+		var buf bytes.Buffer
+		worker.logWriter = &buf
+		worker.logVerbosity = tc.verbosity
+		worker.job.BuildConfig.Debug = tc.debug
+
+		err := worker.runPhase(phaseBuild, 0, func() error {
+			if err := worker.runPreBuildHooks(); err != nil {
+				return err
+			}
+			return worker.executeCommand(exec.Command("echo", "hello-from-command"))
+		})
+		if err != nil {
+			t.Fatalf("%s: build phase failed: %v", tc.verbosity, err)
+		}
+		got := buf.String()
+		for _, line := range tc.present {
+			if !strings.Contains(got, line) {
+				t.Errorf("%s (debug=%t): expected %q in log:\n%s", tc.verbosity, tc.debug, line, got)
+			}
+		}
+		for _, line := range tc.absent {
+			if strings.Contains(got, line) {
+				t.Errorf("%s (debug=%t): expected %q to be suppressed:\n%s", tc.verbosity, tc.debug, line, got)
+			}
+		}
+	}
+}
+
+func TestParseLogVerbosity(t *testing.T) {
+	for raw, want := range map[string]LogVerbosity{"": LogNormal, "quiet": LogQuiet, " Verbose ": LogVerbose, "normal": LogNormal} {
+		if got, err := ParseLogVerbosity(raw); err != nil || got != want {
+			t.Errorf("ParseLogVerbosity(%q) = %s, %v; want %s", raw, got, err, want)
+		}
+	}
+	if _, err := ParseLogVerbosity("loud"); err == nil {
+		t.Fatal("expected an unknown verbosity to be rejected")
+	}
+}
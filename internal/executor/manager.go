@@ -1,32 +1,64 @@
 package executor
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"log"
-	"strings"
+	"sort"
 	"sync"
 	"time"
 
 	"hubfly-builder/internal/allowlist"
 	"hubfly-builder/internal/api"
+	"hubfly-builder/internal/events"
+	"hubfly-builder/internal/gitauth"
+	"hubfly-builder/internal/jobstate"
 	"hubfly-builder/internal/logs"
+	"hubfly-builder/internal/metrics"
 	"hubfly-builder/internal/storage"
 	"os"
 )
 
-const maxRetries = 0
-
 type Manager struct {
-	storage       *storage.Storage
-	logManager    *logs.LogManager
-	allowlist     *allowlist.AllowedCommands
-	apiClient     *api.Client
-	maxConcurrent int
-	lockfilePath  string
-	activeBuilds  map[string]bool
-	activeUsers   map[string]bool
-	mu            sync.Mutex
-	newJobSignal  chan struct{}
+	storage            *storage.Storage
+	logManager         *logs.LogManager
+	allowlist          *allowlist.AllowedCommands
+	apiClient          *api.Client
+	maxConcurrent      int
+	lockfilePath       string
+	hooks              BuildHooks
+	phases             PhaseTimeouts
+	affinity           BuildAffinity
+	lease              ClaimLease
+	dedupContexts      bool
+	pruneContexts      bool
+	scopeContexts      bool
+	debugTTL           time.Duration
+	resumeTTL          time.Duration
+	registryRetryDelay time.Duration
+	retry              RetryPolicy
+	buildOptAllowlist  []string
+	logVerbosity       LogVerbosity
+	maxBuildDuration   time.Duration
+	cacheRegistry      string
+	registryCreds      map[string]RegistryCredential
+	secretResolver     gitauth.SecretResolver
+	imageBuildSlots    chan struct{}
+	imageChecker       ImageChecker
+	selfTestRunning    bool
+	eventBus           *events.Bus
+	activeBuilds       map[string]context.CancelCauseFunc
+	progress           map[string]*buildProgress
+	dnsServers         []string
+	logFormat          LogFormat
+	queueWait          *metrics.Histogram
+	secretScanMode     SecretScanMode
+	secretAllowlist    []string
+	activeUsers        map[string]bool
+	mu                 sync.Mutex
+	dispatchMu         sync.Mutex
+	newJobSignal       chan struct{}
 }
 
 func NewManager(storage *storage.Storage, logManager *logs.LogManager, allowlist *allowlist.AllowedCommands, apiClient *api.Client, maxConcurrent int, lockfilePath string) *Manager {
@@ -37,14 +69,76 @@ func NewManager(storage *storage.Storage, logManager *logs.LogManager, allowlist
 		apiClient:     apiClient,
 		maxConcurrent: maxConcurrent,
 		lockfilePath:  lockfilePath,
-		activeBuilds:  make(map[string]bool),
+		activeBuilds:  make(map[string]context.CancelCauseFunc),
+		progress:      make(map[string]*buildProgress),
 		activeUsers:   make(map[string]bool),
 		newJobSignal:  make(chan struct{}, 1),
+		queueWait:     newQueueWaitHistogram(),
 	}
 	m.updateLockfile()
 	return m
 }
 
+func (m *Manager) SetBuildHooks(hooks BuildHooks) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hooks = hooks
+}
+
+func (m *Manager) SetPhaseTimeouts(phases PhaseTimeouts) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.phases = phases
+}
+
+// BuildAffinity makes the dispatcher prefer projects this instance built last,
+// for cache locality when several builders share one job database. Jobs pinned
+// to another instance are claimed anyway once they have waited MaxDefer.
+type BuildAffinity struct {
+	InstanceID string
+	MaxDefer   time.Duration
+}
+
+// overdueBefore is the creation time before which a job is dispatched
+// regardless of affinity, or the zero time when affinity is off.
+func (a BuildAffinity) overdueBefore() time.Time {
+	if a.InstanceID == "" {
+		return time.Time{}
+	}
+	return time.Now().Add(-a.MaxDefer)
+}
+
+func (m *Manager) SetBuildAffinity(affinity BuildAffinity) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.affinity = affinity
+}
+
+// SetContextDedup makes workers retag the image of an earlier successful job
+// whose build context and build env hash identically instead of rebuilding.
+func (m *Manager) SetContextDedup(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dedupContexts = enabled
+}
+
+// SetContextPrune makes workers delete files excluded by .dockerignore from
+// the build context before handing it to Hubcell.
+func (m *Manager) SetContextPrune(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pruneContexts = enabled
+}
+
+// SetWorkspaceContextScope makes workers trim the repository-root context of
+// generated-Dockerfile workspace subPath builds to the subPath and the
+// workspace packages it depends on.
+func (m *Manager) SetWorkspaceContextScope(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scopeContexts = enabled
+}
+
 func (m *Manager) SignalNewJob() {
 	select {
 	case m.newJobSignal <- struct{}{}:
@@ -67,105 +161,181 @@ func (m *Manager) Start() {
 	}
 }
 
+// tryToDispatchJob starts pending jobs until every concurrency slot is taken
+// or no job is eligible, so a backlog does not drain one job per wake-up.
 func (m *Manager) tryToDispatchJob() {
+	// Dispatches on this instance run one at a time so the capacity and
+	// one-build-per-user checks hold between choosing a job and reserving
+	// it. Other instances are kept off the job by the atomic claim.
+	m.dispatchMu.Lock()
+	defer m.dispatchMu.Unlock()
+
+	for m.dispatchNextJob() {
+	}
+}
+
+// dispatchNextJob claims and starts one pending job. It reports whether it
+// did, which is false once the slots are full or no job is eligible. The
+// caller holds dispatchMu.
+func (m *Manager) dispatchNextJob() bool {
 	m.mu.Lock()
 	if len(m.activeBuilds) >= m.maxConcurrent {
 		m.mu.Unlock()
-		return
-	}
-	excludeUserIDs := make([]string, 0, len(m.activeUsers))
-	for id := range m.activeUsers {
-		excludeUserIDs = append(excludeUserIDs, id)
+		return false
 	}
+	excludeUserIDs := m.activeUserIDsLocked()
+	affinity := m.affinity
+	lease := m.lease
 	m.mu.Unlock()
 
-	job, err := m.storage.GetPendingJobExcludingUsers(excludeUserIDs)
+	job, err := m.claimNextJob(excludeUserIDs, affinity, lease)
 	if err != nil {
-		return
-	}
-	if strings.TrimSpace(job.UserID) == "" {
-		log.Printf("ERROR: job %s missing userId; dropping", job.ID)
-		_ = m.storage.UpdateJobStatus(job.ID, "failed")
-		return
+		if !errors.Is(err, storage.ErrNoPendingJob) {
+			log.Printf("ERROR: could not claim a pending job: %v", err)
+		}
+		return false
 	}
 
+	ctx, cancel := context.WithCancelCause(context.Background())
 	m.mu.Lock()
-	m.activeBuilds[job.ID] = true
+	m.activeBuilds[job.ID] = cancel
 	m.activeUsers[job.UserID] = true
 	m.updateLockfileLocked()
 	m.mu.Unlock()
-
-	if err := m.storage.UpdateJobStatus(job.ID, "claimed"); err != nil {
-		log.Printf("ERROR: could not update job status for %s: %v", job.ID, err)
-		m.mu.Lock()
-		delete(m.activeBuilds, job.ID)
-		delete(m.activeUsers, job.UserID)
-		m.updateLockfileLocked()
-		m.mu.Unlock()
-		return
-	}
+	publishStatus(m.currentEventBus(), job, jobstate.Claimed)
 
 	worker := NewWorker(job, m.storage, m.logManager, m.allowlist, m.apiClient)
+	m.mu.Lock()
+	worker.hooks = m.hooks
+	worker.phases = m.phases
+	worker.dedupContexts = m.dedupContexts
+	worker.pruneContexts = m.pruneContexts
+	worker.scopeContexts = m.scopeContexts
+	worker.registryRetryDelay = m.registryRetryDelay
+	worker.buildOptAllowlist = m.buildOptAllowlist
+	worker.debugTTL = m.debugTTL
+	worker.resumeTTL = m.resumeTTL
+	worker.logVerbosity = m.logVerbosity
+	worker.logFormat = m.logFormat
+	worker.maxBuildDuration = m.maxBuildDuration
+	worker.cacheRegistry = m.cacheRegistry
+	worker.registryCredentials = m.registryCreds
+	worker.secretResolver = m.secretResolver
+	worker.imageBuildSlots = m.imageBuildSlots
+	worker.eventBus = m.eventBus
+	worker.dnsServers = m.dnsServers
+	worker.queueWait = m.queueWait
+	worker.secretScanMode = m.secretScanMode
+	worker.secretScanAllowlist = m.secretAllowlist
+	worker.retry = m.retry
+	worker.claimOwner = m.lease.Owner
+	worker.progress = &buildProgress{}
+	m.progress[job.ID] = worker.progress
+	m.mu.Unlock()
 	go func() {
 		defer func() {
+			cancel(nil)
 			m.mu.Lock()
 			delete(m.activeBuilds, job.ID)
+			delete(m.progress, job.ID)
 			delete(m.activeUsers, job.UserID)
 			m.updateLockfileLocked()
 			m.mu.Unlock()
 		}()
 
-		if err := worker.Run(); err != nil {
+		if err := worker.Run(ctx); err != nil {
 			log.Printf("Worker for job %s finished with error: %v", job.ID, err)
 			if errors.Is(err, ErrBuildFailed) {
-				m.handleFailedJob(job)
+				m.handleFailedJob(job, errors.Is(err, ErrTransientFailure))
+			}
+			return
+		}
+		if affinity.InstanceID != "" {
+			if err := m.storage.RecordProjectBuildInstance(job.ProjectID, affinity.InstanceID); err != nil {
+				log.Printf("WARN: could not record build affinity for project %s: %v", job.ProjectID, err)
 			}
 		}
 	}()
+	return true
 }
 
-func (m *Manager) handleFailedJob(job *storage.BuildJob) {
+func (m *Manager) claimNextJob(excludeUserIDs []string, affinity BuildAffinity, lease ClaimLease) (*storage.BuildJob, error) {
+	return m.storage.ClaimNextPendingJob(excludeUserIDs, affinity.InstanceID, affinity.overdueBefore(), lease.claim(time.Now()))
+}
 
-	// Refetch job to get latest retry count
+// QueuePosition returns the 1-based place of a pending job in this builder's
+// dispatch order, applying the same user exclusions and affinity as the
+// dispatcher. It returns 0 for a job the dispatcher would not claim now.
+func (m *Manager) QueuePosition(jobID string) (int, error) {
+	m.mu.Lock()
+	excludeUserIDs := m.activeUserIDsLocked()
+	affinity := m.affinity
+	m.mu.Unlock()
+	return m.storage.QueuePosition(jobID, excludeUserIDs, affinity.InstanceID, affinity.overdueBefore())
+}
 
-	latestJob, err := m.storage.GetJob(job.ID)
+// activeUserIDsLocked lists the users with a build running, whose jobs are
+// not dispatched until it finishes. The caller holds m.mu.
+func (m *Manager) activeUserIDsLocked() []string {
+	ids := make([]string, 0, len(m.activeUsers))
+	for id := range m.activeUsers {
+		ids = append(ids, id)
+	}
+	return ids
+}
 
-	if err != nil {
+// handleFailedJob puts a transiently failed job back on the queue while it
+// has retries left, to be picked up again once its backoff has elapsed. Any
+// other failure is final.
+func (m *Manager) handleFailedJob(job *storage.BuildJob, transient bool) {
+	m.mu.Lock()
+	policy := m.retry
+	m.mu.Unlock()
 
+	// Refetch job to get latest retry count
+	latestJob, err := m.storage.GetJob(job.ID)
+	if err != nil {
 		log.Printf("ERROR: could not get job %s for retry logic: %v", job.ID, err)
-
 		return
-
+	}
+	if !transient {
+		m.releaseGitCredential(latestJob.ID)
+		return
+	}
+	if latestJob.RetryCount >= policy.MaxRetries {
+		log.Printf("Job %s has reached max retries (%d)", latestJob.ID, policy.MaxRetries)
+		m.releaseGitCredential(latestJob.ID)
+		return
 	}
 
-	if latestJob.RetryCount < maxRetries {
-
-		log.Printf("Retrying job %s (attempt %d)", latestJob.ID, latestJob.RetryCount+1)
-
-		if err := m.storage.IncrementJobRetryCount(latestJob.ID); err != nil {
-
-			log.Printf("ERROR: could not increment retry count for job %s: %v", latestJob.ID, err)
-
-			return
-
-		}
-
-		if err := m.storage.UpdateJobStatus(latestJob.ID, "pending"); err != nil {
-
-			log.Printf("ERROR: could not reset job status to pending for retry: %v", err)
-
+	delay := policy.delay(latestJob.RetryCount)
+	log.Printf("Retrying job %s in %s (attempt %d of %d)", latestJob.ID, delay, latestJob.RetryCount+1, policy.MaxRetries)
+	if ok, err := m.storage.ScheduleJobRetry(latestJob.ID, time.Now().Add(delay)); err != nil {
+		log.Printf("ERROR: could not reset job status to pending for retry: %v", err)
+		// The worker left the result to the retry, so report the failure here.
+		if err := m.apiClient.ReportResult(latestJob, "failed", "build failed and could not be retried"); err != nil {
+			log.Printf("ERROR: could not report result to backend for job %s: %v", latestJob.ID, err)
 		}
-
-		m.SignalNewJob() // Signal to pick it up again
-
+	} else if !ok {
+		log.Printf("WARN: job %s is no longer failed; not retrying", latestJob.ID)
 	} else {
-
-		log.Printf("Job %s has reached max retries (%d)", latestJob.ID, maxRetries)
-
-		// The job status is already set to 'failed' by the worker.
-
+		publishStatus(m.currentEventBus(), latestJob, jobstate.Pending)
 	}
+}
 
+// releaseGitCredential drops the credential of a job that failed for good.
+// While resumes are enabled it is kept so a resumed build can clone again,
+// and ReapGitCredentials drops it once the resume TTL has passed.
+func (m *Manager) releaseGitCredential(jobID string) {
+	m.mu.Lock()
+	resumeTTL := m.resumeTTL
+	m.mu.Unlock()
+	if resumeTTL > 0 {
+		return
+	}
+	if err := m.storage.ClearJobGitCredential(jobID); err != nil {
+		log.Printf("WARN: could not clear git credential of job %s: %v", jobID, err)
+	}
 }
 
 func (m *Manager) GetActiveBuilds() []string {
@@ -185,6 +355,59 @@ func (m *Manager) GetActiveBuilds() []string {
 	return ids
 }
 
+// RunningBuild describes a job this manager is currently running.
+type RunningBuild struct {
+	ID        string     `json:"id"`
+	ProjectID string     `json:"projectId"`
+	UserID    string     `json:"userId"`
+	Status    string     `json:"status"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+}
+
+// GetRunningBuilds returns the active builds with their current job rows,
+// oldest first. Jobs that finish or disappear between the snapshot of active
+// builds and the storage read are left out.
+func (m *Manager) GetRunningBuilds() ([]RunningBuild, error) {
+	builds := []RunningBuild{}
+	for _, id := range m.GetActiveBuilds() {
+		job, err := m.storage.GetJob(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if jobstate.IsTerminal(jobstate.Status(job.Status)) {
+			continue
+		}
+		build := RunningBuild{
+			ID:        job.ID,
+			ProjectID: job.ProjectID,
+			UserID:    job.UserID,
+			Status:    job.Status,
+		}
+		if job.StartedAt.Valid {
+			startedAt := job.StartedAt.Time
+			build.StartedAt = &startedAt
+		}
+		builds = append(builds, build)
+	}
+	sort.SliceStable(builds, func(i, j int) bool {
+		left, right := builds[i].StartedAt, builds[j].StartedAt
+		switch {
+		case left != nil && right != nil && !left.Equal(*right):
+			return left.Before(*right)
+		case left == nil && right != nil:
+			// Claimed jobs that have not started yet come last.
+			return false
+		case left != nil && right == nil:
+			return true
+		}
+		return builds[i].ID < builds[j].ID
+	})
+	return builds, nil
+}
+
 func (m *Manager) updateLockfileLocked() {
 	if m.lockfilePath == "" {
 		return
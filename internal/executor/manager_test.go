@@ -0,0 +1,334 @@
+package executor
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"hubfly-builder/internal/api"
+	"hubfly-builder/internal/events"
+	"hubfly-builder/internal/jobstate"
+	"hubfly-builder/internal/logs"
+	"hubfly-builder/internal/storage"
+)
+
+func TestClaimNextJobHonoursBuildAffinity(t *testing.T) {
+	store, err := storage.NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	for _, job := range []*storage.BuildJob{
+		{ID: "build_remote", ProjectID: "proj_remote", UserID: "user_1"},
+		{ID: "build_local", ProjectID: "proj_local", UserID: "user_2"},
+		{ID: "build_new", ProjectID: "proj_new", UserID: "user_3"},
+	} {
+		if err := store.CreateJob(job); err != nil {
+			t.Fatalf("failed to create job %s: %v", job.ID, err)
+		}
+	}
+	if err := store.RecordProjectBuildInstance("proj_remote", "builder-b"); err != nil {
+		t.Fatalf("failed to record affinity: %v", err)
+	}
+	if err := store.RecordProjectBuildInstance("proj_local", "builder-a"); err != nil {
+		t.Fatalf("failed to record affinity: %v", err)
+	}
+	manager := &Manager{storage: store}
+	local := BuildAffinity{InstanceID: "builder-a", MaxDefer: time.Hour}
+
+	job, err := manager.claimNextJob(nil, local, ClaimLease{})
+	if err != nil || job.ID != "build_local" || job.Status != string(jobstate.Claimed) {
+		t.Fatalf("expected locally built project to be claimed first, got %v (err=%v)", job, err)
+	}
+
+	job, err = manager.claimNextJob([]string{"user_3"}, local, ClaimLease{})
+	if !errors.Is(err, storage.ErrNoPendingJob) {
+		t.Fatalf("expected the job pinned elsewhere to be deferred, got %v (err=%v)", job, err)
+	}
+
+	job, err = manager.claimNextJob(nil, BuildAffinity{InstanceID: "builder-a", MaxDefer: -time.Hour}, ClaimLease{})
+	if err != nil || job.ID != "build_remote" {
+		t.Fatalf("expected overdue job pinned elsewhere to fall back to this instance, got %v (err=%v)", job, err)
+	}
+
+	job, err = manager.claimNextJob(nil, BuildAffinity{}, ClaimLease{})
+	if err != nil || job.ID != "build_new" {
+		t.Fatalf("expected FIFO dispatch without affinity, got %v (err=%v)", job, err)
+	}
+}
+
+func TestClaimLeasesAreRenewedWhileBuildsRun(t *testing.T) {
+	store, err := storage.NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	for _, id := range []string{"build_running", "build_abandoned"} {
+		if err := store.CreateJob(&storage.BuildJob{ID: id, ProjectID: "proj", UserID: id}); err != nil {
+			t.Fatalf("failed to create job %s: %v", id, err)
+		}
+	}
+	manager := NewManager(store, nil, nil, nil, 4, "")
+	manager.SetClaimLease(ClaimLease{Owner: "builder-a", Duration: time.Minute})
+	now := time.Now()
+	for range 2 {
+		if _, err := manager.claimNextJob(nil, BuildAffinity{}, manager.lease); err != nil {
+			t.Fatalf("failed to claim job: %v", err)
+		}
+	}
+	// build_abandoned stands in for a job claimed by an instance that stopped.
+	manager.activeBuilds["build_running"] = func(error) {}
+
+	if renewed := manager.RenewClaimLeases(now.Add(time.Minute)); renewed != 1 {
+		t.Fatalf("expected the running build's lease to be renewed, renewed %d", renewed)
+	}
+	if moved := manager.RequeueExpiredClaims(now.Add(90 * time.Second)); moved != 1 {
+		t.Fatalf("expected the abandoned job to be requeued, moved %d", moved)
+	}
+	if job, err := store.GetJob("build_abandoned"); err != nil || job.Status != string(jobstate.Pending) {
+		t.Fatalf("expected the abandoned job to be pending again, got %+v (err %v)", job, err)
+	}
+	if job, err := store.GetJob("build_running"); err != nil || job.Status != string(jobstate.Claimed) {
+		t.Fatalf("expected the running job to stay claimed, got %+v (err %v)", job, err)
+	}
+}
+
+func TestRenewClaimLeasesStopsBuildsWhoseClaimLapsed(t *testing.T) {
+	store, err := storage.NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := store.CreateJob(&storage.BuildJob{ID: "build_lapsed", ProjectID: "proj", UserID: "user"}); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	manager := NewManager(store, nil, nil, nil, 4, "")
+	manager.SetClaimLease(ClaimLease{Owner: "builder-a", Duration: time.Minute})
+	now := time.Now()
+	if _, err := manager.claimNextJob(nil, BuildAffinity{}, manager.lease); err != nil {
+		t.Fatalf("failed to claim job: %v", err)
+	}
+	var cause error
+	manager.activeBuilds["build_lapsed"] = func(err error) { cause = err }
+	// Another instance requeues the job after this one missed its renewals.
+	if moved, err := store.RequeueExpiredClaims(now.Add(2 * time.Minute)); err != nil || moved != 1 {
+		t.Fatalf("expected the job to be requeued, moved %d (err %v)", moved, err)
+	}
+
+	if renewed := manager.RenewClaimLeases(now.Add(2 * time.Minute)); renewed != 0 {
+		t.Fatalf("expected no lease to be renewed, renewed %d", renewed)
+	}
+	if !errors.Is(cause, ErrClaimLost) {
+		t.Fatalf("expected the build to be stopped with ErrClaimLost, got %v", cause)
+	}
+}
+
+func TestConcurrentDispatchRunsOneWorkerPerJob(t *testing.T) {
+	store, err := storage.NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	logManager, err := logs.NewLogManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	// Without a network the worker fails right after claiming the job, so the
+	// test never reaches git or Hubcell.
+	job := &storage.BuildJob{ID: "build_race", ProjectID: "proj", UserID: "user"}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	manager := NewManager(store, logManager, nil, api.NewClient(""), 8, "")
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			manager.tryToDispatchJob()
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		manager.mu.Lock()
+		active := len(manager.activeBuilds) + len(manager.activeUsers)
+		manager.mu.Unlock()
+		if active == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the worker to finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	log.SetOutput(os.Stderr)
+	if runs := strings.Count(logged.String(), "Starting build for job build_race"); runs != 1 {
+		t.Fatalf("expected exactly one worker to run the job, got %d:\n%s", runs, logged.String())
+	}
+	stored, err := store.GetJob(job.ID)
+	if err != nil || stored.Status != "failed" {
+		t.Fatalf("expected the job to fail for its missing network, got %+v (err %v)", stored, err)
+	}
+}
+
+func TestGetRunningBuildsOmitsFinishedJobs(t *testing.T) {
+	store := newDedupTestStorage(t)
+	steps := map[string][]jobstate.Event{
+		"build_running":  {jobstate.Claim, jobstate.Start},
+		"build_claimed":  {jobstate.Claim},
+		"build_finished": {jobstate.Claim, jobstate.Start, jobstate.Succeed},
+	}
+	for id, events := range steps {
+		if err := store.CreateJob(&storage.BuildJob{ID: id, ProjectID: "proj", UserID: "user"}); err != nil {
+			t.Fatalf("failed to create job: %v", err)
+		}
+		current := jobstate.Pending
+		for _, event := range events {
+			if ok, err := store.TransitionJob(id, current, event); err != nil || !ok {
+				t.Fatalf("failed to apply %s to %s: %v", event, id, err)
+			}
+			current, _ = jobstate.Transition(current, event)
+		}
+	}
+
+	manager := NewManager(store, nil, nil, nil, 4, "")
+	for _, id := range []string{"build_running", "build_claimed", "build_finished", "build_missing"} {
+		manager.activeBuilds[id] = func(error) {}
+	}
+
+	builds, err := manager.GetRunningBuilds()
+	if err != nil {
+		t.Fatalf("failed to list running builds: %v", err)
+	}
+	if len(builds) != 2 {
+		t.Fatalf("expected the running and claimed jobs only, got %+v", builds)
+	}
+	if builds[0].ID != "build_running" || builds[0].Status != string(jobstate.Building) || builds[0].StartedAt == nil {
+		t.Fatalf("expected the started build first with its start time, got %+v", builds[0])
+	}
+	if builds[1].ID != "build_claimed" || builds[1].Status != string(jobstate.Claimed) || builds[1].StartedAt != nil {
+		t.Fatalf("expected the claimed build without a start time, got %+v", builds[1])
+	}
+}
+
+func TestDispatchPublishesJobStatusEvents(t *testing.T) {
+	store := newDedupTestStorage(t)
+	logManager, err := logs.NewLogManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	// Without a network the worker fails right after starting the build.
+	job := &storage.BuildJob{ID: "build_events", ProjectID: "proj", UserID: "user"}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	bus := events.NewBus()
+	sub := bus.Subscribe(events.Filter{ProjectID: "proj"}, 16)
+	defer sub.Close()
+	manager := NewManager(store, logManager, nil, api.NewClient(""), 1, "")
+	manager.SetEventBus(bus)
+
+	manager.tryToDispatchJob()
+
+	var statuses []string
+	timeout := time.After(10 * time.Second)
+	for len(statuses) < 3 {
+		select {
+		case event := <-sub.Events():
+			if event.Type != events.JobStatus || event.JobID != job.ID || event.UserID != "user" {
+				t.Fatalf("unexpected event %+v", event)
+			}
+			statuses = append(statuses, event.Status)
+		case <-timeout:
+			t.Fatalf("timed out waiting for status events, got %v", statuses)
+		}
+	}
+	if got := strings.Join(statuses, ","); got != "claimed,building,failed" {
+		t.Fatalf("expected claimed, building and failed events, got %s", got)
+	}
+}
+
+func TestDispatchFailsJobsWithoutAUser(t *testing.T) {
+	store := newDedupTestStorage(t)
+	logManager, err := logs.NewLogManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	job := &storage.BuildJob{ID: "build_no_user", ProjectID: "proj", UserID: " ", BuildConfig: storage.BuildConfig{Network: "proj-network"}}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	manager := NewManager(store, logManager, nil, api.NewClient(""), 1, "")
+
+	runResumeTestJob(t, manager, store, job.ID, jobstate.Failed)
+}
+
+func TestDispatchFillsEveryFreeSlotInOneCycle(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	installHangingSudo(t)
+	repo := writeGitRepository(t, map[string]string{"Dockerfile": "FROM alpine\nCMD [\"true\"]\n"})
+	store := newDedupTestStorage(t)
+	logManager, err := logs.NewLogManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	for _, job := range []*storage.BuildJob{
+		{ID: "build_1", UserID: "user_1"},
+		{ID: "build_2", UserID: "user_1"},
+		{ID: "build_3", UserID: "user_2"},
+		{ID: "build_4", UserID: "user_3"},
+		{ID: "build_5", UserID: "user_4"},
+		{ID: "build_6", UserID: "user_5"},
+	} {
+		job.ProjectID = "proj_" + job.ID
+		job.SourceInfo = storage.SourceInfo{GitRepository: repo}
+		job.BuildConfig = storage.BuildConfig{Network: "proj-network"}
+		if err := store.CreateJob(job); err != nil {
+			t.Fatalf("failed to create job %s: %v", job.ID, err)
+		}
+	}
+	manager := NewManager(store, logManager, nil, api.NewClient(""), 4, "")
+	defer func() {
+		for _, id := range manager.GetActiveBuilds() {
+			manager.CancelJob(id)
+		}
+		waitFor(t, "the workers to stop", func() bool {
+			return len(manager.GetActiveBuilds()) == 0
+		})
+	}()
+
+	manager.tryToDispatchJob()
+
+	active := map[string]bool{}
+	for _, id := range manager.GetActiveBuilds() {
+		active[id] = true
+	}
+	// build_2 waits for the other build of user_1 to finish.
+	for _, id := range []string{"build_1", "build_3", "build_4", "build_5"} {
+		if !active[id] {
+			t.Fatalf("expected one dispatch to fill all 4 slots in queue order, got %v", manager.GetActiveBuilds())
+		}
+	}
+	if len(active) != 4 {
+		t.Fatalf("expected 4 active builds, got %v", manager.GetActiveBuilds())
+	}
+	for _, id := range []string{"build_2", "build_6"} {
+		stored, err := store.GetJob(id)
+		if err != nil || stored.Status != string(jobstate.Pending) {
+			t.Fatalf("expected %s to stay pending, got %+v (err %v)", id, stored, err)
+		}
+	}
+}
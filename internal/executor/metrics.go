@@ -0,0 +1,34 @@
+package executor
+
+import (
+	"io"
+
+	"hubfly-builder/internal/metrics"
+)
+
+// queueWaitBuckets are the upper bounds, in seconds, of the queue wait
+// histogram: from jobs started right away to jobs that waited an hour.
+var queueWaitBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600}
+
+func newQueueWaitHistogram() *metrics.Histogram {
+	return metrics.NewHistogram("hubfly_queue_wait_seconds",
+		"Time jobs waited between being created and a worker starting them.", queueWaitBuckets)
+}
+
+// WriteMetrics writes this manager's metrics in the Prometheus text format.
+func (m *Manager) WriteMetrics(w io.Writer) error {
+	return m.queueWait.WriteText(w)
+}
+
+// observeQueueWait records how long the job waited before this worker
+// started it. A retried job counts from its original creation.
+func (w *Worker) observeQueueWait() {
+	if w.job.CreatedAt.IsZero() || !w.job.StartedAt.Valid {
+		return
+	}
+	wait := w.job.StartedAt.Time.Sub(w.job.CreatedAt).Seconds()
+	if wait < 0 {
+		wait = 0
+	}
+	w.queueWait.Observe(wait)
+}
@@ -0,0 +1,60 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"hubfly-builder/internal/api"
+	"hubfly-builder/internal/logs"
+	"hubfly-builder/internal/storage"
+)
+
+func TestWorkerStartObservesQueueWait(t *testing.T) {
+	store := newDedupTestStorage(t)
+	logManager, err := logs.NewLogManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	// Without a network the worker fails right after starting the build.
+	job := &storage.BuildJob{ID: "build_queued", ProjectID: "proj", UserID: "user"}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	job.CreatedAt = time.Now().Add(-90 * time.Second)
+	manager := NewManager(store, logManager, nil, api.NewClient(""), 1, "")
+	worker := NewWorker(job, store, logManager, nil, api.NewClient(""))
+	worker.queueWait = manager.queueWait
+
+	worker.Run(context.Background())
+
+	var buf bytes.Buffer
+	if err := manager.WriteMetrics(&buf); err != nil {
+		t.Fatalf("failed to write metrics: %v", err)
+	}
+	text := buf.String()
+	for _, want := range []string{
+		"# TYPE hubfly_queue_wait_seconds histogram\n",
+		`hubfly_queue_wait_seconds_bucket{le="60"} 0` + "\n",
+		`hubfly_queue_wait_seconds_bucket{le="120"} 1` + "\n",
+		"hubfly_queue_wait_seconds_count 1\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in the metrics:\n%s", want, text)
+		}
+	}
+	var sum float64
+	for _, line := range strings.Split(text, "\n") {
+		if value, ok := strings.CutPrefix(line, "hubfly_queue_wait_seconds_sum "); ok {
+			if _, err := fmt.Sscan(value, &sum); err != nil {
+				t.Fatalf("failed to parse %q: %v", line, err)
+			}
+		}
+	}
+	if sum < 90 || sum > 100 {
+		t.Fatalf("expected a queue wait of about 90 seconds, got %v", sum)
+	}
+}
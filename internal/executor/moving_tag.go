@@ -0,0 +1,83 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const defaultMovingTag = "latest"
+
+var imageTagNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// movingImageTag returns the project-level moving tag (e.g.
+// hubcell.local/user/project:latest) to apply next to imageTag, or "" when
+// neither buildConfig.movingTag nor MOVING_IMAGE_TAG asks for one.
+func (w *Worker) movingImageTag(imageTag string) (string, error) {
+	name := strings.TrimSpace(os.Getenv("MOVING_IMAGE_TAG"))
+	if options := w.job.BuildConfig.MovingTag; options != nil {
+		name = strings.TrimSpace(options.Name)
+		if name == "" {
+			name = defaultMovingTag
+		}
+	}
+	if name == "" {
+		return "", nil
+	}
+	if !imageTagNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid movingTag name %q: tags may contain letters, digits, '_', '.' and '-' and must not start with '.' or '-'", name)
+	}
+
+	return imageRepository(imageTag) + ":" + name, nil
+}
+
+// refImageTag returns the tag naming the job's branch or tag next to imageTag,
+// e.g. hubcell.local/user/project:feature-x for the ref feature/x, or "" when
+// buildConfig.refTag is unset or the job has no ref.
+func (w *Worker) refImageTag(imageTag string) string {
+	if !w.job.BuildConfig.RefTag {
+		return ""
+	}
+	ref := strings.TrimSpace(w.job.SourceInfo.Ref)
+	for _, prefix := range []string{"refs/heads/", "refs/tags/"} {
+		ref = strings.TrimPrefix(ref, prefix)
+	}
+	name := sanitizeImageTagComponent(ref)
+	if len(name) > 128 {
+		name = strings.TrimRight(name[:128], ".-")
+	}
+	if name == "" {
+		return ""
+	}
+	return imageRepository(imageTag) + ":" + name
+}
+
+// imageRepository strips the tag from imageTag.
+func imageRepository(imageTag string) string {
+	if index := strings.LastIndex(imageTag, ":"); index > strings.LastIndex(imageTag, "/") {
+		return imageTag[:index]
+	}
+	return imageTag
+}
+
+// extraImageTags resolves the moving and ref tags and logs them, failing the
+// job on an invalid moving tag name before anything is built.
+func (w *Worker) extraImageTags(imageTag string) ([]string, error) {
+	movingTag, err := w.movingImageTag(imageTag)
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	if movingTag != "" {
+		w.logInfo("Moving tag: %s", movingTag)
+		tags = append(tags, movingTag)
+	}
+	if refTag := w.refImageTag(imageTag); refTag != "" && refTag != movingTag && refTag != imageTag {
+		w.logInfo("Ref tag: %s", refTag)
+		tags = append(tags, refTag)
+	} else if w.job.BuildConfig.RefTag && refTag == "" {
+		w.log("WARNING: buildConfig.refTag is set but the job has no branch or tag ref; skipping the ref tag")
+	}
+	return tags, nil
+}
@@ -0,0 +1,164 @@
+package executor
+
+import (
+	"strings"
+	"testing"
+
+	"hubfly-builder/internal/storage"
+)
+
+func TestMovingImageTagResolution(t *testing.T) {
+	const imageTag = "hubcell.local/user/project:abc123-b-build-1-v20260101T000000Z"
+	tests := []struct {
+		name   string
+		env    string
+		config storage.BuildConfig
+		want   string
+	}{
+		{name: "disabled by default"},
+		{name: "job default name", config: storage.BuildConfig{MovingTag: &storage.MovingTag{}}, want: "hubcell.local/user/project:latest"},
+		{name: "job custom name", config: storage.BuildConfig{MovingTag: &storage.MovingTag{Name: "stable"}}, want: "hubcell.local/user/project:stable"},
+		{name: "builder-wide name", env: "edge", want: "hubcell.local/user/project:edge"},
+		{name: "job overrides builder", env: "edge", config: storage.BuildConfig{MovingTag: &storage.MovingTag{Name: "stable"}}, want: "hubcell.local/user/project:stable"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("MOVING_IMAGE_TAG", test.env)
+			got, err := newTestWorker(t, &storage.BuildJob{ID: "build_moving", BuildConfig: test.config}).movingImageTag(imageTag)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Fatalf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestMovingImageTagRejectsInvalidName(t *testing.T) {
+	t.Setenv("MOVING_IMAGE_TAG", "")
+	worker := newTestWorker(t, &storage.BuildJob{ID: "build_moving", BuildConfig: storage.BuildConfig{MovingTag: &storage.MovingTag{Name: "-bad:tag"}}})
+	if _, err := worker.movingImageTag("hubcell.local/user/project:abc"); err == nil {
+		t.Fatal("expected an invalid tag name to be rejected")
+	}
+}
+
+func TestBuildProducesImmutableAndMovingTags(t *testing.T) {
+	calls := installFakeSudo(t)
+	t.Setenv("MOVING_IMAGE_TAG", "")
+	store := newDedupTestStorage(t)
+
+	worker := newDedupTestWorker(t, store, "build_moving")
+	worker.dedupContexts = false
+	worker.job.BuildConfig.MovingTag = &storage.MovingTag{}
+	opts := dedupBuildOpts(worker)
+	extraTags, err := worker.extraImageTags(opts.ImageTag)
+	if err != nil {
+		t.Fatalf("extraImageTags returned error: %v", err)
+	}
+	opts.ExtraTags = extraTags
+	if err := worker.buildOrReuseImage(opts); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	got := readSudoCalls(t, calls)
+	for _, want := range []string{"-t hubcell.local/user/proj_build_moving:build_moving", "-t hubcell.local/user/proj_build_moving:latest"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in build command, got %q", want, got)
+		}
+	}
+}
+
+func TestRetaggedBuildAlsoMovesTag(t *testing.T) {
+	calls := installFakeSudo(t)
+	store := newDedupTestStorage(t)
+
+	first := newDedupTestWorker(t, store, "build_first")
+	firstTag := dedupBuildOpts(first).ImageTag
+	if err := store.UpdateJobImageTag(first.job.ID, firstTag); err != nil {
+		t.Fatalf("failed to update image tag: %v", err)
+	}
+	if err := first.buildOrReuseImage(dedupBuildOpts(first)); err != nil {
+		t.Fatalf("first build failed: %v", err)
+	}
+	if err := store.UpdateJobStatus(first.job.ID, "success"); err != nil {
+		t.Fatalf("failed to update status: %v", err)
+	}
+
+	second := newDedupTestWorker(t, store, "build_second")
+	opts := dedupBuildOpts(second)
+	opts.ExtraTags = []string{"hubcell.local/user/proj_build_second:latest"}
+	if err := second.buildOrReuseImage(opts); err != nil {
+		t.Fatalf("second build failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(readSudoCalls(t, calls)), "\n")
+	want := []string{
+		"hubcell tag " + firstTag + " " + opts.ImageTag,
+		"hubcell tag " + firstTag + " hubcell.local/user/proj_build_second:latest",
+	}
+	if len(lines) != 3 || lines[1] != want[0] || lines[2] != want[1] {
+		t.Fatalf("expected a build then tags %q, got %q", want, lines)
+	}
+}
+
+func TestRefImageTagSanitizesRef(t *testing.T) {
+	const imageTag = "hubcell.local/user/project:abc123-b-build-1-v20260101T000000Z"
+	tests := map[string]string{
+		"feature/x":           "hubcell.local/user/project:feature-x",
+		"refs/heads/main":     "hubcell.local/user/project:main",
+		"refs/tags/v1.2.0":    "hubcell.local/user/project:v1.2.0",
+		"Release/2026 Spring": "hubcell.local/user/project:release-2026-spring",
+		"":                    "",
+	}
+	for ref, want := range tests {
+		worker := newTestWorker(t, &storage.BuildJob{ID: "build_moving", BuildConfig: storage.BuildConfig{RefTag: true}})
+		worker.job.SourceInfo.Ref = ref
+		if got := worker.refImageTag(imageTag); got != want {
+			t.Errorf("ref %q: expected %q, got %q", ref, want, got)
+		}
+	}
+
+	worker := newTestWorker(t, &storage.BuildJob{ID: "build_moving", BuildConfig: storage.BuildConfig{}})
+	worker.job.SourceInfo.Ref = "main"
+	if got := worker.refImageTag(imageTag); got != "" {
+		t.Fatalf("expected no ref tag without buildConfig.refTag, got %q", got)
+	}
+}
+
+func TestBuildProducesCommitRefAndMovingTags(t *testing.T) {
+	calls := installFakeSudo(t)
+	t.Setenv("MOVING_IMAGE_TAG", "")
+	t.Setenv("IMAGE_TAG_TEMPLATE", "")
+	store := newDedupTestStorage(t)
+
+	worker := newDedupTestWorker(t, store, "build_ref")
+	worker.dedupContexts = false
+	worker.job.SourceInfo.Ref = "feature/x"
+	worker.job.SourceInfo.CommitSha = "0123456789abcdef"
+	worker.job.BuildConfig.RefTag = true
+	worker.job.BuildConfig.MovingTag = &storage.MovingTag{}
+	imageTag, err := worker.generateImageTag()
+	if err != nil {
+		t.Fatalf("generateImageTag returned error: %v", err)
+	}
+	opts := dedupBuildOpts(worker)
+	opts.ImageTag = imageTag
+	if opts.ExtraTags, err = worker.extraImageTags(imageTag); err != nil {
+		t.Fatalf("extraImageTags returned error: %v", err)
+	}
+	if err := worker.buildOrReuseImage(opts); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+
+	got := readSudoCalls(t, calls)
+	for _, want := range []string{
+		"-t hubcell.local/user/proj-build-ref:0123456789ab-bbuild_ref-v",
+		"-t hubcell.local/user/proj-build-ref:latest",
+		"-t hubcell.local/user/proj-build-ref:feature-x",
+	} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in build command, got %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"hubfly-builder/internal/logs"
+)
+
+const (
+	phaseClone    = "clone"
+	phasePrebuild = "prebuild"
+	phaseBuild    = "build"
+)
+
+// PhaseTimeouts are optional per-phase budgets enforced inside the overall
+// build timeout. A zero value leaves the phase bounded only by the job timeout.
+type PhaseTimeouts struct {
+	Clone    time.Duration
+	Prebuild time.Duration
+	Build    time.Duration
+}
+
+type phaseTimeoutError struct {
+	phase  string
+	budget time.Duration
+	err    error
+}
+
+func (e *phaseTimeoutError) Error() string {
+	return fmt.Sprintf("%s phase exceeded its %s budget: %v", e.phase, e.budget, e.err)
+}
+
+func (e *phaseTimeoutError) Unwrap() error {
+	return e.err
+}
+
+// Code is the failure code reported for the phase, e.g. "prebuild_timeout".
+func (e *phaseTimeoutError) Code() string {
+	return e.phase + "_timeout"
+}
+
+// runPhase writes a phase marker to the job log and narrows w.ctx to the phase
+// budget while fn runs, so every command started through execCommand is killed
+// once the budget is spent.
+func (w *Worker) runPhase(phase string, budget time.Duration, fn func() error) error {
+	w.setLogPhase(phase)
+	w.log("%s%s", logs.PhaseMarkerPrefix, phase)
+	w.publishPhase(phase)
+	w.enterProgressStage(phase)
+	w.reportProgress(phase, "started the "+phase+" phase")
+	if budget <= 0 {
+		return fn()
+	}
+	parent := w.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, budget)
+	w.ctx = ctx
+	defer func() {
+		cancel()
+		w.ctx = parent
+	}()
+
+	err := fn()
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && parent.Err() == nil {
+		w.log("ERROR: %s phase exceeded its %s budget", phase, budget)
+		return &phaseTimeoutError{phase: phase, budget: budget, err: err}
+	}
+	return err
+}
+
+func (w *Worker) stepFailureReason(err error, reason string) string {
+	var phaseErr *phaseTimeoutError
+	if errors.As(err, &phaseErr) {
+		return fmt.Sprintf("%s: %s phase exceeded its %d second budget", phaseErr.Code(), phaseErr.phase, int(phaseErr.budget/time.Second))
+	}
+	var platformErr *unsupportedPlatformError
+	if errors.As(err, &platformErr) {
+		return platformErr.Error()
+	}
+	var rateLimitErr *registryRateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return fmt.Sprintf("%s: an image registry rate limited the build; retry later or authenticate pulls", rateLimitErr.Code())
+	}
+	if w.isTimeoutError(err) {
+		timeoutSeconds := int(w.buildTimeout() / time.Second)
+		return fmt.Sprintf("build timed out after %ds", timeoutSeconds)
+	}
+	return reason
+}
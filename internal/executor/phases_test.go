@@ -0,0 +1,162 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"hubfly-builder/internal/allowlist"
+	"hubfly-builder/internal/api"
+	"hubfly-builder/internal/driver"
+	"hubfly-builder/internal/jobstate"
+	"hubfly-builder/internal/logs"
+	"hubfly-builder/internal/storage"
+)
+
+const phaseTestBudget = 100 * time.Millisecond
+
+func assertPhaseTimeout(t *testing.T, worker *Worker, err error, code string, started time.Time) {
+	t.Helper()
+	if err == nil {
+		t.Fatal("expected phase to fail")
+	}
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Fatalf("expected phase budget to stop the command early, took %s", elapsed)
+	}
+	reason := worker.stepFailureReason(err, "generic failure")
+	if !strings.HasPrefix(reason, code+": ") {
+		t.Fatalf("expected failure reason to start with %q, got %q", code, reason)
+	}
+}
+
+func TestClonePhaseTimeoutReportsCloneTimeout(t *testing.T) {
+	installFakeBinary(t, "git", "exec sleep 5\n")
+	worker := newCloneTestWorker(t)
+	worker.phases = PhaseTimeouts{Clone: phaseTestBudget}
+
+	started := time.Now()
+	err := worker.runPhase(phaseClone, worker.phases.Clone, worker.cloneRepository)
+	assertPhaseTimeout(t, worker, err, "clone_timeout", started)
+}
+
+func TestPrebuildPhaseTimeoutReportsPrebuildTimeout(t *testing.T) {
+	worker := newTestWorker(t, nil)
+	worker.hooks = BuildHooks{PreBuild: []string{"exec sleep 5"}}
+	worker.allowlist = &allowlist.AllowedCommands{Hooks: []string{"exec sleep *"}}
+	worker.phases = PhaseTimeouts{Prebuild: phaseTestBudget}
+
+	started := time.Now()
+	err := worker.runPhase(phasePrebuild, worker.phases.Prebuild, worker.runPreBuildHooks)
+	assertPhaseTimeout(t, worker, err, "prebuild_timeout", started)
+}
+
+func TestBuildPhaseTimeoutReportsBuildTimeout(t *testing.T) {
+	binDir := installFakeBinary(t, "pack", "exec sleep 5\n")
+	worker := newTestWorker(t, nil)
+	worker.phases = PhaseTimeouts{Build: phaseTestBudget}
+
+	started := time.Now()
+	err := worker.buildImageWithPack(driver.PackBuildOpts{
+		PackPath:    filepath.Join(binDir, "pack"),
+		ContextPath: worker.workDir,
+		ImageTag:    "hubcell.local/user/project:tag",
+	})
+	assertPhaseTimeout(t, worker, err, "build_timeout", started)
+}
+
+func TestRunPhaseLeavesOverallTimeoutReporting(t *testing.T) {
+	worker := newTestWorker(t, nil)
+	worker.hooks = BuildHooks{PreBuild: []string{"exec sleep 5"}}
+	worker.allowlist = &allowlist.AllowedCommands{Hooks: []string{"exec sleep *"}}
+	ctx, cancel := context.WithTimeout(context.Background(), phaseTestBudget)
+	defer cancel()
+	worker.ctx = ctx
+
+	err := worker.runPhase(phasePrebuild, time.Minute, worker.runPreBuildHooks)
+	if err == nil {
+		t.Fatal("expected hook to be killed by the overall timeout")
+	}
+	reason := worker.stepFailureReason(err, "generic failure")
+	if !strings.HasPrefix(reason, "build timed out after") {
+		t.Fatalf("expected overall timeout reason, got %q", reason)
+	}
+	if worker.ctx != ctx {
+		t.Fatal("expected runPhase to restore the job context")
+	}
+}
+
+func TestJobTimeoutFailsSlowBuild(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	installHangingSudo(t)
+	repo := writeGitRepository(t, map[string]string{"Dockerfile": "FROM alpine\nCMD [\"true\"]\n"})
+
+	var mu sync.Mutex
+	var reported []api.ReportPayload
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload api.ReportPayload
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		reported = append(reported, payload)
+		mu.Unlock()
+	}))
+	defer callback.Close()
+
+	store := newDedupTestStorage(t)
+	logManager, err := logs.NewLogManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	job := &storage.BuildJob{
+		ID:          "build_timeout",
+		ProjectID:   "proj",
+		UserID:      "user",
+		SourceInfo:  storage.SourceInfo{GitRepository: repo},
+		BuildConfig: storage.BuildConfig{Network: "proj-network", TimeoutSeconds: 1},
+	}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	manager := NewManager(store, logManager, nil, api.NewClient(callback.URL), 1, "")
+
+	started := time.Now()
+	manager.tryToDispatchJob()
+	waitFor(t, "the worker to stop", func() bool {
+		return len(manager.GetActiveBuilds()) == 0
+	})
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Fatalf("expected the timeout to kill the build promptly, took %s", elapsed)
+	}
+
+	stored, err := store.GetJob(job.ID)
+	if err != nil || stored.Status != string(jobstate.Failed) {
+		t.Fatalf("expected the job to fail, got %+v (err %v)", stored, err)
+	}
+	workspaces, _ := filepath.Glob(filepath.Join(os.TempDir(), workspacePrefix+job.ID+"-*"))
+	if len(workspaces) != 0 {
+		t.Fatalf("expected the workspace to be removed, found %v", workspaces)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reported) != 1 || reported[0].Error != "build timed out after 1s" {
+		t.Fatalf("expected one timed out callback, got %+v", reported)
+	}
+}
+
+func TestBuildTimeoutIsUnsetWithoutTimeoutSeconds(t *testing.T) {
+	for _, seconds := range []int{0, -5} {
+		worker := &Worker{job: &storage.BuildJob{BuildConfig: storage.BuildConfig{TimeoutSeconds: seconds}}}
+		if timeout := worker.buildTimeout(); timeout != 0 {
+			t.Fatalf("expected no timeout for %d seconds, got %s", seconds, timeout)
+		}
+	}
+}
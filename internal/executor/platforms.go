@@ -0,0 +1,43 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+)
+
+const unsupportedPlatformCode = "unsupported_platform"
+
+// unsupportedPlatformMarkers are printed when a multi-platform build needs an
+// architecture the host can neither run natively nor emulate, or the base
+// image is not published for it.
+var unsupportedPlatformMarkers = []string{
+	"exec format error",
+	"no match for platform in manifest",
+	"does not provide the specified platform",
+	"unsupported platform",
+	"no emulator",
+}
+
+func isPlatformUnsupported(output string) bool {
+	return containsAnyMarker(output, unsupportedPlatformMarkers)
+}
+
+// unsupportedPlatformError marks a build that failed because one of the
+// requested platforms cannot be built on this host.
+type unsupportedPlatformError struct {
+	platforms []string
+	err       error
+}
+
+func (e *unsupportedPlatformError) Error() string {
+	return fmt.Sprintf("%s: the builder cannot build for %s; install QEMU binfmt emulation on the host or check that the base image is published for each platform", e.Code(), strings.Join(e.platforms, ","))
+}
+
+func (e *unsupportedPlatformError) Unwrap() error {
+	return e.err
+}
+
+// Code is the failure code reported for the job.
+func (e *unsupportedPlatformError) Code() string {
+	return unsupportedPlatformCode
+}
@@ -0,0 +1,36 @@
+package executor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUnemulatedPlatformFailsWithPlatformCode(t *testing.T) {
+	installFakeBinary(t, "sudo", "echo 'exec /bin/sh: exec format error' >&2\nexit 1\n")
+	worker := newTestWorker(t, nil)
+	worker.job.BuildConfig.Platforms = []string{"linux/amd64", "linux/arm64"}
+	opts := registryTestOpts(t)
+	opts.Platforms = worker.job.BuildConfig.Platforms
+
+	err := worker.buildImageWithHubcell(opts)
+	var platformErr *unsupportedPlatformError
+	if !errors.As(err, &platformErr) {
+		t.Fatalf("expected an unsupported platform error, got %v", err)
+	}
+	reason := worker.stepFailureReason(err, "failed to build image with hubcell")
+	if !strings.HasPrefix(reason, "unsupported_platform: ") || !strings.Contains(reason, "linux/amd64,linux/arm64") {
+		t.Fatalf("expected an unsupported_platform failure naming the platforms, got %q", reason)
+	}
+}
+
+func TestExecFormatErrorWithoutPlatformsIsAnOrdinaryFailure(t *testing.T) {
+	installFakeBinary(t, "sudo", "echo 'exec /bin/sh: exec format error' >&2\nexit 1\n")
+	worker := newTestWorker(t, nil)
+
+	err := worker.buildImageWithHubcell(registryTestOpts(t))
+	var platformErr *unsupportedPlatformError
+	if err == nil || errors.As(err, &platformErr) {
+		t.Fatalf("expected a plain build failure, got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package executor
+
+import (
+	"regexp"
+	"strconv"
+	"sync"
+
+	"hubfly-builder/internal/events"
+)
+
+// progressPush is the progress stage of registry pushes and post-build
+// hooks. It has no phase budget of its own.
+const progressPush = "push"
+
+// progressWeights are the shares of the progress estimate, in percent, of
+// each stage in the order a build runs them.
+var progressWeights = []struct {
+	stage  string
+	weight int
+}{
+	{phaseClone, 10},
+	{phasePrebuild, 30},
+	{phaseBuild, 55},
+	{progressPush, 5},
+}
+
+// buildProgressStepPattern matches the first line BuildKit's plain progress
+// output prints for a Dockerfile step, e.g. "#7 [builder 3/5] RUN make".
+var buildProgressStepPattern = regexp.MustCompile(`^#\d+ \[(?:(.*) )?(\d+)/(\d+)\]`)
+
+// buildProgress estimates how far a running build is, from the stage it is in
+// and, during the image build, the Dockerfile steps BuildKit has started. The
+// estimate never decreases. A nil *buildProgress ignores updates.
+type buildProgress struct {
+	mu      sync.Mutex
+	stage   string
+	percent int
+	// steps holds the highest started step and the step count of each
+	// Dockerfile stage seen in the build output.
+	steps map[string][2]int
+}
+
+// Percent returns the current estimate.
+func (p *buildProgress) Percent() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.percent
+}
+
+// enter moves the estimate to the start of stage. It reports the new
+// estimate and whether it changed.
+func (p *buildProgress) enter(stage string) (int, bool) {
+	if p == nil {
+		return 0, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stage = stage
+	p.steps = nil
+	return p.advanceLocked(0, 1)
+}
+
+// observe updates the estimate from a line of image build output.
+func (p *buildProgress) observe(line string) (int, bool) {
+	if p == nil {
+		return 0, false
+	}
+	match := buildProgressStepPattern.FindStringSubmatch(line)
+	if match == nil {
+		return 0, false
+	}
+	step, _ := strconv.Atoi(match[2])
+	total, _ := strconv.Atoi(match[3])
+	if total <= 0 || step > total {
+		return 0, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stage != phaseBuild {
+		return p.percent, false
+	}
+	if p.steps == nil {
+		p.steps = make(map[string][2]int)
+	}
+	if seen := p.steps[match[1]]; step > seen[0] {
+		p.steps[match[1]] = [2]int{step, total}
+	}
+	started, steps := 0, 0
+	for _, counts := range p.steps {
+		// A started step is still running, so it does not count as done.
+		started += counts[0] - 1
+		steps += counts[1]
+	}
+	return p.advanceLocked(started, steps)
+}
+
+// complete moves the estimate to 100.
+func (p *buildProgress) complete() (int, bool) {
+	if p == nil {
+		return 0, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.percent == 100 {
+		return 100, false
+	}
+	p.percent = 100
+	return 100, true
+}
+
+// advanceLocked sets the estimate to done/of of the current stage's weight
+// past the stages before it, unless that would lower it.
+func (p *buildProgress) advanceLocked(done, of int) (int, bool) {
+	base := 0
+	for _, entry := range progressWeights {
+		if entry.stage != p.stage {
+			base += entry.weight
+			continue
+		}
+		percent := base + entry.weight*done/of
+		if percent <= p.percent {
+			return p.percent, false
+		}
+		p.percent = percent
+		return percent, true
+	}
+	return p.percent, false
+}
+
+// JobProgress returns the progress estimate of a job this manager is
+// running, in percent.
+func (m *Manager) JobProgress(jobID string) (int, bool) {
+	m.mu.Lock()
+	progress, ok := m.progress[jobID]
+	m.mu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	return progress.Percent(), true
+}
+
+func (w *Worker) enterProgressStage(stage string) {
+	if percent, changed := w.progress.enter(stage); changed {
+		w.publishProgress(percent)
+	}
+}
+
+func (w *Worker) observeBuildProgress(line string) {
+	if percent, changed := w.progress.observe(line); changed {
+		w.publishProgress(percent)
+	}
+}
+
+func (w *Worker) completeProgress() {
+	if percent, changed := w.progress.complete(); changed {
+		w.publishProgress(percent)
+	}
+}
+
+func (w *Worker) publishProgress(percent int) {
+	w.eventBus.Publish(events.Event{
+		Type:              events.JobProgress,
+		JobID:             w.job.ID,
+		ProjectID:         w.job.ProjectID,
+		UserID:            w.job.UserID,
+		EstimatedProgress: percent,
+	})
+}
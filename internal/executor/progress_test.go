@@ -0,0 +1,90 @@
+package executor
+
+import (
+	"testing"
+
+	"hubfly-builder/internal/events"
+	"hubfly-builder/internal/storage"
+)
+
+func TestBuildProgressIncreasesAcrossPhases(t *testing.T) {
+	bus := events.NewBus()
+	sub := bus.Subscribe(events.Filter{}, 64)
+	defer sub.Close()
+	worker := &Worker{
+		job:      &storage.BuildJob{ID: "build_progress", ProjectID: "proj", UserID: "user"},
+		eventBus: bus,
+		progress: &buildProgress{},
+	}
+
+	last := 0
+	check := func(step string, want int) {
+		t.Helper()
+		got := worker.progress.Percent()
+		if got < last {
+			t.Fatalf("%s: progress went down from %d to %d", step, last, got)
+		}
+		if want >= 0 && got != want {
+			t.Fatalf("%s: expected progress %d, got %d", step, want, got)
+		}
+		last = got
+	}
+
+	worker.enterProgressStage(phaseClone)
+	check("clone", 0)
+	worker.enterProgressStage(phasePrebuild)
+	check("prebuild", 10)
+	// Output outside the build phase is not build progress.
+	worker.observeBuildProgress("#6 [builder 2/4] COPY . .")
+	check("prebuild output", 10)
+	worker.enterProgressStage(phaseBuild)
+	check("build", 40)
+	for _, line := range []string{
+		"#1 [internal] load build definition from Dockerfile",
+		"#5 [builder 1/4] FROM docker.io/library/golang:1.22",
+		"#6 [builder 2/4] COPY . .",
+		"#6 DONE 0.1s",
+		"#7 [builder 4/4] RUN go build -o app .",
+		// A stage that shows up late adds steps; the estimate holds.
+		"#8 [stage-1 1/2] FROM docker.io/library/alpine:3.20",
+		"#9 [stage-1 2/2] COPY --from=builder /app/app /app",
+	} {
+		worker.observeBuildProgress(line)
+		check(line, -1)
+	}
+	if last <= 40 || last >= 95 {
+		t.Fatalf("expected build steps to move progress within the build phase, got %d", last)
+	}
+	worker.enterProgressStage(progressPush)
+	check("push", 95)
+	worker.completeProgress()
+	check("complete", 100)
+	// Re-entering an earlier phase, as a retried step might, keeps the estimate.
+	worker.enterProgressStage(phaseBuild)
+	check("re-entered build", 100)
+
+	previous := 0
+	for len(sub.Events()) > 0 {
+		event := <-sub.Events()
+		if event.Type != events.JobProgress || event.JobID != "build_progress" {
+			t.Fatalf("unexpected event %+v", event)
+		}
+		if event.EstimatedProgress <= previous {
+			t.Fatalf("expected strictly increasing progress events, got %d after %d", event.EstimatedProgress, previous)
+		}
+		previous = event.EstimatedProgress
+	}
+	if previous != 100 {
+		t.Fatalf("expected the last progress event to report 100, got %d", previous)
+	}
+}
+
+func TestBuildProgressNilIgnoresUpdates(t *testing.T) {
+	worker := &Worker{job: &storage.BuildJob{ID: "build_nil"}}
+	worker.enterProgressStage(phaseBuild)
+	worker.observeBuildProgress("#5 [1/2] FROM alpine")
+	worker.completeProgress()
+	if got := worker.progress.Percent(); got != 0 {
+		t.Fatalf("expected a nil progress to stay at 0, got %d", got)
+	}
+}
@@ -0,0 +1,99 @@
+package executor
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"hubfly-builder/internal/driver"
+	"hubfly-builder/internal/provenance"
+)
+
+// recordProvenance stores a SLSA provenance statement for the finished image.
+// Failing to record it is logged but does not fail an otherwise good build.
+// Without an image digest no statement is stored, since it could not be tied
+// to the image.
+func (w *Worker) recordProvenance() {
+	strategy := "dockerfile"
+	if w.job.BuildConfig.UseBuildpacks {
+		strategy = "buildpacks"
+	}
+	commitSHA := w.commitSHA
+	if commitSHA == "" {
+		commitSHA = w.job.SourceInfo.CommitSha
+	}
+
+	statement, err := provenance.Generate(provenance.Input{
+		JobID:         w.job.ID,
+		Images:        w.provenanceImages(),
+		GitRepository: provenanceSourceURI(w.job.SourceInfo.GitRepository),
+		Ref:           w.job.SourceInfo.Ref,
+		CommitSHA:     commitSHA,
+		WorkingDir:    w.job.SourceInfo.WorkingDir,
+		Runtime:       w.job.BuildConfig.Runtime,
+		BuildStrategy: strategy,
+		Platforms:     w.job.BuildConfig.Platforms,
+		BuildOpts:     w.job.BuildConfig.BuildOpts,
+		Dockerfile:    w.job.BuildConfig.DockerfileContent,
+		StartedAt:     w.job.StartedAt.Time,
+		FinishedAt:    time.Now(),
+	})
+	if errors.Is(err, provenance.ErrNoImageDigest) {
+		w.log("WARNING: not recording provenance: the image digest of %s is unknown", w.job.ImageTag)
+		return
+	}
+	if err != nil {
+		w.log("WARNING: could not generate provenance: %v", err)
+		return
+	}
+	if err := w.storage.UpdateJobProvenance(w.job.ID, statement); err != nil {
+		w.log("WARNING: could not store provenance: %v", err)
+		return
+	}
+	w.logInfo("Recorded build provenance (%s).", provenance.PredicateType)
+}
+
+// provenanceImages are the images the provenance statement is about: each
+// successful registry push with the digest the registry reported, and the
+// local image with the digest Hubcell reports for it.
+func (w *Worker) provenanceImages() []provenance.Image {
+	var images []provenance.Image
+	for _, push := range w.registryPushes {
+		if push.Success && push.Digest != "" {
+			images = append(images, provenance.Image{Name: push.Image, Digest: push.Digest})
+		}
+	}
+	if digest := w.localImageDigest(); digest != "" {
+		images = append(images, provenance.Image{Name: w.job.ImageTag, Digest: digest})
+	}
+	return images
+}
+
+// imageIDPattern matches the image ID in hubcell image inspect output.
+var imageIDPattern = regexp.MustCompile(`"Id"\s*:\s*"(sha256:[0-9a-f]{64})"`)
+
+// localImageDigest returns the ID of the job's image in Hubcell's local image
+// store, or "" when it cannot be inspected.
+func (w *Worker) localImageDigest() string {
+	output, err := w.commandOutput(driver.HubcellImageInspectCommandContext(w.ctx, hubcellCLIPathFromEnv(), w.job.ImageTag))
+	if err != nil {
+		return ""
+	}
+	if match := imageIDPattern.FindStringSubmatch(output); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// provenanceSourceURI drops credentials entirely rather than redacting them, so
+// the recorded URI stays usable for verification.
+func provenanceSourceURI(raw string) string {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || parsed.User == nil {
+		return sanitizeGitRepositoryURL(raw)
+	}
+	parsed.User = nil
+	return parsed.String()
+}
@@ -0,0 +1,104 @@
+package executor
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"hubfly-builder/internal/provenance"
+	"hubfly-builder/internal/storage"
+)
+
+// installInspectingSudo fakes sudo so that hubcell image inspect prints
+// output.
+func installInspectingSudo(t *testing.T, output string) {
+	t.Helper()
+	installFakeBinary(t, "sudo", "case \" $* \" in *\" image inspect \"*) cat <<'EOF'\n"+output+"\nEOF\n;; esac\n")
+}
+
+func TestRecordProvenanceStoresStatementForJob(t *testing.T) {
+	store, err := storage.NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	job := &storage.BuildJob{
+		ID:        "build_prov",
+		ProjectID: "proj",
+		UserID:    "user",
+		ImageTag:  "hubcell.local/user/proj:tag",
+		StartedAt: sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true},
+		SourceInfo: storage.SourceInfo{
+			GitRepository: "https://token@github.com/example/app.git",
+			Ref:           "main",
+		},
+		BuildConfig: storage.BuildConfig{
+			Provenance:        true,
+			DockerfileContent: []byte("FROM alpine\n"),
+		},
+	}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+
+	installInspectingSudo(t, `[{"Id": "`+testPushDigest+`"}]`)
+	worker := &Worker{job: job, storage: store, logWriter: io.Discard, commitSHA: "abc123", ctx: context.Background()}
+	worker.recordProvenance()
+
+	content, err := store.GetJobProvenance(job.ID)
+	if err != nil {
+		t.Fatalf("failed to load provenance: %v", err)
+	}
+	var statement provenance.Statement
+	if err := json.Unmarshal(content, &statement); err != nil {
+		t.Fatalf("stored provenance is not valid JSON: %v", err)
+	}
+	if len(statement.Subject) != 1 || statement.Subject[0].Name != job.ImageTag || "sha256:"+statement.Subject[0].Digest["sha256"] != testPushDigest {
+		t.Fatalf("expected subject %q with the inspected digest, got %+v", job.ImageTag, statement.Subject)
+	}
+	deps := statement.Predicate.BuildDefinition.ResolvedDependencies
+	if len(deps) != 1 || deps[0].Digest["gitCommit"] != "abc123" {
+		t.Fatalf("expected resolved commit abc123, got %+v", deps)
+	}
+	if deps[0].URI != "git+https://github.com/example/app.git" {
+		t.Fatalf("expected credentials to be stripped from the source URI, got %q", deps[0].URI)
+	}
+}
+
+func TestRecordProvenanceUsesPushedDigestsAndSkipsWithoutDigest(t *testing.T) {
+	store, err := storage.NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	job := &storage.BuildJob{ID: "build_prov_push", ProjectID: "proj", UserID: "user", ImageTag: "hubcell.local/user/proj:tag"}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	installInspectingSudo(t, "image not found")
+	worker := &Worker{job: job, storage: store, logWriter: io.Discard, ctx: context.Background()}
+
+	worker.recordProvenance()
+	if content, err := store.GetJobProvenance(job.ID); err != nil || len(content) != 0 {
+		t.Fatalf("expected no provenance without an image digest, got %q (err %v)", content, err)
+	}
+
+	worker.registryPushes = storage.RegistryPushes{
+		{Image: "ghcr.io/acme/api:tag", Success: true, Digest: testPushDigest},
+		{Image: "registry.internal:5000/api:tag", Error: "push failed"},
+	}
+	worker.recordProvenance()
+	content, err := store.GetJobProvenance(job.ID)
+	if err != nil {
+		t.Fatalf("failed to load provenance: %v", err)
+	}
+	var statement provenance.Statement
+	if err := json.Unmarshal(content, &statement); err != nil {
+		t.Fatalf("stored provenance is not valid JSON: %v", err)
+	}
+	if len(statement.Subject) != 1 || statement.Subject[0].Name != "ghcr.io/acme/api:tag" {
+		t.Fatalf("expected the successful push as the only subject, got %+v", statement.Subject)
+	}
+}
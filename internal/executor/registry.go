@@ -0,0 +1,236 @@
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const registryRateLimitedCode = "registry_rate_limited"
+
+// registryRateLimitMarkers are the errors a registry answers a throttled pull
+// or push with: the toomanyrequests error code or an HTTP 429.
+var registryRateLimitMarkers = []string{
+	"toomanyrequests",
+	"429 too many requests",
+	"status: 429",
+	"status code 429",
+}
+
+// registryOperationMarkers tell a pull or push error apart from other lines
+// that mention an HTTP 429; toomanyrequests is only ever sent by registries.
+var registryOperationMarkers = []string{
+	"toomanyrequests",
+	"pull",
+	"push",
+	"failed to copy",
+	"failed to resolve source metadata",
+	"failed to fetch",
+	"manifest",
+	"blob",
+}
+
+// buildProcessOutputPattern matches what a build step's own process prints,
+// such as an npm install hitting its package registry, in BuildKit plain
+// progress ("#7 1.234 ...") and pack ("[builder] ...") output.
+var buildProcessOutputPattern = regexp.MustCompile(`^(?:#\d+ \d+\.\d+ |\[builder\] )`)
+
+// registryRateLimitError marks an image build that failed because a registry
+// throttled an image pull or push.
+type registryRateLimitError struct {
+	err error
+}
+
+func (e *registryRateLimitError) Error() string {
+	return "registry rate limit: " + e.err.Error()
+}
+
+func (e *registryRateLimitError) Unwrap() error {
+	return e.err
+}
+
+// Code is the failure code reported for the job.
+func (e *registryRateLimitError) Code() string {
+	return registryRateLimitedCode
+}
+
+// registryNetworkMarkers are printed when an image pull or push fails on the
+// network or in the registry rather than because of the build itself.
+var registryNetworkMarkers = []string{
+	"i/o timeout",
+	"tls handshake timeout",
+	"context deadline exceeded",
+	"connection reset by peer",
+	"connection refused",
+	"temporary failure in name resolution",
+	"no such host",
+	"net/http: request canceled while waiting for connection",
+	"status: 500",
+	"status: 502",
+	"status: 503",
+	"status: 504",
+}
+
+// isRegistryRateLimited reports whether output has a registry error line
+// showing that a pull or push was rate limited. Output of the build's own
+// processes is ignored.
+func isRegistryRateLimited(output string) bool {
+	for _, line := range strings.Split(output, "\n") {
+		if isRegistryRateLimitLine(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func isRegistryRateLimitLine(line string) bool {
+	return !buildProcessOutputPattern.MatchString(line) &&
+		containsAnyMarker(line, registryRateLimitMarkers) &&
+		containsAnyMarker(line, registryOperationMarkers)
+}
+
+// buildStepImagePattern matches the BuildKit lines that name the image a step
+// pulls, and failedPullImagePattern the error naming the image it could not.
+var (
+	buildStepImagePattern  = regexp.MustCompile(`^#(\d+) (?:\[internal\] load metadata for|\[[^\]]+\] FROM) (\S+)`)
+	buildStepNumberPattern = regexp.MustCompile(`^#(\d+) `)
+	failedPullImagePattern = regexp.MustCompile(`failed to resolve source metadata for (\S+?):? `)
+)
+
+// rateLimitedImages returns the images whose pull was rate limited in the
+// BuildKit output of a build, in the order they failed.
+func rateLimitedImages(output string) []string {
+	stepImages := make(map[string]string)
+	seen := make(map[string]bool)
+	var images []string
+	for _, line := range strings.Split(output, "\n") {
+		if match := buildStepImagePattern.FindStringSubmatch(line); match != nil {
+			stepImages[match[1]] = strings.SplitN(match[2], "@", 2)[0]
+			continue
+		}
+		if !isRegistryRateLimitLine(line) {
+			continue
+		}
+		image := ""
+		if match := failedPullImagePattern.FindStringSubmatch(line); match != nil {
+			image = match[1]
+		} else if match := buildStepNumberPattern.FindStringSubmatch(line); match != nil {
+			image = stepImages[match[1]]
+		}
+		if image != "" && !seen[image] {
+			seen[image] = true
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+func isRegistryNetworkFailure(output string) bool {
+	return containsAnyMarker(output, registryNetworkMarkers)
+}
+
+func containsAnyMarker(output string, markers []string) bool {
+	lower := strings.ToLower(output)
+	for _, marker := range markers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRegistryRateLimitRetry makes workers retry an image pull or push once,
+// after delay, when a registry rate limits it. Zero disables the
+// retry; the job then fails with registry_rate_limited straight away.
+func (m *Manager) SetRegistryRateLimitRetry(delay time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registryRetryDelay = delay
+}
+
+// runImageBuild runs the command newCmd returns, records the warnings and
+// cache statistics it prints and classifies a registry rate limit or network
+// failure in its output. Hubcell keeps images locally, so the registry
+// traffic that fails is a base image pull, which stops the build before any
+// step ran. A rate-limited pull is retried on its own with pullCmd, once,
+// and the build then runs again on the pulled image; without pullCmd, or when
+// the output does not name the image, the build fails straight away.
+func (w *Worker) runImageBuild(newCmd func() *exec.Cmd, pullCmd func(image string) *exec.Cmd) error {
+	cmd := newCmd()
+	w.lastBuildCommand = redactedBuildCommand(cmd)
+	output, err := w.runImageBuildCommand(cmd)
+	if err == nil || w.isTimeoutError(err) {
+		return err
+	}
+	if len(w.job.BuildConfig.Platforms) > 0 && isPlatformUnsupported(output) {
+		return &unsupportedPlatformError{platforms: w.job.BuildConfig.Platforms, err: err}
+	}
+	if !isRegistryRateLimited(output) {
+		if isRegistryNetworkFailure(output) {
+			return fmt.Errorf("%w: image pull failed on the network: %w", ErrTransientFailure, err)
+		}
+		return err
+	}
+	images := rateLimitedImages(output)
+	if w.registryRetryDelay <= 0 || pullCmd == nil || len(images) == 0 {
+		w.log("ERROR: registry rate limit hit")
+		return &registryRateLimitError{err: err}
+	}
+
+	for _, image := range images {
+		w.log("WARNING: registry rate limit hit pulling %s; retrying the pull in %s", image, w.registryRetryDelay)
+	}
+	if err := w.sleep(w.registryRetryDelay); err != nil {
+		return err
+	}
+	for _, image := range images {
+		if _, err := w.runRegistryCommand(func() *exec.Cmd { return pullCmd(image) }, "pull of "+image, false); err != nil {
+			return err
+		}
+	}
+	output, err = w.runImageBuildCommand(newCmd())
+	if err != nil && !w.isTimeoutError(err) && isRegistryRateLimited(output) {
+		w.log("ERROR: registry rate limit hit again after retrying")
+		return &registryRateLimitError{err: err}
+	}
+	return err
+}
+
+func (w *Worker) runImageBuildCommand(cmd *exec.Cmd) (string, error) {
+	output, err := w.executeCommandCapturingOutput(cmd, false, &outputCapture{})
+	w.recordBuildWarnings(output)
+	w.recordBuildCacheStats(output)
+	w.inspectBuildCache(output, err)
+	return output, err
+}
+
+// runRegistryCommand runs a registry pull or push, named what in the log. When
+// retry is set and the registry rate limits it, the command alone is run
+// once more after the retry delay. A pull or push that is still rate limited
+// fails with registryRateLimitError, and one that failed on the network or
+// with a registry server error is marked transient.
+func (w *Worker) runRegistryCommand(newCmd func() *exec.Cmd, what string, retry bool) (string, error) {
+	output, err := w.executeCommandCapturingOutput(newCmd(), true, &outputCapture{})
+	if err != nil && !w.isTimeoutError(err) && isRegistryRateLimited(output) && retry && w.registryRetryDelay > 0 {
+		w.log("WARNING: registry rate limit hit on the %s; retrying in %s", what, w.registryRetryDelay)
+		if err := w.sleep(w.registryRetryDelay); err != nil {
+			return "", err
+		}
+		output, err = w.executeCommandCapturingOutput(newCmd(), true, &outputCapture{})
+	}
+	if err == nil || w.isTimeoutError(err) {
+		return output, err
+	}
+	if isRegistryRateLimited(output) {
+		w.log("ERROR: registry rate limit hit on the %s", what)
+		return "", &registryRateLimitError{err: err}
+	}
+	// A registry that timed out, dropped the connection or answered 5xx
+	// may well accept the same pull or push later.
+	if isRegistryNetworkFailure(output) {
+		return "", fmt.Errorf("%w: %s failed on the network: %w", ErrTransientFailure, what, err)
+	}
+	return "", err
+}
@@ -0,0 +1,122 @@
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"hubfly-builder/internal/driver"
+	"hubfly-builder/internal/storage"
+)
+
+// RegistryCredential logs the builder in to a registry before it pushes
+// there.
+type RegistryCredential struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// SetRegistryCredentials sets the credentials used for buildConfig.registries,
+// keyed by registry host such as "ghcr.io" or "registry.internal:5000".
+// Registries without credentials are pushed to anonymously.
+func (m *Manager) SetRegistryCredentials(credentials map[string]RegistryCredential) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registryCreds = credentials
+}
+
+// pushToRegistries pushes the built image to each of buildConfig.registries
+// under the tag of the job's image tag and records the outcome of every push.
+// It returns an error when a push to a required registry failed; failed pushes
+// to optional registries are only logged.
+func (w *Worker) pushToRegistries() error {
+	targets := w.job.BuildConfig.Registries
+	if len(targets) == 0 {
+		return nil
+	}
+	tag := strings.TrimPrefix(strings.TrimPrefix(w.job.ImageTag, imageRepository(w.job.ImageTag)), ":")
+	if tag == "" {
+		tag = defaultMovingTag
+	}
+
+	pushes := make(storage.RegistryPushes, 0, len(targets))
+	loggedIn := make(map[string]bool)
+	var requiredErr error
+	for _, target := range targets {
+		push := storage.RegistryPush{
+			Repository: target.Repository,
+			Image:      target.Repository + ":" + tag,
+			Required:   !target.Optional,
+		}
+		w.logInfo("Pushing %s", push.Image)
+		digest, err := w.pushImage(push.Image, registryHost(target.Repository), loggedIn)
+		if err != nil {
+			push.Error = err.Error()
+			if target.Optional {
+				w.log("WARNING: could not push to optional registry %s: %v", target.Repository, err)
+			} else {
+				w.log("ERROR: could not push to %s: %v", target.Repository, err)
+				if requiredErr == nil {
+					requiredErr = fmt.Errorf("push to %s failed: %w", target.Repository, err)
+				}
+			}
+		} else {
+			push.Success = true
+			push.Digest = digest
+		}
+		pushes = append(pushes, push)
+	}
+	w.registryPushes = pushes
+	if err := w.storage.SetJobRegistryPushes(w.job.ID, pushes); err != nil {
+		w.log("WARNING: could not record registry pushes: %v", err)
+	}
+	return requiredErr
+}
+
+// pushImage tags the job's image as image and pushes it, logging in to host
+// first when the builder has credentials for it. It returns the manifest
+// digest the push printed, or "" when it printed none.
+func (w *Worker) pushImage(image, host string, loggedIn map[string]bool) (string, error) {
+	hubcellPath := hubcellCLIPathFromEnv()
+	if err := w.executeCommand(driver.HubcellTagCommandContext(w.ctx, hubcellPath, w.job.ImageTag, image)); err != nil {
+		return "", fmt.Errorf("could not tag the image: %w", err)
+	}
+	if credential, ok := w.registryCredentials[host]; ok && !loggedIn[host] {
+		cmd := driver.HubcellLoginCommandContext(w.ctx, hubcellPath, host, credential.Username)
+		cmd.Stdin = strings.NewReader(credential.Password)
+		if err := w.executeCommand(cmd); err != nil {
+			return "", fmt.Errorf("could not log in to %s: %w", host, err)
+		}
+		loggedIn[host] = true
+	}
+	output, err := w.runRegistryCommand(func() *exec.Cmd {
+		return driver.HubcellPushCommandContext(w.ctx, hubcellPath, image)
+	}, "push of "+image, true)
+	if err != nil {
+		return "", err
+	}
+	return pushedDigest(output), nil
+}
+
+// pushDigestPattern matches the "digest: sha256:<hex> size: <n>" line a push
+// prints once the registry has accepted the manifest.
+var pushDigestPattern = regexp.MustCompile(`digest: (sha256:[0-9a-f]{64})`)
+
+func pushedDigest(output string) string {
+	if match := pushDigestPattern.FindStringSubmatch(output); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// registryHost is the host[:port] part of a repository such as
+// ghcr.io/acme/api. As in Docker, the first path segment is only a host when
+// it has a "." or ":" or is localhost; acme/api and api are on docker.io.
+func registryHost(repository string) string {
+	host, _, found := strings.Cut(repository, "/")
+	if !found || !strings.ContainsAny(host, ".:") && host != "localhost" {
+		return "docker.io"
+	}
+	return host
+}
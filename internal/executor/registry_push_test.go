@@ -0,0 +1,185 @@
+package executor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"hubfly-builder/internal/allowlist"
+	"hubfly-builder/internal/api"
+	"hubfly-builder/internal/jobstate"
+	"hubfly-builder/internal/logs"
+	"hubfly-builder/internal/storage"
+)
+
+// testPushDigest is the manifest digest installPushingSudo reports for pushes.
+const testPushDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// installPushingSudo records each sudo invocation, including the password a
+// login reads from stdin, fails pushes to failingRepository and reports
+// testPushDigest for the others.
+func installPushingSudo(t *testing.T, failingRepository string) string {
+	t.Helper()
+	return fakeCalls(installFakeBinary(t, "sudo", recordFakeCall+
+		"case \" $* \" in\n"+
+		"  *\" login \"*) cat >> \"$(dirname \"$0\")/calls\" ;;\n"+
+		"  *\" push "+failingRepository+":\"*) exit 1 ;;\n"+
+		"  *\" push \"*) echo \"latest: digest: "+testPushDigest+" size: 1234\" ;;\n"+
+		"esac\n"))
+}
+
+func runRegistryPushTestJob(t *testing.T, registries storage.RegistryTargets, want jobstate.Status) (*storage.BuildJob, storage.RegistryPushes) {
+	t.Helper()
+	repo := writeGitRepository(t, map[string]string{"Dockerfile": "FROM alpine\nCMD [\"true\"]\n"})
+	store := newDedupTestStorage(t)
+	logManager, err := logs.NewLogManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	job := &storage.BuildJob{
+		ID:          "build_push",
+		ProjectID:   "proj",
+		UserID:      "user",
+		SourceInfo:  storage.SourceInfo{GitRepository: repo},
+		BuildConfig: storage.BuildConfig{Network: "proj-network", Registries: registries},
+	}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	manager := NewManager(store, logManager, &allowlist.AllowedCommands{}, api.NewClient(""), 1, "")
+	manager.SetRegistryCredentials(map[string]RegistryCredential{"ghcr.io": {Username: "bot", Password: "s3cret"}})
+
+	finished := runResumeTestJob(t, manager, store, job.ID, want)
+	pushes, err := store.GetJobRegistryPushes(job.ID)
+	if err != nil {
+		t.Fatalf("failed to load registry pushes: %v", err)
+	}
+	return finished, pushes
+}
+
+func TestPushToRegistriesPushesToEveryRegistry(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	calls := installPushingSudo(t, "none")
+	job, pushes := runRegistryPushTestJob(t, storage.RegistryTargets{
+		{Repository: "ghcr.io/acme/api"},
+		{Repository: "registry.internal:5000/api"},
+	}, jobstate.Success)
+
+	tag := strings.TrimPrefix(job.ImageTag, imageRepository(job.ImageTag)+":")
+	if len(pushes) != 2 {
+		t.Fatalf("expected a result per registry, got %+v", pushes)
+	}
+	for i, repository := range []string{"ghcr.io/acme/api", "registry.internal:5000/api"} {
+		want := storage.RegistryPush{Repository: repository, Image: repository + ":" + tag, Required: true, Success: true, Digest: testPushDigest}
+		if pushes[i] != want {
+			t.Fatalf("expected push %d to be %+v, got %+v", i, want, pushes[i])
+		}
+	}
+
+	recorded := readSudoCalls(t, calls)
+	for _, want := range []string{
+		"tag " + job.ImageTag + " ghcr.io/acme/api:" + tag,
+		"login --username bot --password-stdin ghcr.io\ns3cret",
+		"push ghcr.io/acme/api:" + tag,
+		"push registry.internal:5000/api:" + tag,
+	} {
+		if !strings.Contains(recorded, want) {
+			t.Fatalf("expected %q among the sudo calls, got:\n%s", want, recorded)
+		}
+	}
+	if strings.Contains(recorded, "login --username bot --password-stdin registry.internal") {
+		t.Fatalf("expected no login to a registry without credentials, got:\n%s", recorded)
+	}
+}
+
+func TestPushToRegistriesToleratesOptionalFailure(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	installPushingSudo(t, "registry.internal:5000/api")
+	_, pushes := runRegistryPushTestJob(t, storage.RegistryTargets{
+		{Repository: "ghcr.io/acme/api"},
+		{Repository: "registry.internal:5000/api", Optional: true},
+	}, jobstate.Success)
+
+	if len(pushes) != 2 || !pushes[0].Success || pushes[1].Success || pushes[1].Required || pushes[1].Error == "" {
+		t.Fatalf("expected only the optional push to fail, got %+v", pushes)
+	}
+}
+
+func TestPushToRegistriesFailsOnRequiredFailure(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	installPushingSudo(t, "ghcr.io/acme/api")
+	_, pushes := runRegistryPushTestJob(t, storage.RegistryTargets{
+		{Repository: "ghcr.io/acme/api"},
+		{Repository: "registry.internal:5000/api", Optional: true},
+	}, jobstate.Failed)
+
+	if len(pushes) != 2 || pushes[0].Success || !pushes[1].Success {
+		t.Fatalf("expected the remaining pushes to run after the required one failed, got %+v", pushes)
+	}
+}
+
+func TestPushImageMarksRegistryServerErrorsTransient(t *testing.T) {
+	installFakeBinary(t, "sudo", "case \" $* \" in\n"+
+		"  *\" push \"*) echo 'received unexpected HTTP status: 503 Service Unavailable' >&2; exit 1 ;;\n"+
+		"esac\n")
+	worker := newTestWorker(t, nil)
+	worker.job.ImageTag = "hubcell.local/user/proj:latest"
+
+	_, err := worker.pushImage("ghcr.io/acme/api:latest", "ghcr.io", map[string]bool{})
+	if !errors.Is(err, ErrTransientFailure) {
+		t.Fatalf("expected a 503 from the registry to be transient, got %v", err)
+	}
+}
+
+func TestPushImageRetriesRateLimitedPushOnce(t *testing.T) {
+	calls := fakeCalls(installFakeBinary(t, "sudo", recordFakeCall+
+		"case \" $* \" in\n"+
+		"  *\" push \"*) if [ \"$(grep -c ' push ' \"$(dirname \"$0\")/calls\")\" -le 1 ]; then echo 'toomanyrequests: too many push requests' >&2; exit 1; fi\n"+
+		"    echo \"latest: digest: "+testPushDigest+" size: 1234\" ;;\n"+
+		"esac\n"))
+	worker := newTestWorker(t, nil)
+	worker.registryRetryDelay = time.Millisecond
+	worker.job.ImageTag = "hubcell.local/user/proj:latest"
+
+	digest, err := worker.pushImage("ghcr.io/acme/api:latest", "ghcr.io", map[string]bool{})
+	if err != nil || digest != testPushDigest {
+		t.Fatalf("expected the retried push to succeed, got %q, %v", digest, err)
+	}
+	recorded := readSudoCalls(t, calls)
+	if strings.Count(recorded, " push ") != 2 || strings.Count(recorded, " tag ") != 1 {
+		t.Fatalf("expected only the push to be retried, got %q", recorded)
+	}
+
+	worker.registryRetryDelay = 0
+	os.Remove(calls)
+	_, err = worker.pushImage("ghcr.io/acme/api:latest", "ghcr.io", map[string]bool{})
+	var rateLimitErr *registryRateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("expected a registry rate limit error without a retry delay, got %v", err)
+	}
+}
+
+func TestRegistryHost(t *testing.T) {
+	tests := map[string]string{
+		"ghcr.io/acme/api":           "ghcr.io",
+		"registry.internal:5000/api": "registry.internal:5000",
+		"localhost/api":              "localhost",
+		"acme/api":                   "docker.io",
+		"api":                        "docker.io",
+		"docker.io/library/alpine":   "docker.io",
+	}
+	for repository, want := range tests {
+		if got := registryHost(repository); got != want {
+			t.Errorf("registryHost(%q) = %q, want %q", repository, got, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package executor
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"hubfly-builder/internal/driver"
+)
+
+// installRateLimitedSudo fakes a sudo whose first failures invocations print
+// a Docker Hub rate-limit error for the alpine base image pull, as BuildKit
+// does, and exit non-zero; later ones succeed.
+func installRateLimitedSudo(t *testing.T, failures int) string {
+	t.Helper()
+	return fakeCalls(installFakeBinary(t, "sudo", recordFakeCall+
+		"if [ \"$(wc -l < \"$(dirname \"$0\")/calls\")\" -le "+strconv.Itoa(failures)+" ]; then\n"+
+		"  echo '#2 [internal] load metadata for docker.io/library/alpine:3.20' >&2\n"+
+		"  echo '#2 ERROR: failed to resolve source metadata for docker.io/library/alpine:3.20: toomanyrequests: You have reached your pull rate limit.' >&2\n"+
+		"  exit 1\n"+
+		"fi\n"))
+}
+
+func registryTestOpts(t *testing.T) driver.HubcellBuildOpts {
+	return driver.HubcellBuildOpts{
+		HubcellPath: "hubcell",
+		WorkDir:     t.TempDir(),
+		ContextPath: ".",
+		ImageTag:    "hubcell.local/user/project:tag",
+	}
+}
+
+func TestIsRegistryRateLimited(t *testing.T) {
+	tests := map[string]bool{
+		"toomanyrequests: You have reached your pull rate limit. You may increase the limit by authenticating": true,
+		"failed to copy: httpReadSeeker: failed open: unexpected status code 429 Too Many Requests":            true,
+		"ERROR: pulling from host registry-1.docker.io failed with status: 429":                                true,
+		"failed to solve: process \"/bin/sh -c npm run build\" did not complete successfully: exit code: 1":    false,
+		"manifest unknown": false,
+		"#7 3.210 npm ERR! 429 Too Many Requests - GET https://registry.npmjs.org/left-pad":     false,
+		"[builder] npm ERR! 429 Too Many Requests - GET https://registry.npmjs.org/left-pad":    false,
+		"#7 ERROR: process \"/bin/sh -c curl https://api.example.com\" failed: status code 429": false,
+	}
+	for output, want := range tests {
+		if got := isRegistryRateLimited(output); got != want {
+			t.Errorf("isRegistryRateLimited(%q) = %t, want %t", output, got, want)
+		}
+	}
+}
+
+func TestRateLimitedBuildFailsWithRegistryCode(t *testing.T) {
+	calls := installRateLimitedSudo(t, 9)
+	worker := newTestWorker(t, nil)
+
+	err := worker.buildImageWithHubcell(registryTestOpts(t))
+	var rateLimitErr *registryRateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("expected a registry rate limit error, got %v", err)
+	}
+	if reason := worker.stepFailureReason(err, "failed to build image with hubcell"); !strings.HasPrefix(reason, "registry_rate_limited: ") {
+		t.Fatalf("expected registry_rate_limited failure reason, got %q", reason)
+	}
+	if lines := strings.Count(readSudoCalls(t, calls), "\n"); lines != 1 {
+		t.Fatalf("expected no retry when disabled, got %d build attempts", lines)
+	}
+}
+
+func TestRateLimitedImages(t *testing.T) {
+	output := "#2 [internal] load metadata for docker.io/library/node:20\n" +
+		"#3 [internal] load metadata for ghcr.io/acme/base:1\n" +
+		"#3 DONE 0.4s\n" +
+		"#2 ERROR: unexpected status code 429 Too Many Requests fetching manifest\n" +
+		"ERROR: failed to solve: failed to resolve source metadata for docker.io/library/node:20: toomanyrequests: slow down\n"
+	if got := rateLimitedImages(output); strings.Join(got, ",") != "docker.io/library/node:20" {
+		t.Fatalf("expected only the rate-limited node image, got %v", got)
+	}
+}
+
+func TestRateLimitedBuildRetriesOnlyThePull(t *testing.T) {
+	calls := installRateLimitedSudo(t, 1)
+	worker := newTestWorker(t, nil)
+	worker.registryRetryDelay = time.Millisecond
+
+	if err := worker.buildImageWithHubcell(registryTestOpts(t)); err != nil {
+		t.Fatalf("expected the build to succeed after the pull was retried, got %v", err)
+	}
+	attempts := strings.Split(strings.TrimSpace(readSudoCalls(t, calls)), "\n")
+	if len(attempts) != 3 || !strings.HasSuffix(attempts[1], " pull docker.io/library/alpine:3.20") || !strings.Contains(attempts[2], " build ") {
+		t.Fatalf("expected the failed build, the retried pull and the build, got %q", attempts)
+	}
+}
+
+func TestRateLimitedPullIsNotRetriedTwice(t *testing.T) {
+	calls := installRateLimitedSudo(t, 2)
+	worker := newTestWorker(t, nil)
+	worker.registryRetryDelay = time.Millisecond
+
+	err := worker.buildImageWithHubcell(registryTestOpts(t))
+	var rateLimitErr *registryRateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("expected a registry rate limit error, got %v", err)
+	}
+	if lines := strings.Count(readSudoCalls(t, calls), "\n"); lines != 2 {
+		t.Fatalf("expected the build and one pull, got %d commands", lines)
+	}
+}
+
+func TestOtherBuildFailuresAreNotRetried(t *testing.T) {
+	calls := fakeCalls(installFakeBinary(t, "sudo", recordFakeCall+"echo 'exit code: 1' >&2\nexit 1\n"))
+	worker := newTestWorker(t, nil)
+	worker.registryRetryDelay = time.Millisecond
+
+	err := worker.buildImageWithHubcell(registryTestOpts(t))
+	var rateLimitErr *registryRateLimitError
+	if err == nil || errors.As(err, &rateLimitErr) {
+		t.Fatalf("expected a plain build failure, got %v", err)
+	}
+	if lines := strings.Count(readSudoCalls(t, calls), "\n"); lines != 1 {
+		t.Fatalf("expected a single build attempt, got %d", lines)
+	}
+}
@@ -0,0 +1,174 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"hubfly-builder/internal/jobstate"
+	"hubfly-builder/internal/storage"
+)
+
+// resumeFromPostBuild is reported for a resumed job whose image was already
+// built, so only the post-build hooks run again.
+const resumeFromPostBuild = "postbuild"
+
+// ErrJobNotResumable reports a job that has not failed.
+var ErrJobNotResumable = errors.New("job cannot be resumed")
+
+// SetResumeWorkspaceTTL sets how long the workspace of a job that failed after
+// its clone is kept so that a resumed or retried build can reuse it. Zero
+// disables retention, and resumed jobs then always rebuild from the clone.
+func (m *Manager) SetResumeWorkspaceTTL(ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resumeTTL = ttl
+}
+
+// ResumeJob puts a failed job back on the queue. When the failed attempt's
+// workspace was retained the build continues after the last phase it
+// completed; otherwise it starts over from the clone. It returns the phase
+// the build will resume from.
+func (m *Manager) ResumeJob(jobID string) (string, error) {
+	job, err := m.storage.GetJob(jobID)
+	if err != nil {
+		return "", err
+	}
+	session, err := m.storage.GetJobDebugSession(jobID)
+	if err != nil {
+		return "", err
+	}
+	resumeFrom := resumePhase(job, session)
+	if resumeFrom == phaseClone && job.SourceInfo.Credential.Kind() != "" {
+		credential, err := m.storage.GetJobGitCredential(jobID)
+		if err != nil {
+			return "", err
+		}
+		if credential == nil {
+			return "", fmt.Errorf("%w: its git credential has expired; submit a new build", ErrJobNotResumable)
+		}
+	}
+
+	if ok, err := m.storage.TransitionJob(jobID, jobstate.Failed, jobstate.Retry); err != nil {
+		return "", err
+	} else if !ok {
+		return "", fmt.Errorf("%w: it has not failed", ErrJobNotResumable)
+	}
+	log.Printf("Resuming job %s from its %s phase", jobID, resumeFrom)
+	m.publishStatusByID(jobID, jobstate.Pending)
+	m.SignalNewJob()
+	return resumeFrom, nil
+}
+
+// ReapGitCredentials drops the git credentials of failed jobs whose resume
+// TTL has ended, or of every failed job when resumes are disabled, and
+// returns how many it dropped.
+func (m *Manager) ReapGitCredentials(now time.Time) int {
+	m.mu.Lock()
+	resumeTTL := m.resumeTTL
+	m.mu.Unlock()
+	cleared, err := m.storage.ClearExpiredGitCredentials(now.Add(-resumeTTL))
+	if err != nil {
+		log.Printf("ERROR: could not clear expired git credentials: %v", err)
+		return 0
+	}
+	return int(cleared)
+}
+
+// resumePhase is the phase a resumed build of job starts with, given the
+// workspace its failed attempt left behind.
+func resumePhase(job *storage.BuildJob, session *storage.DebugSession) string {
+	if session == nil || !isJobWorkspacePath(session.Workspace) || !dirExists(session.Workspace) {
+		return phaseClone
+	}
+	switch {
+	case job.LastCheckpoint == phaseBuild && job.ImageTag != "":
+		return resumeFromPostBuild
+	case job.LastCheckpoint != "":
+		return phasePrebuild
+	}
+	return phaseClone
+}
+
+// recordCheckpoint stores the last phase the build completed.
+func (w *Worker) recordCheckpoint(checkpoint string) {
+	w.job.LastCheckpoint = checkpoint
+	if err := w.storage.UpdateJobCheckpoint(w.job.ID, checkpoint); err != nil {
+		w.log("WARNING: could not record build checkpoint: %v", err)
+	}
+}
+
+// resumeWorkspace takes over the workspace a failed attempt of the job left
+// behind and returns the last phase that attempt completed. It returns ""
+// when there is nothing to resume and the build starts from the clone.
+func (w *Worker) resumeWorkspace() string {
+	if w.job.LastCheckpoint == "" {
+		return ""
+	}
+	session, err := w.storage.GetJobDebugSession(w.job.ID)
+	if err != nil {
+		w.log("WARNING: could not load the workspace of the failed attempt: %v", err)
+	}
+	resumeFrom := resumePhase(w.job, session)
+	if resumeFrom == phaseClone {
+		w.logInfo("The workspace of the failed attempt is gone; rebuilding from the clone")
+		w.recordCheckpoint("")
+		return ""
+	}
+	// The retained workspace belongs to this build again, so the reaper
+	// must not remove it.
+	if err := w.storage.ClearJobDebugSession(w.job.ID); err != nil {
+		w.log("WARNING: could not release the retained workspace: %v", err)
+	}
+	w.workDir = session.Workspace
+
+	if resumeFrom == resumeFromPostBuild {
+		w.logInfo("Resuming after the image build; %s was built by the failed attempt", w.job.ImageTag)
+		return phaseBuild
+	}
+	if err := w.restoreClonedWorkspace(); err != nil {
+		w.log("WARNING: could not restore the workspace of the failed attempt: %v; rebuilding from the clone", err)
+		os.RemoveAll(w.workDir)
+		w.workDir = ""
+		w.recordCheckpoint("")
+		return ""
+	}
+	w.logInfo("Resuming from the workspace of the failed attempt; skipping the clone")
+	return phaseClone
+}
+
+// restoreClonedWorkspace discards everything the failed attempt changed in
+// the checked-out source, such as generated Dockerfiles and pruned files.
+func (w *Worker) restoreClonedWorkspace() error {
+	if err := w.executeCommand(w.execCommand("git", "-C", w.workDir, "reset", "--hard", "--quiet")); err != nil {
+		return err
+	}
+	return w.executeCommand(w.execCommand("git", "-C", w.workDir, "clean", "-ffdxq"))
+}
+
+// retainResumeWorkspace keeps the workspace of a job that failed after its
+// clone for the resume TTL, recorded like a debug workspace so the reaper
+// removes it once the TTL has passed.
+func (w *Worker) retainResumeWorkspace() {
+	if w.resumeTTL <= 0 || w.workDir == "" || w.keepWorkspace || w.job.LastCheckpoint == "" {
+		return
+	}
+	session := storage.DebugSession{
+		Workspace: w.workDir,
+		Command:   w.lastBuildCommand,
+		ExpiresAt: time.Now().Add(w.resumeTTL),
+	}
+	if err := w.storage.SetJobDebugSession(w.job.ID, session); err != nil {
+		w.log("WARNING: could not retain the workspace for a resume: %v", err)
+		return
+	}
+	w.keepWorkspace = true
+	w.logInfo("Keeping the workspace until %s so the job can be resumed", session.ExpiresAt.UTC().Format(time.RFC3339))
+}
+
+func dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,175 @@
+package executor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"hubfly-builder/internal/allowlist"
+	"hubfly-builder/internal/api"
+	"hubfly-builder/internal/jobstate"
+	"hubfly-builder/internal/logs"
+	"hubfly-builder/internal/storage"
+)
+
+// installGatedSudo records each sudo invocation like installFakeSudo, but
+// fails image builds until gate exists.
+func installGatedSudo(t *testing.T, gate string) string {
+	t.Helper()
+	return fakeCalls(installFakeBinary(t, "sudo", recordFakeCall+"case \" $* \" in *\" build \"*) test -f "+gate+" || exit 1 ;; esac\n"))
+}
+
+// installRecordingGit runs the real git through GIT_CLI_PATH and records its
+// arguments.
+func installRecordingGit(t *testing.T) string {
+	t.Helper()
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+	binDir := installFakeBinary(t, "git-wrapper", recordFakeCall+"exec "+gitPath+" \"$@\"\n")
+	t.Setenv("GIT_CLI_PATH", filepath.Join(binDir, "git-wrapper"))
+	return fakeCalls(binDir)
+}
+
+func newResumeTestManager(t *testing.T, hooks BuildHooks, allowedHooks []string) (*Manager, *storage.Storage, *storage.BuildJob) {
+	t.Helper()
+	repo := writeGitRepository(t, map[string]string{"Dockerfile": "FROM alpine\nCMD [\"true\"]\n"})
+	store := newDedupTestStorage(t)
+	logManager, err := logs.NewLogManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	job := &storage.BuildJob{
+		ID:          "build_resume",
+		ProjectID:   "proj",
+		UserID:      "user",
+		SourceInfo:  storage.SourceInfo{GitRepository: repo},
+		BuildConfig: storage.BuildConfig{Network: "proj-network"},
+	}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	manager := NewManager(store, logManager, &allowlist.AllowedCommands{Hooks: allowedHooks}, api.NewClient(""), 1, "")
+	manager.SetBuildHooks(hooks)
+	manager.SetResumeWorkspaceTTL(time.Hour)
+	return manager, store, job
+}
+
+func runResumeTestJob(t *testing.T, manager *Manager, store *storage.Storage, id string, want jobstate.Status) *storage.BuildJob {
+	t.Helper()
+	manager.tryToDispatchJob()
+	waitFor(t, "the worker to stop", func() bool {
+		return len(manager.GetActiveBuilds()) == 0
+	})
+	job, err := store.GetJob(id)
+	if err != nil || job.Status != string(want) {
+		t.Fatalf("expected the job to end %s, got %+v (err %v)", want, job, err)
+	}
+	return job
+}
+
+func TestResumeJobRerunsOnlyPostBuildHooks(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	sudoCalls := installFakeSudo(t)
+	registryUp := filepath.Join(t.TempDir(), "registry-up")
+	pushHook := "test -f " + registryUp
+	manager, store, job := newResumeTestManager(t, BuildHooks{PostBuild: []string{pushHook}, FailOnPostBuildError: true}, []string{pushHook})
+
+	failed := runResumeTestJob(t, manager, store, job.ID, jobstate.Failed)
+	if failed.LastCheckpoint != phaseBuild || failed.ImageTag == "" {
+		t.Fatalf("expected the failed job to be checkpointed after its build, got %+v", failed)
+	}
+	session, err := store.GetJobDebugSession(job.ID)
+	if err != nil || session == nil || !dirExists(session.Workspace) {
+		t.Fatalf("expected the workspace to be retained, got %+v (err %v)", session, err)
+	}
+	builds := strings.Count(readSudoCalls(t, sudoCalls), " build ")
+
+	gitCalls := installRecordingGit(t)
+	if err := os.WriteFile(registryUp, nil, 0o644); err != nil {
+		t.Fatalf("failed to write gate: %v", err)
+	}
+	resumeFrom, err := manager.ResumeJob(job.ID)
+	if err != nil || resumeFrom != resumeFromPostBuild {
+		t.Fatalf("expected the job to resume from its post-build hooks, got %q (err %v)", resumeFrom, err)
+	}
+	resumed := runResumeTestJob(t, manager, store, job.ID, jobstate.Success)
+
+	if resumed.ImageTag != failed.ImageTag {
+		t.Fatalf("expected the resumed job to keep image %s, got %s", failed.ImageTag, resumed.ImageTag)
+	}
+	if got := strings.Count(readSudoCalls(t, sudoCalls), " build "); got != builds {
+		t.Fatalf("expected no image build on resume, got %d builds after %d", got, builds)
+	}
+	if _, err := os.Stat(gitCalls); !os.IsNotExist(err) {
+		t.Fatalf("expected no git command on resume, got %v", err)
+	}
+	if _, err := os.Stat(session.Workspace); !os.IsNotExist(err) {
+		t.Fatalf("expected the workspace to be removed after the resumed build, got %v", err)
+	}
+}
+
+func TestResumeJobAfterBuildFailureSkipsClone(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	buildable := filepath.Join(t.TempDir(), "buildable")
+	installGatedSudo(t, buildable)
+	manager, store, job := newResumeTestManager(t, BuildHooks{}, nil)
+
+	failed := runResumeTestJob(t, manager, store, job.ID, jobstate.Failed)
+	if failed.LastCheckpoint != phaseClone {
+		t.Fatalf("expected the failed job to be checkpointed after its clone, got %q", failed.LastCheckpoint)
+	}
+
+	gitCalls := installRecordingGit(t)
+	if err := os.WriteFile(buildable, nil, 0o644); err != nil {
+		t.Fatalf("failed to write gate: %v", err)
+	}
+	if resumeFrom, err := manager.ResumeJob(job.ID); err != nil || resumeFrom != phasePrebuild {
+		t.Fatalf("expected the job to resume from its prebuild phase, got %q (err %v)", resumeFrom, err)
+	}
+	runResumeTestJob(t, manager, store, job.ID, jobstate.Success)
+
+	data, err := os.ReadFile(gitCalls)
+	if err != nil {
+		t.Fatalf("expected the workspace to be restored with git: %v", err)
+	}
+	if strings.Contains(string(data), "clone") || !strings.Contains(string(data), "clean -ffdxq") {
+		t.Fatalf("expected a restore without a clone, got git calls:\n%s", data)
+	}
+}
+
+func TestResumeJobRebuildsWithoutRetainedWorkspace(t *testing.T) {
+	store := newDedupTestStorage(t)
+	job := &storage.BuildJob{ID: "build_gone", ProjectID: "proj", UserID: "user", ImageTag: "hubcell.local/user/proj:build_gone"}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	manager := NewManager(store, nil, nil, nil, 1, "")
+
+	if _, err := manager.ResumeJob(job.ID); !errors.Is(err, ErrJobNotResumable) {
+		t.Fatalf("expected a pending job to be refused, got %v", err)
+	}
+
+	if err := store.UpdateJobCheckpoint(job.ID, phaseBuild); err != nil {
+		t.Fatalf("failed to record checkpoint: %v", err)
+	}
+	if err := store.UpdateJobStatus(job.ID, string(jobstate.Failed)); err != nil {
+		t.Fatalf("failed to update status: %v", err)
+	}
+	resumeFrom, err := manager.ResumeJob(job.ID)
+	if err != nil || resumeFrom != phaseClone {
+		t.Fatalf("expected a rebuild from the clone, got %q (err %v)", resumeFrom, err)
+	}
+	if stored, _ := store.GetJob(job.ID); stored.Status != string(jobstate.Pending) {
+		t.Fatalf("expected the job back on the queue, got %s", stored.Status)
+	}
+}
@@ -0,0 +1,60 @@
+package executor
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	defaultRetryBaseDelay = 30 * time.Second
+	maxRetryDelay         = 30 * time.Minute
+)
+
+// ErrTransientFailure marks a build that failed for reasons outside the job,
+// such as a network error while cloning or a throttled registry. Only such
+// failures are retried.
+var ErrTransientFailure = errors.New("transient failure")
+
+// RetryPolicy controls how failed builds are retried. A job is retried at most
+// MaxRetries times, and only after a transient failure. The n-th retry waits
+// BaseDelay * 2^(n-1), capped at 30 minutes.
+type RetryPolicy struct {
+	MaxRetries int
+	BaseDelay  time.Duration
+}
+
+// SetRetryPolicy enables retries of transiently failed builds. The zero policy
+// never retries.
+func (m *Manager) SetRetryPolicy(policy RetryPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retry = policy
+}
+
+// delay returns how long a job that has already been retried retryCount times
+// waits before its next attempt.
+func (p RetryPolicy) delay(retryCount int) time.Duration {
+	base := p.BaseDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	delay := base
+	for i := 0; i < retryCount && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+func isTransientFailure(err error) bool {
+	var rateLimitErr *registryRateLimitError
+	return errors.Is(err, ErrTransientFailure) || errors.As(err, &rateLimitErr)
+}
+
+// willRetry reports whether the manager will queue the failed job again: its
+// failure was transient and it has retries left.
+func (w *Worker) willRetry() bool {
+	return w.transientFailure && w.job.RetryCount < w.retry.MaxRetries
+}
@@ -0,0 +1,178 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"hubfly-builder/internal/api"
+	"hubfly-builder/internal/jobstate"
+	"hubfly-builder/internal/storage"
+)
+
+func TestRetryPolicyDelayDoublesUpToTheCap(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 10, BaseDelay: time.Minute}
+	for retryCount, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 16 * time.Minute, 30 * time.Minute, 30 * time.Minute} {
+		if got := policy.delay(retryCount); got != want {
+			t.Fatalf("delay(%d) = %s, want %s", retryCount, got, want)
+		}
+	}
+	if got := (RetryPolicy{}).delay(0); got != defaultRetryBaseDelay {
+		t.Fatalf("expected the default base delay, got %s", got)
+	}
+}
+
+func TestIsTransientFailure(t *testing.T) {
+	cases := map[error]bool{
+		fmt.Errorf("%w: clone failed after 3 attempts: exit status 128", ErrTransientFailure): true,
+		&registryRateLimitError{err: errors.New("exit status 1")}:                             true,
+		errors.New("command not allowed: curl"):                                               false,
+		&phaseTimeoutError{phase: phaseBuild, budget: time.Second, err: errors.New("killed")}: false,
+	}
+	for err, want := range cases {
+		if got := isTransientFailure(err); got != want {
+			t.Fatalf("isTransientFailure(%v) = %t, want %t", err, got, want)
+		}
+	}
+}
+
+func newFailedRetryTestJob(t *testing.T, store *storage.Storage, id string) {
+	t.Helper()
+	job := &storage.BuildJob{
+		ID:        id,
+		ProjectID: "proj",
+		UserID:    "user",
+		SourceInfo: storage.SourceInfo{
+			GitRepository: "https://example.com/private.git",
+			Credential:    &storage.GitCredential{Token: "secret-token"},
+		},
+	}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	if ok, err := store.TransitionJob(id, jobstate.Pending, jobstate.Fail); err != nil || !ok {
+		t.Fatalf("failed to fail job: %v", err)
+	}
+}
+
+func TestHandleFailedJobRetriesTransientFailureAfterBackoff(t *testing.T) {
+	store := newDedupTestStorage(t)
+	newFailedRetryTestJob(t, store, "build_flaky")
+	manager := NewManager(store, nil, nil, nil, 1, "")
+	manager.SetRetryPolicy(RetryPolicy{MaxRetries: 2, BaseDelay: time.Hour})
+
+	manager.handleFailedJob(&storage.BuildJob{ID: "build_flaky"}, true)
+
+	stored, err := store.GetJob("build_flaky")
+	if err != nil || stored.Status != string(jobstate.Pending) || stored.RetryCount != 1 {
+		t.Fatalf("expected the job back in the queue after one retry, got %+v (err %v)", stored, err)
+	}
+	if credential, err := store.GetJobGitCredential("build_flaky"); err != nil || credential == nil {
+		t.Fatalf("expected the credential to be kept for the retry, got %+v (err %v)", credential, err)
+	}
+	if job, err := manager.claimNextJob(nil, BuildAffinity{}, ClaimLease{}); !errors.Is(err, storage.ErrNoPendingJob) {
+		t.Fatalf("expected the job to wait out its backoff, got %+v (err %v)", job, err)
+	}
+}
+
+func TestHandleFailedJobDoesNotRetryDeterministicFailure(t *testing.T) {
+	store := newDedupTestStorage(t)
+	newFailedRetryTestJob(t, store, "build_broken")
+	manager := NewManager(store, nil, nil, nil, 1, "")
+	manager.SetRetryPolicy(RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond})
+
+	manager.handleFailedJob(&storage.BuildJob{ID: "build_broken"}, false)
+
+	stored, err := store.GetJob("build_broken")
+	if err != nil || stored.Status != string(jobstate.Failed) || stored.RetryCount != 0 {
+		t.Fatalf("expected the job to stay failed, got %+v (err %v)", stored, err)
+	}
+	if credential, err := store.GetJobGitCredential("build_broken"); err != nil || credential != nil {
+		t.Fatalf("expected the credential to be cleared, got %+v (err %v)", credential, err)
+	}
+}
+
+func TestHandleFailedJobKeepsCredentialForResumeTTL(t *testing.T) {
+	store := newDedupTestStorage(t)
+	newFailedRetryTestJob(t, store, "build_resumable")
+	manager := NewManager(store, nil, nil, nil, 1, "")
+	manager.SetResumeWorkspaceTTL(time.Hour)
+
+	manager.handleFailedJob(&storage.BuildJob{ID: "build_resumable"}, false)
+
+	if credential, err := store.GetJobGitCredential("build_resumable"); err != nil || credential == nil {
+		t.Fatalf("expected the credential to be kept for a resume, got %+v (err %v)", credential, err)
+	}
+	if cleared := manager.ReapGitCredentials(time.Now()); cleared != 0 {
+		t.Fatalf("expected no credential to expire within the resume TTL, cleared %d", cleared)
+	}
+	if cleared := manager.ReapGitCredentials(time.Now().Add(2 * time.Hour)); cleared != 1 {
+		t.Fatalf("expected the credential to expire after the resume TTL, cleared %d", cleared)
+	}
+	if _, err := manager.ResumeJob("build_resumable"); !errors.Is(err, ErrJobNotResumable) {
+		t.Fatalf("expected a resume that needs a clone without a credential to be refused, got %v", err)
+	}
+}
+
+func TestHandleFailedJobStopsAtMaxRetries(t *testing.T) {
+	store := newDedupTestStorage(t)
+	newFailedRetryTestJob(t, store, "build_exhausted")
+	manager := NewManager(store, nil, nil, nil, 1, "")
+	manager.SetRetryPolicy(RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond})
+
+	manager.handleFailedJob(&storage.BuildJob{ID: "build_exhausted"}, true)
+	if ok, err := store.TransitionJob("build_exhausted", jobstate.Pending, jobstate.Fail); err != nil || !ok {
+		t.Fatalf("failed to fail the retried job: %v", err)
+	}
+	manager.handleFailedJob(&storage.BuildJob{ID: "build_exhausted"}, true)
+
+	stored, err := store.GetJob("build_exhausted")
+	if err != nil || stored.Status != string(jobstate.Failed) || stored.RetryCount != 1 {
+		t.Fatalf("expected the job to stay failed after its only retry, got %+v (err %v)", stored, err)
+	}
+}
+
+func TestFailJobReportsFailureOnlyOnceRetriesAreSpent(t *testing.T) {
+	var mu sync.Mutex
+	var results []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload api.ReportPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err == nil && payload.Type == api.PayloadTypeResult {
+			mu.Lock()
+			results = append(results, payload.ID+"="+payload.Status)
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	store := newDedupTestStorage(t)
+
+	for id, retryCount := range map[string]int{"build_retrying": 1, "build_spent": 2} {
+		if err := store.CreateJob(&storage.BuildJob{ID: id, ProjectID: "proj", UserID: id}); err != nil {
+			t.Fatalf("failed to create job: %v", err)
+		}
+		if ok, err := store.TransitionJob(id, jobstate.Pending, jobstate.Claim); err != nil || !ok {
+			t.Fatalf("failed to claim job: %v", err)
+		}
+		worker := newTestWorker(t, nil)
+		worker.job = &storage.BuildJob{ID: id, ProjectID: "proj", UserID: id, RetryCount: retryCount}
+		worker.storage = store
+		worker.apiClient = api.NewClient(server.URL)
+		worker.retry = RetryPolicy{MaxRetries: 2}
+		worker.transientFailure = true
+		if err := worker.failJob("network unreachable"); !errors.Is(err, ErrTransientFailure) {
+			t.Fatalf("expected a transient failure for %s, got %v", id, err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(results) != 1 || results[0] != "build_spent=failed" {
+		t.Fatalf("expected only the job without retries left to report failure, got %v", results)
+	}
+}
@@ -0,0 +1,162 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"hubfly-builder/internal/driver"
+)
+
+const (
+	// selfTestImageTag is the scratch tag every self-test builds, so repeated
+	// runs replace one image instead of accumulating them.
+	selfTestImageTag = "hubfly-builder/selftest:latest"
+	// selfTestTimeout bounds a self-test whose build has no phase budget.
+	selfTestTimeout = 5 * time.Minute
+	// selfTestDockerfile builds in seconds from a small public base image.
+	selfTestDockerfile = "FROM busybox:stable\nRUN echo hubfly-builder self-test > /selftest\n"
+)
+
+// ErrSelfTestRunning is returned when a self-test is started while another
+// one is still running.
+var ErrSelfTestRunning = errors.New("a self-test is already running")
+
+// SelfTestStep is one stage of a self-test build.
+type SelfTestStep struct {
+	Name       string `json:"name"`
+	Success    bool   `json:"success"`
+	Skipped    bool   `json:"skipped,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+	Error      string `json:"error,omitempty"`
+}
+
+// SelfTestResult reports a self-test build. Success is true only when every
+// step that ran succeeded.
+type SelfTestResult struct {
+	Success    bool           `json:"success"`
+	ImageTag   string         `json:"imageTag"`
+	DurationMs int64          `json:"durationMs"`
+	Steps      []SelfTestStep `json:"steps"`
+}
+
+// SelfTest builds a canned Dockerfile with Hubcell to check that this
+// deployment can build at all. It skips the clone, generates the Dockerfile
+// in a fresh workspace, builds it to a scratch tag and, when an image checker
+// is configured, looks the image up. The self-test does not take a build slot
+// and records no job.
+func (m *Manager) SelfTest(ctx context.Context) (SelfTestResult, error) {
+	m.mu.Lock()
+	if m.selfTestRunning {
+		m.mu.Unlock()
+		return SelfTestResult{}, ErrSelfTestRunning
+	}
+	m.selfTestRunning = true
+	budget := m.phases.Build
+	checker := m.imageChecker
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.selfTestRunning = false
+		m.mu.Unlock()
+	}()
+
+	if budget <= 0 {
+		budget = selfTestTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, budget)
+	defer cancel()
+
+	result := SelfTestResult{ImageTag: selfTestImageTag, Success: true}
+	started := time.Now()
+	run := func(name string, step func() error) bool {
+		stepStarted := time.Now()
+		err := step()
+		entry := SelfTestStep{Name: name, Success: err == nil, DurationMs: time.Since(stepStarted).Milliseconds()}
+		if err != nil {
+			entry.Error = err.Error()
+			result.Success = false
+		}
+		result.Steps = append(result.Steps, entry)
+		return err == nil
+	}
+
+	result.Steps = append(result.Steps, SelfTestStep{Name: phaseClone, Success: true, Skipped: true})
+
+	var workDir string
+	defer func() {
+		if workDir != "" {
+			os.RemoveAll(workDir)
+		}
+	}()
+	if !run("generate", func() error {
+		var err error
+		if workDir, err = os.MkdirTemp("", workspacePrefix+"selftest-"); err != nil {
+			return fmt.Errorf("could not create workspace: %w", err)
+		}
+		return os.WriteFile(filepath.Join(workDir, "Dockerfile"), []byte(selfTestDockerfile), 0o644)
+	}) {
+		return finishSelfTest(result, started), nil
+	}
+
+	cpu, memoryMB := defaultHubcellResourceLimits()
+	opts := driver.HubcellBuildOpts{
+		HubcellPath: hubcellCLIPathFromEnv(),
+		WorkDir:     workDir,
+		ContextPath: ".",
+		ImageTag:    selfTestImageTag,
+		MemoryBytes: memoryMBToBytes(memoryMB),
+		CPUPeriod:   defaultHubcellCPUPeriod,
+		CPUQuota:    cpuToQuota(cpu, defaultHubcellCPUPeriod),
+	}
+	applyDefaultHubcellRootfs(&opts)
+	if !run(phaseBuild, func() error {
+		output, err := driver.HubcellBuildCommandContext(ctx, opts).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("hubcell build failed: %v: %s", err, lastLines(string(output), 10))
+		}
+		return nil
+	}) {
+		return finishSelfTest(result, started), nil
+	}
+
+	if checker == nil {
+		result.Steps = append(result.Steps, SelfTestStep{Name: "verify", Success: true, Skipped: true})
+	} else {
+		run("verify", func() error {
+			exists, err := checker.ImageExists(ctx, selfTestImageTag)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("image %s is missing after a successful build", selfTestImageTag)
+			}
+			return nil
+		})
+	}
+	return finishSelfTest(result, started), nil
+}
+
+func finishSelfTest(result SelfTestResult, started time.Time) SelfTestResult {
+	result.DurationMs = time.Since(started).Milliseconds()
+	if result.Success {
+		log.Printf("Self-test build succeeded in %dms", result.DurationMs)
+	} else {
+		log.Printf("ERROR: self-test build failed after %dms: %s", result.DurationMs, result.Steps[len(result.Steps)-1].Error)
+	}
+	return result
+}
+
+// lastLines keeps the last n lines of command output for an error message.
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,53 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSelfTestBuildsTheCannedDockerfile(t *testing.T) {
+	calls := installFakeSudo(t)
+	m := NewManager(nil, nil, nil, nil, 1, "")
+
+	result, err := m.SelfTest(context.Background())
+	if err != nil {
+		t.Fatalf("SelfTest returned error: %v", err)
+	}
+	if !result.Success || result.ImageTag != selfTestImageTag {
+		t.Fatalf("expected a successful self-test of %s, got %+v", selfTestImageTag, result)
+	}
+	var names []string
+	for _, step := range result.Steps {
+		names = append(names, step.Name)
+	}
+	if got := strings.Join(names, ","); got != "clone,generate,build,verify" {
+		t.Fatalf("unexpected steps %s", got)
+	}
+	recorded, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatalf("failed to read sudo calls: %v", err)
+	}
+	if !strings.Contains(string(recorded), "build --verbose") || !strings.Contains(string(recorded), "-t "+selfTestImageTag) {
+		t.Fatalf("expected a hubcell build of the scratch tag, got %q", recorded)
+	}
+}
+
+func TestSelfTestReportsAFailedBuild(t *testing.T) {
+	installFakeBinary(t, "sudo", "echo 'cannot reach buildkit' >&2\nexit 1\n")
+	m := NewManager(nil, nil, nil, nil, 1, "")
+	m.SetImageChecker(fakeImageChecker{})
+
+	result, err := m.SelfTest(context.Background())
+	if err != nil {
+		t.Fatalf("SelfTest returned error: %v", err)
+	}
+	if result.Success {
+		t.Fatalf("expected the self-test to fail, got %+v", result)
+	}
+	last := result.Steps[len(result.Steps)-1]
+	if last.Name != "build" || !strings.Contains(last.Error, "cannot reach buildkit") {
+		t.Fatalf("expected the build step to fail with the hubcell output, got %+v", last)
+	}
+}
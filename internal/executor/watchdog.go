@@ -0,0 +1,115 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"hubfly-builder/internal/jobstate"
+)
+
+const wallClockTimeoutCode = "wall_clock_timeout"
+
+// ErrWallClockExceeded is the cancellation cause of a build stopped by the
+// wall-clock watchdog.
+var ErrWallClockExceeded = errors.New("build exceeded the wall-clock limit")
+
+// watchdogGrace is how long a build killed by the watchdog has to return
+// before the watchdog abandons it and fails the job itself.
+var watchdogGrace = 30 * time.Second
+
+// SetMaxBuildDuration sets the absolute wall-clock limit of any build,
+// whatever its phase and job timeouts say. A build still running once it has
+// passed is killed and fails with wall_clock_timeout. Zero disables the
+// watchdog.
+func (m *Manager) SetMaxBuildDuration(limit time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxBuildDuration = limit
+}
+
+// startWatchdog arms the wall-clock limit. Once it has passed, cancel is
+// called with ErrWallClockExceeded, which kills the running command, and the
+// returned channel is closed. stop disarms the watchdog.
+func (w *Worker) startWatchdog(cancel context.CancelCauseFunc) (expired <-chan struct{}, stop func()) {
+	if w.maxBuildDuration <= 0 {
+		return nil, func() {}
+	}
+	fired := make(chan struct{})
+	timer := time.AfterFunc(w.maxBuildDuration, func() {
+		log.Printf("WARN: job %s exceeded the %s wall-clock limit; killing the build", w.job.ID, w.maxBuildDuration)
+		cancel(ErrWallClockExceeded)
+		close(fired)
+	})
+	return fired, func() { timer.Stop() }
+}
+
+// superviseBuild runs build with the job log open. When the watchdog fires
+// and build does not return within watchdogGrace, for example because a step
+// ignores cancellation, the build is abandoned and the job failed from here
+// so that its build slot is freed.
+func (w *Worker) superviseBuild(build func() error, expired <-chan struct{}) error {
+	if expired == nil {
+		return w.runLogged(build)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- w.runLogged(build)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-expired:
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(watchdogGrace):
+	}
+	if !w.resolve() {
+		// The build got as far as reporting its outcome after all.
+		return <-done
+	}
+	return w.abandonBuild()
+}
+
+// resolve reports whether the caller is the first to settle the job's
+// outcome, and so the one to transition it and send its result callback. An
+// abandoned build keeps running, and must stay silent once the watchdog
+// has failed the job.
+func (w *Worker) resolve() bool {
+	return w.resolved.CompareAndSwap(false, true)
+}
+
+// abandonedByWatchdog ends the build the watchdog abandoned, without
+// touching the job or reporting a second result.
+func (w *Worker) abandonedByWatchdog() error {
+	log.Printf("WARN: abandoned build for job %s returned; the watchdog already failed the job", w.job.ID)
+	return fmt.Errorf("%w: job %s was abandoned by the watchdog", ErrBuildFailed, w.job.ID)
+}
+
+func (w *Worker) abandonBuild() error {
+	reason := w.wallClockReason()
+	log.Printf("ERROR: build for job %s did not stop within %s of being killed; abandoning it", w.job.ID, watchdogGrace)
+	if ok, err := w.transitionStatus(jobstate.Fail, jobstate.Building, jobstate.Claimed, jobstate.Cancelling); err != nil {
+		log.Printf("ERROR: could not update job status to 'failed' for job %s: %v", w.job.ID, err)
+	} else if !ok {
+		log.Printf("WARN: job %s is no longer in progress; leaving its status unchanged", w.job.ID)
+	}
+	if err := w.apiClient.ReportResult(w.job, "failed", reason); err != nil {
+		log.Printf("ERROR: could not report result to backend for job %s: %v", w.job.ID, err)
+	}
+	return fmt.Errorf("%w: %s", ErrBuildFailed, reason)
+}
+
+// wallClockExceeded reports whether the watchdog stopped the build.
+func (w *Worker) wallClockExceeded() bool {
+	return w.ctx != nil && errors.Is(context.Cause(w.ctx), ErrWallClockExceeded)
+}
+
+func (w *Worker) wallClockReason() string {
+	return fmt.Sprintf("%s: build exceeded the builder's %s wall-clock limit", wallClockTimeoutCode, w.maxBuildDuration)
+}
@@ -0,0 +1,148 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"hubfly-builder/internal/api"
+	"hubfly-builder/internal/jobstate"
+	"hubfly-builder/internal/storage"
+)
+
+// newWatchdogTestWorker returns a worker for a job that is building, with
+// the watchdog armed to fire after limit. The build context is cancelled
+// when the test ends.
+func newWatchdogTestWorker(t *testing.T, limit time.Duration) (*Worker, <-chan struct{}) {
+	t.Helper()
+	store := newDedupTestStorage(t)
+	job := &storage.BuildJob{ID: "build_watchdog", ProjectID: "proj", UserID: "user"}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	if ok, err := store.TransitionJob(job.ID, jobstate.Pending, jobstate.Claim); err != nil || !ok {
+		t.Fatalf("failed to claim job: %v", err)
+	}
+	if ok, err := store.TransitionJob(job.ID, jobstate.Claimed, jobstate.Start); err != nil || !ok {
+		t.Fatalf("failed to start job: %v", err)
+	}
+	logFile, err := os.Create(filepath.Join(t.TempDir(), "build.log"))
+	if err != nil {
+		t.Fatalf("failed to create log file: %v", err)
+	}
+
+	w := newTestWorker(t, job)
+	w.storage = store
+	w.apiClient = api.NewClient("")
+	w.logFile = logFile
+	w.maxBuildDuration = limit
+	ctx, kill := context.WithCancelCause(context.Background())
+	t.Cleanup(func() { kill(nil) })
+	expired, stop := w.startWatchdog(kill)
+	t.Cleanup(stop)
+	w.ctx = ctx
+	return w, expired
+}
+
+func TestWatchdogKillsBuildRunningPastTheWallClockLimit(t *testing.T) {
+	w, expired := newWatchdogTestWorker(t, 200*time.Millisecond)
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	started := time.Now()
+	err := w.superviseBuild(func() error {
+		if err := w.executeCommand(w.execCommand("sleep", "30")); err != nil {
+			return w.failForStep(err, "failed to build image with hubcell")
+		}
+		return w.succeedJob()
+	}, expired)
+	if !errors.Is(err, ErrBuildFailed) {
+		t.Fatalf("expected the build to fail, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Fatalf("expected the watchdog to kill the command, build ran %s", elapsed)
+	}
+	if !strings.Contains(logged.String(), "wall_clock_timeout: build exceeded the builder's 200ms wall-clock limit") {
+		t.Fatalf("expected a wall_clock_timeout failure, got:\n%s", logged.String())
+	}
+	if job, err := w.storage.GetJob(w.job.ID); err != nil || job.Status != string(jobstate.Failed) {
+		t.Fatalf("expected the job to be failed, got %+v (err %v)", job, err)
+	}
+}
+
+func TestWatchdogAbandonsBuildIgnoringCancellation(t *testing.T) {
+	grace := watchdogGrace
+	watchdogGrace = 100 * time.Millisecond
+	defer func() { watchdogGrace = grace }()
+	w, expired := newWatchdogTestWorker(t, 100*time.Millisecond)
+
+	release := make(chan struct{})
+	defer close(release)
+	err := w.superviseBuild(func() error {
+		// A step that never looks at the build context.
+		<-release
+		return nil
+	}, expired)
+	if !errors.Is(err, ErrBuildFailed) || !strings.Contains(err.Error(), "wall_clock_timeout") {
+		t.Fatalf("expected the abandoned build to fail with wall_clock_timeout, got %v", err)
+	}
+	if job, err := w.storage.GetJob(w.job.ID); err != nil || job.Status != string(jobstate.Failed) {
+		t.Fatalf("expected the job to be failed, got %+v (err %v)", job, err)
+	}
+}
+
+func TestAbandonedBuildDoesNotReportAfterTheWatchdog(t *testing.T) {
+	grace := watchdogGrace
+	watchdogGrace = 100 * time.Millisecond
+	defer func() { watchdogGrace = grace }()
+	var mu sync.Mutex
+	var results []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload api.ReportPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err == nil && payload.Type == api.PayloadTypeResult {
+			mu.Lock()
+			results = append(results, payload.Status)
+			mu.Unlock()
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	w, expired := newWatchdogTestWorker(t, 100*time.Millisecond)
+	w.apiClient = api.NewClient(server.URL)
+
+	release := make(chan struct{})
+	returned := make(chan error, 1)
+	err := w.superviseBuild(func() error {
+		<-release
+		err := w.succeedJob()
+		returned <- err
+		return err
+	}, expired)
+	if !errors.Is(err, ErrBuildFailed) {
+		t.Fatalf("expected the abandoned build to fail, got %v", err)
+	}
+	close(release)
+	if err := <-returned; !errors.Is(err, ErrBuildFailed) {
+		t.Fatalf("expected the late success to be dropped, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(results) != 1 || results[0] != "failed" {
+		t.Fatalf("expected only the watchdog's failure to be reported, got %v", results)
+	}
+	if job, err := w.storage.GetJob(w.job.ID); err != nil || job.Status != string(jobstate.Failed) {
+		t.Fatalf("expected the job to stay failed, got %+v (err %v)", job, err)
+	}
+}
@@ -8,12 +8,15 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime/debug"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"hubfly-builder/internal/allowlist"
@@ -22,14 +25,17 @@ import (
 	"hubfly-builder/internal/dockerfileparams"
 	"hubfly-builder/internal/driver"
 	"hubfly-builder/internal/envplan"
+	"hubfly-builder/internal/events"
+	"hubfly-builder/internal/gitauth"
+	"hubfly-builder/internal/jobstate"
 	"hubfly-builder/internal/logs"
+	"hubfly-builder/internal/metrics"
 	"hubfly-builder/internal/storage"
 )
 
 var ErrBuildFailed = errors.New("build failed")
 
 const (
-	defaultBuildTimeout         = 15 * time.Minute
 	defaultHubcellCPUPeriod     = int64(100000)
 	defaultHubcellRootfsInitial = "10g"
 	defaultHubcellCPU           = 2.0
@@ -39,16 +45,79 @@ const (
 )
 
 type Worker struct {
-	job        *storage.BuildJob
-	storage    *storage.Storage
-	logManager *logs.LogManager
-	allowlist  *allowlist.AllowedCommands
-	apiClient  *api.Client
-	logFile    *os.File
-	logWriter  io.Writer
-	workDir    string
-	ctx        context.Context
-	cancel     context.CancelFunc
+	job           *storage.BuildJob
+	storage       *storage.Storage
+	logManager    *logs.LogManager
+	allowlist     *allowlist.AllowedCommands
+	apiClient     *api.Client
+	hooks         BuildHooks
+	phases        PhaseTimeouts
+	dedupContexts bool
+	pruneContexts bool
+	scopeContexts bool
+	debugTTL      time.Duration
+	resumeTTL     time.Duration
+	eventBus      *events.Bus
+	progress      *buildProgress
+	queueWait     *metrics.Histogram
+	// dnsServers are the builder's default build resolvers.
+	dnsServers []string
+	gitAuth    *gitauth.Session
+	// secretResolver resolves the job's git credential reference, if any.
+	secretResolver gitauth.SecretResolver
+	// registryRetryDelay is how long to wait before retrying an image pull or
+	// push that hit a registry rate limit; zero disables the retry.
+	registryRetryDelay time.Duration
+	buildOptAllowlist  []string
+	logVerbosity       LogVerbosity
+	maxBuildDuration   time.Duration
+	// resolved is set by whichever of the build and the watchdog reports the
+	// job's outcome first; see resolve.
+	resolved atomic.Bool
+	// cacheRegistry holds the per-project build cache images; empty disables
+	// the build cache.
+	cacheRegistry string
+	// registryCredentials are keyed by registry host.
+	registryCredentials map[string]RegistryCredential
+	// imageBuildSlots is shared by all workers and bounds concurrent image
+	// builds; nil means no limit.
+	imageBuildSlots chan struct{}
+	// secretScanMode sets how the build context is scanned for committed
+	// secrets; files matching secretScanAllowlist globs are skipped.
+	secretScanMode      SecretScanMode
+	secretScanAllowlist []string
+	// secretRedactor masks build secret values in command output.
+	secretRedactor *strings.Replacer
+	// buildCacheRef is the cache image of the current build, and
+	// buildCacheImportFailed records that its last run could not import it.
+	buildCacheRef          string
+	buildCacheImportFailed bool
+	keepWorkspace          bool
+	// lastBuildCommand is the redacted image build command, kept for debug
+	// sessions.
+	lastBuildCommand string
+	// registryPushes are the outcomes of the pushes to buildConfig.registries.
+	registryPushes storage.RegistryPushes
+	// retry is the manager's retry policy, which decides whether a failed
+	// build is reported or queued again.
+	retry RetryPolicy
+	// claimOwner names this instance in the job's claim; the worker's status
+	// changes apply only while the claim is still its own.
+	claimOwner string
+	// transientFailure records that the step the job failed in failed
+	// transiently, so the manager may retry the job.
+	transientFailure bool
+	logFile          *os.File
+	logWriter        io.Writer
+	logFormat        LogFormat
+	// logMu serialises log writes; logPhase is the phase the build last
+	// entered, attached to JSON log records.
+	logMu     sync.Mutex
+	logPhase  string
+	workDir   string
+	commitSHA string
+	ctx       context.Context
+	cancel    context.CancelFunc
 }
 
 func NewWorker(job *storage.BuildJob, storage *storage.Storage, logManager *logs.LogManager, allowlist *allowlist.AllowedCommands, apiClient *api.Client) *Worker {
@@ -61,11 +130,26 @@ func NewWorker(job *storage.BuildJob, storage *storage.Storage, logManager *logs
 	}
 }
 
-func (w *Worker) Run() error {
+// Run builds the job until it finishes, fails, or ctx is cancelled. A build
+// cancelled with ErrJobCanceled as the cause is recorded as canceled. A
+// positive BuildConfig.TimeoutSeconds bounds the whole pipeline; once it is
+// spent the running command is killed and the job fails. The manager's
+// wall-clock limit applies on top; see SetMaxBuildDuration.
+func (w *Worker) Run(ctx context.Context) error {
 	log.Printf("Starting build for job %s", w.job.ID)
+	w.reportProgress(string(jobstate.Claimed), "claimed by a builder")
 	w.job.BuildConfig.NormalizePhaseAliases()
 	w.job.StartedAt = sql.NullTime{Time: time.Now(), Valid: true}
-	w.ctx, w.cancel = context.WithTimeout(context.Background(), w.buildTimeout())
+	w.observeQueueWait()
+	ctx, kill := context.WithCancelCause(ctx)
+	defer kill(nil)
+	expired, stopWatchdog := w.startWatchdog(kill)
+	defer stopWatchdog()
+	if timeout := w.buildTimeout(); timeout > 0 {
+		w.ctx, w.cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		w.ctx, w.cancel = context.WithCancel(ctx)
+	}
 	defer w.cancel()
 
 	logPath, logFile, err := w.logManager.CreateLogFile(w.job.ID)
@@ -75,27 +159,76 @@ func (w *Worker) Run() error {
 	}
 	w.job.LogPath = logPath
 	w.logFile = logFile
-	defer w.logFile.Close()
-	w.logWriter = io.MultiWriter(os.Stdout, w.logFile)
+	w.logWriter = io.MultiWriter(os.Stdout, w.logManager.LimitJobLog(w.logFile, w.formatTruncationMarker))
+	return w.superviseBuild(w.build, expired)
+}
+
+// runLogged runs build with the job log open and syncs the log to disk however
+// build ends: returning, being cancelled or panicking. A panic fails the job
+// rather than taking the whole builder down.
+func (w *Worker) runLogged(build func() error) (err error) {
+	defer w.closeLog()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ERROR: build for job %s panicked: %v\n%s", w.job.ID, r, debug.Stack())
+			w.log("ERROR: builder panicked: %v", r)
+			err = w.failJob("internal builder error")
+		}
+	}()
+	return build()
+}
 
-	if err := w.storage.UpdateJobLogPath(w.job.ID, logPath); err != nil {
+// closeLog flushes the job log to disk before closing it, so the persisted log
+// holds every line written up to the end of the build.
+func (w *Worker) closeLog() {
+	if err := w.logFile.Sync(); err != nil {
+		log.Printf("WARN: could not sync log for job %s: %v", w.job.ID, err)
+	}
+	if err := w.logFile.Close(); err != nil {
+		log.Printf("WARN: could not close log for job %s: %v", w.job.ID, err)
+	}
+}
+
+func (w *Worker) build() error {
+	var err error
+	if err := w.storage.UpdateJobLogPath(w.job.ID, w.job.LogPath); err != nil {
 		w.log("ERROR: could not update log path: %v", err)
 		return w.failJob("internal server error")
 	}
 
-	if err := w.storage.UpdateJobStatus(w.job.ID, "building"); err != nil {
+	if ok, err := w.storage.TransitionClaimedJob(w.job.ID, w.claimOwner, jobstate.Claimed, jobstate.Start); err != nil {
 		w.log("ERROR: could not update status to 'building': %v", err)
 		return w.failJob("internal server error")
+	} else if !ok {
+		if w.canceled() {
+			return w.cancelJob()
+		}
+		w.log("ERROR: job is no longer claimed by this worker; not building")
+		return fmt.Errorf("%w: job %s left the claimed state", ErrBuildFailed, w.job.ID)
 	}
+	publishStatus(w.eventBus, w.job, jobstate.Building)
+	w.reportProgress(string(jobstate.Building), "build started")
 
-	w.workDir, err = os.MkdirTemp("", fmt.Sprintf("hubfly-builder-ws-%s-", w.job.ID))
-	if err != nil {
-		w.log("ERROR: could not create workspace: %v", err)
-		return w.failJob("internal server error")
+	checkpoint := w.resumeWorkspace()
+	if w.workDir == "" {
+		w.workDir, err = os.MkdirTemp("", fmt.Sprintf("%s%s-", workspacePrefix, w.job.ID))
+		if err != nil {
+			w.log("ERROR: could not create workspace: %v", err)
+			return w.failJob("internal server error")
+		}
+		w.logDebug("Created workspace: %s", w.workDir)
+	}
+	defer w.cleanupWorkspace()
+	if checkpoint == phaseBuild {
+		return w.finishSuccessfulBuild()
 	}
-	defer os.RemoveAll(w.workDir)
-	w.log("Created workspace: %s", w.workDir)
 
+	// The server rejects such jobs, but rows written another way must still
+	// leave the queue rather than sit there forever.
+	if strings.TrimSpace(w.job.UserID) == "" {
+		w.log("ERROR: no userId provided")
+		return w.failJob("no userId provided")
+	}
 	requestedNetwork := strings.TrimSpace(w.job.BuildConfig.Network)
 	if requestedNetwork == "" {
 		w.log("ERROR: no user network provided")
@@ -104,65 +237,67 @@ func (w *Worker) Run() error {
 	w.applyNetworkLimits(requestedNetwork, buildNetworkRateBPS, buildNetworkRateBPS)
 	defer w.applyNetworkLimits(requestedNetwork, defaultNetworkRateBPS, defaultNetworkRateBPS)
 
-	cloneCmd := w.execCommand("git", "clone", w.job.SourceInfo.GitRepository, w.workDir)
-	if err := w.executeCommand(cloneCmd); err != nil {
-		w.log("ERROR: failed to clone repository: %v", err)
-		return w.failForStep(err, "failed to clone repository")
-	}
-
-	var defaultBranch string
-	if w.job.SourceInfo.Ref == "" && w.job.SourceInfo.CommitSha == "" {
-		branchName, err := w.commandOutput(w.execCommand("git", "-C", w.workDir, "rev-parse", "--abbrev-ref", "HEAD"))
-		if err == nil && branchName != "" && branchName != "HEAD" {
-			defaultBranch = branchName
-			w.log("Detected default branch: %s", defaultBranch)
-		}
-		w.log("No ref or commit specified; syncing to latest default branch HEAD")
-		if err := w.executeCommand(w.execCommand("git", "-C", w.workDir, "fetch", "--prune", "origin")); err != nil {
-			w.log("ERROR: failed to fetch latest commits: %v", err)
-			return w.failForStep(err, "failed to fetch latest commits")
-		}
-		resetTarget := "origin/HEAD"
-		if defaultBranch != "" {
-			resetTarget = "origin/" + defaultBranch
-		}
-		if err := w.executeCommand(w.execCommand("git", "-C", w.workDir, "reset", "--hard", resetTarget)); err != nil {
-			w.log("ERROR: failed to reset to %s: %v", resetTarget, err)
-			return w.failForStep(err, "failed to reset to latest commit")
+	if checkpoint == "" {
+		if err := w.fetchSource(); err != nil {
+			return err
 		}
+		w.recordCheckpoint(phaseClone)
 	}
 
-	if w.job.SourceInfo.Ref != "" {
-		w.log("Checking out ref: %s", w.job.SourceInfo.Ref)
-		checkoutRefCmd := w.execCommand("git", "-C", w.workDir, "checkout", w.job.SourceInfo.Ref)
-		if err := w.executeCommand(checkoutRefCmd); err != nil {
-			w.log("ERROR: failed to checkout ref %s: %v", w.job.SourceInfo.Ref, err)
-			return w.failForStep(err, "failed to checkout ref")
-		}
-	}
-
-	if w.job.SourceInfo.CommitSha != "" {
-		w.log("Checking out commit SHA: %s", w.job.SourceInfo.CommitSha)
-		checkoutShaCmd := w.execCommand("git", "-C", w.workDir, "checkout", w.job.SourceInfo.CommitSha)
-		if err := w.executeCommand(checkoutShaCmd); err != nil {
-			w.log("ERROR: failed to checkout commit %s: %v", w.job.SourceInfo.CommitSha, err)
-			return w.failForStep(err, "failed to checkout commit")
-		}
+	if len(w.job.BuildConfig.Env) == 0 && len(w.job.Env) > 0 {
+		w.job.BuildConfig.Env = copyStringMap(w.job.Env)
 	}
+	// Secret values are masked from here on, before any hook can print them.
+	w.redactSecretsFromLogs(envplan.SecretValues(w.job.BuildConfig.Env, w.job.BuildConfig.EnvOverrides))
 
-	if commitSHA, err := w.commandOutput(w.execCommand("git", "-C", w.workDir, "rev-parse", "HEAD")); err == nil && commitSHA != "" {
-		w.log("Checked out commit SHA: %s", commitSHA)
+	if err := w.runPhase(phasePrebuild, w.phases.Prebuild, w.runPreBuildHooks); err != nil {
+		w.log("ERROR: %v", err)
+		return w.failForStep(err, "pre-build hook failed")
 	}
 
-	w.log("Repository cloned and checked out successfully.")
-
 	appDir, appPath, err := resolveWorkspacePath(w.workDir, w.job.SourceInfo.WorkingDir)
 	if err != nil {
 		w.log("ERROR: invalid working directory %q: %v", w.job.SourceInfo.WorkingDir, err)
 		return w.failJob("invalid working directory")
 	}
 	if appDir != "." {
-		w.log("Using working directory: %s", appDir)
+		w.logInfo("Using working directory: %s", appDir)
+	}
+
+	if len(w.job.BuildConfig.BuildOpts) > 0 {
+		if w.job.BuildConfig.UseBuildpacks {
+			w.log("WARNING: buildConfig.buildOpts only apply to Hubcell builds and are ignored for buildpacks")
+		} else if err := ValidateBuildOpts(w.job.BuildConfig.BuildOpts, w.buildOptAllowlist); err != nil {
+			w.log("ERROR: %v", err)
+			return w.failJob(err.Error())
+		}
+	}
+
+	if err := w.job.BuildConfig.ExtraHosts.Validate(); err != nil {
+		w.log("ERROR: %v", err)
+		return w.failJob(err.Error())
+	}
+	if err := w.job.BuildConfig.DNSServers.Validate(); err != nil {
+		w.log("ERROR: %v", err)
+		return w.failJob(err.Error())
+	}
+	if err := w.job.BuildConfig.Platforms.Validate(); err != nil {
+		w.log("ERROR: %v", err)
+		return w.failJob(err.Error())
+	}
+	if err := w.job.BuildConfig.ValidateDockerfilePath(); err != nil {
+		w.log("ERROR: %v", err)
+		return w.failJob(err.Error())
+	}
+
+	if w.job.BuildConfig.UseBuildpacks {
+		if strings.TrimSpace(w.job.BuildConfig.BaseImage) != "" {
+			w.log("WARNING: buildConfig.baseImage only applies to generated Dockerfiles and is ignored for buildpacks")
+		}
+		if err := w.buildWithBuildpacks(appPath, requestedNetwork); err != nil {
+			return err
+		}
+		return w.finishSuccessfulBuild()
 	}
 
 	buildContextDir := appDir
@@ -172,8 +307,16 @@ func (w *Worker) Run() error {
 	hasCustomDockerfile := len(customDockerfile) > 0
 	hasExistingDockerfile := false
 	if hasCustomDockerfile {
-		w.log("Using custom Dockerfile from build request.")
-		dockerfilePath = filepath.Join(buildContext, "Dockerfile")
+		w.logInfo("Using custom Dockerfile from build request.")
+		// The custom Dockerfile is kept outside the checkout, so the repository
+		// Dockerfile is left alone and the custom one is not sent in the context.
+		dockerfileDir, err := os.MkdirTemp("", "hubfly-dockerfile-*")
+		if err != nil {
+			w.log("ERROR: failed to stage custom Dockerfile: %v", err)
+			return w.failJob("failed to stage custom Dockerfile")
+		}
+		defer os.RemoveAll(dockerfileDir)
+		dockerfilePath = filepath.Join(dockerfileDir, "Dockerfile")
 		if err := os.WriteFile(dockerfilePath, customDockerfile, 0644); err != nil {
 			w.log("ERROR: failed to stage custom Dockerfile at %s: %v", dockerfilePath, err)
 			return w.failJob("failed to stage custom Dockerfile")
@@ -181,8 +324,13 @@ func (w *Worker) Run() error {
 		hasExistingDockerfile = true
 	} else {
 		var dockerfileContextDir string
-		dockerfilePath, dockerfileContextDir = detectDockerfileLayout(w.workDir, appDir)
+		dockerfilePath, dockerfileContextDir = detectDockerfileLayout(w.workDir, appDir, w.job.BuildConfig.DockerfileName())
 		hasExistingDockerfile = dockerfilePath != ""
+		if !hasExistingDockerfile && strings.TrimSpace(w.job.BuildConfig.DockerfilePath) != "" {
+			err := fmt.Errorf("dockerfilePath %q was not found in the working directory or the repository root", w.job.BuildConfig.DockerfilePath)
+			w.log("ERROR: %v", err)
+			return w.failJob(err.Error())
+		}
 		if dockerfilePath != "" {
 			buildContextDir = dockerfileContextDir
 			if requestedContextDir := strings.TrimSpace(w.job.BuildConfig.BuildContextDir); requestedContextDir != "" {
@@ -214,6 +362,10 @@ func (w *Worker) Run() error {
 			plannedConfig, err = autodetect.AutoDetectBuildConfigWithOptions(autodetect.AutoDetectOptions{
 				RepoRoot:   w.workDir,
 				WorkingDir: appDir,
+				BaseImage:  w.job.BuildConfig.BaseImage,
+				RunAs:      (*autodetect.ContainerUser)(w.job.BuildConfig.RunAs),
+				Shell:      w.job.BuildConfig.Shell,
+				Overrides:  commandOverrides(w.job.BuildConfig),
 			}, w.allowlist)
 			if err != nil {
 				w.log("ERROR: failed to auto-detect build config: %v", err)
@@ -223,6 +375,9 @@ func (w *Worker) Run() error {
 			plannedConfig, err = autodetect.FinalizeBuildConfigWithOptions(autodetect.AutoDetectOptions{
 				RepoRoot:   w.workDir,
 				WorkingDir: appDir,
+				BaseImage:  w.job.BuildConfig.BaseImage,
+				RunAs:      (*autodetect.ContainerUser)(w.job.BuildConfig.RunAs),
+				Shell:      w.job.BuildConfig.Shell,
 			}, toAutodetectBuildConfig(w.job.BuildConfig), w.allowlist)
 			if err != nil {
 				w.log("ERROR: failed to finalize submitted build config: %v", err)
@@ -239,11 +394,7 @@ func (w *Worker) Run() error {
 		}
 	}
 
-	if len(w.job.BuildConfig.Env) == 0 && len(w.job.Env) > 0 {
-		w.job.BuildConfig.Env = copyStringMap(w.job.Env)
-	}
-
-	envResult := envplan.ResolveForPaths([]string{buildContext, appPath}, w.job.BuildConfig.Env, w.job.BuildConfig.EnvOverrides)
+	envResult := envplan.ResolveForPaths([]string{buildContext, appPath}, w.job.BuildConfig.Env, w.job.BuildConfig.EnvOverrides, w.job.BuildConfig.RuntimeOnly)
 	w.job.BuildConfig.ResolvedEnvPlan = envResult.Entries
 	w.job.BuildConfig.ValidationWarnings = mergeWarnings(w.job.BuildConfig.ValidationWarnings, envResult.Warnings)
 	w.logResolvedEnvPlan(envResult.Entries)
@@ -256,17 +407,31 @@ func (w *Worker) Run() error {
 		}
 	}
 
-	cpuLimit, memLimit := defaultHubcellResourceLimits()
-	if w.job.BuildConfig.ResourceLimits.CPU > 0 || w.job.BuildConfig.ResourceLimits.MemoryMB > 0 {
-		w.log("WARNING: buildConfig.resourceLimits is currently ignored; using default Hubcell limits cpu=%.1f memoryMB=%d", cpuLimit, memLimit)
+	cpuLimit, memLimit, err := hubcellResourceLimits(w.job.BuildConfig.ResourceLimits)
+	if err != nil {
+		w.log("ERROR: %v", err)
+		return w.failJob(err.Error())
+	}
+	w.logDebug("Hubcell build limits: cpu=%.1f memoryMB=%d", cpuLimit, memLimit)
+	buildEnvEntries := resolvedBuildArgEntries(envResult)
+	secretDir, buildSecrets, err := writeBuildSecrets(envResult.BuildSecrets)
+	if err != nil {
+		w.log("ERROR: %v", err)
+		return w.failJob("failed to prepare build secrets")
+	}
+	if secretDir != "" {
+		defer os.RemoveAll(secretDir)
+		w.logInfo("Mounting build secrets: %s", strings.Join(envResult.BuildSecretKeys(), ", "))
 	}
-	buildEnvEntries := resolvedBuildEnvEntries(envResult)
 
 	if hasExistingDockerfile {
 		if hasCustomDockerfile {
-			w.log("Custom Dockerfile staged in context, starting Hubcell build...")
+			w.logInfo("Custom Dockerfile staged outside the build context, starting Hubcell build...")
 		} else {
-			w.log("Dockerfile found in context, starting Hubcell build...")
+			w.logInfo("Using %s from the repository instead of generating a Dockerfile, starting Hubcell build...", relativeToRepo(w.workDir, dockerfilePath))
+		}
+		if strings.TrimSpace(w.job.BuildConfig.BaseImage) != "" {
+			w.log("WARNING: buildConfig.baseImage only applies to generated Dockerfiles and is ignored")
 		}
 
 		var stagedDockerfile []byte
@@ -293,6 +458,7 @@ func (w *Worker) Run() error {
 		}
 		w.job.BuildConfig.BuildContextDir = buildContextDir
 		w.job.BuildConfig.AppDir = appDir
+		w.job.BuildConfig.UsesRepoDockerfile = !hasCustomDockerfile
 		w.job.BuildConfig.ValidationWarnings = mergeWarnings(w.job.BuildConfig.ValidationWarnings, audit.Warnings)
 		if hasCustomDockerfile {
 			w.job.BuildConfig.DockerfileContent = customDockerfile
@@ -309,32 +475,61 @@ func (w *Worker) Run() error {
 			w.log("WARNING: submitted install/setup/build/run phases are ignored because a Dockerfile was provided. Keep custom lifecycle steps in the Dockerfile itself.")
 		}
 
-		imageTag := w.generateImageTag()
-		w.log("Image tag: %s", imageTag)
+		imageTag, err := w.generateImageTag()
+		if err != nil {
+			w.log("ERROR: %v", err)
+			return w.failJob(err.Error())
+		}
+		w.logInfo("Image tag: %s", imageTag)
 		opts := driver.HubcellBuildOpts{
 			HubcellPath: hubcellCLIPathFromEnv(),
 			WorkDir:     w.workDir,
 			ContextPath: hubcellBuildPath(w.workDir, dockerfilePath),
 			ImageTag:    imageTag,
 			Envs:        buildEnvEntries,
+			Secrets:     buildSecrets,
 			Network:     requestedNetwork,
 			MemoryBytes: memoryMBToBytes(memLimit),
 			CPUPeriod:   defaultHubcellCPUPeriod,
 			CPUQuota:    cpuToQuota(cpuLimit, defaultHubcellCPUPeriod),
+			BuildOpts:   w.job.BuildConfig.BuildOpts,
+			ExtraHosts:  w.job.BuildConfig.ExtraHosts,
+			DNSServers:  w.buildDNSServers(),
+			Platforms:   w.job.BuildConfig.Platforms,
+		}
+		if hasCustomDockerfile {
+			opts.ContextPath = hubcellContextPath(w.workDir, buildContext)
+			opts.Dockerfile = dockerfilePath
+		} else if filepath.Base(dockerfilePath) != "Dockerfile" {
+			opts.Dockerfile = relativeToRepo(w.workDir, dockerfilePath)
 		}
 		applyDefaultHubcellRootfs(&opts)
-		if err := w.buildImageWithHubcell(opts); err != nil {
+		w.applyBuildCache(&opts)
+		if opts.ExtraTags, err = w.extraImageTags(imageTag); err != nil {
+			w.log("ERROR: %v", err)
+			return w.failJob(err.Error())
+		}
+		w.checkDockerignore(hubcellContextDir(opts), appPath)
+		if err := w.scanContextSecrets(hubcellContextDir(opts)); err != nil {
+			w.log("ERROR: %v", err)
+			return w.failJob(err.Error())
+		}
+		if err := w.stageBuildInfo(hubcellContextDir(opts), imageTag); err != nil {
+			w.log("ERROR: failed to write build info: %v", err)
+			return w.failJob(err.Error())
+		}
+		if err := w.buildOrReuseImage(opts); err != nil {
 			w.log("ERROR: hubcell build failed: %v", err)
 			return w.failForStep(err, "failed to build image with hubcell")
 		}
-		w.log("Hubcell build successful.")
+		w.logInfo("Hubcell build successful.")
 		w.job.ImageTag = imageTag
 		if err := w.storage.UpdateJobImageTag(w.job.ID, imageTag); err != nil {
 			w.log("ERROR: could not update image tag: %v", err)
 			// Don't fail the build for this, just log it.
 		}
 	} else {
-		w.log("No Dockerfile found in context, attempting to auto-detect and generate...")
+		w.logInfo("No Dockerfile found in context, attempting to auto-detect and generate...")
 		if !w.job.BuildConfig.IsAutoBuild && !hasStructuredBuildStrategy(w.job.BuildConfig) {
 			w.log("ERROR: Auto-build is not enabled for this job.")
 			return w.failJob("No build strategy found (e.g., Dockerfile missing and auto-build disabled)")
@@ -344,8 +539,13 @@ func (w *Worker) Run() error {
 		var detectedConfig autodetect.BuildConfig
 		if w.job.BuildConfig.IsAutoBuild {
 			detectedConfig, err = autodetect.AutoDetectBuildConfigWithEnvOptions(autodetect.AutoDetectOptions{
-				RepoRoot:   w.workDir,
-				WorkingDir: appDir,
+				RepoRoot:       w.workDir,
+				WorkingDir:     appDir,
+				BaseImage:      w.job.BuildConfig.BaseImage,
+				RunAs:          (*autodetect.ContainerUser)(w.job.BuildConfig.RunAs),
+				Shell:          w.job.BuildConfig.Shell,
+				RuntimeEnvKeys: envResult.RuntimeKeys(),
+				Overrides:      commandOverrides(w.job.BuildConfig),
 			}, w.allowlist, envResult.BuildArgKeys(), envResult.BuildSecretKeys())
 			if err != nil {
 				w.log("ERROR: failed to auto-detect build config: %v", err)
@@ -353,8 +553,12 @@ func (w *Worker) Run() error {
 			}
 		} else {
 			detectedConfig, err = autodetect.FinalizeBuildConfigWithEnvOptions(autodetect.AutoDetectOptions{
-				RepoRoot:   w.workDir,
-				WorkingDir: appDir,
+				RepoRoot:       w.workDir,
+				WorkingDir:     appDir,
+				BaseImage:      w.job.BuildConfig.BaseImage,
+				RunAs:          (*autodetect.ContainerUser)(w.job.BuildConfig.RunAs),
+				Shell:          w.job.BuildConfig.Shell,
+				RuntimeEnvKeys: envResult.RuntimeKeys(),
 			}, toAutodetectBuildConfig(w.job.BuildConfig), w.allowlist, envResult.BuildArgKeys(), envResult.BuildSecretKeys())
 			if err != nil {
 				w.log("ERROR: failed to finalize submitted build config: %v", err)
@@ -370,24 +574,24 @@ func (w *Worker) Run() error {
 		}
 		dockerfilePath = filepath.Join(buildContext, "Dockerfile")
 
-		w.log("Auto-detected runtime: %s, version: %s", detectedConfig.Runtime, detectedConfig.Version)
+		w.logInfo("Auto-detected runtime: %s, version: %s", detectedConfig.Runtime, detectedConfig.Version)
 		if detectedConfig.Framework != "" {
-			w.log("Auto-detected framework: %s", detectedConfig.Framework)
+			w.logInfo("Auto-detected framework: %s", detectedConfig.Framework)
 		}
 		if detectedConfig.InstallCommand != "" {
-			w.log("Resolved install command: %s", detectedConfig.InstallCommand)
+			w.logInfo("Resolved install command: %s", detectedConfig.InstallCommand)
 		}
 		for _, command := range detectedConfig.SetupCommands {
-			w.log("Resolved setup command: %s", command)
+			w.logInfo("Resolved setup command: %s", command)
 		}
 		for _, command := range detectedConfig.PostBuildCommands {
-			w.log("Resolved post-build command: %s", command)
+			w.logInfo("Resolved post-build command: %s", command)
 		}
 		for _, warning := range detectedConfig.ValidationWarnings {
 			w.log("Resolved warning: %s", warning)
 		}
 		if detectedConfig.UseStaticRuntime {
-			w.log("Resolved static output dir: %s", detectedConfig.StaticOutputDir)
+			w.logInfo("Resolved static output dir: %s", detectedConfig.StaticOutputDir)
 		}
 
 		applyDetectedBuildConfig(&w.job.BuildConfig, detectedConfig)
@@ -402,10 +606,17 @@ func (w *Worker) Run() error {
 			w.log("ERROR: failed to write generated Dockerfile: %v", err)
 			return w.failJob("failed to write generated Dockerfile")
 		}
+		if buildContextDir == "." {
+			w.scopeContext(buildContext, appDir)
+		}
 
-		w.log("Dockerfile generated successfully, starting Hubcell build...")
-		imageTag := w.generateImageTag()
-		w.log("Image tag: %s", imageTag)
+		w.logInfo("Dockerfile generated successfully, starting Hubcell build...")
+		imageTag, err := w.generateImageTag()
+		if err != nil {
+			w.log("ERROR: %v", err)
+			return w.failJob(err.Error())
+		}
+		w.logInfo("Image tag: %s", imageTag)
 
 		opts := driver.HubcellBuildOpts{
 			HubcellPath: hubcellCLIPathFromEnv(),
@@ -413,42 +624,160 @@ func (w *Worker) Run() error {
 			ContextPath: hubcellBuildPath(w.workDir, dockerfilePath),
 			ImageTag:    imageTag,
 			Envs:        buildEnvEntries,
+			Secrets:     buildSecrets,
 			Network:     requestedNetwork,
 			MemoryBytes: memoryMBToBytes(memLimit),
 			CPUPeriod:   defaultHubcellCPUPeriod,
 			CPUQuota:    cpuToQuota(cpuLimit, defaultHubcellCPUPeriod),
+			BuildOpts:   w.job.BuildConfig.BuildOpts,
+			ExtraHosts:  w.job.BuildConfig.ExtraHosts,
+			DNSServers:  w.buildDNSServers(),
+			Platforms:   w.job.BuildConfig.Platforms,
 		}
 		applyDefaultHubcellRootfs(&opts)
-		if err := w.buildImageWithHubcell(opts); err != nil {
+		w.applyBuildCache(&opts)
+		if opts.ExtraTags, err = w.extraImageTags(imageTag); err != nil {
+			w.log("ERROR: %v", err)
+			return w.failJob(err.Error())
+		}
+		w.checkDockerignore(hubcellContextDir(opts), appPath)
+		if err := w.scanContextSecrets(hubcellContextDir(opts)); err != nil {
+			w.log("ERROR: %v", err)
+			return w.failJob(err.Error())
+		}
+		if err := w.stageBuildInfo(hubcellContextDir(opts), imageTag); err != nil {
+			w.log("ERROR: failed to write build info: %v", err)
+			return w.failJob(err.Error())
+		}
+		if err := w.buildOrReuseImage(opts); err != nil {
 			w.log("ERROR: hubcell build failed: %v", err)
 			return w.failForStep(err, "failed to build image with hubcell")
 		}
-		w.log("Hubcell build successful.")
+		w.logInfo("Hubcell build successful.")
 		w.job.ImageTag = imageTag
 		if err := w.storage.UpdateJobImageTag(w.job.ID, imageTag); err != nil {
 			w.log("ERROR: could not update image tag: %v", err)
 		}
 	}
 
+	return w.finishSuccessfulBuild()
+}
+
+// fetchSource clones the repository into the workspace and checks out the
+// requested source.
+func (w *Worker) fetchSource() error {
+	if err := w.prepareGitAuth(); err != nil {
+		w.log("ERROR: could not prepare git credential: %v", err)
+		return w.failJob("could not prepare git credential")
+	}
+	if err := w.runPhase(phaseClone, w.phases.Clone, w.cloneRepository); err != nil {
+		w.log("ERROR: failed to clone repository: %v", err)
+		return w.failForStep(err, "failed to clone repository")
+	}
+
+	var defaultBranch string
+	if w.job.SourceInfo.Ref == "" && w.job.SourceInfo.CommitSha == "" {
+		branchName, err := w.commandOutput(w.execCommand("git", "-C", w.workDir, "rev-parse", "--abbrev-ref", "HEAD"))
+		if err == nil && branchName != "" && branchName != "HEAD" {
+			defaultBranch = branchName
+			w.logInfo("Detected default branch: %s", defaultBranch)
+		}
+		w.logInfo("No ref or commit specified; syncing to latest default branch HEAD")
+		if err := w.executeCommand(w.execCommand("git", "-C", w.workDir, "fetch", "--prune", "origin")); err != nil {
+			w.log("ERROR: failed to fetch latest commits: %v", err)
+			return w.failForStep(err, "failed to fetch latest commits")
+		}
+		resetTarget := "origin/HEAD"
+		if defaultBranch != "" {
+			resetTarget = "origin/" + defaultBranch
+		}
+		if err := w.executeCommand(w.execCommand("git", "-C", w.workDir, "reset", "--hard", resetTarget)); err != nil {
+			w.log("ERROR: failed to reset to %s: %v", resetTarget, err)
+			return w.failForStep(err, "failed to reset to latest commit")
+		}
+	}
+
+	if err := w.checkoutRequestedSource(); err != nil {
+		w.log("ERROR: %v", err)
+		return w.failForStep(err, err.Error())
+	}
+	w.scrubGitAuth()
+
+	w.logInfo("Repository cloned and checked out successfully.")
+	return nil
+}
+
+func (w *Worker) finishSuccessfulBuild() error {
+	w.recordCheckpoint(phaseBuild)
+	w.enterProgressStage(progressPush)
+	if err := w.pushToRegistries(); err != nil {
+		return w.failForStep(err, "registry push failed")
+	}
+	if err := w.runPostBuildHooks(); err != nil {
+		w.log("ERROR: %v", err)
+		return w.failForStep(err, "post-build hook failed")
+	}
+	if w.job.BuildConfig.Provenance {
+		w.recordProvenance()
+	}
+
+	w.completeProgress()
 	return w.succeedJob()
 }
 
 func (w *Worker) failJob(reason string) error {
+	if w.claimLost() {
+		return w.abandonLostClaim()
+	}
+	if w.canceled() {
+		return w.cancelJob()
+	}
+	if !w.resolve() {
+		return w.abandonedByWatchdog()
+	}
+	if w.wallClockExceeded() {
+		reason = w.wallClockReason()
+		w.transientFailure = false
+	}
 	log.Printf("Failing job %s: %s", w.job.ID, reason)
-	if err := w.storage.UpdateJobStatus(w.job.ID, "failed"); err != nil {
+	w.retainDebugWorkspace()
+	w.retainResumeWorkspace()
+	if ok, err := w.transitionStatus(jobstate.Fail, jobstate.Building, jobstate.Claimed, jobstate.Cancelling); err != nil {
 		log.Printf("ERROR: could not update job status to 'failed' for job %s: %v", w.job.ID, err)
-	}
-	if err := w.apiClient.ReportResult(w.job, "failed", reason); err != nil {
+	} else if !ok {
+		log.Printf("WARN: job %s is no longer in progress; leaving its status unchanged", w.job.ID)
+	}
+	if w.willRetry() {
+		// The job is not done yet: the manager queues it again, and only its
+		// last attempt reports a result.
+		w.log("Build failed transiently; it will be retried (attempt %d of %d)", w.job.RetryCount+1, w.retry.MaxRetries)
+		w.reportProgress("retrying", reason)
+	} else if err := w.apiClient.ReportResult(w.job, "failed", reason); err != nil {
 		log.Printf("ERROR: could not report result to backend for job %s: %v", w.job.ID, err)
 	}
+	if w.transientFailure {
+		return fmt.Errorf("%w: %w: %s", ErrBuildFailed, ErrTransientFailure, reason)
+	}
 	return fmt.Errorf("%w: %s", ErrBuildFailed, reason)
 }
 
 func (w *Worker) succeedJob() error {
+	if w.claimLost() {
+		return w.abandonLostClaim()
+	}
+	if w.canceled() {
+		return w.cancelJob()
+	}
+	if !w.resolve() {
+		return w.abandonedByWatchdog()
+	}
 	log.Printf("Succeeding job %s", w.job.ID)
-	if err := w.storage.UpdateJobStatus(w.job.ID, "success"); err != nil {
+	if ok, err := w.transitionStatus(jobstate.Succeed, jobstate.Building, jobstate.Cancelling); err != nil {
 		log.Printf("ERROR: could not update status to 'success' for job %s: %v", w.job.ID, err)
 		return err
+	} else if !ok {
+		log.Printf("ERROR: job %s is no longer building; not reporting success", w.job.ID)
+		return fmt.Errorf("job %s left the building state before it succeeded", w.job.ID)
 	}
 	if err := w.apiClient.ReportResult(w.job, "success", ""); err != nil {
 		log.Printf("ERROR: could not report result to backend for job %s: %v", w.job.ID, err)
@@ -457,25 +786,39 @@ func (w *Worker) succeedJob() error {
 	return nil
 }
 
+// transitionStatus applies event from the first of from the job is still in,
+// and reports whether any transition happened.
+func (w *Worker) transitionStatus(event jobstate.Event, from ...jobstate.Status) (bool, error) {
+	for _, current := range from {
+		ok, err := w.storage.TransitionClaimedJob(w.job.ID, w.claimOwner, current, event)
+		if err != nil || ok {
+			if ok {
+				next, _ := jobstate.Transition(current, event)
+				publishStatus(w.eventBus, w.job, next)
+			}
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
 func (w *Worker) log(format string, args ...interface{}) {
 	logLine := fmt.Sprintf(format, args...)
-	fmt.Fprintf(w.logWriter, "[%s] %s\n", time.Now().UTC().Format(time.RFC3339), logLine)
+	w.writeLog(logLevel(logLine), logLine)
 }
 
+// buildTimeout is the job's overall time limit; zero means the job has none.
 func (w *Worker) buildTimeout() time.Duration {
 	timeoutSeconds := w.job.BuildConfig.TimeoutSeconds
 	if timeoutSeconds <= 0 {
-		return defaultBuildTimeout
+		return 0
 	}
 	return time.Duration(timeoutSeconds) * time.Second
 }
 
 func (w *Worker) failForStep(err error, reason string) error {
-	if w.isTimeoutError(err) {
-		timeoutSeconds := int(w.buildTimeout() / time.Second)
-		return w.failJob(fmt.Sprintf("build timed out after %d seconds", timeoutSeconds))
-	}
-	return w.failJob(reason)
+	w.transientFailure = isTransientFailure(err)
+	return w.failJob(w.stepFailureReason(err, reason))
 }
 
 func (w *Worker) isTimeoutError(err error) bool {
@@ -489,10 +832,20 @@ func (w *Worker) isTimeoutError(err error) bool {
 }
 
 func (w *Worker) execCommand(name string, args ...string) *exec.Cmd {
+	path := name
+	if name == "git" {
+		path = gitCLIPathFromEnv()
+	}
+	var cmd *exec.Cmd
 	if w.ctx == nil {
-		return exec.Command(name, args...)
+		cmd = exec.Command(path, args...)
+	} else {
+		cmd = exec.CommandContext(w.ctx, path, args...)
 	}
-	return exec.CommandContext(w.ctx, name, args...)
+	if name == "git" {
+		w.gitAuth.Apply(cmd)
+	}
+	return cmd
 }
 
 func (w *Worker) executeCommand(cmd *exec.Cmd) error {
@@ -504,14 +857,21 @@ func (w *Worker) executeCommandWithoutLogging(cmd *exec.Cmd) error {
 }
 
 func (w *Worker) executeCommandWithLogging(cmd *exec.Cmd, logCommand bool) error {
+	_, err := w.executeCommandCapturingOutput(cmd, logCommand, nil)
+	return err
+}
+
+// executeCommandCapturingOutput streams output to the job log like
+// executeCommand and, when capture is non-nil, also keeps it for inspection.
+func (w *Worker) executeCommandCapturingOutput(cmd *exec.Cmd, logCommand bool, capture *outputCapture) (string, error) {
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	var wg sync.WaitGroup
@@ -519,28 +879,71 @@ func (w *Worker) executeCommandWithLogging(cmd *exec.Cmd, logCommand bool) error
 
 	go func() {
 		defer wg.Done()
-		w.streamPipe(stdout)
+		w.streamPipe(stdout, capture)
 	}()
 
 	go func() {
 		defer wg.Done()
-		w.streamPipe(stderr)
+		w.streamPipe(stderr, capture)
 	}()
 
 	if logCommand {
-		w.log("Executing: %s", sanitizeCommandForLog(cmd))
+		w.logDebug("Executing: %s", sanitizeCommandForLog(cmd))
 	}
+	killProcessGroupOnCancel(cmd)
 	if err := cmd.Start(); err != nil {
-		return err
+		return "", err
 	}
 
-	err = cmd.Wait()
+	// Wait closes the pipes, so drain them first or trailing output is lost.
 	wg.Wait()
-	return err
+	err = cmd.Wait()
+	return capture.String(), err
+}
+
+// maxCapturedOutputBytes bounds the command output outputCapture keeps. Only
+// the tail is kept, which is where a failing command prints its error; the
+// whole output is still streamed to the job log.
+const maxCapturedOutputBytes = 4 << 20
+
+// outputCapture keeps the last maxCapturedOutputBytes of a command's output
+// lines.
+type outputCapture struct {
+	mu    sync.Mutex
+	lines []string
+	bytes int
+}
+
+func (c *outputCapture) add(line string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, line)
+	c.bytes += len(line) + 1
+	for c.bytes > maxCapturedOutputBytes && len(c.lines) > 1 {
+		c.bytes -= len(c.lines[0]) + 1
+		c.lines[0] = ""
+		c.lines = c.lines[1:]
+	}
+}
+
+func (c *outputCapture) String() string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.lines) == 0 {
+		return ""
+	}
+	return strings.Join(c.lines, "\n") + "\n"
 }
 
 func (w *Worker) commandOutput(cmd *exec.Cmd) (string, error) {
-	w.log("Executing: %s", sanitizeCommandForLog(cmd))
+	w.logDebug("Executing: %s", sanitizeCommandForLog(cmd))
+	killProcessGroupOnCancel(cmd)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		out := strings.TrimSpace(string(output))
@@ -558,7 +961,7 @@ func (w *Worker) buildImageWithHubcell(opts driver.HubcellBuildOpts) error {
 	if err := driver.ValidateHubcellBuildOpts(opts); err != nil {
 		return err
 	}
-	w.log("Building image with Hubcell CLI: tag=%s path=%s network=%s memoryBytes=%d cpuPeriod=%d cpuQuota=%d",
+	w.logDebug("Building image with Hubcell CLI: tag=%s path=%s network=%s memoryBytes=%d cpuPeriod=%d cpuQuota=%d",
 		opts.ImageTag,
 		opts.ContextPath,
 		opts.Network,
@@ -566,7 +969,28 @@ func (w *Worker) buildImageWithHubcell(opts driver.HubcellBuildOpts) error {
 		opts.CPUPeriod,
 		opts.CPUQuota,
 	)
-	return w.executeCommandWithoutLogging(driver.HubcellBuildCommandContext(w.ctx, opts))
+	release, err := w.acquireImageBuildSlot()
+	if err != nil {
+		return err
+	}
+	defer release()
+	return w.runPhase(phaseBuild, w.phases.Build, func() error {
+		build := func() error {
+			return w.runImageBuild(func() *exec.Cmd {
+				return driver.HubcellBuildCommandContext(w.ctx, opts)
+			}, func(image string) *exec.Cmd {
+				return driver.HubcellPullCommandContext(w.ctx, opts.HubcellPath, image)
+			})
+		}
+		err := build()
+		if err == nil || opts.CacheRef == "" || opts.NoCacheImport || !w.buildCacheImportFailed || w.isTimeoutError(err) {
+			return err
+		}
+		// A missing cache image must not fail the first build of a project.
+		w.log("WARNING: could not import build cache %s; building without it", opts.CacheRef)
+		opts.NoCacheImport = true
+		return build()
+	})
 }
 
 func applyDefaultHubcellRootfs(opts *driver.HubcellBuildOpts) {
@@ -575,13 +999,26 @@ func applyDefaultHubcellRootfs(opts *driver.HubcellBuildOpts) {
 	}
 }
 
+// relativeToRepo shows path relative to the repository root.
+func relativeToRepo(repoRoot, path string) string {
+	if rel, err := filepath.Rel(repoRoot, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
 func hubcellBuildPath(repoRoot, dockerfilePath string) string {
-	dockerfileDir := filepath.Clean(filepath.Dir(dockerfilePath))
-	repoRoot = filepath.Clean(repoRoot)
-	if dockerfileDir == repoRoot {
+	return hubcellContextPath(repoRoot, filepath.Dir(dockerfilePath))
+}
+
+// hubcellContextPath is the build context argument for contextDir: "." for
+// the repository root, which Hubcell runs in, and contextDir otherwise.
+func hubcellContextPath(repoRoot, contextDir string) string {
+	contextDir = filepath.Clean(contextDir)
+	if contextDir == filepath.Clean(repoRoot) {
 		return "."
 	}
-	return dockerfileDir
+	return contextDir
 }
 
 func memoryMBToBytes(memoryMB int) int64 {
@@ -602,7 +1039,39 @@ func defaultHubcellResourceLimits() (float64, int) {
 	return defaultHubcellCPU, defaultHubcellMemoryMB
 }
 
-func resolvedBuildEnvEntries(result envplan.Result) []string {
+// hubcellResourceLimits applies the job's resource limits over the Hubcell
+// defaults; a zero limit keeps the default.
+func hubcellResourceLimits(limits storage.ResourceLimits) (float64, int, error) {
+	if err := limits.Validate(); err != nil {
+		return 0, 0, err
+	}
+	cpu, memoryMB := defaultHubcellResourceLimits()
+	if limits.CPU > 0 {
+		cpu = limits.CPU
+	}
+	if limits.MemoryMB > 0 {
+		memoryMB = limits.MemoryMB
+	}
+	return cpu, memoryMB, nil
+}
+
+// resolvedBuildArgEntries renders the build args as KEY="value" entries for
+// `hubcell build -e`. Build secrets are mounted from files instead; see
+// writeBuildSecrets.
+func resolvedBuildArgEntries(result envplan.Result) []string {
+	keys := result.BuildArgKeys()
+	entries := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, secret := result.BuildSecrets[key]; secret {
+			continue
+		}
+		escapedValue := strings.ReplaceAll(result.BuildArgs[key], `"`, `\"`)
+		entries = append(entries, key+`="`+escapedValue+`"`)
+	}
+	return entries
+}
+
+func resolvedBuildEnvValues(result envplan.Result) ([]string, map[string]string) {
 	keys := make([]string, 0, len(result.BuildArgs)+len(result.BuildSecrets))
 	values := make(map[string]string, len(result.BuildArgs)+len(result.BuildSecrets))
 
@@ -618,13 +1087,7 @@ func resolvedBuildEnvEntries(result envplan.Result) []string {
 	}
 
 	sort.Strings(keys)
-
-	entries := make([]string, 0, len(keys))
-	for _, key := range keys {
-		escapedValue := strings.ReplaceAll(values[key], `"`, `\"`)
-		entries = append(entries, key+`="`+escapedValue+`"`)
-	}
-	return entries
+	return keys, values
 }
 
 func (w *Worker) applyNetworkLimits(networkName string, egressRateBPS, ingressRateBPS int64) {
@@ -646,41 +1109,49 @@ func (w *Worker) applyNetworkLimits(networkName string, egressRateBPS, ingressRa
 		w.log("WARNING: failed to apply network limits for %s: %v", networkName, err)
 		return
 	}
-	w.log("Applied network limits for %s: egressRateBPS=%d ingressRateBPS=%d", networkName, egressRateBPS, ingressRateBPS)
+	w.logDebug("Applied network limits for %s: egressRateBPS=%d ingressRateBPS=%d", networkName, egressRateBPS, ingressRateBPS)
 }
 
-func (w *Worker) streamPipe(pipe io.Reader) {
+// maxLogLineBytes bounds a single logged line. Longer lines, such as minified
+// bundles in stack traces, are logged in pieces of this size instead of
+// stopping the scanner with bufio.ErrTooLong.
+const maxLogLineBytes = 1 << 20
+
+func (w *Worker) streamPipe(pipe io.Reader, capture *outputCapture) {
 	scanner := bufio.NewScanner(pipe)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLogLineBytes)
+	scanner.Split(scanLogLines)
 	for scanner.Scan() {
-		w.log("%s", scanner.Text())
+		line := w.redactSecrets(scanner.Text())
+		capture.add(line)
+		w.observeBuildProgress(line)
+		w.log("%s", line)
+	}
+	if err := scanner.Err(); err != nil {
+		w.log("WARNING: could not read command output: %v", err)
+		// Keep draining so the command never blocks on a full pipe.
+		io.Copy(io.Discard, pipe)
 	}
 }
 
-func (w *Worker) generateImageTag() string {
-	ts := time.Now().UTC().Format("20060102T150405Z")
-	shortSha := sanitizeImageTagComponent(w.job.SourceInfo.CommitSha)
-	if shortSha == "" {
-		shortSha = sanitizeImageTagComponent(w.job.SourceInfo.Ref)
+// scanLogLines is bufio.ScanLines, except that a line filling the whole
+// buffer is returned as-is rather than failing with bufio.ErrTooLong.
+func scanLogLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if advance == 0 && token == nil && err == nil && len(data) >= maxLogLineBytes {
+		return len(data), data, nil
 	}
-	if shortSha == "" {
-		shortSha = "latest"
-	}
-	if len(shortSha) > 12 {
-		shortSha = shortSha[:12]
-	}
-	sanitizedUserID := sanitize(w.job.UserID)
-	sanitizedProjectID := sanitize(w.job.ProjectID)
-	return fmt.Sprintf("hubcell.local/%s/%s:%s-b%s-v%s", sanitizedUserID, sanitizedProjectID, shortSha, w.job.ID, ts)
+	return advance, token, err
 }
 
 func (w *Worker) logResolvedEnvPlan(entries []storage.ResolvedEnvVar) {
 	if len(entries) == 0 {
-		w.log("Env auto-resolution: no env variables provided")
+		w.logDebug("Env auto-resolution: no env variables provided")
 		return
 	}
 
 	for _, entry := range entries {
-		w.log("Env auto-resolution: key=%s scope=%s secret=%t reason=%s", entry.Key, entry.Scope, entry.Secret, entry.Reason)
+		w.logDebug("Env auto-resolution: key=%s scope=%s secret=%t reason=%s", entry.Key, entry.Scope, entry.Secret, entry.Reason)
 	}
 }
 
@@ -691,11 +1162,25 @@ func sanitizeCommandForLog(cmd *exec.Cmd) string {
 
 	sanitized := make([]string, 0, len(cmd.Args))
 	for _, arg := range cmd.Args {
-		sanitized = append(sanitized, redactBuildArg(arg))
+		sanitized = append(sanitized, sanitizeGitRepositoryURL(redactBuildArg(arg)))
 	}
 	return strings.Join(sanitized, " ")
 }
 
+func sanitizeGitRepositoryURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.User == nil {
+		return raw
+	}
+	parsed.User = url.User("REDACTED")
+	return parsed.String()
+}
+
 func redactBuildArg(arg string) string {
 	idx := strings.Index(arg, "build-arg:")
 	if idx == -1 {
@@ -768,6 +1253,10 @@ func hubcellCLIPathFromEnv() string {
 	return strings.TrimSpace(os.Getenv("HUBCELL_CLI_PATH"))
 }
 
+func gitCLIPathFromEnv() string {
+	return driver.ResolveGitCLIPath(os.Getenv("GIT_CLI_PATH"))
+}
+
 func resolveWorkspacePath(repoRoot, workingDir string) (string, string, error) {
 	trimmed := strings.TrimSpace(workingDir)
 	if trimmed == "" || trimmed == "." {
@@ -778,8 +1267,35 @@ func resolveWorkspacePath(repoRoot, workingDir string) (string, string, error) {
 	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(os.PathSeparator)) {
 		return "", "", fmt.Errorf("working directory must stay within the repository root")
 	}
+	appPath := filepath.Join(repoRoot, cleaned)
+	if err := checkWorkingDirectory(repoRoot, appPath); err != nil {
+		return "", "", err
+	}
+
+	return filepath.ToSlash(cleaned), appPath, nil
+}
 
-	return filepath.ToSlash(cleaned), filepath.Join(repoRoot, cleaned), nil
+// checkWorkingDirectory rejects a working directory that does not exist in the
+// checkout or that a symlink points outside of it.
+func checkWorkingDirectory(repoRoot, appPath string) error {
+	root, err := filepath.EvalSymlinks(repoRoot)
+	if err != nil {
+		return err
+	}
+	resolved, err := filepath.EvalSymlinks(appPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("working directory does not exist in the repository")
+	} else if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return fmt.Errorf("working directory must stay within the repository root")
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return fmt.Errorf("working directory is not a directory")
+	}
+	return nil
 }
 
 func resolveBuildContextPath(repoRoot, buildContextDir string) (string, error) {
@@ -818,15 +1334,17 @@ func normalizeDockerfileBuildContextDir(buildContextDir, appDir string) (string,
 	return cleaned, nil
 }
 
-func detectDockerfileLayout(repoRoot, appDir string) (string, string) {
+// detectDockerfileLayout looks for the Dockerfile called name in the working
+// directory and then in the repository root.
+func detectDockerfileLayout(repoRoot, appDir, name string) (string, string) {
 	if appDir != "." {
-		appDockerfile := filepath.Join(repoRoot, filepath.FromSlash(appDir), "Dockerfile")
+		appDockerfile := filepath.Join(repoRoot, filepath.FromSlash(appDir), name)
 		if info, err := os.Stat(appDockerfile); err == nil && info.Mode().IsRegular() {
 			return appDockerfile, "."
 		}
 	}
 
-	rootDockerfile := filepath.Join(repoRoot, "Dockerfile")
+	rootDockerfile := filepath.Join(repoRoot, name)
 	if info, err := os.Stat(rootDockerfile); err == nil && info.Mode().IsRegular() {
 		return rootDockerfile, "."
 	}
@@ -859,6 +1377,16 @@ func hasStructuredBuildStrategy(cfg storage.BuildConfig) bool {
 	return autodetect.HasStructuredBuildPhases(toAutodetectBuildConfig(cfg))
 }
 
+// commandOverrides are the commands the job replaces in its auto-detected
+// build.
+func commandOverrides(cfg storage.BuildConfig) autodetect.CommandOverrides {
+	return autodetect.CommandOverrides{
+		Prebuild: cfg.PrebuildCommandOverride,
+		Build:    cfg.BuildCommandOverride,
+		Run:      cfg.RunCommandOverride,
+	}
+}
+
 func toAutodetectBuildConfig(cfg storage.BuildConfig) autodetect.BuildConfig {
 	cfg.NormalizePhaseAliases()
 	return autodetect.BuildConfig{
@@ -1,13 +1,25 @@
 package executor
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
 
+	"hubfly-builder/internal/allowlist"
+	"hubfly-builder/internal/api"
+	"hubfly-builder/internal/autodetect"
+	"hubfly-builder/internal/driver"
 	"hubfly-builder/internal/envplan"
+	"hubfly-builder/internal/logs"
 	"hubfly-builder/internal/storage"
 )
 
@@ -18,7 +30,7 @@ func TestDetectDockerfileLayoutIgnoresDockerfileDirectory(t *testing.T) {
 		t.Fatalf("failed to create Dockerfile directory: %v", err)
 	}
 
-	path, ctx := detectDockerfileLayout(repo, ".")
+	path, ctx := detectDockerfileLayout(repo, ".", "Dockerfile")
 	if path != "" || ctx != "" {
 		t.Fatalf("expected no Dockerfile to be detected, got path=%q ctx=%q", path, ctx)
 	}
@@ -38,7 +50,7 @@ func TestDetectDockerfileLayoutPrefersAppDockerfile(t *testing.T) {
 		t.Fatalf("failed to write app Dockerfile: %v", err)
 	}
 
-	path, ctx := detectDockerfileLayout(repo, "apps/web")
+	path, ctx := detectDockerfileLayout(repo, "apps/web", "Dockerfile")
 	if path != filepath.Join(appDir, "Dockerfile") {
 		t.Fatalf("expected app Dockerfile path, got %q", path)
 	}
@@ -47,6 +59,25 @@ func TestDetectDockerfileLayoutPrefersAppDockerfile(t *testing.T) {
 	}
 }
 
+func TestDetectDockerfileLayoutFindsNamedDockerfile(t *testing.T) {
+	repo := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(repo, "Dockerfile"), []byte("FROM busybox\n"), 0o644); err != nil {
+		t.Fatalf("failed to write Dockerfile: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "Dockerfile.prod"), []byte("FROM busybox\n"), 0o644); err != nil {
+		t.Fatalf("failed to write Dockerfile.prod: %v", err)
+	}
+
+	path, ctx := detectDockerfileLayout(repo, "apps/web", "Dockerfile.prod")
+	if path != filepath.Join(repo, "Dockerfile.prod") || ctx != "." {
+		t.Fatalf("expected the root Dockerfile.prod, got path=%q ctx=%q", path, ctx)
+	}
+	if path, _ := detectDockerfileLayout(repo, ".", "Dockerfile.staging"); path != "" {
+		t.Fatalf("expected no Dockerfile for a missing name, got %q", path)
+	}
+}
+
 func TestNormalizeDockerfileBuildContextAllowsAncestorContext(t *testing.T) {
 	ctx, err := normalizeDockerfileBuildContextDir(".", "backend")
 	if err != nil {
@@ -83,7 +114,10 @@ func TestGenerateImageTagUsesRefFallbackWhenCommitMissing(t *testing.T) {
 		},
 	}
 
-	tag := worker.generateImageTag()
+	tag, err := worker.generateImageTag()
+	if err != nil {
+		t.Fatalf("generateImageTag returned error: %v", err)
+	}
 	if strings.Contains(tag, ":-b") {
 		t.Fatalf("expected non-empty image tag source component, got %q", tag)
 	}
@@ -95,6 +129,39 @@ func TestGenerateImageTagUsesRefFallbackWhenCommitMissing(t *testing.T) {
 	}
 }
 
+func TestGenerateImageTagRendersBuildNumberTemplate(t *testing.T) {
+	t.Setenv("IMAGE_TAG_TEMPLATE", "{buildNumber}-{ref}")
+	store := newDedupTestStorage(t)
+	for _, id := range []string{"build_first", "build_second"} {
+		if err := store.CreateJob(&storage.BuildJob{ID: id, ProjectID: "proj", UserID: "user"}); err != nil {
+			t.Fatalf("failed to create job %s: %v", id, err)
+		}
+	}
+
+	for _, want := range []struct{ id, tag string }{
+		{"build_first", "hubcell.local/user/proj:1-abc123"},
+		{"build_second", "hubcell.local/user/proj:2-abc123"},
+	} {
+		worker := &Worker{
+			job:     &storage.BuildJob{ID: want.id, ProjectID: "proj", UserID: "user", SourceInfo: storage.SourceInfo{CommitSha: "abc123"}},
+			storage: store,
+		}
+		tag, err := worker.generateImageTag()
+		if err != nil || tag != want.tag {
+			t.Fatalf("expected tag %q for %s, got %q (err %v)", want.tag, want.id, tag, err)
+		}
+	}
+}
+
+func TestGenerateImageTagRejectsInvalidTemplate(t *testing.T) {
+	t.Setenv("IMAGE_TAG_TEMPLATE", "{ref}:{jobId}")
+	worker := &Worker{job: &storage.BuildJob{ID: "build_test", ProjectID: "proj", UserID: "user"}}
+
+	if _, err := worker.generateImageTag(); err == nil || !strings.Contains(err.Error(), "invalid tag") {
+		t.Fatalf("expected an invalid template to be rejected, got %v", err)
+	}
+}
+
 func TestHubcellBuildPathUsesDotForRootDockerfile(t *testing.T) {
 	got := hubcellBuildPath("/tmp/repo", "/tmp/repo/Dockerfile")
 	if got != "." {
@@ -119,21 +186,315 @@ func TestDefaultHubcellResourceLimits(t *testing.T) {
 	}
 }
 
-func TestResolvedBuildEnvEntriesMergesArgsAndSecrets(t *testing.T) {
-	got := resolvedBuildEnvEntries(envplan.Result{
+func TestHubcellResourceLimitsOverridesDefaults(t *testing.T) {
+	cpu, memoryMB, err := hubcellResourceLimits(storage.ResourceLimits{MemoryMB: 1024})
+	if err != nil || cpu != 2 || memoryMB != 1024 {
+		t.Fatalf("expected default cpu with 1024MB, got cpu=%v memoryMB=%d err=%v", cpu, memoryMB, err)
+	}
+	cpu, memoryMB, err = hubcellResourceLimits(storage.ResourceLimits{CPU: 0.5})
+	if err != nil || cpu != 0.5 || memoryMB != 4096 {
+		t.Fatalf("expected cpu 0.5 with default memory, got cpu=%v memoryMB=%d err=%v", cpu, memoryMB, err)
+	}
+	if _, _, err := hubcellResourceLimits(storage.ResourceLimits{MemoryMB: 64}); err == nil || !strings.Contains(err.Error(), "below the 512 MB") {
+		t.Fatalf("expected a memory limit below the minimum to be rejected, got %v", err)
+	}
+}
+
+func TestResolvedBuildArgEntriesLeavesOutSecrets(t *testing.T) {
+	got := resolvedBuildArgEntries(envplan.Result{
 		BuildArgs: map[string]string{
-			"APP_ENV": "production",
+			"APP_ENV":   "production",
+			"API_TOKEN": "s3cret",
 		},
 		BuildSecrets: map[string]string{
+			"API_TOKEN":    "s3cret",
 			"DATABASE_URL": "postgres://db/app",
 		},
 	})
 
-	want := []string{
-		`APP_ENV="production"`,
-		`DATABASE_URL="postgres://db/app"`,
-	}
+	want := []string{`APP_ENV="production"`}
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("expected env entries %v, got %v", want, got)
 	}
 }
+
+func TestWriteBuildSecretsWritesPrivateFiles(t *testing.T) {
+	dir, secrets, err := writeBuildSecrets(map[string]string{"NPM_TOKEN": "s3cret", "API_KEY": "k"})
+	if err != nil {
+		t.Fatalf("failed to write build secrets: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if len(secrets) != 2 || secrets[0].ID != "API_KEY" || secrets[1].ID != "NPM_TOKEN" {
+		t.Fatalf("expected secrets sorted by id, got %+v", secrets)
+	}
+	data, err := os.ReadFile(secrets[1].Path)
+	if err != nil || string(data) != "s3cret" {
+		t.Fatalf("expected the secret value in its file, got %q (%v)", data, err)
+	}
+	info, err := os.Stat(secrets[1].Path)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected a 0600 secret file, got %v (%v)", info.Mode(), err)
+	}
+	if none, mounts, err := writeBuildSecrets(nil); none != "" || mounts != nil || err != nil {
+		t.Fatalf("expected nothing written without secrets, got %q %v %v", none, mounts, err)
+	}
+}
+
+func TestPackBuildOptsUsesConfiguredBuilderAndEnvFile(t *testing.T) {
+	t.Setenv("BUILDPACKS_BUILDER", "paketobuildpacks/builder-jammy-full")
+	t.Setenv("BUILDPACKS_PUBLISH", "true")
+	t.Setenv("PACK_CLI_PATH", "/opt/pack/bin/pack")
+	worker := &Worker{workDir: "/tmp/repo"}
+
+	opts := worker.packBuildOpts("/tmp/repo/app", "project-net", "hubcell.local/user/project:tag", "/tmp/hubfly-pack-env-1/env")
+
+	if opts.Builder != "paketobuildpacks/builder-jammy-full" || opts.PackPath != "/opt/pack/bin/pack" || !opts.Publish {
+		t.Fatalf("expected configured pack settings, got %+v", opts)
+	}
+	if opts.ImageTag != "hubcell.local/user/project:tag" || opts.ContextPath != "/tmp/repo/app" || opts.Network != "project-net" {
+		t.Fatalf("unexpected pack build target: %+v", opts)
+	}
+	if opts.EnvFile != "/tmp/hubfly-pack-env-1/env" || len(opts.Envs) != 0 {
+		t.Fatalf("expected the env to go through the env file only, got %+v", opts)
+	}
+}
+
+func TestWritePackEnvFileKeepsSecretsOffArgv(t *testing.T) {
+	dir, path, err := writePackEnvFile(envplan.Result{
+		BuildArgs:    map[string]string{"APP_ENV": "production"},
+		BuildSecrets: map[string]string{"TOKEN": `a"b`},
+	})
+	if err != nil {
+		t.Fatalf("failed to write pack env file: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "APP_ENV=production\nTOKEN=a\"b\n" {
+		t.Fatalf("expected unquoted env entries in the file, got %q (%v)", data, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected a 0600 env file, got %v (%v)", info.Mode(), err)
+	}
+	if info, err := os.Stat(dir); err != nil || info.Mode().Perm() != 0o700 {
+		t.Fatalf("expected a 0700 env directory, got %v (%v)", info.Mode(), err)
+	}
+
+	worker := &Worker{workDir: "/tmp/repo"}
+	cmd := driver.PackBuildCommandContext(context.Background(), worker.packBuildOpts("/tmp/repo", "", "hubcell.local/user/project:tag", path))
+	for _, arg := range cmd.Args {
+		if strings.Contains(arg, `a"b`) {
+			t.Fatalf("expected the secret to stay off pack's argv, got %v", cmd.Args)
+		}
+	}
+
+	if _, _, err := writePackEnvFile(envplan.Result{BuildSecrets: map[string]string{"KEY": "line1\nline2"}}); err == nil {
+		t.Fatal("expected a multi-line value to be rejected")
+	}
+	if none, file, err := writePackEnvFile(envplan.Result{}); none != "" || file != "" || err != nil {
+		t.Fatalf("expected nothing written without env, got %q %q %v", none, file, err)
+	}
+}
+
+func TestPackBuildOptsDefaultsBuilder(t *testing.T) {
+	t.Setenv("BUILDPACKS_BUILDER", "")
+	t.Setenv("BUILDPACKS_PUBLISH", "")
+	worker := &Worker{workDir: "/tmp/repo"}
+
+	opts := worker.packBuildOpts("/tmp/repo", "", "hubcell.local/user/project:tag", "")
+	if opts.Builder != driver.DefaultBuildpacksBuilder {
+		t.Fatalf("expected default builder %q, got %q", driver.DefaultBuildpacksBuilder, opts.Builder)
+	}
+	if opts.Publish {
+		t.Fatal("expected publish to be off by default")
+	}
+}
+
+func TestRunPreBuildHooksFailureAbortsBuild(t *testing.T) {
+	worker := newTestWorker(t, nil)
+	worker.hooks = BuildHooks{
+		PreBuild: []string{"exit 3", "touch should-not-run"},
+	}
+	worker.allowlist = &allowlist.AllowedCommands{Hooks: []string{"exit 3", "touch should-not-run"}}
+
+	if err := worker.runPreBuildHooks(); err == nil {
+		t.Fatalf("expected failing pre-build hook to return an error")
+	}
+	if _, err := os.Stat(filepath.Join(worker.workDir, "should-not-run")); !os.IsNotExist(err) {
+		t.Fatalf("expected hooks after the failed pre-build hook to be skipped")
+	}
+}
+
+func TestRunPreBuildHooksRejectsHookOutsideAllowlist(t *testing.T) {
+	worker := newTestWorker(t, nil)
+	worker.hooks = BuildHooks{
+		PreBuild: []string{"touch license-checked"},
+	}
+	worker.allowlist = &allowlist.AllowedCommands{}
+
+	err := worker.runPreBuildHooks()
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("expected allowlist rejection, got %v", err)
+	}
+}
+
+func TestRunPostBuildHooksRunsWithJobMetadata(t *testing.T) {
+	worker := newTestWorker(t, nil)
+	worker.hooks = BuildHooks{
+		PostBuild: []string{`echo "$HUBFLY_JOB_ID" > post-hook-ran`},
+	}
+	worker.allowlist = &allowlist.AllowedCommands{Hooks: []string{`echo "$HUBFLY_JOB_ID" > post-hook-ran`}}
+	worker.job.ImageTag = "hubcell.local/user/proj:latest"
+
+	if err := worker.runPostBuildHooks(); err != nil {
+		t.Fatalf("expected post-build hook to succeed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(worker.workDir, "post-hook-ran"))
+	if err != nil {
+		t.Fatalf("expected post-build hook output file: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != "build_test" {
+		t.Fatalf("expected job id in hook env, got %q", string(data))
+	}
+}
+
+func TestRunPostBuildHooksFailureIsConfigurable(t *testing.T) {
+	worker := newTestWorker(t, nil)
+	worker.hooks = BuildHooks{
+		PostBuild: []string{"exit 1"},
+	}
+	worker.allowlist = &allowlist.AllowedCommands{Hooks: []string{"exit 1"}}
+
+	if err := worker.runPostBuildHooks(); err != nil {
+		t.Fatalf("expected non-fatal post-build hook failure to be ignored: %v", err)
+	}
+
+	worker.hooks.FailOnPostBuildError = true
+	if err := worker.runPostBuildHooks(); err == nil {
+		t.Fatalf("expected fatal post-build hook failure to return an error")
+	}
+}
+
+func TestStreamPipeCapturesLinesLongerThanScannerDefault(t *testing.T) {
+	var logged bytes.Buffer
+	worker := &Worker{logWriter: &logged}
+	longLine := strings.Repeat("x", 200*1024)
+	hugeLine := strings.Repeat("y", maxLogLineBytes+10)
+	capture := &outputCapture{}
+
+	worker.streamPipe(strings.NewReader(longLine+"\n"+hugeLine+"\nnext line\n"), capture)
+
+	captured := capture.String()
+	if !strings.Contains(captured, longLine+"\n") {
+		t.Fatalf("expected the 200KB line to be captured intact")
+	}
+	if strings.Count(captured, "y") != len(hugeLine) {
+		t.Fatalf("expected every byte of the oversized line to be captured, got %d of %d", strings.Count(captured, "y"), len(hugeLine))
+	}
+	if !strings.HasSuffix(captured, "next line\n") {
+		t.Fatalf("expected output after the long lines to be captured, got suffix %q", captured[len(captured)-20:])
+	}
+	if strings.Contains(logged.String(), "WARNING") {
+		t.Fatalf("expected no scanner error to be logged")
+	}
+}
+
+func TestFailJobDoesNotOverwriteFinishedJob(t *testing.T) {
+	store := newDedupTestStorage(t)
+	job := &storage.BuildJob{ID: "build_finished", ProjectID: "proj", UserID: "user"}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	if err := store.UpdateJobStatus(job.ID, "success"); err != nil {
+		t.Fatalf("failed to update status: %v", err)
+	}
+	worker := &Worker{job: job, storage: store, apiClient: api.NewClient(""), logWriter: io.Discard}
+
+	worker.failJob("late failure")
+
+	stored, err := store.GetJob(job.ID)
+	if err != nil {
+		t.Fatalf("failed to load job: %v", err)
+	}
+	if stored.Status != "success" {
+		t.Fatalf("expected a finished job to keep its status, got %q", stored.Status)
+	}
+}
+
+func TestRunLoggedPersistsLogWhenBuildPanics(t *testing.T) {
+	store := newDedupTestStorage(t)
+	job := &storage.BuildJob{ID: "build_panic", ProjectID: "proj", UserID: "user"}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	if err := store.UpdateJobStatus(job.ID, "building"); err != nil {
+		t.Fatalf("failed to update status: %v", err)
+	}
+	logManager, err := logs.NewLogManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	logPath, logFile, err := logManager.CreateLogFile(job.ID)
+	if err != nil {
+		t.Fatalf("failed to create log file: %v", err)
+	}
+	worker := &Worker{job: job, storage: store, apiClient: api.NewClient(""), logFile: logFile, logWriter: logFile}
+
+	err = worker.runLogged(func() error {
+		worker.log("Cloning repository")
+		worker.log("Building image")
+		panic("boom")
+	})
+	if !errors.Is(err, ErrBuildFailed) {
+		t.Fatalf("expected a panic to fail the build, got %v", err)
+	}
+
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+	for _, want := range []string{"Cloning repository", "Building image", "ERROR: builder panicked: boom"} {
+		if !strings.Contains(string(content), want) {
+			t.Fatalf("expected log to contain %q, got %q", want, content)
+		}
+	}
+	if stored, err := store.GetJob(job.ID); err != nil || stored.Status != "failed" {
+		t.Fatalf("expected the job to be failed, got %+v (err %v)", stored, err)
+	}
+}
+
+func TestApplyDetectedBuildConfigReportsDetectedRuntimeVersion(t *testing.T) {
+	job := &storage.BuildJob{
+		ID:          "build_detected",
+		BuildConfig: storage.BuildConfig{IsAutoBuild: true, Runtime: "node", Version: "18"},
+	}
+	applyDetectedBuildConfig(&job.BuildConfig, autodetect.BuildConfig{Runtime: "bun", Version: "1.2"})
+
+	var payload api.ReportPayload
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer callback.Close()
+
+	if err := api.NewClient(callback.URL).ReportResult(job, "success", ""); err != nil {
+		t.Fatalf("ReportResult returned error: %v", err)
+	}
+	if payload.Runtime != "bun" || payload.Version != "1.2" {
+		t.Fatalf("expected the detected runtime and version in the callback, got %q %q", payload.Runtime, payload.Version)
+	}
+}
+
+func TestOutputCaptureKeepsTheTail(t *testing.T) {
+	capture := &outputCapture{}
+	line := strings.Repeat("x", 1<<10)
+	for i := 0; i < 2*maxCapturedOutputBytes/len(line); i++ {
+		capture.add(line)
+	}
+	capture.add("ERROR: the last line")
+
+	output := capture.String()
+	if len(output) > maxCapturedOutputBytes || !strings.HasSuffix(output, "ERROR: the last line\n") {
+		t.Fatalf("expected at most %d bytes ending in the last line, got %d bytes", maxCapturedOutputBytes, len(output))
+	}
+}
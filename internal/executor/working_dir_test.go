@@ -0,0 +1,153 @@
+package executor
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hubfly-builder/internal/allowlist"
+	"hubfly-builder/internal/api"
+	"hubfly-builder/internal/jobstate"
+	"hubfly-builder/internal/logs"
+	"hubfly-builder/internal/storage"
+)
+
+func TestResolveWorkspacePath(t *testing.T) {
+	repo := t.TempDir()
+	writeContextFiles(t, repo, map[string]string{"services/api/main.go": "package main\n", "README.md": "mono\n"})
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(repo, "services", "escape")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	appDir, appPath, err := resolveWorkspacePath(repo, "./services/api/")
+	if err != nil || appDir != "services/api" || appPath != filepath.Join(repo, "services", "api") {
+		t.Fatalf("expected services/api, got %q %q (err %v)", appDir, appPath, err)
+	}
+	for _, workingDir := range []string{"../other", "/etc", "services/missing", "README.md", "services/escape"} {
+		if _, _, err := resolveWorkspacePath(repo, workingDir); err == nil {
+			t.Fatalf("expected working directory %q to be rejected", workingDir)
+		}
+	}
+}
+
+func TestAutoBuildUsesWorkingDirectoryAsContext(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	sudoCalls := installFakeSudo(t)
+	repo := writeGitRepository(t, map[string]string{
+		"README.md":            "mono\n",
+		"services/api/go.mod":  "module api\n\ngo 1.22\n",
+		"services/api/main.go": "package main\n\nfunc main() {}\n",
+		"services/web/app.js":  "console.log('web')\n",
+	})
+	store := newDedupTestStorage(t)
+	logManager, err := logs.NewLogManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	job := &storage.BuildJob{
+		ID:          "build_mono",
+		ProjectID:   "proj",
+		UserID:      "user",
+		SourceInfo:  storage.SourceInfo{GitRepository: repo, WorkingDir: "services/api"},
+		BuildConfig: storage.BuildConfig{IsAutoBuild: true, Network: "proj-network"},
+	}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	manager := NewManager(store, logManager, allowlist.DefaultAllowedCommands(), api.NewClient(""), 1, "")
+
+	built := runResumeTestJob(t, manager, store, job.ID, jobstate.Success)
+	if built.BuildConfig.AppDir != "services/api" || built.BuildConfig.BuildContextDir != "services/api" {
+		t.Fatalf("expected services/api as the app dir and build context, got %q and %q", built.BuildConfig.AppDir, built.BuildConfig.BuildContextDir)
+	}
+	if built.BuildConfig.Runtime != "go" {
+		t.Fatalf("expected the Go service to be detected, got runtime %q", built.BuildConfig.Runtime)
+	}
+	var buildCall string
+	for _, call := range strings.Split(readSudoCalls(t, sudoCalls), "\n") {
+		if strings.Contains(call, " build ") {
+			buildCall = call
+		}
+	}
+	if !strings.Contains(buildCall, filepath.Join("services", "api")) {
+		t.Fatalf("expected the build context to be services/api, got %q", buildCall)
+	}
+}
+
+func TestCustomDockerfileBuildsRepositoryContextFromOutside(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	sudoCalls := installFakeSudo(t)
+	// Record the Dockerfile in the context Hubcell is given, the last argument.
+	script := "#!/bin/sh\necho \"$@\" >> " + sudoCalls + "\nfor context; do :; done\ncat \"$context/Dockerfile\" >> " + sudoCalls + " 2>/dev/null\nexit 0\n"
+	if err := os.WriteFile(filepath.Join(filepath.Dir(sudoCalls), "sudo"), []byte(script), 0o755); err != nil {
+		t.Fatalf("failed to write fake sudo: %v", err)
+	}
+	repo := writeGitRepository(t, map[string]string{
+		"README.md":               "mono\n",
+		"services/api/Dockerfile": "FROM alpine:3.20\nLABEL source=repository\n",
+		"services/api/server.js":  "console.log('api')\n",
+		"services/web/index.html": "<p>web</p>\n",
+	})
+	store := newDedupTestStorage(t)
+	logManager, err := logs.NewLogManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	custom := "FROM node:20-alpine\nLABEL source=request\nCOPY . /app\nCMD [\"node\", \"/app/server.js\"]\n"
+	job := &storage.BuildJob{
+		ID:          "build_external_dockerfile",
+		ProjectID:   "proj",
+		UserID:      "user",
+		SourceInfo:  storage.SourceInfo{GitRepository: repo, WorkingDir: "services/api"},
+		BuildConfig: storage.BuildConfig{CustomDockerfile: custom, Network: "proj-network"},
+	}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	manager := NewManager(store, logManager, allowlist.DefaultAllowedCommands(), api.NewClient(""), 1, "")
+
+	built := runResumeTestJob(t, manager, store, job.ID, jobstate.Success)
+	if built.BuildConfig.BuildContextDir != "services/api" || built.BuildConfig.UsesRepoDockerfile {
+		t.Fatalf("expected services/api as the context of a custom Dockerfile, got %q (repo Dockerfile %v)", built.BuildConfig.BuildContextDir, built.BuildConfig.UsesRepoDockerfile)
+	}
+	if string(built.BuildConfig.DockerfileContent) != custom {
+		t.Fatalf("expected the custom Dockerfile to be recorded, got %q", built.BuildConfig.DockerfileContent)
+	}
+
+	calls := readSudoCalls(t, sudoCalls)
+	var args []string
+	for _, call := range strings.Split(calls, "\n") {
+		if strings.Contains(call, " build ") {
+			args = strings.Fields(call)
+		}
+	}
+	if len(args) == 0 {
+		t.Fatalf("expected a hubcell build, got calls:\n%s", calls)
+	}
+	context := args[len(args)-1]
+	if filepath.Base(filepath.Dir(context)) != "services" || filepath.Base(context) != "api" {
+		t.Fatalf("expected services/api as the build context, got %q", context)
+	}
+	var dockerfile string
+	for i, arg := range args[:len(args)-1] {
+		if arg == "-f" {
+			dockerfile = args[i+1]
+		}
+	}
+	if !filepath.IsAbs(dockerfile) || strings.HasPrefix(dockerfile, context) {
+		t.Fatalf("expected -f to name a Dockerfile outside the context %q, got %q", context, dockerfile)
+	}
+	if !strings.Contains(calls, "LABEL source=repository") || strings.Contains(calls, "LABEL source=request") {
+		t.Fatalf("expected the repository Dockerfile to be left in the context, got:\n%s", calls)
+	}
+	if _, err := os.Stat(dockerfile); !os.IsNotExist(err) {
+		t.Fatalf("expected the staged Dockerfile to be removed after the build, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+// Package gitauth lets git authenticate to private remotes with a job's
+// credential without the secret reaching a command line, a remote URL or a
+// log. The secret is written to files in a private temporary directory that
+// git reads through GIT_ASKPASS (HTTPS tokens) or GIT_SSH_COMMAND (SSH keys).
+package gitauth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"hubfly-builder/internal/outbound"
+	"hubfly-builder/internal/storage"
+)
+
+// Session holds the credential files for one job. Close removes them.
+type Session struct {
+	dir string
+	env []string
+}
+
+// Prepare writes credential to a new private directory. It returns a nil
+// Session, which applies nothing, when credential carries no secret. A
+// credential that names its secret by reference must be resolved with
+// ResolveCredential first.
+func Prepare(credential *storage.GitCredential) (*Session, error) {
+	if !credential.HasSecret() {
+		return nil, nil
+	}
+	if credential.HasRef() {
+		return nil, errors.New("git credential reference has not been resolved")
+	}
+	dir, err := os.MkdirTemp("", "hubfly-git-auth-")
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{dir: dir}
+	if credential.Kind() == "token" {
+		err = session.writeToken(credential)
+	} else {
+		err = session.writeSSHKey(credential)
+	}
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *Session) writeToken(credential *storage.GitCredential) error {
+	username := strings.TrimSpace(credential.Username)
+	if username == "" {
+		username = storage.DefaultGitUsername
+	}
+	usernamePath := filepath.Join(s.dir, "username")
+	tokenPath := filepath.Join(s.dir, "token")
+	askpassPath := filepath.Join(s.dir, "askpass")
+	if err := os.WriteFile(usernamePath, []byte(username), 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(tokenPath, []byte(strings.TrimSpace(credential.Token)), 0o600); err != nil {
+		return err
+	}
+	// git asks "Username for '...'" and then "Password for '...'".
+	script := "#!/bin/sh\ncase \"$1\" in\nUsername*) cat " + shellQuote(usernamePath) + " ;;\n*) cat " + shellQuote(tokenPath) + " ;;\nesac\n"
+	if err := os.WriteFile(askpassPath, []byte(script), 0o700); err != nil {
+		return err
+	}
+	s.env = []string{"GIT_ASKPASS=" + askpassPath, "GIT_TERMINAL_PROMPT=0"}
+	return nil
+}
+
+func (s *Session) writeSSHKey(credential *storage.GitCredential) error {
+	key := strings.TrimSpace(credential.SSHKey) + "\n"
+	keyPath := filepath.Join(s.dir, "id_key")
+	knownHostsPath := filepath.Join(s.dir, "known_hosts")
+	if err := os.WriteFile(keyPath, []byte(key), 0o600); err != nil {
+		return err
+	}
+	hostKeyChecking := "accept-new"
+	if knownHosts := strings.TrimSpace(credential.KnownHosts); knownHosts != "" {
+		hostKeyChecking = "yes"
+		if err := os.WriteFile(knownHostsPath, []byte(knownHosts+"\n"), 0o600); err != nil {
+			return err
+		}
+	}
+	sshCommand := fmt.Sprintf("ssh -i %s -o IdentitiesOnly=yes -o BatchMode=yes -o UserKnownHostsFile=%s -o StrictHostKeyChecking=%s",
+		shellQuote(keyPath), shellQuote(knownHostsPath), hostKeyChecking)
+	s.env = []string{"GIT_SSH_COMMAND=" + sshCommand, "GIT_TERMINAL_PROMPT=0"}
+	return nil
+}
+
+// Env returns the environment entries that point git at the credential.
+func (s *Session) Env() []string {
+	if s == nil {
+		return nil
+	}
+	return s.env
+}
+
+// Apply sets the environment of git command cmd to the operator's outbound
+// settings plus, when s is not nil, the credential.
+func (s *Session) Apply(cmd *exec.Cmd) {
+	env := outbound.GitCommandEnv()
+	if extra := s.Env(); len(extra) > 0 {
+		if env == nil {
+			env = os.Environ()
+		}
+		env = append(env, extra...)
+	}
+	cmd.Env = env
+}
+
+// Close removes the credential files. It is safe to call more than once and
+// on a nil Session.
+func (s *Session) Close() error {
+	if s == nil || s.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(s.dir)
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		s.dir = ""
+		s.env = nil
+		return nil
+	}
+	return err
+}
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,154 @@
+package gitauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"hubfly-builder/internal/storage"
+)
+
+// SecretRefScheme prefixes the credential references the builder resolves.
+const SecretRefScheme = "vault://"
+
+// maxSecretFileSize bounds the files FileResolver reads.
+const maxSecretFileSize = 64 << 10
+
+// ErrNoSecretResolver reports a credential reference on a builder that has no
+// secret store configured.
+var ErrNoSecretResolver = errors.New("git credential references are not enabled on this builder")
+
+// SecretResolver looks up the secret a credential reference names.
+type SecretResolver interface {
+	ResolveSecret(ref string) (string, error)
+}
+
+// ParseSecretRef splits "vault://<path>#<field>" into its path and optional
+// field. The path must be relative and must not leave the secret store.
+func ParseSecretRef(ref string) (string, string, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(ref), SecretRefScheme)
+	if !ok {
+		return "", "", fmt.Errorf("credential reference %q must start with %s", ref, SecretRefScheme)
+	}
+	path, field, _ := strings.Cut(rest, "#")
+	cleaned := filepath.Clean(filepath.FromSlash(path))
+	if path == "" || filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(os.PathSeparator)) {
+		return "", "", fmt.Errorf("credential reference %q must name a path inside the secret store", ref)
+	}
+	return cleaned, field, nil
+}
+
+// ValidateCredentialRef checks the reference of credential, if it has one,
+// before the job is accepted.
+func ValidateCredentialRef(credential *storage.GitCredential, resolver SecretResolver) error {
+	if !credential.HasRef() {
+		return nil
+	}
+	if resolver == nil {
+		return ErrNoSecretResolver
+	}
+	_, _, err := ParseSecretRef(credentialRef(credential))
+	return err
+}
+
+// ResolveCredential returns credential with its reference replaced by the
+// secret it names. A credential without a reference is returned as is.
+func ResolveCredential(credential *storage.GitCredential, resolver SecretResolver) (*storage.GitCredential, error) {
+	if !credential.HasRef() {
+		return credential, nil
+	}
+	if resolver == nil {
+		return nil, ErrNoSecretResolver
+	}
+	ref := credentialRef(credential)
+	secret, err := resolver.ResolveSecret(ref)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve git credential %s: %w", ref, err)
+	}
+	if strings.TrimSpace(secret) == "" {
+		return nil, fmt.Errorf("git credential %s is empty", ref)
+	}
+	resolved := *credential
+	if credential.TokenRef != "" {
+		resolved.Token, resolved.TokenRef = secret, ""
+	} else {
+		resolved.SSHKey, resolved.SSHKeyRef = secret, ""
+	}
+	return &resolved, nil
+}
+
+func credentialRef(credential *storage.GitCredential) string {
+	if credential.TokenRef != "" {
+		return credential.TokenRef
+	}
+	return credential.SSHKeyRef
+}
+
+// FileResolver resolves "vault://<path>#<field>" from the files under Dir.
+// The path names a file relative to Dir. With a field the file holds a JSON
+// object and the secret is that field's string value; without one the whole
+// file, trimmed, is the secret.
+type FileResolver struct {
+	Dir string
+}
+
+func (r FileResolver) ResolveSecret(ref string) (string, error) {
+	path, field, err := ParseSecretRef(ref)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := r.resolvePath(path)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.Open(resolved)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxSecretFileSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxSecretFileSize {
+		return "", fmt.Errorf("secret file is larger than %d bytes", maxSecretFileSize)
+	}
+	if field == "" {
+		return strings.TrimSpace(string(data)), nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", fmt.Errorf("secret file is not a JSON object: %w", err)
+	}
+	raw, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("secret file has no field %q", field)
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("secret field %q is not a string", field)
+	}
+	return value, nil
+}
+
+// resolvePath follows symlinks in path and refuses a file that then lies
+// outside Dir, so a link in the vault cannot expose other files on the host.
+func (r FileResolver) resolvePath(path string) (string, error) {
+	root, err := filepath.EvalSymlinks(r.Dir)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, path))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("secret reference %q resolves outside the vault", path)
+	}
+	return resolved, nil
+}
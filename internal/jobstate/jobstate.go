@@ -0,0 +1,94 @@
+// Package jobstate defines the build job lifecycle and the transitions
+// allowed between its statuses.
+package jobstate
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Status string
+
+const (
+	Pending  Status = "pending"
+	Claimed  Status = "claimed"
+	Building Status = "building"
+	Success  Status = "success"
+	Failed   Status = "failed"
+	Canceled Status = "canceled"
+	Skipped  Status = "skipped"
+
+	// Cancelling is an in-progress job whose worker has been asked to stop.
+	Cancelling Status = "cancelling"
+)
+
+type Event string
+
+const (
+	// Claim is a worker taking a pending job off the queue.
+	Claim Event = "claim"
+	// Start is the claimed job's build beginning.
+	Start   Event = "start"
+	Succeed Event = "succeed"
+	Fail    Event = "fail"
+	Cancel  Event = "cancel"
+	// Interrupt asks the worker of an in-progress job to stop; the worker
+	// then records the job as canceled.
+	Interrupt Event = "interrupt"
+	// Retry puts a failed job back on the queue.
+	Retry Event = "retry"
+	// Requeue returns an in-progress job to the queue, e.g. after a restart.
+	Requeue Event = "requeue"
+	// Skip records a job that needs no build.
+	Skip Event = "skip"
+)
+
+var ErrIllegalTransition = errors.New("illegal job status transition")
+
+var transitions = map[Status]map[Event]Status{
+	Pending: {
+		Claim:  Claimed,
+		Fail:   Failed,
+		Cancel: Canceled,
+		Skip:   Skipped,
+	},
+	Claimed: {
+		Start:     Building,
+		Fail:      Failed,
+		Cancel:    Canceled,
+		Interrupt: Cancelling,
+		Requeue:   Pending,
+	},
+	Building: {
+		Succeed:   Success,
+		Fail:      Failed,
+		Cancel:    Canceled,
+		Interrupt: Cancelling,
+		Requeue:   Pending,
+	},
+	// A build that finishes before its worker notices the interrupt keeps
+	// its real outcome.
+	Cancelling: {
+		Succeed: Success,
+		Fail:    Failed,
+		Cancel:  Canceled,
+	},
+	Failed: {
+		Retry: Pending,
+	},
+}
+
+// Transition returns the status event moves current to, or an error wrapping
+// ErrIllegalTransition when current does not accept event. Success, Canceled
+// and Skipped are terminal.
+func Transition(current Status, event Event) (Status, error) {
+	if next, ok := transitions[current][event]; ok {
+		return next, nil
+	}
+	return "", fmt.Errorf("%w: %s on %q", ErrIllegalTransition, event, current)
+}
+
+// IsTerminal reports whether status accepts no further events.
+func IsTerminal(status Status) bool {
+	return len(transitions[status]) == 0
+}
@@ -0,0 +1,52 @@
+package jobstate
+
+import (
+	"errors"
+	"testing"
+)
+
+var allStatuses = []Status{Pending, Claimed, Building, Cancelling, Success, Failed, Canceled, Skipped}
+
+var allEvents = []Event{Claim, Start, Succeed, Fail, Cancel, Interrupt, Retry, Requeue, Skip}
+
+func TestTransitionAllowsOnlyLegalMoves(t *testing.T) {
+	legal := map[Status]map[Event]Status{
+		Pending:    {Claim: Claimed, Fail: Failed, Cancel: Canceled, Skip: Skipped},
+		Claimed:    {Start: Building, Fail: Failed, Cancel: Canceled, Interrupt: Cancelling, Requeue: Pending},
+		Building:   {Succeed: Success, Fail: Failed, Cancel: Canceled, Interrupt: Cancelling, Requeue: Pending},
+		Cancelling: {Succeed: Success, Fail: Failed, Cancel: Canceled},
+		Failed:     {Retry: Pending},
+	}
+
+	for _, status := range allStatuses {
+		for _, event := range allEvents {
+			next, err := Transition(status, event)
+			want, ok := legal[status][event]
+			if ok {
+				if err != nil || next != want {
+					t.Errorf("Transition(%s, %s) = %q, %v; want %q", status, event, next, err, want)
+				}
+				continue
+			}
+			if !errors.Is(err, ErrIllegalTransition) {
+				t.Errorf("Transition(%s, %s) = %q, %v; want ErrIllegalTransition", status, event, next, err)
+			}
+		}
+	}
+}
+
+func TestTerminalStatusesRejectEveryEvent(t *testing.T) {
+	for _, status := range []Status{Success, Canceled, Skipped} {
+		if !IsTerminal(status) {
+			t.Errorf("expected %s to be terminal", status)
+		}
+	}
+	for _, status := range []Status{Pending, Claimed, Building, Cancelling, Failed} {
+		if IsTerminal(status) {
+			t.Errorf("expected %s not to be terminal", status)
+		}
+	}
+	if _, err := Transition(Success, Start); err == nil {
+		t.Fatal("expected a succeeded job to reject start")
+	}
+}
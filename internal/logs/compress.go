@@ -0,0 +1,85 @@
+package logs
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// compressedLogSuffix is appended to the name of a log Cleanup has gzipped.
+const compressedLogSuffix = ".gz"
+
+// SetCompressAfter makes Cleanup gzip job logs that have not been written to
+// for age, until they are old enough to be deleted. Zero or less disables
+// compression.
+func (m *LogManager) SetCompressAfter(age time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.compressAfter = age
+}
+
+// SetActiveJobs gives Cleanup the IDs of the jobs still running, whose logs
+// it leaves uncompressed however long they have been silent.
+func (m *LogManager) SetActiveJobs(activeJobs func() []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.activeJobs = activeJobs
+}
+
+// isJobLog reports whether name is an uncompressed job log, as opposed to a
+// system log the builder may still be writing.
+func isJobLog(name string) bool {
+	return strings.HasPrefix(name, "build-") && strings.HasSuffix(name, ".log")
+}
+
+// isActiveJobLog reports whether name is the log of one of activeJobs. The
+// timestamp after the job ID has a fixed length, so the log of job "a-1" is
+// not taken for one of job "a".
+func isActiveJobLog(name string, activeJobs []string) bool {
+	for _, jobID := range activeJobs {
+		stamp, ok := strings.CutPrefix(name, "build-"+jobID+"-")
+		if ok && len(stamp) == len("20060102T150405Z.log") {
+			return true
+		}
+	}
+	return false
+}
+
+// compressLog replaces logPath with a gzipped copy at logPath.gz. The copy
+// keeps the log's modification time, so it is deleted when the log would
+// have been.
+func compressLog(logPath string, modTime time.Time) error {
+	src, err := os.Open(logPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpPath := logPath + compressedLogSuffix + ".tmp"
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	writer := gzip.NewWriter(dst)
+	if _, err := io.Copy(writer, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmpPath, modTime, modTime); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, logPath+compressedLogSuffix); err != nil {
+		return err
+	}
+	return os.Remove(logPath)
+}
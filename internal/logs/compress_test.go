@@ -0,0 +1,125 @@
+package logs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeAgedLog(t *testing.T, path string, content []byte, age time.Duration) {
+	t.Helper()
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	modTime := time.Now().Add(-age)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("failed to age %s: %v", path, err)
+	}
+}
+
+func TestCleanupCompressesJobLogsThenGetLogReadsThem(t *testing.T) {
+	dir := t.TempDir()
+	manager, err := NewLogManager(dir)
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	manager.SetCompressAfter(24 * time.Hour)
+	content := bytes.Repeat([]byte("[2026-01-01T00:00:00Z] step output\n"), 500)
+	oldLog := filepath.Join(dir, "build-old-20260101T000000Z.log")
+	recentLog := filepath.Join(dir, "build-recent-20260107T000000Z.log")
+	expiredLog := filepath.Join(dir, "build-expired-20251201T000000Z.log")
+	systemLog := filepath.Join(dir, "system-20260101T000000Z.log")
+	writeAgedLog(t, oldLog, content, 48*time.Hour)
+	writeAgedLog(t, recentLog, content, time.Hour)
+	writeAgedLog(t, expiredLog, content, 30*24*time.Hour)
+	writeAgedLog(t, systemLog, content, 48*time.Hour)
+
+	if err := manager.Cleanup(7 * 24 * time.Hour); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+
+	if _, err := os.Stat(oldLog); !os.IsNotExist(err) {
+		t.Fatalf("expected the old log to be replaced by its compressed copy, got %v", err)
+	}
+	info, err := os.Stat(oldLog + ".gz")
+	if err != nil {
+		t.Fatalf("expected a compressed copy of the old log: %v", err)
+	}
+	if info.Size() >= int64(len(content)) {
+		t.Fatalf("expected the compressed log to be smaller than %d bytes, got %d", len(content), info.Size())
+	}
+	if time.Since(info.ModTime()) < 47*time.Hour {
+		t.Fatalf("expected the compressed log to keep the log's age, got %s", info.ModTime())
+	}
+	for _, path := range []string{recentLog, systemLog} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("expected %s to stay uncompressed: %v", filepath.Base(path), err)
+		}
+	}
+	if _, err := os.Stat(expiredLog); !os.IsNotExist(err) {
+		t.Fatalf("expected the expired log to be deleted, got %v", err)
+	}
+
+	got, err := manager.GetLog(oldLog)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("expected GetLog to decompress the old log, got %d bytes (err %v)", len(got), err)
+	}
+	got, err = manager.GetLog(recentLog)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("expected GetLog to read the recent log, got %d bytes (err %v)", len(got), err)
+	}
+
+	// A compressed log is deleted once it is past the retention window.
+	if err := manager.Cleanup(time.Hour); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(oldLog + ".gz"); !os.IsNotExist(err) {
+		t.Fatalf("expected the compressed log to be deleted, got %v", err)
+	}
+	if _, err := manager.GetLog(oldLog); !os.IsNotExist(err) {
+		t.Fatalf("expected a deleted log to be reported missing, got %v", err)
+	}
+}
+
+func TestCleanupWithoutCompressAfterLeavesLogs(t *testing.T) {
+	dir := t.TempDir()
+	manager, err := NewLogManager(dir)
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	logPath := filepath.Join(dir, "build-job-20260101T000000Z.log")
+	writeAgedLog(t, logPath, []byte("line\n"), 48*time.Hour)
+
+	if err := manager.Cleanup(7 * 24 * time.Hour); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(logPath); err != nil {
+		t.Fatalf("expected the log to stay uncompressed: %v", err)
+	}
+}
+
+func TestCleanupLeavesLogsOfActiveJobsUncompressed(t *testing.T) {
+	dir := t.TempDir()
+	manager, err := NewLogManager(dir)
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	manager.SetCompressAfter(24 * time.Hour)
+	manager.SetActiveJobs(func() []string { return []string{"running"} })
+	runningLog := filepath.Join(dir, "build-running-20260101T000000Z.log")
+	finishedLog := filepath.Join(dir, "build-running-2-20260101T000000Z.log")
+	writeAgedLog(t, runningLog, []byte("line\n"), 48*time.Hour)
+	writeAgedLog(t, finishedLog, []byte("line\n"), 48*time.Hour)
+
+	if err := manager.Cleanup(7 * 24 * time.Hour); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(runningLog); err != nil {
+		t.Fatalf("expected the log of the running job to stay uncompressed: %v", err)
+	}
+	if _, err := os.Stat(finishedLog + ".gz"); err != nil {
+		t.Fatalf("expected the log of the finished job to be compressed: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package logs
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// TruncatedMarkerPrefix starts the line a capped job log ends with.
+const TruncatedMarkerPrefix = "==> Log truncated: the job log reached its limit of "
+
+// SetMaxLogSize caps every job log created from now on at maxBytes. Zero or
+// less leaves job logs unbounded.
+func (m *LogManager) SetMaxLogSize(maxBytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxLogSize = maxBytes
+}
+
+// MarkerFormatter writes message to w as a line of a job log, in the format
+// the rest of the log is written in.
+type MarkerFormatter func(w io.Writer, message string)
+
+// LimitJobLog wraps the writer of a job log so that it stops growing at the
+// size set with SetMaxLogSize. The truncation marker is written with format,
+// or as a plain line when format is nil.
+func (m *LogManager) LimitJobLog(w io.Writer, format MarkerFormatter) io.Writer {
+	m.mu.Lock()
+	limit := m.maxLogSize
+	m.mu.Unlock()
+	if limit <= 0 {
+		return w
+	}
+	return NewLimitedWriter(w, limit, format)
+}
+
+// LimitedWriter writes at most limit bytes to the underlying writer. The first
+// write that does not fit is replaced by a truncation marker, and everything
+// after it is dropped. Writes always report success, so that a capped log
+// never fails the build or the other writers of an io.MultiWriter.
+type LimitedWriter struct {
+	mu        sync.Mutex
+	w         io.Writer
+	limit     int64
+	written   int64
+	truncated bool
+	format    MarkerFormatter
+}
+
+func NewLimitedWriter(w io.Writer, limit int64, format MarkerFormatter) *LimitedWriter {
+	return &LimitedWriter{w: w, limit: limit, format: format}
+}
+
+func (l *LimitedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.truncated {
+		return len(p), nil
+	}
+	if l.written+int64(len(p)) > l.limit {
+		l.truncated = true
+		marker := fmt.Sprintf("%s%d bytes; later output is dropped", TruncatedMarkerPrefix, l.limit)
+		if l.format != nil {
+			l.format(l.w, marker)
+		} else {
+			fmt.Fprintln(l.w, marker)
+		}
+		return len(p), nil
+	}
+	n, err := l.w.Write(p)
+	l.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return len(p), nil
+}
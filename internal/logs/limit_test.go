@@ -0,0 +1,75 @@
+package logs
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLimitedWriterCapsOutputWithMarker(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewLimitedWriter(&buf, 100, nil)
+	line := []byte(strings.Repeat("x", 29) + "\n")
+	for i := 0; i < 1000; i++ {
+		if n, err := writer.Write(line); err != nil || n != len(line) {
+			t.Fatalf("expected writes to report success, got %d, %v", n, err)
+		}
+	}
+
+	got := buf.String()
+	if !strings.HasPrefix(got, strings.Repeat(string(line), 3)+TruncatedMarkerPrefix+"100 bytes") {
+		t.Fatalf("expected 3 whole lines and the truncation marker, got %q", got)
+	}
+	if strings.Count(got, TruncatedMarkerPrefix) != 1 || int64(buf.Len()) > 100+int64(len(TruncatedMarkerPrefix))+64 {
+		t.Fatalf("expected the log to stay bounded with one marker, got %d bytes:\n%s", buf.Len(), got)
+	}
+}
+
+func TestLimitJobLogBoundsTheLogFile(t *testing.T) {
+	manager, err := NewLogManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	manager.SetMaxLogSize(4096)
+	logPath, file, err := manager.CreateLogFile("build_runaway")
+	if err != nil {
+		t.Fatalf("failed to create log file: %v", err)
+	}
+	var stdout bytes.Buffer
+	writer := io.MultiWriter(&stdout, manager.LimitJobLog(file, nil))
+	chunk := bytes.Repeat([]byte("runaway build output\n"), 100)
+	for i := 0; i < 1000; i++ {
+		if _, err := writer.Write(chunk); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	file.Close()
+
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatalf("failed to stat log: %v", err)
+	}
+	if info.Size() > 4096+256 {
+		t.Fatalf("expected the log file to stay near 4096 bytes, got %d", info.Size())
+	}
+	if stdout.Len() != 1000*len(chunk) {
+		t.Fatalf("expected the other writers to get all output, got %d bytes", stdout.Len())
+	}
+	content, _ := manager.GetLog(logPath)
+	if !bytes.Contains(content, []byte(TruncatedMarkerPrefix)) {
+		t.Fatalf("expected the truncation marker at the end of the log, got %q", content[len(content)-200:])
+	}
+}
+
+func TestLimitJobLogWithoutLimitReturnsWriter(t *testing.T) {
+	manager, err := NewLogManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	var buf bytes.Buffer
+	if manager.LimitJobLog(&buf, nil) != io.Writer(&buf) {
+		t.Fatal("expected an unlimited manager to leave the writer unwrapped")
+	}
+}
@@ -1,15 +1,26 @@
 package logs
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
 type LogManager struct {
 	logDir string
+
+	mu            sync.Mutex
+	maxLogSize    int64
+	compressAfter time.Duration
+	activeJobs    func() []string
 }
 
 func NewLogManager(logDir string) (*LogManager, error) {
@@ -45,16 +56,41 @@ func (m *LogManager) CreateSystemLogFile() (string, *os.File, error) {
 	return logPath, f, nil
 }
 
+// GetLog reads a log, or its gzipped copy once Cleanup has compressed it.
 func (m *LogManager) GetLog(logPath string) ([]byte, error) {
-	return os.ReadFile(logPath)
+	content, err := os.ReadFile(logPath)
+	if !os.IsNotExist(err) {
+		return content, err
+	}
+	compressed, gzErr := os.Open(logPath + compressedLogSuffix)
+	if gzErr != nil {
+		return nil, err
+	}
+	defer compressed.Close()
+	reader, gzErr := gzip.NewReader(compressed)
+	if gzErr != nil {
+		return nil, fmt.Errorf("could not read compressed log %s: %w", logPath, gzErr)
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
 }
 
+// Cleanup deletes logs older than maxAge and gzips job logs older than the
+// age set with SetCompressAfter.
 func (m *LogManager) Cleanup(maxAge time.Duration) error {
+	m.mu.Lock()
+	compressAfter := m.compressAfter
+	activeJobs := m.activeJobs
+	m.mu.Unlock()
 	log.Printf("Running log cleanup, max age: %s", maxAge)
 	files, err := os.ReadDir(m.logDir)
 	if err != nil {
 		return err
 	}
+	var active []string
+	if compressAfter > 0 && activeJobs != nil {
+		active = activeJobs()
+	}
 
 	for _, file := range files {
 		if file.IsDir() {
@@ -65,13 +101,60 @@ func (m *LogManager) Cleanup(maxAge time.Duration) error {
 			log.Printf("WARN: could not get info for log file %s: %v", file.Name(), err)
 			continue
 		}
-		if time.Since(info.ModTime()) > maxAge {
-			logPath := filepath.Join(m.logDir, file.Name())
+		logPath := filepath.Join(m.logDir, file.Name())
+		age := time.Since(info.ModTime())
+		if age > maxAge {
 			log.Printf("Deleting old log file: %s", logPath)
 			if err := os.Remove(logPath); err != nil {
 				log.Printf("WARN: could not delete old log file %s: %v", logPath, err)
 			}
+		} else if compressAfter > 0 && age > compressAfter && isJobLog(file.Name()) && !isActiveJobLog(file.Name(), active) {
+			if err := compressLog(logPath, info.ModTime()); err != nil {
+				log.Printf("WARN: could not compress log file %s: %v", logPath, err)
+			}
 		}
 	}
 	return nil
 }
+
+// PhaseMarkerPrefix starts the job log line a worker writes when it enters a
+// build phase, so readers can locate phases without parsing free-form output.
+const PhaseMarkerPrefix = "==> Phase: "
+
+type PhaseOffset struct {
+	Name   string `json:"name"`
+	Offset int    `json:"offset"`
+	Line   int    `json:"line"`
+}
+
+// PhaseOffsets returns the byte offset and 1-based line of every phase marker
+// in a job log, in order of appearance. Logs in the JSON format carry the
+// marker in the message of a record.
+func PhaseOffsets(content []byte) []PhaseOffset {
+	var phases []PhaseOffset
+	offset := 0
+	for index, line := range bytes.SplitAfter(content, []byte("\n")) {
+		if name, ok := phaseMarker(line); ok {
+			phases = append(phases, PhaseOffset{Name: name, Offset: offset, Line: index + 1})
+		}
+		offset += len(line)
+	}
+	return phases
+}
+
+func phaseMarker(line []byte) (string, bool) {
+	if bytes.HasPrefix(line, []byte("{")) {
+		var record struct {
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal(line, &record); err == nil {
+			name, ok := strings.CutPrefix(record.Message, PhaseMarkerPrefix)
+			return strings.TrimSpace(name), ok
+		}
+	}
+	marker := bytes.Index(line, []byte(PhaseMarkerPrefix))
+	if marker < 0 {
+		return "", false
+	}
+	return strings.TrimSpace(string(line[marker+len(PhaseMarkerPrefix):])), true
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHistogramWritesCumulativeBuckets(t *testing.T) {
+	h := NewHistogram("hubfly_test_seconds", "Test durations.", []float64{10, 1, 60})
+	for _, value := range []float64{0.5, 3, 45, 120} {
+		h.Observe(value)
+	}
+
+	var buf bytes.Buffer
+	if err := h.WriteText(&buf); err != nil {
+		t.Fatalf("failed to write histogram: %v", err)
+	}
+	want := `# HELP hubfly_test_seconds Test durations.
+# TYPE hubfly_test_seconds histogram
+hubfly_test_seconds_bucket{le="1"} 1
+hubfly_test_seconds_bucket{le="10"} 2
+hubfly_test_seconds_bucket{le="60"} 3
+hubfly_test_seconds_bucket{le="+Inf"} 4
+hubfly_test_seconds_sum 168.5
+hubfly_test_seconds_count 4
+`
+	if got := buf.String(); got != want {
+		t.Fatalf("unexpected histogram text:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestNilHistogramIgnoresObservations(t *testing.T) {
+	var h *Histogram
+	h.Observe(1)
+	var buf bytes.Buffer
+	if err := h.WriteText(&buf); err != nil || buf.Len() != 0 {
+		t.Fatalf("expected a nil histogram to write nothing, got %q (err %v)", buf.String(), err)
+	}
+}
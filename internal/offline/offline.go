@@ -33,6 +33,7 @@ type configBuild struct {
 	RunCommand         string   `json:"runCommand,omitempty"`
 	RuntimeInitCommand string   `json:"runtimeInitCommand,omitempty"`
 	ExposePort         string   `json:"exposePort,omitempty"`
+	BaseImage          string   `json:"baseImage,omitempty"`
 }
 
 type configFile struct {
@@ -56,6 +57,9 @@ type inspectBuildConfig struct {
 	BuildContextDir    string   `json:"buildContextDir,omitempty"`
 	AppDir             string   `json:"appDir,omitempty"`
 	ValidationWarnings []string `json:"validationWarnings,omitempty"`
+	BuildTool          string   `json:"buildTool,omitempty"`
+	Confidence         float64  `json:"confidence,omitempty"`
+	Signals            []string `json:"signals,omitempty"`
 }
 
 type inspectOutput struct {
@@ -102,6 +106,7 @@ func runInspect(args []string) error {
 	opts := autodetect.AutoDetectOptions{
 		RepoRoot:   projectRoot,
 		WorkingDir: normalizeDirOrDefault(cfg.Build.WorkingDir, "."),
+		BaseImage:  strings.TrimSpace(cfg.Build.BaseImage),
 	}
 
 	var buildCfg autodetect.BuildConfig
@@ -159,6 +164,9 @@ func runInspect(args []string) error {
 			BuildContextDir:    buildCfg.BuildContextDir,
 			AppDir:             buildCfg.AppDir,
 			ValidationWarnings: cloneStringSlice(buildCfg.ValidationWarnings),
+			BuildTool:          buildCfg.BuildTool,
+			Confidence:         buildCfg.Confidence,
+			Signals:            cloneStringSlice(buildCfg.Signals),
 		},
 		Dockerfile:      string(buildCfg.DockerfileContent),
 		BuildArgKeys:    buildArgKeys,
@@ -230,7 +238,15 @@ func resolveBuildEnvKeys(values []configEnvVar) ([]string, []string) {
 		buildArgs = append(buildArgs, name)
 	}
 
-	return buildArgs, secrets
+	// A name declared both ways is passed only as a secret; the generated
+	// Dockerfile warns about it.
+	args := buildArgs[:0]
+	for _, name := range buildArgs {
+		if _, ok := seenSecrets[name]; !ok {
+			args = append(args, name)
+		}
+	}
+	return args, secrets
 }
 
 func normalizeDirOrDefault(value, fallback string) string {
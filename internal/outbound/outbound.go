@@ -0,0 +1,122 @@
+// Package outbound carries the operator-configured User-Agent and extra
+// headers for requests the builder makes to third parties: git remotes and
+// result callbacks.
+package outbound
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	UserAgentEnv      = "OUTBOUND_USER_AGENT"
+	HeadersEnv        = "OUTBOUND_HEADERS"
+	GitHeaderHostsEnv = "OUTBOUND_GIT_HEADER_HOSTS"
+)
+
+// UserAgentFromEnv returns the configured User-Agent, or "" to keep the
+// client's default.
+func UserAgentFromEnv() string {
+	return strings.TrimSpace(os.Getenv(UserAgentEnv))
+}
+
+// HeadersFromEnv parses OUTBOUND_HEADERS, a JSON object of header names to
+// values. Invalid JSON yields no headers.
+func HeadersFromEnv() map[string]string {
+	raw := strings.TrimSpace(os.Getenv(HeadersEnv))
+	if raw == "" {
+		return nil
+	}
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(raw), &headers); err != nil {
+		return nil
+	}
+	return headers
+}
+
+// GitHeaderHostsFromEnv parses OUTBOUND_GIT_HEADER_HOSTS, a JSON array of git
+// hosts such as "git.example.com", or URL prefixes such as
+// "http://git.internal:8080/", that may receive the extra headers. Invalid
+// JSON yields no hosts.
+func GitHeaderHostsFromEnv() []string {
+	raw := strings.TrimSpace(os.Getenv(GitHeaderHostsEnv))
+	if raw == "" {
+		return nil
+	}
+	var hosts []string
+	if err := json.Unmarshal([]byte(raw), &hosts); err != nil {
+		return nil
+	}
+	return hosts
+}
+
+// gitHeaderURL turns a configured host into the URL prefix of an
+// http.<url>.extraHeader key. Bare hosts mean HTTPS.
+func gitHeaderURL(host string) string {
+	host = strings.TrimSpace(host)
+	if host == "" {
+		return ""
+	}
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+	if !strings.HasSuffix(host, "/") {
+		host += "/"
+	}
+	return host
+}
+
+// GitEnv returns environment entries that make git send the configured
+// User-Agent on HTTP(S) remotes, and the extra headers only on remotes under
+// OUTBOUND_GIT_HEADER_HOSTS: the headers may carry operator credentials, and
+// most remotes are chosen by tenants. Headers go through GIT_CONFIG_* rather
+// than `-c` so their values never appear in logged command lines.
+func GitEnv() []string {
+	var env []string
+	if userAgent := UserAgentFromEnv(); userAgent != "" {
+		env = append(env, "GIT_HTTP_USER_AGENT="+userAgent)
+	}
+
+	headers := HeadersFromEnv()
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		if strings.TrimSpace(name) != "" {
+			names = append(names, name)
+		}
+	}
+	var urls []string
+	for _, host := range GitHeaderHostsFromEnv() {
+		if prefix := gitHeaderURL(host); prefix != "" {
+			urls = append(urls, prefix)
+		}
+	}
+	if len(names) == 0 || len(urls) == 0 {
+		return env
+	}
+	sort.Strings(names)
+	env = append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(names)*len(urls)))
+	i := 0
+	for _, prefix := range urls {
+		for _, name := range names {
+			env = append(env,
+				fmt.Sprintf("GIT_CONFIG_KEY_%d=http.%s.extraHeader", i, prefix),
+				fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s: %s", i, strings.TrimSpace(name), headers[name]),
+			)
+			i++
+		}
+	}
+	return env
+}
+
+// GitCommandEnv is os.Environ() plus GitEnv(), or nil when nothing is
+// configured so exec.Cmd keeps inheriting the environment.
+func GitCommandEnv() []string {
+	extra := GitEnv()
+	if len(extra) == 0 {
+		return nil
+	}
+	return append(os.Environ(), extra...)
+}
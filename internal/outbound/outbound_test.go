@@ -0,0 +1,48 @@
+package outbound
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGitEnvSetsUserAgentAndExtraHeaders(t *testing.T) {
+	t.Setenv(UserAgentEnv, "hubfly-builder/1.2")
+	t.Setenv(HeadersEnv, `{"X-Team":"builds","Authorization":"Bearer abc"}`)
+	t.Setenv(GitHeaderHostsEnv, `["git.hubfly.space","http://git.internal:8080"]`)
+
+	want := []string{
+		"GIT_HTTP_USER_AGENT=hubfly-builder/1.2",
+		"GIT_CONFIG_COUNT=4",
+		"GIT_CONFIG_KEY_0=http.https://git.hubfly.space/.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Bearer abc",
+		"GIT_CONFIG_KEY_1=http.https://git.hubfly.space/.extraHeader",
+		"GIT_CONFIG_VALUE_1=X-Team: builds",
+		"GIT_CONFIG_KEY_2=http.http://git.internal:8080/.extraHeader",
+		"GIT_CONFIG_VALUE_2=Authorization: Bearer abc",
+		"GIT_CONFIG_KEY_3=http.http://git.internal:8080/.extraHeader",
+		"GIT_CONFIG_VALUE_3=X-Team: builds",
+	}
+	if got := GitEnv(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("GitEnv() = %#v, want %#v", got, want)
+	}
+}
+
+func TestGitEnvWithholdsHeadersWithoutConfiguredHosts(t *testing.T) {
+	t.Setenv(UserAgentEnv, "hubfly-builder/1.2")
+	t.Setenv(HeadersEnv, `{"Authorization":"Bearer abc"}`)
+	t.Setenv(GitHeaderHostsEnv, "")
+
+	want := []string{"GIT_HTTP_USER_AGENT=hubfly-builder/1.2"}
+	if got := GitEnv(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("GitEnv() = %#v, want %#v", got, want)
+	}
+}
+
+func TestGitCommandEnvIsNilWhenUnconfigured(t *testing.T) {
+	t.Setenv(UserAgentEnv, "")
+	t.Setenv(HeadersEnv, "")
+
+	if env := GitCommandEnv(); env != nil {
+		t.Fatalf("expected nil env so commands inherit the environment, got %d entries", len(env))
+	}
+}
@@ -0,0 +1,73 @@
+// Package pathfilter matches slash-separated repository paths against
+// gitignore-style globs.
+package pathfilter
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Compile turns a glob into an anchored regexp. `*` and `?` stay within one
+// path segment, `**` spans segments, and a leading or trailing slash is
+// ignored.
+func Compile(pattern string) *regexp.Regexp {
+	pattern = strings.Trim(path.Clean(strings.TrimSpace(pattern)), "/")
+	return regexp.MustCompile("^" + globRegexp(pattern) + "$")
+}
+
+// MatchesPathOrParent reports whether pattern matches rel or one of its
+// parent directories, so a directory pattern covers everything below it.
+func MatchesPathOrParent(pattern *regexp.Regexp, rel string) bool {
+	for candidate := strings.Trim(rel, "/"); candidate != "." && candidate != ""; candidate = path.Dir(candidate) {
+		if pattern.MatchString(candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// AnyMatch reports whether any of files falls under any of patterns.
+func AnyMatch(patterns, files []string) bool {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		compiled = append(compiled, Compile(pattern))
+	}
+	for _, file := range files {
+		file = path.Clean(strings.TrimPrefix(strings.TrimSpace(file), "./"))
+		for _, pattern := range compiled {
+			if MatchesPathOrParent(pattern, file) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func globRegexp(pattern string) string {
+	var expr strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					expr.WriteString("(.*/)?")
+				} else {
+					expr.WriteString(".*")
+				}
+				continue
+			}
+			expr.WriteString("[^/]*")
+		case '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
@@ -0,0 +1,23 @@
+package pathfilter
+
+import "testing"
+
+func TestAnyMatch(t *testing.T) {
+	patterns := []string{"services/api", "packages/*/src/**", "**/*.proto", "/go.mod"}
+	cases := map[string]bool{
+		"services/api/main.go":            true,
+		"services/api":                    true,
+		"services/api-gateway/main.go":    false,
+		"packages/ui/src/button/index.ts": true,
+		"packages/ui/README.md":           false,
+		"proto/v1/user.proto":             true,
+		"user.proto":                      true,
+		"./go.mod":                        true,
+		"tools/go.mod":                    false,
+	}
+	for file, want := range cases {
+		if got := AnyMatch(patterns, []string{file}); got != want {
+			t.Errorf("AnyMatch(%q) = %t, want %t", file, got, want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Package provenance renders SLSA v1 provenance for images built by the
+// builder. Builds run through Hubcell rather than BuildKit, so the statement
+// is assembled from the job record instead of a BuildKit attestation.
+package provenance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrNoImageDigest is returned by Generate when no image has a digest. A
+// subject without a digest cannot be matched to an image, so no statement is
+// produced.
+var ErrNoImageDigest = errors.New("no image digest to record as the provenance subject")
+
+const (
+	StatementType = "https://in-toto.io/Statement/v1"
+	PredicateType = "https://slsa.dev/provenance/v1"
+	BuilderID     = "https://hubfly.space/hubfly-builder"
+	BuildType     = "https://hubfly.space/hubfly-builder/build@v1"
+)
+
+type Statement struct {
+	Type          string    `json:"_type"`
+	Subject       []Subject `json:"subject"`
+	PredicateType string    `json:"predicateType"`
+	Predicate     Predicate `json:"predicate"`
+}
+
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type Predicate struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+type BuildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   map[string]any       `json:"externalParameters"`
+	ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+type RunDetails struct {
+	Builder    Builder              `json:"builder"`
+	Metadata   Metadata             `json:"metadata"`
+	Byproducts []ResourceDescriptor `json:"byproducts,omitempty"`
+}
+
+type Builder struct {
+	ID string `json:"id"`
+}
+
+type Metadata struct {
+	InvocationID string    `json:"invocationId"`
+	StartedOn    time.Time `json:"startedOn,omitempty"`
+	FinishedOn   time.Time `json:"finishedOn"`
+}
+
+type ResourceDescriptor struct {
+	URI    string            `json:"uri,omitempty"`
+	Name   string            `json:"name,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// Image is a built or pushed image and its digest, e.g. "sha256:<hex>".
+type Image struct {
+	Name   string
+	Digest string
+}
+
+// Input is the subset of a finished job that provenance records.
+type Input struct {
+	JobID string
+	// Images become the statement's subjects. Images without a digest are
+	// left out.
+	Images        []Image
+	GitRepository string
+	Ref           string
+	CommitSHA     string
+	WorkingDir    string
+	Runtime       string
+	BuildStrategy string
+	// Platforms are the targets of a multi-platform build. A pushed image's
+	// digest then names its manifest list, which covers every platform.
+	Platforms []string
+	// BuildOpts are the extra hubcell build flags, such as an SBOM
+	// attestation requested with "sbom".
+	BuildOpts  map[string]string
+	Dockerfile []byte
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Generate returns the JSON-encoded in-toto statement for a finished build,
+// with one subject per image digest.
+func Generate(in Input) ([]byte, error) {
+	var subjects []Subject
+	for _, image := range in.Images {
+		algorithm, value, ok := strings.Cut(strings.TrimSpace(image.Digest), ":")
+		if !ok || algorithm == "" || value == "" {
+			continue
+		}
+		subjects = append(subjects, Subject{Name: image.Name, Digest: map[string]string{algorithm: value}})
+	}
+	if len(subjects) == 0 {
+		return nil, ErrNoImageDigest
+	}
+
+	params := map[string]any{
+		"source":        in.GitRepository,
+		"buildStrategy": in.BuildStrategy,
+	}
+	if ref := strings.TrimSpace(in.Ref); ref != "" {
+		params["ref"] = ref
+	}
+	if dir := strings.TrimSpace(in.WorkingDir); dir != "" {
+		params["workingDir"] = dir
+	}
+	if runtime := strings.TrimSpace(in.Runtime); runtime != "" {
+		params["runtime"] = runtime
+	}
+	if len(in.Platforms) > 0 {
+		params["platforms"] = in.Platforms
+	}
+	if len(in.BuildOpts) > 0 {
+		params["buildOpts"] = in.BuildOpts
+	}
+
+	statement := Statement{
+		Type:          StatementType,
+		Subject:       subjects,
+		PredicateType: PredicateType,
+		Predicate: Predicate{
+			BuildDefinition: BuildDefinition{
+				BuildType:          BuildType,
+				ExternalParameters: params,
+			},
+			RunDetails: RunDetails{
+				Builder: Builder{ID: BuilderID},
+				Metadata: Metadata{
+					InvocationID: in.JobID,
+					StartedOn:    in.StartedAt.UTC(),
+					FinishedOn:   in.FinishedAt.UTC(),
+				},
+			},
+		},
+	}
+	if sha := strings.TrimSpace(in.CommitSHA); sha != "" {
+		statement.Predicate.BuildDefinition.ResolvedDependencies = []ResourceDescriptor{{
+			URI:    "git+" + in.GitRepository,
+			Digest: map[string]string{"gitCommit": sha},
+		}}
+	}
+	if len(in.Dockerfile) > 0 {
+		sum := sha256.Sum256(in.Dockerfile)
+		statement.Predicate.RunDetails.Byproducts = []ResourceDescriptor{{
+			Name:   "Dockerfile",
+			Digest: map[string]string{"sha256": hex.EncodeToString(sum[:])},
+		}}
+	}
+	return json.MarshalIndent(statement, "", "  ")
+}
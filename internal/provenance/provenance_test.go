@@ -0,0 +1,94 @@
+package provenance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateRecordsSourceCommitAndDockerfile(t *testing.T) {
+	dockerfile := []byte("FROM alpine:3.20\n")
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	content, err := Generate(Input{
+		JobID: "build_1",
+		Images: []Image{
+			{Name: "ghcr.io/acme/app:abc", Digest: "sha256:" + strings.Repeat("a", 64)},
+			{Name: "hubcell.local/user/project:abc"},
+		},
+		GitRepository: "https://github.com/example/app.git",
+		Ref:           "main",
+		CommitSHA:     "0123456789abcdef",
+		Runtime:       "node",
+		BuildStrategy: "dockerfile",
+		Platforms:     []string{"linux/amd64", "linux/arm64"},
+		BuildOpts:     map[string]string{"sbom": "true"},
+		Dockerfile:    dockerfile,
+		StartedAt:     started,
+		FinishedAt:    started.Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+
+	var statement Statement
+	if err := json.Unmarshal(content, &statement); err != nil {
+		t.Fatalf("provenance is not valid JSON: %v", err)
+	}
+	if statement.Type != StatementType || statement.PredicateType != PredicateType {
+		t.Fatalf("unexpected statement types: %q %q", statement.Type, statement.PredicateType)
+	}
+	if len(statement.Subject) != 1 || statement.Subject[0].Name != "ghcr.io/acme/app:abc" || statement.Subject[0].Digest["sha256"] != strings.Repeat("a", 64) {
+		t.Fatalf("expected only the image with a digest as subject, got %+v", statement.Subject)
+	}
+	params := statement.Predicate.BuildDefinition.ExternalParameters
+	if platforms, _ := params["platforms"].([]any); len(platforms) != 2 || platforms[1] != "linux/arm64" {
+		t.Fatalf("expected the build platforms to be recorded, got %v", params["platforms"])
+	}
+	if opts, _ := params["buildOpts"].(map[string]any); opts["sbom"] != "true" {
+		t.Fatalf("expected the build opts to be recorded, got %v", params["buildOpts"])
+	}
+	deps := statement.Predicate.BuildDefinition.ResolvedDependencies
+	if len(deps) != 1 || deps[0].Digest["gitCommit"] != "0123456789abcdef" || deps[0].URI != "git+https://github.com/example/app.git" {
+		t.Fatalf("unexpected resolved dependencies: %+v", deps)
+	}
+	if got := statement.Predicate.BuildDefinition.ExternalParameters["ref"]; got != "main" {
+		t.Fatalf("expected ref parameter main, got %v", got)
+	}
+	sum := sha256.Sum256(dockerfile)
+	byproducts := statement.Predicate.RunDetails.Byproducts
+	if len(byproducts) != 1 || byproducts[0].Digest["sha256"] != hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected Dockerfile digest byproduct, got %+v", byproducts)
+	}
+	if statement.Predicate.RunDetails.Metadata.InvocationID != "build_1" {
+		t.Fatalf("expected invocation id build_1, got %q", statement.Predicate.RunDetails.Metadata.InvocationID)
+	}
+}
+
+func TestGenerateOmitsUnknownCommit(t *testing.T) {
+	content, err := Generate(Input{
+		JobID:         "build_2",
+		Images:        []Image{{Name: "hubcell.local/u/p:t", Digest: "sha256:" + strings.Repeat("b", 64)}},
+		BuildStrategy: "buildpacks",
+	})
+	if err != nil {
+		t.Fatalf("Generate returned error: %v", err)
+	}
+	var statement Statement
+	if err := json.Unmarshal(content, &statement); err != nil {
+		t.Fatalf("provenance is not valid JSON: %v", err)
+	}
+	if len(statement.Predicate.BuildDefinition.ResolvedDependencies) != 0 {
+		t.Fatalf("did not expect resolved dependencies without a commit, got %+v", statement.Predicate.BuildDefinition.ResolvedDependencies)
+	}
+}
+
+func TestGenerateRequiresImageDigest(t *testing.T) {
+	_, err := Generate(Input{JobID: "build_3", Images: []Image{{Name: "hubcell.local/u/p:t"}}, BuildStrategy: "dockerfile"})
+	if !errors.Is(err, ErrNoImageDigest) {
+		t.Fatalf("expected ErrNoImageDigest without a digest, got %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"hubfly-builder/internal/storage"
+)
+
+// APIKey maps a bearer key to the user it acts as. Admin keys may read any
+// user's jobs.
+type APIKey struct {
+	Key    string `json:"key"`
+	UserID string `json:"userId"`
+	Admin  bool   `json:"admin,omitempty"`
+}
+
+type caller struct {
+	userID string
+	admin  bool
+}
+
+// SetAPIKeys enables API-key authentication for job read endpoints. With no
+// keys configured every request is allowed, as before.
+func (s *Server) SetAPIKeys(keys []APIKey) {
+	s.apiKeys = nil
+	for _, key := range keys {
+		if strings.TrimSpace(key.Key) == "" {
+			continue
+		}
+		s.apiKeys = append(s.apiKeys, key)
+	}
+}
+
+func (s *Server) authenticate(r *http.Request) (*caller, bool) {
+	presented := strings.TrimSpace(r.Header.Get("X-API-Key"))
+	if header := r.Header.Get("Authorization"); presented == "" && len(header) > len("Bearer ") && strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		presented = strings.TrimSpace(header[len("Bearer "):])
+	}
+	if presented == "" {
+		return nil, false
+	}
+
+	var matched *caller
+	for _, key := range s.apiKeys {
+		// Compare every key so timing does not reveal which one matched.
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(key.Key)) == 1 && matched == nil {
+			matched = &caller{userID: key.UserID, admin: key.Admin}
+		}
+	}
+	return matched, matched != nil
+}
+
+// authorizeJobAccess writes a 401 or 403 and returns false unless the caller
+// owns the job or holds an admin key.
+func (s *Server) authorizeJobAccess(w http.ResponseWriter, r *http.Request, job *storage.BuildJob) bool {
+	if len(s.apiKeys) == 0 {
+		return true
+	}
+	identity, ok := s.authenticate(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "UNAUTHORIZED", "a valid API key is required")
+		return false
+	}
+	if identity.admin || identity.userID == job.UserID {
+		return true
+	}
+	writeJSONError(w, http.StatusForbidden, "FORBIDDEN", "job belongs to another user")
+	return false
+}
+
+// statsScope returns the user whose jobs a stats or list read may include:
+// "" for every user when auth is off or the caller is an admin. It writes a
+// 401 and returns false when auth is on and the caller has no valid key.
+func (s *Server) statsScope(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if len(s.apiKeys) == 0 {
+		return "", true
+	}
+	identity, ok := s.authenticate(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "UNAUTHORIZED", "a valid API key is required")
+		return "", false
+	}
+	if identity.admin {
+		return "", true
+	}
+	return identity.userID, true
+}
+
+// authorizeAdmin writes a 401 or 403 and returns false unless the caller
+// holds an admin key. With auth off every request is allowed.
+func (s *Server) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if len(s.apiKeys) == 0 {
+		return true
+	}
+	identity, ok := s.authenticate(r)
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "UNAUTHORIZED", "a valid API key is required")
+		return false
+	}
+	if !identity.admin {
+		writeJSONError(w, http.StatusForbidden, "FORBIDDEN", "an admin API key is required")
+		return false
+	}
+	return true
+}
+
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   code,
+		"message": message,
+	})
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"hubfly-builder/internal/executor"
+	"hubfly-builder/internal/jobstate"
+)
+
+// CancelJobHandler cancels a pending job, or interrupts a running one. A
+// running job answers 202 with status cancelling; its worker records the job
+// as canceled once the build has stopped.
+func (s *Server) CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	job, err := s.storage.GetJob(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJobNotFound(w)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !s.authorizeJobAccess(w, r, job) {
+		return
+	}
+	if s.manager == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "CANCEL_UNAVAILABLE", "this builder does not run jobs")
+		return
+	}
+
+	status, err := s.manager.CancelJob(job.ID)
+	if err != nil {
+		if errors.Is(err, executor.ErrJobNotCancelable) {
+			writeJSONError(w, http.StatusConflict, "JOB_NOT_CANCELABLE", err.Error())
+			return
+		}
+		log.Printf("ERROR: could not cancel job %s: %v", job.ID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	code := http.StatusOK
+	if status == jobstate.Cancelling {
+		code = http.StatusAccepted
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"id": job.ID, "status": string(status)})
+}
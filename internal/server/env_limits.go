@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+
+	"hubfly-builder/internal/storage"
+)
+
+const (
+	// DefaultMaxEnvKeys bounds the variables a job may submit across
+	// buildConfig.env, dockerfileArgs and dockerfileEnv.
+	DefaultMaxEnvKeys = 500
+	// DefaultMaxEnvBytes bounds the total size of their keys and values.
+	DefaultMaxEnvBytes = 256 * 1024
+)
+
+// EnvLimits bounds the env payload of a job, which ends up in the env plan and
+// the generated Dockerfile.
+type EnvLimits struct {
+	MaxKeys  int
+	MaxBytes int
+}
+
+// SetEnvLimits replaces the env payload limits. A non-positive field keeps
+// the default for it.
+func (s *Server) SetEnvLimits(limits EnvLimits) {
+	if limits.MaxKeys <= 0 {
+		limits.MaxKeys = DefaultMaxEnvKeys
+	}
+	if limits.MaxBytes <= 0 {
+		limits.MaxBytes = DefaultMaxEnvBytes
+	}
+	s.envLimits = limits
+}
+
+// checkEnvLimits rejects a job whose env, dockerfileArgs and dockerfileEnv
+// together hold more variables or bytes than the limits allow.
+func (s *Server) checkEnvLimits(cfg storage.BuildConfig) error {
+	keys, size := 0, 0
+	for _, values := range []map[string]string{cfg.Env, cfg.DockerfileArgs, cfg.DockerfileEnv} {
+		keys += len(values)
+		for key, value := range values {
+			size += len(key) + len(value)
+		}
+	}
+	if keys > s.envLimits.MaxKeys {
+		return fmt.Errorf("env has %d variables; at most %d are allowed across env, dockerfileArgs and dockerfileEnv", keys, s.envLimits.MaxKeys)
+	}
+	if size > s.envLimits.MaxBytes {
+		return fmt.Errorf("env is %d bytes; at most %d bytes are allowed across env, dockerfileArgs and dockerfileEnv", size, s.envLimits.MaxBytes)
+	}
+	return nil
+}
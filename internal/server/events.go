@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"hubfly-builder/internal/events"
+	"hubfly-builder/internal/jobstate"
+	"hubfly-builder/internal/storage"
+)
+
+// eventStreamBuffer is how many events a slow /api/v1/events client may fall
+// behind before it starts missing them.
+const eventStreamBuffer = 256
+
+// SetEventBus enables GET /api/v1/events and makes job creation publish to
+// bus.
+func (s *Server) SetEventBus(bus *events.Bus) {
+	s.eventBus = bus
+}
+
+func (s *Server) publishJobEvent(eventType events.Type, job *storage.BuildJob, status jobstate.Status) {
+	s.eventBus.Publish(events.Event{
+		Type:      eventType,
+		JobID:     job.ID,
+		ProjectID: job.ProjectID,
+		UserID:    job.UserID,
+		Status:    string(status),
+	})
+}
+
+// StreamEventsHandler streams job events as newline-delimited JSON until the
+// client disconnects. The projectId and userId query parameters narrow the
+// feed. When API keys are configured, non-admin callers only see their own
+// jobs. A client that reads too slowly misses events and is sent a "dropped"
+// event with the count before the next one.
+func (s *Server) StreamEventsHandler(w http.ResponseWriter, r *http.Request) {
+	filter := events.Filter{
+		ProjectID: strings.TrimSpace(r.URL.Query().Get("projectId")),
+		UserID:    strings.TrimSpace(r.URL.Query().Get("userId")),
+	}
+	scope, ok := s.statsScope(w, r)
+	if !ok {
+		return
+	}
+	if scope != "" {
+		if filter.UserID != "" && filter.UserID != scope {
+			writeJSONError(w, http.StatusForbidden, "FORBIDDEN", "events of another user were requested")
+			return
+		}
+		filter.UserID = scope
+	}
+	if s.eventBus == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "EVENTS_UNAVAILABLE", "this builder does not publish events")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := s.eventBus.Subscribe(filter, eventStreamBuffer)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, open := <-sub.Events():
+			if !open {
+				return
+			}
+			if dropped := sub.TakeDropped(); dropped > 0 {
+				if err := encoder.Encode(events.Event{Type: events.Dropped, Dropped: dropped, Time: event.Time}); err != nil {
+					return
+				}
+			}
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
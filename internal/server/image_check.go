@@ -0,0 +1,56 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"hubfly-builder/internal/executor"
+	"hubfly-builder/internal/storage"
+)
+
+// VerifyJobImageHandler looks up a successful job's image tag in the image
+// store now and records whether it has disappeared, for example after
+// garbage collection.
+func (s *Server) VerifyJobImageHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	job, err := s.storage.GetJob(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJobNotFound(w)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !s.authorizeJobAccess(w, r, job) {
+		return
+	}
+
+	check, err := s.manager.VerifyJobImage(r.Context(), job)
+	if err != nil {
+		switch {
+		case errors.Is(err, executor.ErrNoImageToVerify):
+			writeJSONError(w, http.StatusConflict, "IMAGE_NOT_VERIFIABLE", err.Error())
+		case errors.Is(err, executor.ErrImageCheckDisabled):
+			writeJSONError(w, http.StatusNotImplemented, "IMAGE_CHECK_DISABLED", err.Error())
+		default:
+			log.Printf("ERROR: could not verify image of job %s: %v", job.ID, err)
+			writeJSONError(w, http.StatusBadGateway, "IMAGE_CHECK_FAILED", err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		ID       string `json:"id"`
+		ImageTag string `json:"imageTag"`
+		*storage.ImageCheck
+	}{ID: job.ID, ImageTag: job.ImageTag, ImageCheck: &check})
+}
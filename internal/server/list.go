@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"hubfly-builder/internal/storage"
+)
+
+// jobListResponse is one page of ListJobsHandler. Total counts every job
+// matching the filters, not just this page.
+type jobListResponse struct {
+	Jobs   []*storage.BuildJob `json:"jobs"`
+	Total  int                 `json:"total"`
+	Limit  int                 `json:"limit"`
+	Offset int                 `json:"offset"`
+}
+
+// ListJobsHandler lists jobs newest first, filtered by the status, projectId
+// and userId query parameters. limit and offset page through the results;
+// limit defaults to 50 and is capped at 200. Non-admin API keys only see their
+// own jobs.
+func (s *Server) ListJobsHandler(w http.ResponseWriter, r *http.Request) {
+	scope, ok := s.statsScope(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := storage.JobFilter{
+		Status:    strings.TrimSpace(query.Get("status")),
+		ProjectID: strings.TrimSpace(query.Get("projectId")),
+		UserID:    strings.TrimSpace(query.Get("userId")),
+	}
+	if scope != "" {
+		if filter.UserID != "" && filter.UserID != scope {
+			writeJSONError(w, http.StatusForbidden, "FORBIDDEN", "jobs belong to another user")
+			return
+		}
+		filter.UserID = scope
+	}
+
+	limit, err := parseListParam(query, "limit")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := parseListParam(query, "offset")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, offset = storage.ClampJobListPage(limit, offset)
+
+	jobs, total, err := s.storage.ListJobs(filter, limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(jobListResponse{Jobs: jobs, Total: total, Limit: limit, Offset: offset})
+}
+
+func parseListParam(query url.Values, name string) (int, error) {
+	raw := strings.TrimSpace(query.Get(name))
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: must be an integer", name, raw)
+	}
+	return value, nil
+}
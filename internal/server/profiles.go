@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"hubfly-builder/internal/storage"
+)
+
+// BuildProfile is a named set of build settings a job can reference with
+// buildConfig.profile instead of repeating them in every payload. Settings the
+// job sets itself win: env keys are merged with the job's values on top, and
+// other fields only fill what the job leaves empty. Boolean flags can only
+// switch a feature on.
+type BuildProfile struct {
+	Env            map[string]string      `json:"env,omitempty"`
+	Network        string                 `json:"network,omitempty"`
+	TimeoutSeconds int                    `json:"timeoutSeconds,omitempty"`
+	ResourceLimits storage.ResourceLimits `json:"resourceLimits"`
+	Provenance     bool                   `json:"provenance,omitempty"`
+	Debug          bool                   `json:"debug,omitempty"`
+	MovingTag      *storage.MovingTag     `json:"movingTag,omitempty"`
+}
+
+// Profiles maps a profile name to its settings.
+type Profiles map[string]BuildProfile
+
+// SetBuildProfiles replaces the profiles jobs may reference by name.
+func (s *Server) SetBuildProfiles(profiles Profiles) {
+	s.profiles = make(Profiles, len(profiles))
+	for name, profile := range profiles {
+		if name = strings.TrimSpace(name); name != "" {
+			s.profiles[name] = profile
+		}
+	}
+}
+
+// applyBuildProfile merges the profile named by cfg.Profile into cfg. It is a
+// no-op when the job names no profile and fails when the name is unknown.
+func (s *Server) applyBuildProfile(cfg *storage.BuildConfig) error {
+	name := strings.TrimSpace(cfg.Profile)
+	if name == "" {
+		return nil
+	}
+	profile, ok := s.profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown build profile %q", name)
+	}
+	cfg.Profile = name
+
+	if len(profile.Env) > 0 {
+		merged := copyStringMap(profile.Env)
+		for key, value := range cfg.Env {
+			merged[key] = value
+		}
+		cfg.Env = merged
+	}
+	if strings.TrimSpace(cfg.Network) == "" {
+		cfg.Network = profile.Network
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		cfg.TimeoutSeconds = profile.TimeoutSeconds
+	}
+	if cfg.ResourceLimits.CPU <= 0 {
+		cfg.ResourceLimits.CPU = profile.ResourceLimits.CPU
+	}
+	if cfg.ResourceLimits.MemoryMB <= 0 {
+		cfg.ResourceLimits.MemoryMB = profile.ResourceLimits.MemoryMB
+	}
+	cfg.Provenance = cfg.Provenance || profile.Provenance
+	cfg.Debug = cfg.Debug || profile.Debug
+	if cfg.MovingTag == nil && profile.MovingTag != nil {
+		movingTag := *profile.MovingTag
+		cfg.MovingTag = &movingTag
+	}
+	return nil
+}
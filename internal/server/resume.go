@@ -0,0 +1,53 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"hubfly-builder/internal/executor"
+	"hubfly-builder/internal/jobstate"
+)
+
+// ResumeJobHandler puts a failed job back on the queue. Its build continues
+// after the last phase the failed attempt completed when that attempt's
+// workspace was retained, and starts over from the clone otherwise.
+func (s *Server) ResumeJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	job, err := s.storage.GetJob(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJobNotFound(w)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !s.authorizeJobAccess(w, r, job) {
+		return
+	}
+	if s.manager == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "RESUME_UNAVAILABLE", "this builder does not run jobs")
+		return
+	}
+
+	resumeFrom, err := s.manager.ResumeJob(job.ID)
+	if err != nil {
+		if errors.Is(err, executor.ErrJobNotResumable) {
+			writeJSONError(w, http.StatusConflict, "JOB_NOT_RESUMABLE", err.Error())
+			return
+		}
+		log.Printf("ERROR: could not resume job %s: %v", job.ID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"id": job.ID, "status": string(jobstate.Pending), "resumeFrom": resumeFrom})
+}
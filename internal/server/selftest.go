@@ -0,0 +1,35 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"hubfly-builder/internal/executor"
+)
+
+// SelfTestHandler runs a canned build through Hubcell and reports each step
+// with its timing. A failed self-test answers 503 so probes can alert on it.
+func (s *Server) SelfTestHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+
+	result, err := s.manager.SelfTest(r.Context())
+	if err != nil {
+		if errors.Is(err, executor.ErrSelfTestRunning) {
+			writeJSONError(w, http.StatusConflict, "SELFTEST_RUNNING", err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "SELFTEST_FAILED", err.Error())
+		return
+	}
+
+	status := http.StatusOK
+	if !result.Success {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
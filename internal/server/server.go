@@ -14,14 +14,21 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"hubfly-builder/internal/allowlist"
+	"hubfly-builder/internal/api"
 	"hubfly-builder/internal/autodetect"
 	"hubfly-builder/internal/dockerfileparams"
+	"hubfly-builder/internal/driver"
 	"hubfly-builder/internal/envplan"
+	"hubfly-builder/internal/events"
 	"hubfly-builder/internal/executor"
+	"hubfly-builder/internal/gitauth"
+	"hubfly-builder/internal/jobstate"
 	"hubfly-builder/internal/logs"
+	"hubfly-builder/internal/pathfilter"
 	"hubfly-builder/internal/storage"
 )
 
@@ -30,27 +37,51 @@ type Server struct {
 	logManager *logs.LogManager
 	manager    *executor.Manager
 	allowlist  *allowlist.AllowedCommands
+	apiClient  *api.Client
+	apiKeys    []APIKey
+	profiles   Profiles
+	eventBus   *events.Bus
+	envLimits  EnvLimits
+	// secretResolver resolves git credential references; nil rejects them.
+	secretResolver gitauth.SecretResolver
 }
 
 var credentialURLPattern = regexp.MustCompile(`https?://[^@\s]+@`)
 
-func NewServer(storage *storage.Storage, logManager *logs.LogManager, manager *executor.Manager, allowlist *allowlist.AllowedCommands) *Server {
+func NewServer(storage *storage.Storage, logManager *logs.LogManager, manager *executor.Manager, allowlist *allowlist.AllowedCommands, apiClient *api.Client) *Server {
 	return &Server{
 		storage:    storage,
 		logManager: logManager,
 		manager:    manager,
 		allowlist:  allowlist,
+		apiClient:  apiClient,
+		envLimits:  EnvLimits{MaxKeys: DefaultMaxEnvKeys, MaxBytes: DefaultMaxEnvBytes},
 	}
 }
 
+// SetSecretResolver lets jobs name their git credential by reference, e.g.
+// "vault://github/ci#token". Without a resolver such jobs are rejected.
+func (s *Server) SetSecretResolver(resolver gitauth.SecretResolver) {
+	s.secretResolver = resolver
+}
+
 func (s *Server) Start(addr string) error {
 	r := mux.NewRouter()
 	r.HandleFunc("/api/v1/jobs", s.CreateJobHandler).Methods("POST")
+	r.HandleFunc("/api/v1/jobs", s.ListJobsHandler).Methods("GET")
 	r.HandleFunc("/api/v1/jobs/{id}", s.GetJobHandler).Methods("GET")
 	r.HandleFunc("/api/v1/jobs/{id}/logs", s.GetJobLogsHandler).Methods("GET")
+	r.HandleFunc("/api/v1/jobs/{id}/provenance", s.GetJobProvenanceHandler).Methods("GET")
+	r.HandleFunc("/api/v1/jobs/{id}/verify-image", s.VerifyJobImageHandler).Methods("POST")
+	r.HandleFunc("/api/v1/jobs/{id}/cancel", s.CancelJobHandler).Methods("POST")
+	r.HandleFunc("/api/v1/jobs/{id}/resume", s.ResumeJobHandler).Methods("POST")
+	r.HandleFunc("/api/v1/projects/{projectId}/stats", s.GetProjectStatsHandler).Methods("GET")
+	r.HandleFunc("/api/v1/events", s.StreamEventsHandler).Methods("GET")
+	r.HandleFunc("/api/v1/admin/selftest", s.SelfTestHandler).Methods("POST")
 	r.HandleFunc("/dev/running-builds", s.GetRunningBuildsHandler).Methods("GET")
 	r.HandleFunc("/dev/reset-db", s.ResetDatabaseHandler).Methods("POST")
 	r.HandleFunc("/healthz", HealthCheckHandler).Methods("GET")
+	r.HandleFunc("/metrics", s.MetricsHandler).Methods("GET")
 
 	return http.ListenAndServe(addr, r)
 }
@@ -86,16 +117,122 @@ func (s *Server) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "userId is required", http.StatusBadRequest)
 		return
 	}
+	if len(job.BuildConfig.Env) == 0 && len(job.Env) > 0 {
+		job.BuildConfig.Env = copyStringMap(job.Env)
+	}
+	if err := s.applyBuildProfile(&job.BuildConfig); err != nil {
+		log.Printf("ERROR: job %s rejected: %v", job.ID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.checkEnvLimits(job.BuildConfig); err != nil {
+		log.Printf("ERROR: job %s rejected: %v", job.ID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if baseImage := strings.TrimSpace(job.BuildConfig.BaseImage); baseImage != "" {
+		if err := autodetect.ValidateBaseImage(baseImage); err != nil {
+			log.Printf("ERROR: job %s rejected: %v", job.ID, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := job.BuildConfig.ResourceLimits.Validate(); err != nil {
+		log.Printf("ERROR: job %s rejected: %v", job.ID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := job.BuildConfig.ExtraHosts.Validate(); err != nil {
+		log.Printf("ERROR: job %s rejected: %v", job.ID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := job.BuildConfig.DNSServers.Validate(); err != nil {
+		log.Printf("ERROR: job %s rejected: %v", job.ID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := job.BuildConfig.Platforms.Validate(); err != nil {
+		log.Printf("ERROR: job %s rejected: %v", job.ID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := autodetect.ValidateBuildShell(job.BuildConfig.Shell); err != nil {
+		log.Printf("ERROR: job %s rejected: %v", job.ID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := job.BuildConfig.RunAs.Validate(); err != nil {
+		log.Printf("ERROR: job %s rejected: %v", job.ID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := job.BuildConfig.Registries.Validate(); err != nil {
+		log.Printf("ERROR: job %s rejected: %v", job.ID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := job.BuildConfig.ValidateDockerfilePath(); err != nil {
+		log.Printf("ERROR: job %s rejected: %v", job.ID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	overrides := autodetect.CommandOverrides{
+		Prebuild: job.BuildConfig.PrebuildCommandOverride,
+		Build:    job.BuildConfig.BuildCommandOverride,
+		Run:      job.BuildConfig.RunCommandOverride,
+	}
+	if !overrides.IsZero() {
+		if !job.BuildConfig.IsAutoBuild {
+			log.Printf("ERROR: job %s rejected: command overrides without isAutoBuild", job.ID)
+			http.Error(w, "command overrides require isAutoBuild; set the commands directly instead", http.StatusBadRequest)
+			return
+		}
+		if err := autodetect.ValidateCommandOverrides(overrides, s.allowlist); err != nil {
+			log.Printf("ERROR: job %s rejected: %v", job.ID, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if s.manager != nil {
+		if err := s.manager.ValidateBuildOpts(job.BuildConfig.BuildOpts); err != nil {
+			log.Printf("ERROR: job %s rejected: %v", job.ID, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 	if strings.TrimSpace(job.BuildConfig.Network) == "" {
 		log.Printf("ERROR: job %s missing buildConfig.network", job.ID)
 		http.Error(w, "no user network provided", http.StatusBadRequest)
 		return
 	}
-	if len(job.BuildConfig.Env) == 0 && len(job.Env) > 0 {
-		job.BuildConfig.Env = copyStringMap(job.Env)
+	if job.SourceInfo.Credential != nil {
+		if err := job.SourceInfo.Credential.Validate(); err != nil {
+			log.Printf("ERROR: job %s rejected: %v", job.ID, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := gitauth.ValidateCredentialRef(job.SourceInfo.Credential, s.secretResolver); err != nil {
+			log.Printf("ERROR: job %s rejected: %v", job.ID, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	job.CallbackURL = strings.TrimSpace(job.CallbackURL)
+	if job.CallbackURL != "" && s.apiClient != nil {
+		if err := s.apiClient.ValidateCallbackURL(job.CallbackURL); err != nil {
+			log.Printf("ERROR: job %s rejected callbackUrl: %v", job.ID, err)
+			http.Error(w, fmt.Sprintf("invalid callbackUrl: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if shouldSkipForChangedPaths(job) {
+		s.skipJob(w, &job)
+		return
 	}
 
 	if job.BuildConfig.IsAutoBuild {
+		requested := job.BuildConfig
 		// For auto-build, we need to clone the repo first to inspect it.
 		// This is a simplified approach. A more robust solution might involve
 		// a separate service to handle repo inspection before creating the job.
@@ -107,7 +244,23 @@ func (s *Server) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		defer os.RemoveAll(tempDir)
 
-		cloneCmd := exec.Command("git", "clone", job.SourceInfo.GitRepository, tempDir)
+		credential, err := gitauth.ResolveCredential(job.SourceInfo.Credential, s.secretResolver)
+		if err != nil {
+			log.Printf("ERROR: job %s failed to resolve git credential for autodetect: %v", job.ID, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		gitAuth, err := gitauth.Prepare(credential)
+		if err != nil {
+			log.Printf("ERROR: job %s failed to prepare git credential for autodetect: %v", job.ID, err)
+			http.Error(w, "failed to prepare git credential for autodetect", http.StatusInternalServerError)
+			return
+		}
+		defer gitAuth.Close()
+
+		gitPath := driver.ResolveGitCLIPath(os.Getenv("GIT_CLI_PATH"))
+		cloneCmd := exec.Command(gitPath, "clone", job.SourceInfo.GitRepository, tempDir)
+		gitAuth.Apply(cloneCmd)
 		if output, err := cloneCmd.CombinedOutput(); err != nil {
 			log.Printf(
 				"ERROR: job %s failed to clone repository repo=%s err=%v output=%s",
@@ -121,7 +274,7 @@ func (s *Server) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if job.SourceInfo.Ref != "" {
-			if output, err := exec.Command("git", "-C", tempDir, "checkout", job.SourceInfo.Ref).CombinedOutput(); err != nil {
+			if output, err := exec.Command(gitPath, "-C", tempDir, "checkout", job.SourceInfo.Ref).CombinedOutput(); err != nil {
 				log.Printf(
 					"ERROR: job %s failed to checkout ref=%s err=%v output=%s",
 					job.ID,
@@ -134,7 +287,7 @@ func (s *Server) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		if job.SourceInfo.CommitSha != "" {
-			if output, err := exec.Command("git", "-C", tempDir, "checkout", job.SourceInfo.CommitSha).CombinedOutput(); err != nil {
+			if output, err := exec.Command(gitPath, "-C", tempDir, "checkout", job.SourceInfo.CommitSha).CombinedOutput(); err != nil {
 				log.Printf(
 					"ERROR: job %s failed to checkout commit=%s err=%v output=%s",
 					job.ID,
@@ -155,7 +308,12 @@ func (s *Server) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		customDockerfile := job.BuildConfig.CustomDockerfileBytes()
-		dockerfilePath, buildContextDir := detectDockerfileLayout(tempDir, appDir)
+		dockerfilePath, buildContextDir := detectDockerfileLayout(tempDir, appDir, job.BuildConfig.DockerfileName())
+		if dockerfilePath == "" && len(customDockerfile) == 0 && strings.TrimSpace(job.BuildConfig.DockerfilePath) != "" {
+			log.Printf("ERROR: job %s rejected: dockerfilePath %q not found for appDir=%q", job.ID, job.BuildConfig.DockerfilePath, appDir)
+			http.Error(w, fmt.Sprintf("dockerfilePath %q was not found in the working directory or the repository root", job.BuildConfig.DockerfilePath), http.StatusBadRequest)
+			return
+		}
 		if len(customDockerfile) > 0 {
 			buildContextDir = appDir
 			buildContextPath, err := resolveBuildContextPath(tempDir, buildContextDir)
@@ -248,11 +406,17 @@ func (s *Server) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
 				DockerfileEnv:      job.BuildConfig.DockerfileEnv,
 				CustomDockerfile:   job.BuildConfig.CustomDockerfile,
 				DockerfileContent:  dockerfileContent,
+				UsesRepoDockerfile: true,
 			}
 		} else {
 			detectedConfig, err := autodetect.AutoDetectBuildConfigWithOptions(autodetect.AutoDetectOptions{
-				RepoRoot:   tempDir,
-				WorkingDir: appDir,
+				RepoRoot:       tempDir,
+				WorkingDir:     appDir,
+				BaseImage:      job.BuildConfig.BaseImage,
+				RunAs:          (*autodetect.ContainerUser)(job.BuildConfig.RunAs),
+				Shell:          job.BuildConfig.Shell,
+				RuntimeEnvKeys: envKeys(job.BuildConfig.Env),
+				Overrides:      overrides,
 			}, s.allowlist)
 			if err != nil {
 				log.Printf(
@@ -281,6 +445,9 @@ func (s *Server) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
 				BuildContextDir:    detectedConfig.BuildContextDir,
 				AppDir:             detectedConfig.AppDir,
 				ValidationWarnings: detectedConfig.ValidationWarnings,
+				BuildTool:          detectedConfig.BuildTool,
+				Confidence:         detectedConfig.Confidence,
+				Signals:            detectedConfig.Signals,
 				Network:            job.BuildConfig.Network,
 				TimeoutSeconds:     job.BuildConfig.TimeoutSeconds,
 				ResourceLimits:     job.BuildConfig.ResourceLimits,
@@ -294,13 +461,15 @@ func (s *Server) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		carryRequestOptions(&job.BuildConfig, requested)
+
 		buildContextPath, err := resolveBuildContextPath(tempDir, job.BuildConfig.BuildContextDir)
 		if err != nil {
 			log.Printf("ERROR: job %s invalid build context %q: %v", job.ID, job.BuildConfig.BuildContextDir, err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		envResult := envplan.ResolveForPaths([]string{buildContextPath, inspectDir}, job.BuildConfig.Env, job.BuildConfig.EnvOverrides)
+		envResult := envplan.ResolveForPaths([]string{buildContextPath, inspectDir}, job.BuildConfig.Env, job.BuildConfig.EnvOverrides, job.BuildConfig.RuntimeOnly)
 		job.BuildConfig.ResolvedEnvPlan = envResult.Entries
 		job.BuildConfig.ValidationWarnings = mergeWarnings(job.BuildConfig.ValidationWarnings, envResult.Warnings)
 	}
@@ -310,6 +479,7 @@ func (s *Server) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	s.publishJobEvent(events.JobCreated, &job, jobstate.Pending)
 
 	// Signal the manager that a new job is available
 	s.manager.SignalNewJob()
@@ -319,6 +489,59 @@ func (s *Server) CreateJobHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(job)
 }
 
+// carryRequestOptions copies the opt-in flags of the submitted buildConfig
+// onto the one rebuilt from repository inspection.
+func carryRequestOptions(dst *storage.BuildConfig, requested storage.BuildConfig) {
+	dst.UseBuildpacks = requested.UseBuildpacks
+	dst.Provenance = requested.Provenance
+	dst.BuildInfo = requested.BuildInfo
+	dst.Debug = requested.Debug
+	dst.WatchPaths = requested.WatchPaths
+	dst.MovingTag = requested.MovingTag
+	dst.Profile = requested.Profile
+	dst.BuildOpts = requested.BuildOpts
+	dst.BaseImage = requested.BaseImage
+	dst.ExtraHosts = requested.ExtraHosts
+	dst.Platforms = requested.Platforms
+	dst.DisableCache = requested.DisableCache
+	dst.PrebuildCommandOverride = requested.PrebuildCommandOverride
+	dst.BuildCommandOverride = requested.BuildCommandOverride
+	dst.RunCommandOverride = requested.RunCommandOverride
+	dst.DockerfilePath = requested.DockerfilePath
+	dst.RefTag = requested.RefTag
+	dst.Registries = requested.Registries
+	dst.RunAs = requested.RunAs
+	dst.Shell = requested.Shell
+	dst.RuntimeOnly = requested.RuntimeOnly
+	dst.DNSServers = requested.DNSServers
+}
+
+// shouldSkipForChangedPaths reports whether the push that triggered job touched
+// none of buildConfig.watchPaths. Without watch paths, or without a list of
+// changed files, the job always builds.
+func shouldSkipForChangedPaths(job storage.BuildJob) bool {
+	if len(job.BuildConfig.WatchPaths) == 0 || len(job.SourceInfo.ChangedFiles) == 0 {
+		return false
+	}
+	return !pathfilter.AnyMatch(job.BuildConfig.WatchPaths, job.SourceInfo.ChangedFiles)
+}
+
+// skipJob records job as skipped without queuing it.
+func (s *Server) skipJob(w http.ResponseWriter, job *storage.BuildJob) {
+	log.Printf("CreateJob %s skipped: none of %d changed files match buildConfig.watchPaths", job.ID, len(job.SourceInfo.ChangedFiles))
+	if err := s.storage.CreateSkippedJob(job); err != nil {
+		log.Printf("ERROR: job %s failed to persist in storage: %v", job.ID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.publishJobEvent(events.JobCreated, job, jobstate.Pending)
+	s.publishJobEvent(events.JobStatus, job, jobstate.Skipped)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(job)
+}
+
 func sanitizeGitRepositoryURL(raw string) string {
 	raw = strings.TrimSpace(raw)
 	if raw == "" {
@@ -361,19 +584,46 @@ func resolveWorkingDirectory(repoRoot, workingDir string) (string, string, error
 	if filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(os.PathSeparator)) {
 		return "", "", fmt.Errorf("working directory must stay within the repository root")
 	}
+	appPath := filepath.Join(repoRoot, cleaned)
+	if err := checkWorkingDirectory(repoRoot, appPath); err != nil {
+		return "", "", err
+	}
+
+	return filepath.ToSlash(cleaned), appPath, nil
+}
 
-	return filepath.ToSlash(cleaned), filepath.Join(repoRoot, cleaned), nil
+// checkWorkingDirectory rejects a working directory that does not exist in the
+// checkout or that a symlink points outside of it.
+func checkWorkingDirectory(repoRoot, appPath string) error {
+	root, err := filepath.EvalSymlinks(repoRoot)
+	if err != nil {
+		return err
+	}
+	resolved, err := filepath.EvalSymlinks(appPath)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("working directory does not exist in the repository")
+	} else if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return fmt.Errorf("working directory must stay within the repository root")
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return fmt.Errorf("working directory is not a directory")
+	}
+	return nil
 }
 
-func detectDockerfileLayout(repoRoot, appDir string) (string, string) {
+func detectDockerfileLayout(repoRoot, appDir, name string) (string, string) {
 	if appDir != "." {
-		appDockerfile := filepath.Join(repoRoot, filepath.FromSlash(appDir), "Dockerfile")
+		appDockerfile := filepath.Join(repoRoot, filepath.FromSlash(appDir), name)
 		if info, err := os.Stat(appDockerfile); err == nil && info.Mode().IsRegular() {
 			return appDockerfile, "."
 		}
 	}
 
-	rootDockerfile := filepath.Join(repoRoot, "Dockerfile")
+	rootDockerfile := filepath.Join(repoRoot, name)
 	if info, err := os.Stat(rootDockerfile); err == nil && info.Mode().IsRegular() {
 		return rootDockerfile, "."
 	}
@@ -417,6 +667,16 @@ func normalizeDockerfileBuildContextDir(buildContextDir, appDir string) (string,
 	return cleaned, nil
 }
 
+// envKeys lists the keys of the job env, which the detected Dockerfile
+// previews as supplied at run time.
+func envKeys(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
 func mergeWarnings(primary []string, extras []string) []string {
 	if len(primary) == 0 && len(extras) == 0 {
 		return nil
@@ -451,10 +711,82 @@ func (s *Server) GetJobHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !s.authorizeJobAccess(w, r, job) {
+		return
+	}
+
+	response := jobStatusResponse{BuildJob: job}
+	if job.Status == "pending" {
+		var position int
+		if s.manager != nil {
+			position, err = s.manager.QueuePosition(job.ID)
+		} else {
+			position, err = s.storage.QueuePosition(job.ID, nil, "", time.Time{})
+		}
+		if err != nil {
+			log.Printf("WARN: could not compute queue position for job %s: %v", job.ID, err)
+		}
+		response.QueuePosition = position
+	}
+	if job.Status != "pending" {
+		warnings, err := s.storage.GetJobBuildWarnings(job.ID)
+		if err != nil {
+			log.Printf("WARN: could not load build warnings for job %s: %v", job.ID, err)
+		}
+		response.BuildWarnings = warnings
+		stats, err := s.storage.GetJobBuildCacheStats(job.ID)
+		if err != nil {
+			log.Printf("WARN: could not load build cache stats for job %s: %v", job.ID, err)
+		}
+		job.BuildCache = stats
+	}
+	if job.Status == "failed" {
+		session, err := s.storage.GetJobDebugSession(job.ID)
+		if err != nil {
+			log.Printf("WARN: could not load debug session for job %s: %v", job.ID, err)
+		}
+		response.DebugSession = session
+	}
+	if job.Status == "success" {
+		check, err := s.storage.GetJobImageCheck(job.ID)
+		if err != nil {
+			log.Printf("WARN: could not load image check for job %s: %v", job.ID, err)
+		}
+		response.ImageCheck = check
+	}
+	if job.Status == "success" || job.Status == "failed" {
+		pushes, err := s.storage.GetJobRegistryPushes(job.ID)
+		if err != nil {
+			log.Printf("WARN: could not load registry pushes for job %s: %v", job.ID, err)
+		}
+		response.RegistryPushes = pushes
+	}
+	if job.Status == "success" {
+		done := 100
+		response.EstimatedProgress = &done
+	} else if s.manager != nil {
+		if percent, ok := s.manager.JobProgress(job.ID); ok {
+			response.EstimatedProgress = &percent
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(job)
+	json.NewEncoder(w).Encode(response)
+}
+
+// jobStatusResponse adds fields computed at read time to the stored job.
+type jobStatusResponse struct {
+	*storage.BuildJob
+	QueuePosition int                   `json:"queuePosition,omitempty"`
+	DebugSession  *storage.DebugSession `json:"debugSession,omitempty"`
+	BuildWarnings storage.BuildWarnings `json:"buildWarnings,omitempty"`
+	// RegistryPushes reports the push to each of buildConfig.registries.
+	RegistryPushes storage.RegistryPushes `json:"registryPushes,omitempty"`
+	// EstimatedProgress is a rough completion percentage of a running
+	// build, derived from phase weights and BuildKit step counts.
+	EstimatedProgress *int `json:"estimatedProgress,omitempty"`
+	*storage.ImageCheck
 }
 
 func (s *Server) GetJobLogsHandler(w http.ResponseWriter, r *http.Request) {
@@ -470,6 +802,9 @@ func (s *Server) GetJobLogsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !s.authorizeJobAccess(w, r, job) {
+		return
+	}
 
 	if job.LogPath == "" {
 		writeBuildLogNotFound(w)
@@ -486,11 +821,98 @@ func (s *Server) GetJobLogsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if acceptsJSON(r) {
+		writeJobLogsJSON(w, logs)
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
 	w.Write(logs)
 }
 
+// maxJSONLogBytes caps the content embedded in a JSON logs response; the
+// oldest output is dropped first since failures show up at the end.
+const maxJSONLogBytes = 4 << 20
+
+type jobLogsResponse struct {
+	Content   string             `json:"content"`
+	Bytes     int                `json:"bytes"`
+	Truncated bool               `json:"truncated"`
+	Phases    []logs.PhaseOffset `json:"phases"`
+}
+
+func writeJobLogsJSON(w http.ResponseWriter, content []byte) {
+	response := jobLogsResponse{
+		Bytes:  len(content),
+		Phases: logs.PhaseOffsets(content),
+	}
+	if response.Phases == nil {
+		response.Phases = []logs.PhaseOffset{}
+	}
+	if len(content) > maxJSONLogBytes {
+		content = content[len(content)-maxJSONLogBytes:]
+		response.Truncated = true
+	}
+	response.Content = string(content)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func acceptsJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		if strings.EqualFold(mediaType, "application/json") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) GetJobProvenanceHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	job, err := s.storage.GetJob(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJobNotFound(w)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !s.authorizeJobAccess(w, r, job) {
+		return
+	}
+
+	provenance, err := s.storage.GetJobProvenance(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJobNotFound(w)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if len(provenance) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error":   "PROVENANCE_NOT_FOUND",
+			"message": "provenance not found",
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.in-toto+json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(provenance)
+}
+
 func writeBuildLogNotFound(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusNotFound)
@@ -510,17 +932,15 @@ func writeJobNotFound(w http.ResponseWriter) {
 }
 
 func (s *Server) GetRunningBuildsHandler(w http.ResponseWriter, r *http.Request) {
-	activeIDs := s.manager.GetActiveBuilds()
-	runningBuilds := []storage.BuildJob{}
-
-	for _, id := range activeIDs {
-		job, err := s.storage.GetJob(id)
+	runningBuilds := []executor.RunningBuild{}
+	if s.manager != nil {
+		builds, err := s.manager.GetRunningBuilds()
 		if err != nil {
-			// Log the error but don't fail the whole request
-			log.Printf("WARN: could not get job details for active job %s: %v", id, err)
-			continue
+			log.Printf("ERROR: could not list running builds: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		runningBuilds = append(runningBuilds, *job)
+		runningBuilds = builds
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -537,6 +957,17 @@ func (s *Server) ResetDatabaseHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "Database reset successful")
 }
 
+// MetricsHandler serves the builder's metrics in the Prometheus text format.
+func (s *Server) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if s.manager == nil {
+		return
+	}
+	if err := s.manager.WriteMetrics(w); err != nil {
+		log.Printf("ERROR: could not write metrics: %v", err)
+	}
+}
+
 func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintln(w, "healthy")
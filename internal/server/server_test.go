@@ -0,0 +1,976 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"hubfly-builder/internal/allowlist"
+	"hubfly-builder/internal/events"
+	"hubfly-builder/internal/executor"
+	"hubfly-builder/internal/gitauth"
+	"hubfly-builder/internal/jobstate"
+	"hubfly-builder/internal/logs"
+	"hubfly-builder/internal/storage"
+)
+
+const testJobLog = "[2026-01-01T00:00:00Z] Created workspace\n" +
+	"[2026-01-01T00:00:01Z] ==> Phase: clone\n" +
+	"[2026-01-01T00:00:02Z] Cloning repository (attempt 1/4)\n" +
+	"[2026-01-01T00:00:03Z] ==> Phase: build\n"
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := storage.NewStorage(filepath.Join(dir, "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	logManager, err := logs.NewLogManager(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatalf("failed to create log manager: %v", err)
+	}
+	return NewServer(store, logManager, nil, nil, nil)
+}
+
+func createJobWithLog(t *testing.T, s *Server, id, content string) {
+	t.Helper()
+	if err := s.storage.CreateJob(&storage.BuildJob{ID: id, ProjectID: "proj", UserID: "user"}); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	logPath, logFile, err := s.logManager.CreateLogFile(id)
+	if err != nil {
+		t.Fatalf("failed to create log file: %v", err)
+	}
+	if _, err := logFile.WriteString(content); err != nil {
+		t.Fatalf("failed to write log: %v", err)
+	}
+	logFile.Close()
+	if err := s.storage.UpdateJobLogPath(id, logPath); err != nil {
+		t.Fatalf("failed to update log path: %v", err)
+	}
+}
+
+func serveJobRequest(s *Server, handler http.HandlerFunc, id, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/"+id+"/logs", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestGetJobLogsHandlerReturnsPlainTextByDefault(t *testing.T) {
+	s := newTestServer(t)
+	createJobWithLog(t, s, "build_logs", testJobLog)
+
+	rec := serveJobRequest(s, s.GetJobLogsHandler, "build_logs", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/plain" {
+		t.Fatalf("expected text/plain, got %q", got)
+	}
+	if rec.Body.String() != testJobLog {
+		t.Fatalf("expected raw log body, got %q", rec.Body.String())
+	}
+}
+
+func TestGetJobLogsHandlerReturnsStructuredJSON(t *testing.T) {
+	s := newTestServer(t)
+	createJobWithLog(t, s, "build_logs", testJobLog)
+
+	rec := serveJobRequest(s, s.GetJobLogsHandler, "build_logs", "text/html, application/json;q=0.9")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Fatalf("expected application/json, got %q", got)
+	}
+
+	var response jobLogsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("invalid JSON response: %v", err)
+	}
+	if response.Content != testJobLog || response.Bytes != len(testJobLog) || response.Truncated {
+		t.Fatalf("unexpected logs response: %+v", response)
+	}
+	if len(response.Phases) != 2 || response.Phases[0].Name != "clone" || response.Phases[1].Name != "build" {
+		t.Fatalf("expected clone and build phase markers, got %+v", response.Phases)
+	}
+	if !strings.HasPrefix(testJobLog[response.Phases[1].Offset:], "[2026-01-01T00:00:03Z] ==> Phase: build") {
+		t.Fatalf("expected build phase offset to point at its marker line, got %d", response.Phases[1].Offset)
+	}
+}
+
+func TestGetJobLogsHandlerTruncatesLargeJSONContent(t *testing.T) {
+	s := newTestServer(t)
+	large := strings.Repeat("x", maxJSONLogBytes) + "\n[2026-01-01T00:00:00Z] tail\n"
+	createJobWithLog(t, s, "build_large", large)
+
+	rec := serveJobRequest(s, s.GetJobLogsHandler, "build_large", "application/json")
+	var response jobLogsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("invalid JSON response: %v", err)
+	}
+	if !response.Truncated || response.Bytes != len(large) || len(response.Content) != maxJSONLogBytes {
+		t.Fatalf("expected truncated response keeping the last %d bytes, got truncated=%t bytes=%d content=%d", maxJSONLogBytes, response.Truncated, response.Bytes, len(response.Content))
+	}
+	if !strings.HasSuffix(response.Content, "tail\n") {
+		t.Fatal("expected truncation to keep the end of the log")
+	}
+}
+
+func TestGetJobLogsHandlerServesCompressedLogs(t *testing.T) {
+	s := newTestServer(t)
+	createJobWithLog(t, s, "build_compressed", testJobLog)
+	job, err := s.storage.GetJob("build_compressed")
+	if err != nil {
+		t.Fatalf("failed to load job: %v", err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(job.LogPath, old, old); err != nil {
+		t.Fatalf("failed to age log: %v", err)
+	}
+	s.logManager.SetCompressAfter(24 * time.Hour)
+	if err := s.logManager.Cleanup(7 * 24 * time.Hour); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if _, err := os.Stat(job.LogPath + ".gz"); err != nil {
+		t.Fatalf("expected the log to be compressed: %v", err)
+	}
+
+	rec := serveJobRequest(s, s.GetJobLogsHandler, "build_compressed", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != testJobLog {
+		t.Fatalf("expected the decompressed log, got %d %q", rec.Code, rec.Body.String())
+	}
+	rec = serveJobRequest(s, s.GetJobLogsHandler, "build_compressed", "application/json")
+	var response jobLogsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("invalid JSON response: %v", err)
+	}
+	if response.Content != testJobLog || len(response.Phases) == 0 {
+		t.Fatalf("expected the decompressed log with its phases, got %+v", response)
+	}
+}
+
+func TestGetJobLogsHandlerMissingJobReturnsNotFound(t *testing.T) {
+	s := newTestServer(t)
+	rec := serveJobRequest(s, s.GetJobLogsHandler, "build_missing", "application/json")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+}
+
+func serveAuthenticatedJobRequest(s *Server, handler http.HandlerFunc, id, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/"+id, nil)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func newAuthTestServer(t *testing.T) *Server {
+	t.Helper()
+	s := newTestServer(t)
+	s.SetAPIKeys([]APIKey{
+		{Key: "owner-key", UserID: "user"},
+		{Key: "other-key", UserID: "someone-else"},
+		{Key: "admin-key", UserID: "ops", Admin: true},
+	})
+	createJobWithLog(t, s, "build_auth", testJobLog)
+	return s
+}
+
+func TestJobReadEndpointsAllowOwner(t *testing.T) {
+	s := newAuthTestServer(t)
+
+	for name, handler := range map[string]http.HandlerFunc{"status": s.GetJobHandler, "logs": s.GetJobLogsHandler} {
+		rec := serveAuthenticatedJobRequest(s, handler, "build_auth", "owner-key")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200 for owner, got %d: %s", name, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestJobReadEndpointsRejectNonOwner(t *testing.T) {
+	s := newAuthTestServer(t)
+
+	handlers := map[string]http.HandlerFunc{
+		"status":     s.GetJobHandler,
+		"logs":       s.GetJobLogsHandler,
+		"provenance": s.GetJobProvenanceHandler,
+	}
+	for name, handler := range handlers {
+		rec := serveAuthenticatedJobRequest(s, handler, "build_auth", "other-key")
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("%s: expected 403 for non-owner, got %d", name, rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "Cloning repository") {
+			t.Fatalf("%s: forbidden response leaked job logs", name)
+		}
+	}
+}
+
+func TestJobReadEndpointsAllowAdmin(t *testing.T) {
+	s := newAuthTestServer(t)
+
+	rec := serveAuthenticatedJobRequest(s, s.GetJobLogsHandler, "build_auth", "admin-key")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 for admin, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "Cloning repository") {
+		t.Fatalf("expected admin to receive logs, got %q", rec.Body.String())
+	}
+}
+
+func TestJobReadEndpointsRequireKeyWhenConfigured(t *testing.T) {
+	s := newAuthTestServer(t)
+
+	for _, key := range []string{"", "unknown-key"} {
+		rec := serveAuthenticatedJobRequest(s, s.GetJobHandler, "build_auth", key)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("key %q: expected 401, got %d", key, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs/build_auth", nil)
+	req.Header.Set("X-API-Key", "owner-key")
+	req = mux.SetURLVars(req, map[string]string{"id": "build_auth"})
+	rec := httptest.NewRecorder()
+	s.GetJobHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected X-API-Key to authenticate, got %d", rec.Code)
+	}
+}
+
+func TestCarryRequestOptionsKeepsOptInFlags(t *testing.T) {
+	requested := storage.BuildConfig{
+		UseBuildpacks: true,
+		Provenance:    true,
+		BuildInfo:     &storage.BuildInfoFile{Format: "env"},
+		Debug:         true,
+	}
+	detected := storage.BuildConfig{IsAutoBuild: true, Runtime: "node"}
+
+	carryRequestOptions(&detected, requested)
+
+	if !detected.UseBuildpacks || !detected.Provenance || !detected.Debug || detected.BuildInfo == nil || detected.BuildInfo.Format != "env" {
+		t.Fatalf("expected opt-in flags to survive auto-detection, got %+v", detected)
+	}
+	if detected.Runtime != "node" {
+		t.Fatalf("expected detected fields to be kept, got %+v", detected)
+	}
+}
+
+func postJob(t *testing.T, s *Server, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/jobs", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.CreateJobHandler(rec, req)
+	return rec
+}
+
+func watchPathJobBody(id, changedFile string) string {
+	return `{"id":"` + id + `","projectId":"proj","userId":"user","sourceType":"git",` +
+		`"sourceInfo":{"gitRepository":"https://example.com/mono.git","changedFiles":["` + changedFile + `"]},` +
+		`"buildConfig":{"network":"proj-network","watchPaths":["services/api/**","go.mod"]}}`
+}
+
+func TestCreateJobBuildsWhenWatchedPathChanged(t *testing.T) {
+	s := newTestServer(t)
+	s.manager = executor.NewManager(s.storage, s.logManager, nil, nil, 1, "")
+
+	rec := postJob(t, s, watchPathJobBody("build_watched", "services/api/handlers/user.go"))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	job, err := s.storage.GetJob("build_watched")
+	if err != nil {
+		t.Fatalf("failed to load job: %v", err)
+	}
+	if job.Status != "pending" {
+		t.Fatalf("expected a watched change to queue the job, got status %q", job.Status)
+	}
+}
+
+func TestCreateJobSkipsWhenNoWatchedPathChanged(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := postJob(t, s, watchPathJobBody("build_unwatched", "services/web/src/App.tsx"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 for a skipped job, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body storage.BuildJob
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if body.Status != "skipped" {
+		t.Fatalf("expected skipped status in response, got %q", body.Status)
+	}
+	job, err := s.storage.GetJob("build_unwatched")
+	if err != nil {
+		t.Fatalf("failed to load job: %v", err)
+	}
+	if job.Status != "skipped" {
+		t.Fatalf("expected stored job to be skipped, got %q", job.Status)
+	}
+}
+
+func TestCreateJobAppliesBuildProfileWithJobOverrides(t *testing.T) {
+	s := newTestServer(t)
+	s.manager = executor.NewManager(s.storage, s.logManager, nil, nil, 1, "")
+	s.SetBuildProfiles(Profiles{
+		"production": {
+			Env:            map[string]string{"NODE_ENV": "production", "LOG_LEVEL": "warn"},
+			Network:        "prod-network",
+			TimeoutSeconds: 900,
+			ResourceLimits: storage.ResourceLimits{CPU: 2, MemoryMB: 2048},
+			Provenance:     true,
+		},
+	})
+
+	rec := postJob(t, s, `{"id":"build_profile","projectId":"proj","userId":"user","sourceType":"git",`+
+		`"sourceInfo":{"gitRepository":"https://example.com/app.git"},`+
+		`"buildConfig":{"profile":"production","env":{"LOG_LEVEL":"debug"},"resourceLimits":{"memoryMB":4096}}}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	job, err := s.storage.GetJob("build_profile")
+	if err != nil {
+		t.Fatalf("failed to load job: %v", err)
+	}
+	cfg := job.BuildConfig
+	if cfg.Profile != "production" || cfg.Network != "prod-network" || cfg.TimeoutSeconds != 900 || !cfg.Provenance {
+		t.Fatalf("expected profile settings to fill the job config, got %+v", cfg)
+	}
+	if cfg.Env["NODE_ENV"] != "production" || cfg.Env["LOG_LEVEL"] != "debug" {
+		t.Fatalf("expected profile env merged under job env, got %v", cfg.Env)
+	}
+	if cfg.ResourceLimits.CPU != 2 || cfg.ResourceLimits.MemoryMB != 4096 {
+		t.Fatalf("expected job memory to override the profile and profile CPU to apply, got %+v", cfg.ResourceLimits)
+	}
+}
+
+func TestCreateJobRejectsUnknownBuildProfile(t *testing.T) {
+	s := newTestServer(t)
+	s.SetBuildProfiles(Profiles{"staging": {Network: "staging-network"}})
+
+	rec := postJob(t, s, `{"id":"build_bad_profile","projectId":"proj","userId":"user","sourceType":"git",`+
+		`"sourceInfo":{"gitRepository":"https://example.com/app.git"},`+
+		`"buildConfig":{"profile":"production","network":"proj-network"}}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `unknown build profile "production"`) {
+		t.Fatalf("expected 400 for an unknown profile, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := s.storage.GetJob("build_bad_profile"); err == nil {
+		t.Fatalf("expected a job with an unknown profile not to be stored")
+	}
+}
+
+func buildOptsJobBody(id, opts string) string {
+	return `{"id":"` + id + `","projectId":"proj","userId":"user","sourceType":"git",` +
+		`"sourceInfo":{"gitRepository":"https://example.com/app.git"},` +
+		`"buildConfig":{"network":"proj-network","buildOpts":` + opts + `}}`
+}
+
+func TestCreateJobAcceptsAllowlistedBuildOpts(t *testing.T) {
+	s := newTestServer(t)
+	s.manager = executor.NewManager(s.storage, s.logManager, nil, nil, 1, "")
+	s.manager.SetBuildOptAllowlist([]string{"squash"})
+
+	rec := postJob(t, s, buildOptsJobBody("build_opts_ok", `{"squash":"true"}`))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	job, err := s.storage.GetJob("build_opts_ok")
+	if err != nil {
+		t.Fatalf("failed to load job: %v", err)
+	}
+	if job.BuildConfig.BuildOpts["squash"] != "true" {
+		t.Fatalf("expected build opts to be stored, got %v", job.BuildConfig.BuildOpts)
+	}
+}
+
+func TestCreateJobRejectsDisallowedBuildOpts(t *testing.T) {
+	s := newTestServer(t)
+	s.manager = executor.NewManager(s.storage, s.logManager, nil, nil, 1, "")
+	s.manager.SetBuildOptAllowlist([]string{"squash"})
+
+	rec := postJob(t, s, buildOptsJobBody("build_opts_bad", `{"squash":"true","privileged":"true"}`))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `buildOpts key "privileged" is not allowed on this builder`) {
+		t.Fatalf("expected 400 for a disallowed build opt, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := s.storage.GetJob("build_opts_bad"); err == nil {
+		t.Fatalf("expected a job with disallowed build opts not to be stored")
+	}
+}
+
+func TestCreateJobRejectsMalformedDNSServers(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := postJob(t, s, `{"id":"build_dns_bad","projectId":"proj","userId":"user","sourceType":"git",`+
+		`"sourceInfo":{"gitRepository":"https://example.com/app.git"},`+
+		`"buildConfig":{"network":"proj-network","dnsServers":["10.0.0.2","dns.internal"]}}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `dnsServers entry "dns.internal" must be an IP address`) {
+		t.Fatalf("expected 400 for a malformed DNS server, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := s.storage.GetJob("build_dns_bad"); err == nil {
+		t.Fatalf("expected a job with malformed DNS servers not to be stored")
+	}
+}
+
+func TestCreateJobRejectsMalformedExtraHosts(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := postJob(t, s, `{"id":"build_hosts_bad","projectId":"proj","userId":"user","sourceType":"git",`+
+		`"sourceInfo":{"gitRepository":"https://example.com/app.git"},`+
+		`"buildConfig":{"network":"proj-network","extraHosts":["mirror.internal:10.0.0.5","mirror.internal"]}}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `extraHosts entry "mirror.internal" must be host:ip`) {
+		t.Fatalf("expected 400 for a malformed extra host, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := s.storage.GetJob("build_hosts_bad"); err == nil {
+		t.Fatalf("expected a job with malformed extra hosts not to be stored")
+	}
+}
+
+func TestCreateJobRejectsUnknownPlatforms(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := postJob(t, s, `{"id":"build_platform_bad","projectId":"proj","userId":"user","sourceType":"git",`+
+		`"sourceInfo":{"gitRepository":"https://example.com/app.git"},`+
+		`"buildConfig":{"network":"proj-network","platforms":["linux/amd64","linux/sparc"]}}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `platforms entry "linux/sparc"`) {
+		t.Fatalf("expected 400 for an unknown platform, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCreateJobRejectsTooManyEnvVars(t *testing.T) {
+	s := newTestServer(t)
+	s.SetEnvLimits(EnvLimits{MaxKeys: 3})
+
+	rec := postJob(t, s, `{"id":"build_env_keys","projectId":"proj","userId":"user","sourceType":"git",`+
+		`"sourceInfo":{"gitRepository":"https://example.com/app.git"},`+
+		`"buildConfig":{"network":"proj-network","env":{"A":"1","B":"2"},"dockerfileArgs":{"C":"3","D":"4"}}}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "env has 4 variables; at most 3 are allowed") {
+		t.Fatalf("expected 400 for too many env vars, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := s.storage.GetJob("build_env_keys"); err == nil {
+		t.Fatalf("expected a job with too many env vars not to be stored")
+	}
+}
+
+func TestCreateJobRejectsOversizedEnv(t *testing.T) {
+	s := newTestServer(t)
+	s.SetEnvLimits(EnvLimits{MaxBytes: 64})
+
+	rec := postJob(t, s, `{"id":"build_env_bytes","projectId":"proj","userId":"user","sourceType":"git",`+
+		`"sourceInfo":{"gitRepository":"https://example.com/app.git"},`+
+		`"env":{"PAYLOAD":"`+strings.Repeat("x", 64)+`"},"buildConfig":{"network":"proj-network"}}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "env is 71 bytes; at most 64 bytes are allowed") {
+		t.Fatalf("expected 400 for an oversized env, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCreateJobRejectsDockerfilePathOutsideRepository(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := postJob(t, s, `{"id":"build_dockerfile_bad","projectId":"proj","userId":"user","sourceType":"git",`+
+		`"sourceInfo":{"gitRepository":"https://example.com/app.git"},`+
+		`"buildConfig":{"network":"proj-network","dockerfilePath":"../Dockerfile.prod"}}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "dockerfilePath") {
+		t.Fatalf("expected 400 for a dockerfilePath outside the repository, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCreateJobRejectsCommandOverrides(t *testing.T) {
+	s := newTestServer(t)
+	s.allowlist = allowlist.DefaultAllowedCommands()
+
+	rec := postJob(t, s, `{"id":"build_override_manual","projectId":"proj","userId":"user","sourceType":"git",`+
+		`"sourceInfo":{"gitRepository":"https://example.com/app.git"},`+
+		`"buildConfig":{"network":"proj-network","buildCommandOverride":"npm run build"}}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "require isAutoBuild") {
+		t.Fatalf("expected 400 for an override without auto-build, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = postJob(t, s, `{"id":"build_override_bad","projectId":"proj","userId":"user","sourceType":"git",`+
+		`"sourceInfo":{"gitRepository":"https://example.com/app.git"},`+
+		`"buildConfig":{"network":"proj-network","isAutoBuild":true,"runCommandOverride":"rm -rf /"}}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "run command is not allowed") {
+		t.Fatalf("expected 400 for a disallowed override, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCreateJobRejectsInvalidBaseImage(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := postJob(t, s, `{"id":"build_base_bad","projectId":"proj","userId":"user","sourceType":"git",`+
+		`"sourceInfo":{"gitRepository":"https://example.com/app.git"},`+
+		`"buildConfig":{"network":"proj-network","baseImage":"node:20 AS builder"}}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "not a valid image reference") {
+		t.Fatalf("expected 400 for an invalid base image, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := s.storage.GetJob("build_base_bad"); err == nil {
+		t.Fatalf("expected a job with an invalid base image not to be stored")
+	}
+}
+
+func TestCreateJobRejectsTooSmallMemoryLimit(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := postJob(t, s, `{"id":"build_tiny_memory","projectId":"proj","userId":"user","sourceType":"git",`+
+		`"sourceInfo":{"gitRepository":"https://example.com/app.git"},`+
+		`"buildConfig":{"network":"proj-network","resourceLimits":{"memoryMB":128}}}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "below the 512 MB") {
+		t.Fatalf("expected 400 for a memory limit below the minimum, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := s.storage.GetJob("build_tiny_memory"); err == nil {
+		t.Fatalf("expected a job with a too small memory limit not to be stored")
+	}
+}
+
+type stubImageChecker map[string]bool
+
+func (c stubImageChecker) ImageExists(_ context.Context, imageTag string) (bool, error) {
+	return c[imageTag], nil
+}
+
+func newImageCheckTestServer(t *testing.T) *Server {
+	t.Helper()
+	s := newTestServer(t)
+	s.manager = executor.NewManager(s.storage, s.logManager, nil, nil, 1, "")
+	s.manager.SetImageChecker(stubImageChecker{"hubcell.local/user/app:present": true})
+	for id, imageTag := range map[string]string{"build_present": "hubcell.local/user/app:present", "build_gone": "hubcell.local/user/app:gone"} {
+		createJobWithLog(t, s, id, testJobLog)
+		if err := s.storage.UpdateJobImageTag(id, imageTag); err != nil {
+			t.Fatalf("failed to set image tag: %v", err)
+		}
+		if err := s.storage.UpdateJobStatus(id, "success"); err != nil {
+			t.Fatalf("failed to set status: %v", err)
+		}
+	}
+	return s
+}
+
+func TestVerifyJobImageReportsPresentAndMissingImages(t *testing.T) {
+	s := newImageCheckTestServer(t)
+
+	for id, wantMissing := range map[string]bool{"build_present": false, "build_gone": true} {
+		rec := serveAuthenticatedJobRequest(s, s.VerifyJobImageHandler, id, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", id, rec.Code, rec.Body.String())
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if body["imageMissing"] != wantMissing || body["imageCheckedAt"] == nil {
+			t.Fatalf("%s: expected imageMissing=%t with a check time, got %v", id, wantMissing, body)
+		}
+	}
+
+	rec := serveAuthenticatedJobRequest(s, s.GetJobHandler, "build_gone", "")
+	var job map[string]interface{}
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		t.Fatalf("failed to decode job: %v", err)
+	}
+	if job["imageMissing"] != true {
+		t.Fatalf("expected the job to report its missing image, got %v", job)
+	}
+}
+
+func TestVerifyJobImageRejectsJobsWithoutImage(t *testing.T) {
+	s := newImageCheckTestServer(t)
+	createJobWithLog(t, s, "build_pending", testJobLog)
+
+	rec := serveAuthenticatedJobRequest(s, s.VerifyJobImageHandler, "build_pending", "")
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "IMAGE_NOT_VERIFIABLE") {
+		t.Fatalf("expected 409 IMAGE_NOT_VERIFIABLE, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func serveStatsRequest(s *Server, projectID, query, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/projects/"+projectID+"/stats"+query, nil)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	req = mux.SetURLVars(req, map[string]string{"projectId": projectID})
+	rec := httptest.NewRecorder()
+	s.GetProjectStatsHandler(rec, req)
+	return rec
+}
+
+func TestProjectStatsHandlerReportsAggregates(t *testing.T) {
+	s := newTestServer(t)
+	for id, events := range map[string][]jobstate.Event{
+		"build_ok":      {jobstate.Claim, jobstate.Start, jobstate.Succeed},
+		"build_failed":  {jobstate.Claim, jobstate.Start, jobstate.Fail},
+		"build_pending": nil,
+	} {
+		if err := s.storage.CreateJob(&storage.BuildJob{ID: id, ProjectID: "proj", UserID: "user"}); err != nil {
+			t.Fatalf("failed to create job: %v", err)
+		}
+		current := jobstate.Pending
+		for _, event := range events {
+			next, err := jobstate.Transition(current, event)
+			if err != nil {
+				t.Fatalf("bad test transition: %v", err)
+			}
+			if ok, err := s.storage.TransitionJob(id, current, event); err != nil || !ok {
+				t.Fatalf("failed to move %s to %s: ok=%t err=%v", id, next, ok, err)
+			}
+			current = next
+		}
+	}
+
+	rec := serveStatsRequest(s, "proj", "?since=24h", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var stats storage.ProjectStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("failed to decode stats: %v", err)
+	}
+	if stats.Total != 3 || stats.StatusCounts["success"] != 1 || stats.StatusCounts["failed"] != 1 || stats.StatusCounts["pending"] != 1 {
+		t.Fatalf("unexpected counts: %+v", stats)
+	}
+	if stats.SuccessRate != 0.5 || stats.DurationSamples != 2 {
+		t.Fatalf("expected a 0.5 success rate over 2 timed builds, got %+v", stats)
+	}
+	if stats.LastBuild == nil {
+		t.Fatal("expected last build info")
+	}
+}
+
+func TestProjectStatsHandlerRejectsInvalidSince(t *testing.T) {
+	s := newTestServer(t)
+	if rec := serveStatsRequest(s, "proj", "?since=yesterday", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+}
+
+func TestProjectStatsHandlerScopesToCaller(t *testing.T) {
+	s := newAuthTestServer(t)
+
+	if rec := serveStatsRequest(s, "proj", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a key, got %d", rec.Code)
+	}
+	for key, want := range map[string]int{"owner-key": 1, "other-key": 0, "admin-key": 1} {
+		rec := serveStatsRequest(s, "proj", "", key)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", key, rec.Code)
+		}
+		var stats storage.ProjectStats
+		if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+			t.Fatalf("failed to decode stats: %v", err)
+		}
+		if stats.Total != want {
+			t.Fatalf("%s: expected %d jobs, got %d", key, want, stats.Total)
+		}
+	}
+}
+
+func serveListJobsRequest(s *Server, query, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs"+query, nil)
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	rec := httptest.NewRecorder()
+	s.ListJobsHandler(rec, req)
+	return rec
+}
+
+func TestListJobsHandlerPagesAndFilters(t *testing.T) {
+	s := newTestServer(t)
+	for _, job := range []*storage.BuildJob{
+		{ID: "build_a", ProjectID: "proj", UserID: "user"},
+		{ID: "build_b", ProjectID: "proj", UserID: "user"},
+		{ID: "build_c", ProjectID: "other", UserID: "user"},
+	} {
+		if err := s.storage.CreateJob(job); err != nil {
+			t.Fatalf("failed to create job: %v", err)
+		}
+	}
+
+	rec := serveListJobsRequest(s, "?projectId=proj&status=pending&limit=1000&offset=-3", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var page jobListResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatalf("failed to decode job list: %v", err)
+	}
+	if page.Total != 2 || len(page.Jobs) != 2 || page.Limit != storage.MaxJobListLimit || page.Offset != 0 {
+		t.Fatalf("expected both project jobs on a clamped page, got %+v", page)
+	}
+
+	if rec := serveListJobsRequest(s, "?limit=ten", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a non-numeric limit, got %d", rec.Code)
+	}
+}
+
+func TestListJobsHandlerScopesToCaller(t *testing.T) {
+	s := newAuthTestServer(t)
+
+	if rec := serveListJobsRequest(s, "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a key, got %d", rec.Code)
+	}
+	if rec := serveListJobsRequest(s, "?userId=user", "other-key"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 when listing another user's jobs, got %d", rec.Code)
+	}
+	for key, want := range map[string]int{"owner-key": 1, "other-key": 0, "admin-key": 1} {
+		rec := serveListJobsRequest(s, "", key)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d", key, rec.Code)
+		}
+		var page jobListResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatalf("failed to decode job list: %v", err)
+		}
+		if page.Total != want || len(page.Jobs) != want {
+			t.Fatalf("%s: expected %d jobs, got %+v", key, want, page)
+		}
+	}
+}
+
+func TestCancelJobHandlerCancelsPendingJob(t *testing.T) {
+	s := newAuthTestServer(t)
+	s.manager = executor.NewManager(s.storage, s.logManager, nil, nil, 1, "")
+	createJobWithLog(t, s, "build_cancel", "queued\n")
+
+	if rec := serveAuthenticatedJobRequest(s, s.CancelJobHandler, "build_cancel", "other-key"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected another user's job to be refused, got %d", rec.Code)
+	}
+
+	rec := serveAuthenticatedJobRequest(s, s.CancelJobHandler, "build_cancel", "owner-key")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"status":"canceled"`) {
+		t.Fatalf("expected the pending job to be canceled, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = serveAuthenticatedJobRequest(s, s.CancelJobHandler, "build_cancel", "owner-key")
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "JOB_NOT_CANCELABLE") {
+		t.Fatalf("expected a canceled job to be rejected, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestResumeJobHandlerRequeuesFailedJob(t *testing.T) {
+	s := newAuthTestServer(t)
+	s.manager = executor.NewManager(s.storage, s.logManager, nil, nil, 1, "")
+	createJobWithLog(t, s, "build_resume", "failed\n")
+
+	rec := serveAuthenticatedJobRequest(s, s.ResumeJobHandler, "build_resume", "owner-key")
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "JOB_NOT_RESUMABLE") {
+		t.Fatalf("expected a pending job to be rejected, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if err := s.storage.UpdateJobStatus("build_resume", "failed"); err != nil {
+		t.Fatalf("failed to update status: %v", err)
+	}
+	if rec := serveAuthenticatedJobRequest(s, s.ResumeJobHandler, "build_resume", "other-key"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected another user's job to be refused, got %d", rec.Code)
+	}
+	rec = serveAuthenticatedJobRequest(s, s.ResumeJobHandler, "build_resume", "owner-key")
+	if rec.Code != http.StatusAccepted || !strings.Contains(rec.Body.String(), `"resumeFrom":"clone"`) {
+		t.Fatalf("expected the failed job to be requeued from the clone, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if job, _ := s.storage.GetJob("build_resume"); job.Status != "pending" {
+		t.Fatalf("expected the job back on the queue, got %s", job.Status)
+	}
+}
+
+func TestGetJobIncludesBuildWarnings(t *testing.T) {
+	s := newTestServer(t)
+	createJobWithLog(t, s, "build_warned", testJobLog)
+	if err := s.storage.UpdateJobStatus("build_warned", "success"); err != nil {
+		t.Fatalf("failed to update status: %v", err)
+	}
+	warnings := storage.BuildWarnings{{Rule: "SecretsUsedInArgOrEnv", Message: `Do not use ARG or ENV instructions for sensitive data (ARG "KEY")`, Line: 3}}
+	if err := s.storage.SetJobBuildWarnings("build_warned", warnings); err != nil {
+		t.Fatalf("failed to store warnings: %v", err)
+	}
+
+	rec := serveAuthenticatedJobRequest(s, s.GetJobHandler, "build_warned", "")
+	var job struct {
+		BuildWarnings storage.BuildWarnings `json:"buildWarnings"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&job); err != nil {
+		t.Fatalf("failed to decode job: %v", err)
+	}
+	if len(job.BuildWarnings) != 1 || job.BuildWarnings[0] != warnings[0] {
+		t.Fatalf("expected the stored warnings in the job response, got %+v", job.BuildWarnings)
+	}
+}
+
+func TestStreamEventsDeliversMatchingJobEvents(t *testing.T) {
+	s := newTestServer(t)
+	s.manager = executor.NewManager(s.storage, s.logManager, nil, nil, 1, "")
+	bus := events.NewBus()
+	s.SetEventBus(bus)
+
+	ts := httptest.NewServer(http.HandlerFunc(s.StreamEventsHandler))
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "?projectId=proj")
+	if err != nil {
+		t.Fatalf("failed to open the event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("expected an NDJSON stream, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	lines := make(chan events.Event)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var event events.Event
+			if err := json.Unmarshal(scanner.Bytes(), &event); err == nil {
+				lines <- event
+			}
+		}
+		close(lines)
+	}()
+
+	if rec := postJob(t, s, `{"id":"build_stream","projectId":"proj","userId":"user","sourceType":"git","sourceInfo":{"gitRepository":"https://example.com/repo.git"},"buildConfig":{"network":"proj-network"}}`); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	bus.Publish(events.Event{Type: events.JobStatus, JobID: "build_other", ProjectID: "other", Status: "claimed"})
+	bus.Publish(events.Event{Type: events.JobStatus, JobID: "build_stream", ProjectID: "proj", UserID: "user", Status: "claimed"})
+	bus.Publish(events.Event{Type: events.JobPhase, JobID: "build_stream", ProjectID: "proj", UserID: "user", Phase: "clone"})
+
+	var got []string
+	timeout := time.After(5 * time.Second)
+	for len(got) < 3 {
+		select {
+		case event, open := <-lines:
+			if !open {
+				t.Fatalf("stream ended early after %v", got)
+			}
+			got = append(got, string(event.Type)+":"+event.JobID+":"+event.Status+event.Phase)
+		case <-timeout:
+			t.Fatalf("timed out waiting for events, got %v", got)
+		}
+	}
+	want := "job.created:build_stream:pending,job.status:build_stream:claimed,job.phase:build_stream:clone"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("expected %s, got %s", want, strings.Join(got, ","))
+	}
+}
+
+func TestStreamEventsRejectsAnotherUsersFeed(t *testing.T) {
+	s := newAuthTestServer(t)
+	s.SetEventBus(events.NewBus())
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/events?userId=user", nil)
+	req.Header.Set("Authorization", "Bearer other-key")
+	rec := httptest.NewRecorder()
+	s.StreamEventsHandler(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for another user's events, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/events", nil)
+	rec = httptest.NewRecorder()
+	s.StreamEventsHandler(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a key, got %d", rec.Code)
+	}
+}
+
+func TestCreateJobKeepsGitCredentialOutOfTheResponse(t *testing.T) {
+	s := newTestServer(t)
+	s.manager = executor.NewManager(s.storage, s.logManager, nil, nil, 1, "")
+
+	rec := postJob(t, s, `{"id":"build_private","projectId":"proj","userId":"user","sourceType":"git",`+
+		`"sourceInfo":{"gitRepository":"https://github.com/acme/private.git","credential":{"token":"ghp_secret123"}},`+
+		`"buildConfig":{"network":"proj-network"}}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "ghp_secret123") || !strings.Contains(rec.Body.String(), `"credential":{"type":"token"}`) {
+		t.Fatalf("expected only the credential kind in the response, got %s", rec.Body.String())
+	}
+
+	rec = postJob(t, s, `{"id":"build_both","projectId":"proj","userId":"user","sourceType":"git",`+
+		`"sourceInfo":{"gitRepository":"https://github.com/acme/private.git","credential":{"token":"t","sshKey":"k"}},`+
+		`"buildConfig":{"network":"proj-network"}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a credential with both token and sshKey, got %d", rec.Code)
+	}
+}
+
+func TestCreateJobValidatesGitCredentialReferences(t *testing.T) {
+	s := newTestServer(t)
+	s.manager = executor.NewManager(s.storage, s.logManager, nil, nil, 1, "")
+	body := func(id, ref string) string {
+		return `{"id":"` + id + `","projectId":"proj","userId":"user","sourceType":"git",` +
+			`"sourceInfo":{"gitRepository":"https://github.com/acme/private.git","credential":{"tokenRef":"` + ref + `"}},` +
+			`"buildConfig":{"network":"proj-network"}}`
+	}
+
+	if rec := postJob(t, s, body("build_no_vault", "vault://github/ci#token")); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a secret resolver, got %d", rec.Code)
+	}
+	s.SetSecretResolver(gitauth.FileResolver{Dir: t.TempDir()})
+	if rec := postJob(t, s, body("build_escape", "vault://../etc/passwd")); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a reference outside the secret store, got %d", rec.Code)
+	}
+	rec := postJob(t, s, body("build_vault", "vault://github/ci#token"))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"credential":{"type":"token"}`) {
+		t.Fatalf("expected the credential kind in the response, got %s", rec.Body.String())
+	}
+}
+
+func TestSelfTestHandlerRequiresAdminKey(t *testing.T) {
+	binDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(binDir, "sudo"), []byte("#!/bin/sh\nexit 0\n"), 0o755); err != nil {
+		t.Fatalf("failed to write fake sudo: %v", err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	s := newAuthTestServer(t)
+	s.manager = executor.NewManager(s.storage, s.logManager, nil, nil, 1, "")
+
+	for key, want := range map[string]int{"": http.StatusUnauthorized, "owner-key": http.StatusForbidden, "admin-key": http.StatusOK} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/selftest", nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		s.SelfTestHandler(rec, req)
+		if rec.Code != want {
+			t.Fatalf("key %q: expected %d, got %d: %s", key, want, rec.Code, rec.Body.String())
+		}
+		if want == http.StatusOK && !strings.Contains(rec.Body.String(), `"success":true`) {
+			t.Fatalf("expected a successful self-test, got %s", rec.Body.String())
+		}
+	}
+}
+
+func TestMetricsHandlerServesQueueWaitHistogram(t *testing.T) {
+	s := newTestServer(t)
+	s.manager = executor.NewManager(s.storage, s.logManager, nil, nil, 1, "")
+
+	rec := httptest.NewRecorder()
+	s.MetricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("expected Prometheus text, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "hubfly_queue_wait_seconds_count 0\n") {
+		t.Fatalf("expected an empty queue wait histogram, got:\n%s", body)
+	}
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultStatsWindow = 30 * 24 * time.Hour
+	maxStatsWindow     = 365 * 24 * time.Hour
+)
+
+// GetProjectStatsHandler reports build counts, success rate and durations for
+// a project. since is an RFC 3339 time or a Go duration such as "168h"
+// counted back from now; it defaults to 30 days and is capped at a year.
+// Non-admin API keys only see their own jobs.
+func (s *Server) GetProjectStatsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.statsScope(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	since, err := parseStatsSince(r.URL.Query().Get("since"), now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stats, err := s.storage.ProjectStats(mux.Vars(r)["projectId"], userID, since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(stats)
+}
+
+func parseStatsSince(raw string, now time.Time) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	since := now.Add(-defaultStatsWindow)
+	if raw != "" {
+		if parsed, err := time.Parse(time.RFC3339, raw); err == nil {
+			since = parsed
+		} else if window, err := time.ParseDuration(raw); err == nil && window > 0 {
+			since = now.Add(-window)
+		} else {
+			return time.Time{}, fmt.Errorf("invalid since %q: use an RFC 3339 time or a duration such as 168h", raw)
+		}
+	}
+	if oldest := now.Add(-maxStatsWindow); since.Before(oldest) {
+		since = oldest
+	}
+	return since, nil
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// BuildCacheStats counts the steps of the job's image build that BuildKit
+// served from its layer cache and the ones it executed.
+type BuildCacheStats struct {
+	CachedSteps   int `json:"cachedSteps"`
+	ExecutedSteps int `json:"executedSteps"`
+	// HitRatio is CachedSteps over all steps, rounded to two decimals.
+	HitRatio float64 `json:"hitRatio"`
+}
+
+// SetJobBuildCacheStats replaces the cache statistics recorded for the job's
+// image build. A nil stats clears them.
+func (s *Storage) SetJobBuildCacheStats(id string, stats *BuildCacheStats) error {
+	encoded := ""
+	if stats != nil {
+		data, err := json.Marshal(stats)
+		if err != nil {
+			return err
+		}
+		encoded = string(data)
+	}
+	_, err := s.exec(`UPDATE build_jobs SET build_cache_stats = ?, updated_at = ? WHERE id = ?`, encoded, time.Now(), id)
+	return err
+}
+
+// GetJobBuildCacheStats returns nil when no image build step was recorded.
+func (s *Storage) GetJobBuildCacheStats(id string) (*BuildCacheStats, error) {
+	var encoded string
+	if err := s.queryRow(`SELECT COALESCE(build_cache_stats, '') FROM build_jobs WHERE id = ?`, id).Scan(&encoded); err != nil || encoded == "" {
+		return nil, err
+	}
+	var stats BuildCacheStats
+	if err := json.Unmarshal([]byte(encoded), &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -0,0 +1,42 @@
+package storage
+
+import "database/sql"
+
+// NextBuildNumber returns the next build number of projectID, starting at 1.
+// The counter is incremented in a single statement, so concurrent callers
+// always get distinct, consecutive numbers.
+func (s *Storage) NextBuildNumber(projectID string) (int64, error) {
+	var number int64
+	err := retryBusy(func() error {
+		return s.queryRow(`
+			INSERT INTO project_build_numbers (project_id, last_number)
+			VALUES (?, 1)
+			ON CONFLICT(project_id) DO UPDATE SET last_number = project_build_numbers.last_number + 1
+			RETURNING last_number
+		`, projectID).Scan(&number)
+	})
+	return number, err
+}
+
+// AssignJobBuildNumber returns the build number of the job, taking the next
+// number of its project the first time it is asked for. A retried job keeps
+// the number of its first attempt.
+func (s *Storage) AssignJobBuildNumber(jobID string) (int64, error) {
+	var projectID string
+	var number sql.NullInt64
+	if err := s.queryRow(`SELECT project_id, build_number FROM build_jobs WHERE id = ?`, jobID).Scan(&projectID, &number); err != nil {
+		return 0, err
+	}
+	if number.Int64 > 0 {
+		return number.Int64, nil
+	}
+
+	next, err := s.NextBuildNumber(projectID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.exec(`UPDATE build_jobs SET build_number = ? WHERE id = ?`, next, jobID); err != nil {
+		return 0, err
+	}
+	return next, nil
+}
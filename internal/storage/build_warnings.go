@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// BuildWarning is a warning the image build printed, such as a Dockerfile
+// check flagging a secret passed through ARG.
+type BuildWarning struct {
+	Rule    string `json:"rule,omitempty"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+}
+
+type BuildWarnings []BuildWarning
+
+// SetJobBuildWarnings replaces the warnings recorded for the job's image build.
+func (s *Storage) SetJobBuildWarnings(id string, warnings BuildWarnings) error {
+	encoded := ""
+	if len(warnings) > 0 {
+		data, err := json.Marshal(warnings)
+		if err != nil {
+			return err
+		}
+		encoded = string(data)
+	}
+	_, err := s.exec(`UPDATE build_jobs SET build_warnings = ?, updated_at = ? WHERE id = ?`, encoded, time.Now(), id)
+	return err
+}
+
+// GetJobBuildWarnings returns nil when the job's build reported no warnings.
+func (s *Storage) GetJobBuildWarnings(id string) (BuildWarnings, error) {
+	var encoded string
+	if err := s.queryRow(`SELECT COALESCE(build_warnings, '') FROM build_jobs WHERE id = ?`, id).Scan(&encoded); err != nil || encoded == "" {
+		return nil, err
+	}
+	var warnings BuildWarnings
+	if err := json.Unmarshal([]byte(encoded), &warnings); err != nil {
+		return nil, err
+	}
+	return warnings, nil
+}
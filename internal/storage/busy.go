@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Writes that still find the database locked once the busy timeout has run
+// out are retried a few times with a doubling backoff before the error is
+// returned, so a brief burst of contention does not fail a job or request.
+var (
+	busyRetryAttempts = 4
+	busyRetryBackoff  = 25 * time.Millisecond
+)
+
+// isBusy reports whether err means another connection held the lock.
+func isBusy(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return err != nil && strings.Contains(err.Error(), "database is locked")
+}
+
+// retryBusy calls write until it succeeds, fails with an error other than
+// SQLITE_BUSY, or busyRetryAttempts retries have been made.
+func retryBusy(write func() error) error {
+	backoff := busyRetryBackoff
+	err := write()
+	for attempt := 0; attempt < busyRetryAttempts && isBusy(err); attempt++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		err = write()
+	}
+	return err
+}
+
+// exec runs a write statement, retrying it while the database is busy.
+// Reads go through s.query and s.queryRow, which do not retry.
+func (s *Storage) exec(query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := retryBusy(func() error {
+		var err error
+		result, err = s.db.Exec(s.dialect.rebind(query), args...)
+		return err
+	})
+	return result, err
+}
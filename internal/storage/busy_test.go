@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func TestRetryBusyRetriesUntilTheWriteSucceeds(t *testing.T) {
+	calls := 0
+	err := retryBusy(func() error {
+		calls++
+		if calls == 1 {
+			return sqlite3.Error{Code: sqlite3.ErrBusy}
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("expected the busy write to succeed on retry, got %v after %d calls", err, calls)
+	}
+}
+
+func TestRetryBusyReturnsOtherErrorsAtOnce(t *testing.T) {
+	calls := 0
+	failure := errors.New("constraint failed")
+	err := retryBusy(func() error {
+		calls++
+		return failure
+	})
+	if !errors.Is(err, failure) || calls != 1 {
+		t.Fatalf("expected the error to be returned without retrying, got %v after %d calls", err, calls)
+	}
+}
+
+func TestRetryBusyGivesUpAfterBoundedAttempts(t *testing.T) {
+	calls := 0
+	err := retryBusy(func() error {
+		calls++
+		return errors.New("database is locked")
+	})
+	if !isBusy(err) || calls != busyRetryAttempts+1 {
+		t.Fatalf("expected %d attempts ending in a busy error, got %v after %d calls", busyRetryAttempts+1, err, calls)
+	}
+}
+
+func TestWritesWaitOutALockHeldPastTheBusyTimeout(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "jobs.sqlite")
+	store, err := NewStorageWithOptions(dbPath, Options{BusyTimeout: time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := store.CreateJob(&BuildJob{ID: "build_locked", ProjectID: "proj", UserID: "user"}); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+
+	other, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open second connection: %v", err)
+	}
+	defer other.Close()
+	conn, err := other.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("failed to open second connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), "BEGIN IMMEDIATE"); err != nil {
+		t.Fatalf("failed to take the write lock: %v", err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = conn.ExecContext(context.Background(), "COMMIT")
+	}()
+
+	if err := store.UpdateJobStatus("build_locked", "claimed"); err != nil {
+		t.Fatalf("expected the write to succeed once the lock was released, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// dialect covers the SQL differences between the backends. Queries are
+// written for SQLite, with ? placeholders and DATETIME/BLOB column types,
+// and the dialect adapts them.
+type dialect interface {
+	name() string
+	// rebind rewrites the ? placeholders of query for the driver.
+	rebind(query string) string
+	// ddl rewrites the column types of a schema statement.
+	ddl(statement string) string
+	// columns lists the columns table has.
+	columns(db *sql.DB, table string) (map[string]struct{}, error)
+	// durationSeconds is the number of seconds from start to end.
+	durationSeconds(start, end string) string
+	// jsonBool is the boolean field of a JSON text column as 0 or 1, 0 when
+	// the field is unset.
+	jsonBool(column, field string) string
+	// insertOrder orders rows created at the same time by insertion.
+	insertOrder(alias string) string
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string { return "sqlite" }
+
+func (sqliteDialect) rebind(query string) string { return query }
+
+func (sqliteDialect) ddl(statement string) string { return statement }
+
+func (sqliteDialect) columns(db *sql.DB, table string) (map[string]struct{}, error) {
+	rows, err := db.Query(`PRAGMA table_info(` + table + `)`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]struct{})
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			columnType string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return nil, err
+		}
+		existing[name] = struct{}{}
+	}
+	return existing, rows.Err()
+}
+
+func (sqliteDialect) durationSeconds(start, end string) string {
+	return `(julianday(` + end + `) - julianday(` + start + `)) * 86400.0`
+}
+
+func (sqliteDialect) jsonBool(column, field string) string {
+	return `COALESCE(json_extract(CAST(` + column + ` AS TEXT), '$.` + field + `'), 0)`
+}
+
+func (sqliteDialect) insertOrder(alias string) string { return alias + `.rowid` }
+
+type postgresDialect struct{}
+
+func (postgresDialect) name() string { return "postgres" }
+
+// rebind numbers the placeholders $1, $2, ... and leaves quoted literals
+// alone.
+func (postgresDialect) rebind(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	inLiteral := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inLiteral = !inLiteral
+		case r == '?' && !inLiteral:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var postgresTypes = strings.NewReplacer(" DATETIME", " TIMESTAMPTZ", " BLOB", " BYTEA")
+
+func (postgresDialect) ddl(statement string) string {
+	return postgresTypes.Replace(statement)
+}
+
+func (postgresDialect) columns(db *sql.DB, table string) (map[string]struct{}, error) {
+	rows, err := db.Query(`SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]struct{})
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		existing[name] = struct{}{}
+	}
+	return existing, rows.Err()
+}
+
+func (postgresDialect) durationSeconds(start, end string) string {
+	return `EXTRACT(EPOCH FROM (` + end + ` - ` + start + `))::float8`
+}
+
+func (postgresDialect) jsonBool(column, field string) string {
+	return `CASE WHEN (` + column + `::jsonb ->> '` + field + `') = 'true' THEN 1 ELSE 0 END`
+}
+
+// Postgres has no rowid; the job id breaks ties instead.
+func (postgresDialect) insertOrder(alias string) string { return alias + `.id` }
+
+// isPostgresDSN reports whether dsn names a Postgres database rather than a
+// SQLite file.
+func isPostgresDSN(dsn string) bool {
+	lower := strings.ToLower(strings.TrimSpace(dsn))
+	return strings.HasPrefix(lower, "postgres://") || strings.HasPrefix(lower, "postgresql://")
+}
+
+func (s *Storage) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.dialect.rebind(query), args...)
+}
+
+func (s *Storage) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.dialect.rebind(query), args...)
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"hubfly-builder/internal/jobstate"
+)
+
+func TestPostgresRebindNumbersPlaceholdersOutsideLiterals(t *testing.T) {
+	got := postgresDialect{}.rebind(`UPDATE build_jobs SET status = ?, last_checkpoint = 'why?' WHERE id = ? AND status = ?`)
+	want := `UPDATE build_jobs SET status = $1, last_checkpoint = 'why?' WHERE id = $2 AND status = $3`
+	if got != want {
+		t.Fatalf("unexpected rebind:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestIsPostgresDSN(t *testing.T) {
+	for dsn, want := range map[string]bool{
+		"postgres://builder@db/jobs":               true,
+		"PostgreSQL://builder@db/jobs?sslmode=off": true,
+		"/var/lib/hubfly-builder/jobs.sqlite":      false,
+		"file:jobs.sqlite":                         false,
+		"":                                         false,
+	} {
+		if got := isPostgresDSN(dsn); got != want {
+			t.Errorf("isPostgresDSN(%q) = %v, want %v", dsn, got, want)
+		}
+	}
+}
+
+// TestPostgresStorage runs against the database in HUBFLY_TEST_POSTGRES_DSN,
+// which it empties.
+func TestPostgresStorage(t *testing.T) {
+	dsn := os.Getenv("HUBFLY_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("HUBFLY_TEST_POSTGRES_DSN is not set")
+	}
+	store, err := NewStorageWithOptions(dsn, Options{})
+	if err != nil {
+		t.Fatalf("failed to open postgres storage: %v", err)
+	}
+	if store.Backend() != "postgres" {
+		t.Fatalf("expected postgres backend, got %q", store.Backend())
+	}
+	if err := store.ResetDatabase(); err != nil {
+		t.Fatalf("failed to reset database: %v", err)
+	}
+
+	job := &BuildJob{ID: "build_pg", ProjectID: "proj", UserID: "user", BuildConfig: BuildConfig{Env: map[string]string{"A": "1"}}}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	loaded, err := store.GetJob("build_pg")
+	if err != nil {
+		t.Fatalf("failed to load job: %v", err)
+	}
+	if loaded.BuildConfig.Env["A"] != "1" {
+		t.Fatalf("build config did not round-trip: %+v", loaded.BuildConfig)
+	}
+	if position, err := store.QueuePosition("build_pg", nil, "", time.Time{}); err != nil || position != 1 {
+		t.Fatalf("expected queue position 1, got %d (%v)", position, err)
+	}
+
+	// Several instances racing for the same pending job: one claim wins.
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		claims int
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := store.TransitionJob("build_pg", jobstate.Pending, jobstate.Claim)
+			if err != nil {
+				t.Errorf("claim failed: %v", err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				claims++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if claims != 1 {
+		t.Fatalf("expected exactly one claim to succeed, got %d", claims)
+	}
+
+	first, err := store.NextBuildNumber("proj")
+	if err != nil {
+		t.Fatalf("failed to allocate build number: %v", err)
+	}
+	if second, _ := store.NextBuildNumber("proj"); second != first+1 {
+		t.Fatalf("expected build number %d, got %d", first+1, second)
+	}
+
+	jobs, total, err := store.ListJobs(JobFilter{ProjectID: "proj"}, 10, 0)
+	if err != nil {
+		t.Fatalf("failed to list jobs: %v", err)
+	}
+	if total != 1 || len(jobs) != 1 || jobs[0].Status != string(jobstate.Claimed) {
+		t.Fatalf("unexpected job list: total=%d jobs=%+v", total, jobs)
+	}
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// DefaultGitUsername is sent with an HTTPS token when the credential names no
+// user. GitHub accepts it for every token type; GitLab accepts any username.
+const DefaultGitUsername = "x-access-token"
+
+// GitCredential authenticates the clone of a private repository with either
+// an HTTPS token or an SSH private key, given inline or as a reference that
+// the builder resolves at clone time. Its secrets are stored in their own
+// column and never marshalled: API responses, callbacks and the stored
+// sourceInfo only show which kind of credential the job carries.
+type GitCredential struct {
+	Username string
+	Token    string
+	SSHKey   string
+	// TokenRef and SSHKeyRef name a token or key in the builder's secret
+	// store, e.g. "vault://github/ci#token", in place of the secret itself.
+	TokenRef  string
+	SSHKeyRef string
+	// KnownHosts pins the SSH host keys of the remote. Without it the host
+	// key is accepted on first use.
+	KnownHosts string
+	// kind is the type recorded in a marshalled credential, which carries no
+	// secrets to derive it from.
+	kind string
+}
+
+type gitCredentialSecrets struct {
+	Username   string `json:"username,omitempty"`
+	Token      string `json:"token,omitempty"`
+	SSHKey     string `json:"sshKey,omitempty"`
+	KnownHosts string `json:"knownHosts,omitempty"`
+	TokenRef   string `json:"tokenRef,omitempty"`
+	SSHKeyRef  string `json:"sshKeyRef,omitempty"`
+}
+
+// Kind is "token", "ssh", or "" for a credential without secrets.
+func (c *GitCredential) Kind() string {
+	switch {
+	case c == nil:
+		return ""
+	case c.Token != "" || c.TokenRef != "":
+		return "token"
+	case c.SSHKey != "" || c.SSHKeyRef != "":
+		return "ssh"
+	}
+	return c.kind
+}
+
+// HasSecret reports whether c carries a token or key, or a reference to one,
+// rather than only the kind recorded in a marshalled job.
+func (c *GitCredential) HasSecret() bool {
+	return c != nil && (c.Token != "" || c.SSHKey != "" || c.HasRef())
+}
+
+// HasRef reports whether c names its secret by reference.
+func (c *GitCredential) HasRef() bool {
+	return c != nil && (c.TokenRef != "" || c.SSHKeyRef != "")
+}
+
+// Validate checks that exactly one of token, sshKey, tokenRef and sshKeyRef
+// is set.
+func (c *GitCredential) Validate() error {
+	set := 0
+	for _, value := range []string{c.Token, c.SSHKey, c.TokenRef, c.SSHKeyRef} {
+		if strings.TrimSpace(value) != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return errors.New("sourceInfo.credential must set only one of token, sshKey, tokenRef and sshKeyRef")
+	}
+	if set == 0 {
+		return errors.New("sourceInfo.credential requires a token, an sshKey, a tokenRef or an sshKeyRef")
+	}
+	return nil
+}
+
+func (c GitCredential) MarshalJSON() ([]byte, error) {
+	redacted := struct {
+		Type     string `json:"type,omitempty"`
+		Username string `json:"username,omitempty"`
+	}{Type: c.Kind()}
+	if redacted.Type == "token" {
+		redacted.Username = c.Username
+	}
+	return json.Marshal(redacted)
+}
+
+func (c *GitCredential) UnmarshalJSON(data []byte) error {
+	var decoded struct {
+		gitCredentialSecrets
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*c = credentialFromSecrets(decoded.gitCredentialSecrets)
+	c.kind = decoded.Type
+	return nil
+}
+
+func credentialFromSecrets(secrets gitCredentialSecrets) GitCredential {
+	return GitCredential{
+		Username:   secrets.Username,
+		Token:      secrets.Token,
+		SSHKey:     secrets.SSHKey,
+		KnownHosts: secrets.KnownHosts,
+		TokenRef:   secrets.TokenRef,
+		SSHKeyRef:  secrets.SSHKeyRef,
+	}
+}
+
+func encodeGitCredential(credential *GitCredential) (string, error) {
+	if !credential.HasSecret() {
+		return "", nil
+	}
+	data, err := json.Marshal(gitCredentialSecrets{
+		Username:   credential.Username,
+		Token:      credential.Token,
+		SSHKey:     credential.SSHKey,
+		KnownHosts: credential.KnownHosts,
+		TokenRef:   credential.TokenRef,
+		SSHKeyRef:  credential.SSHKeyRef,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// GetJobGitCredential returns the credential the job was submitted with, or
+// nil when it has none or the job has finished.
+func (s *Storage) GetJobGitCredential(id string) (*GitCredential, error) {
+	var encoded string
+	if err := s.queryRow(`SELECT COALESCE(git_credential, '') FROM build_jobs WHERE id = ?`, id).Scan(&encoded); err != nil || encoded == "" {
+		return nil, err
+	}
+	var secrets gitCredentialSecrets
+	if err := json.Unmarshal([]byte(encoded), &secrets); err != nil {
+		return nil, err
+	}
+	credential := credentialFromSecrets(secrets)
+	return &credential, nil
+}
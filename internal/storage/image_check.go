@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ImageCheck records the last time a successful job's image tag was looked
+// up in the image store and whether it was gone.
+type ImageCheck struct {
+	Missing   bool      `json:"imageMissing"`
+	CheckedAt time.Time `json:"imageCheckedAt"`
+}
+
+// SetJobImageCheck records the result of looking up the job's image tag.
+func (s *Storage) SetJobImageCheck(id string, check ImageCheck) error {
+	_, err := s.exec(`UPDATE build_jobs SET image_missing = ?, image_checked_at = ?, updated_at = ? WHERE id = ?`, boolToInt(check.Missing), check.CheckedAt.UTC(), time.Now(), id)
+	return err
+}
+
+// GetJobImageCheck returns nil when the job's image has never been checked.
+func (s *Storage) GetJobImageCheck(id string) (*ImageCheck, error) {
+	var missing bool
+	var checkedAt sql.NullTime
+	err := s.queryRow(`SELECT COALESCE(image_missing, 0), image_checked_at FROM build_jobs WHERE id = ?`, id).Scan(&missing, &checkedAt)
+	if err != nil || !checkedAt.Valid {
+		return nil, err
+	}
+	return &ImageCheck{Missing: missing, CheckedAt: checkedAt.Time}, nil
+}
+
+// JobsForImageCheck returns up to limit successful Hubcell-built jobs with an
+// image tag that were last checked before checkedBefore, never-checked jobs
+// first. Buildpacks jobs are left out: their images live elsewhere.
+func (s *Storage) JobsForImageCheck(checkedBefore time.Time, limit int) ([]*BuildJob, error) {
+	rows, err := s.query(`
+		SELECT `+jobColumns+` FROM build_jobs
+		WHERE status = 'success' AND COALESCE(image_tag, '') != ''
+			AND `+s.dialect.jsonBool("build_config", "useBuildpacks")+` = 0
+			AND (image_checked_at IS NULL OR image_checked_at < ?)
+		ORDER BY image_checked_at IS NOT NULL, image_checked_at ASC, created_at ASC
+		LIMIT ?
+	`, checkedBefore.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*BuildJob
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func boolToInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func seedImageJob(t *testing.T, store *Storage, id, status, imageTag string, buildpacks bool) {
+	t.Helper()
+	job := &BuildJob{ID: id, ProjectID: "proj", UserID: "user", BuildConfig: BuildConfig{UseBuildpacks: buildpacks}}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job %s: %v", id, err)
+	}
+	if _, err := store.db.Exec(`UPDATE build_jobs SET status = ?, image_tag = ? WHERE id = ?`, status, imageTag, id); err != nil {
+		t.Fatalf("failed to seed job %s: %v", id, err)
+	}
+}
+
+func TestJobsForImageCheckListsUncheckedSuccessfulHubcellImages(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	seedImageJob(t, store, "build_checked", "success", "img:checked", false)
+	seedImageJob(t, store, "build_new", "success", "img:new", false)
+	seedImageJob(t, store, "build_failed", "failed", "", false)
+	seedImageJob(t, store, "build_pack", "success", "img:pack", true)
+
+	now := time.Now()
+	if err := store.SetJobImageCheck("build_checked", ImageCheck{Missing: true, CheckedAt: now.Add(-time.Hour)}); err != nil {
+		t.Fatalf("SetJobImageCheck returned error: %v", err)
+	}
+
+	jobs, err := store.JobsForImageCheck(now, 10)
+	if err != nil {
+		t.Fatalf("JobsForImageCheck returned error: %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != "build_new" || jobs[1].ID != "build_checked" {
+		t.Fatalf("expected never-checked job first then the stale one, got %v", jobIDs(jobs))
+	}
+
+	jobs, err = store.JobsForImageCheck(now.Add(-2*time.Hour), 10)
+	if err != nil {
+		t.Fatalf("JobsForImageCheck returned error: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != "build_new" {
+		t.Fatalf("expected recently checked jobs to be skipped, got %v", jobIDs(jobs))
+	}
+
+	check, err := store.GetJobImageCheck("build_checked")
+	if err != nil || check == nil || !check.Missing {
+		t.Fatalf("expected a recorded missing image, got %+v, %v", check, err)
+	}
+	if check, err := store.GetJobImageCheck("build_new"); err != nil || check != nil {
+		t.Fatalf("expected no check for an unchecked job, got %+v, %v", check, err)
+	}
+}
+
+func jobIDs(jobs []*BuildJob) []string {
+	ids := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		ids = append(ids, job.ID)
+	}
+	return ids
+}
@@ -0,0 +1,74 @@
+package storage
+
+const (
+	// DefaultJobListLimit is the page size ListJobs uses when none is given.
+	DefaultJobListLimit = 50
+	// MaxJobListLimit caps the page size of ListJobs.
+	MaxJobListLimit = 200
+)
+
+// JobFilter narrows ListJobs. Empty fields match every job.
+type JobFilter struct {
+	Status    string
+	ProjectID string
+	UserID    string
+}
+
+// ClampJobListPage returns the page ListJobs serves for limit and offset: a
+// limit that is not positive uses DefaultJobListLimit, one above
+// MaxJobListLimit is capped, and a negative offset starts from the first job.
+func ClampJobListPage(limit, offset int) (int, int) {
+	if limit <= 0 {
+		limit = DefaultJobListLimit
+	}
+	if limit > MaxJobListLimit {
+		limit = MaxJobListLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+// ListJobs returns a page of the jobs matching filter, newest first, and the
+// number of matching jobs across all pages. limit and offset are clamped with
+// ClampJobListPage.
+func (s *Storage) ListJobs(filter JobFilter, limit, offset int) ([]*BuildJob, int, error) {
+	limit, offset = ClampJobListPage(limit, offset)
+
+	where := `1 = 1`
+	var args []interface{}
+	if filter.Status != "" {
+		where += ` AND status = ?`
+		args = append(args, filter.Status)
+	}
+	if filter.ProjectID != "" {
+		where += ` AND project_id = ?`
+		args = append(args, filter.ProjectID)
+	}
+	if filter.UserID != "" {
+		where += ` AND user_id = ?`
+		args = append(args, filter.UserID)
+	}
+
+	var total int
+	if err := s.queryRow(`SELECT COUNT(*) FROM build_jobs WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.query(`SELECT `+jobColumns+` FROM build_jobs WHERE `+where+` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	jobs := []*BuildJob{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, total, rows.Err()
+}
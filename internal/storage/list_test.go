@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListJobsFiltersAndPagesNewestFirst(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	base := time.Now().Add(-time.Hour)
+	seedStatsJob(t, store, "build_1", "proj", "success", base, time.Second)
+	seedStatsJob(t, store, "build_2", "proj", "failed", base.Add(time.Minute), time.Second)
+	seedStatsJob(t, store, "build_3", "proj", "success", base.Add(2*time.Minute), time.Second)
+	seedStatsJob(t, store, "build_other", "other", "success", base.Add(3*time.Minute), time.Second)
+
+	jobs, total, err := store.ListJobs(JobFilter{ProjectID: "proj"}, 2, 0)
+	if err != nil {
+		t.Fatalf("ListJobs returned error: %v", err)
+	}
+	if total != 3 || len(jobs) != 2 || jobs[0].ID != "build_3" || jobs[1].ID != "build_2" {
+		t.Fatalf("expected the two newest of 3 project jobs, got total %d and %v", total, jobIDs(jobs))
+	}
+
+	jobs, _, err = store.ListJobs(JobFilter{ProjectID: "proj"}, 2, 2)
+	if err != nil || len(jobs) != 1 || jobs[0].ID != "build_1" {
+		t.Fatalf("expected the second page to hold build_1, got %v (err %v)", jobIDs(jobs), err)
+	}
+
+	jobs, total, err = store.ListJobs(JobFilter{Status: "success", UserID: "user"}, 0, -5)
+	if err != nil || total != 3 || len(jobs) != 3 {
+		t.Fatalf("expected 3 successful jobs, got total %d and %v (err %v)", total, jobIDs(jobs), err)
+	}
+
+	jobs, total, err = store.ListJobs(JobFilter{ProjectID: "proj' OR '1'='1"}, 10, 0)
+	if err != nil || total != 0 || len(jobs) != 0 {
+		t.Fatalf("expected no jobs for a quoted project id, got total %d and %v (err %v)", total, jobIDs(jobs), err)
+	}
+}
+
+func TestClampJobListPage(t *testing.T) {
+	for _, tc := range []struct{ limit, offset, wantLimit, wantOffset int }{
+		{10, 20, 10, 20},
+		{0, 0, DefaultJobListLimit, 0},
+		{-1, -1, DefaultJobListLimit, 0},
+		{MaxJobListLimit + 1, 0, MaxJobListLimit, 0},
+	} {
+		limit, offset := ClampJobListPage(tc.limit, tc.offset)
+		if limit != tc.wantLimit || offset != tc.wantOffset {
+			t.Errorf("ClampJobListPage(%d, %d) = %d, %d; want %d, %d", tc.limit, tc.offset, limit, offset, tc.wantLimit, tc.wantOffset)
+		}
+	}
+}
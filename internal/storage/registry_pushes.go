@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// MaxRegistryTargets bounds buildConfig.registries.
+const MaxRegistryTargets = 10
+
+// registryRepositoryPattern matches host[:port]/path without a tag or digest.
+// The host needs a dot or a port, or must be localhost, so that a Docker Hub
+// path such as acme/api is not mistaken for one.
+var registryRepositoryPattern = regexp.MustCompile(`^(?:(?:[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)+|localhost)(?::[0-9]+)?|[A-Za-z0-9-]+:[0-9]+)(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)+$`)
+
+// RegistryTarget is a repository the built image is pushed to, e.g.
+// ghcr.io/acme/api. The image keeps the tag of the job's image tag. A push
+// to a required target that fails fails the job; targets are required unless
+// marked optional.
+type RegistryTarget struct {
+	Repository string `json:"repository"`
+	Optional   bool   `json:"optional,omitempty"`
+}
+
+type RegistryTargets []RegistryTarget
+
+// Validate rejects repositories that do not name a registry host, carry a tag
+// or digest, or are listed twice.
+func (t RegistryTargets) Validate() error {
+	if len(t) > MaxRegistryTargets {
+		return fmt.Errorf("registries lists %d targets; at most %d are allowed", len(t), MaxRegistryTargets)
+	}
+	seen := make(map[string]bool, len(t))
+	for _, target := range t {
+		if !registryRepositoryPattern.MatchString(target.Repository) {
+			return fmt.Errorf("registries entry %q must be a registry host and repository path without a tag, e.g. ghcr.io/acme/api", target.Repository)
+		}
+		if seen[target.Repository] {
+			return fmt.Errorf("registries entry %q is listed twice", target.Repository)
+		}
+		seen[target.Repository] = true
+	}
+	return nil
+}
+
+// RegistryPush is the outcome of pushing the job's image to one of its
+// buildConfig.registries.
+type RegistryPush struct {
+	Repository string `json:"repository"`
+	Image      string `json:"image"`
+	Required   bool   `json:"required"`
+	Success    bool   `json:"success"`
+	// Digest is the manifest digest the registry reported for the push.
+	Digest string `json:"digest,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type RegistryPushes []RegistryPush
+
+// SetJobRegistryPushes replaces the registry push results recorded for the job.
+func (s *Storage) SetJobRegistryPushes(id string, pushes RegistryPushes) error {
+	encoded := ""
+	if len(pushes) > 0 {
+		data, err := json.Marshal(pushes)
+		if err != nil {
+			return err
+		}
+		encoded = string(data)
+	}
+	_, err := s.exec(`UPDATE build_jobs SET registry_pushes = ?, updated_at = ? WHERE id = ?`, encoded, time.Now(), id)
+	return err
+}
+
+// GetJobRegistryPushes returns nil when the job pushed to no registry.
+func (s *Storage) GetJobRegistryPushes(id string) (RegistryPushes, error) {
+	var encoded string
+	if err := s.queryRow(`SELECT COALESCE(registry_pushes, '') FROM build_jobs WHERE id = ?`, id).Scan(&encoded); err != nil || encoded == "" {
+		return nil, err
+	}
+	var pushes RegistryPushes
+	if err := json.Unmarshal([]byte(encoded), &pushes); err != nil {
+		return nil, err
+	}
+	return pushes, nil
+}
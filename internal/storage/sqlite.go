@@ -5,18 +5,67 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"hubfly-builder/internal/jobstate"
+
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Storage struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
+}
+
+// Options are the SQLite connection settings NewStorageWithOptions applies to
+// every pooled connection. Empty strings and a zero BusyTimeout fall back to
+// DefaultOptions. Postgres databases ignore them.
+type Options struct {
+	// JournalMode is a SQLite journal mode such as WAL or DELETE. WAL lets the
+	// HTTP handlers read while the manager writes.
+	JournalMode string
+	// Synchronous is the synchronous level: OFF, NORMAL, FULL or EXTRA.
+	Synchronous string
+	// BusyTimeout is how long a statement waits for a lock held by another
+	// connection before failing with "database is locked".
+	BusyTimeout time.Duration
+	ForeignKeys bool
+}
+
+func DefaultOptions() Options {
+	return Options{
+		JournalMode: "WAL",
+		Synchronous: "NORMAL",
+		BusyTimeout: 5 * time.Second,
+		ForeignKeys: true,
+	}
 }
 
 func NewStorage(dbPath string) (*Storage, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	return NewStorageWithOptions(dbPath, DefaultOptions())
+}
+
+// NewStorageWithOptions opens the job database. A postgres:// or
+// postgresql:// DSN opens a Postgres database, which several builder instances
+// can share; anything else is the path of a SQLite file.
+func NewStorageWithOptions(dbPath string, opts Options) (*Storage, error) {
+	driverName, dsn, d := "postgres", dbPath, dialect(postgresDialect{})
+	if !isPostgresDSN(dbPath) {
+		var err error
+		if dsn, err = sqliteDSN(dbPath, opts); err != nil {
+			return nil, err
+		}
+		driverName, d = "sqlite3", sqliteDialect{}
+	}
+	db, err := sql.Open(driverName, dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -25,15 +74,58 @@ func NewStorage(dbPath string) (*Storage, error) {
 		return nil, err
 	}
 
-	if err := createTables(db); err != nil {
+	if err := createTables(db, d); err != nil {
+		return nil, err
+	}
+	if err := migrateTables(db, d); err != nil {
 		return nil, err
 	}
 
-	return &Storage{db: db}, nil
+	return &Storage{db: db, dialect: d}, nil
 }
 
-func createTables(db *sql.DB) error {
-	_, err := db.Exec(`
+// Backend names the database the jobs are stored in: "sqlite" or "postgres".
+func (s *Storage) Backend() string {
+	return s.dialect.name()
+}
+
+// sqliteDSN carries opts as go-sqlite3 connection parameters, which the
+// driver applies as PRAGMAs on each new connection of the pool.
+func sqliteDSN(dbPath string, opts Options) (string, error) {
+	defaults := DefaultOptions()
+	journalMode := strings.ToUpper(strings.TrimSpace(opts.JournalMode))
+	if journalMode == "" {
+		journalMode = defaults.JournalMode
+	}
+	switch journalMode {
+	case "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF":
+	default:
+		return "", fmt.Errorf("unsupported sqlite journal mode %q", opts.JournalMode)
+	}
+	synchronous := strings.ToUpper(strings.TrimSpace(opts.Synchronous))
+	if synchronous == "" {
+		synchronous = defaults.Synchronous
+	}
+	switch synchronous {
+	case "OFF", "NORMAL", "FULL", "EXTRA":
+	default:
+		return "", fmt.Errorf("unsupported sqlite synchronous level %q", opts.Synchronous)
+	}
+	busyTimeout := opts.BusyTimeout
+	if busyTimeout <= 0 {
+		busyTimeout = defaults.BusyTimeout
+	}
+
+	params := url.Values{}
+	params.Set("_journal_mode", journalMode)
+	params.Set("_synchronous", synchronous)
+	params.Set("_busy_timeout", strconv.FormatInt(busyTimeout.Milliseconds(), 10))
+	params.Set("_foreign_keys", strconv.FormatBool(opts.ForeignKeys))
+	return dbPath + "?" + params.Encode(), nil
+}
+
+func createTables(db *sql.DB, d dialect) error {
+	_, err := db.Exec(d.ddl(`
 		CREATE TABLE IF NOT EXISTS build_jobs (
 			id TEXT PRIMARY KEY,
 			project_id TEXT,
@@ -52,19 +144,102 @@ func createTables(db *sql.DB) error {
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME
 		)
+	`))
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_build_jobs_project_created ON build_jobs (project_id, created_at)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_build_jobs_user_status ON build_jobs (user_id, status)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(d.ddl(`
+		CREATE TABLE IF NOT EXISTS project_affinity (
+			project_id TEXT PRIMARY KEY,
+			instance_id TEXT NOT NULL,
+			updated_at DATETIME
+		)
+	`))
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS project_build_numbers (
+			project_id TEXT PRIMARY KEY,
+			last_number INTEGER NOT NULL
+		)
 	`)
 	return err
 }
 
+// jobColumnMigrations lists columns added after the original schema. They are
+// appended with ALTER TABLE so existing databases keep working.
+var jobColumnMigrations = []struct {
+	name       string
+	definition string
+}{
+	{name: "callback_url", definition: "TEXT DEFAULT ''"},
+	{name: "provenance", definition: "BLOB"},
+	{name: "context_hash", definition: "TEXT DEFAULT ''"},
+	{name: "debug_workspace", definition: "TEXT DEFAULT ''"},
+	{name: "debug_command", definition: "TEXT DEFAULT ''"},
+	{name: "debug_expires_at", definition: "DATETIME"},
+	{name: "image_missing", definition: "INTEGER DEFAULT 0"},
+	{name: "image_checked_at", definition: "DATETIME"},
+	{name: "build_warnings", definition: "TEXT DEFAULT ''"},
+	{name: "git_credential", definition: "TEXT DEFAULT ''"},
+	{name: "next_attempt_at", definition: "DATETIME"},
+	{name: "build_number", definition: "INTEGER DEFAULT 0"},
+	{name: "registry_pushes", definition: "TEXT DEFAULT ''"},
+	{name: "build_cache_stats", definition: "TEXT DEFAULT ''"},
+	{name: "claimed_by", definition: "TEXT DEFAULT ''"},
+	{name: "lease_expires_at", definition: "DATETIME"},
+}
+
+func migrateTables(db *sql.DB, d dialect) error {
+	existing, err := d.columns(db, "build_jobs")
+	if err != nil {
+		return err
+	}
+
+	for _, column := range jobColumnMigrations {
+		if _, ok := existing[column.name]; ok {
+			continue
+		}
+		if _, err := db.Exec(d.ddl(`ALTER TABLE build_jobs ADD COLUMN ` + column.name + ` ` + column.definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type SourceInfo struct {
 	GitRepository string `json:"gitRepository"`
 	CommitSha     string `json:"commitSha"`
 	Ref           string `json:"ref"`
 	WorkingDir    string `json:"workingDir"` // Subdirectory within the repo
+	// ChangedFiles lists the repository paths touched by the triggering push,
+	// when the caller knows them. It is compared against buildConfig.watchPaths.
+	ChangedFiles []string `json:"changedFiles,omitempty"`
+	// CloneDepth limits the clone to that many commits. Zero clones one
+	// commit when a ref or commit is given and the full history otherwise;
+	// a negative value always clones the full history.
+	CloneDepth int `json:"cloneDepth,omitempty"`
+	// SingleBranch limits the clone to the history of Ref. Unset, it is on
+	// whenever Ref is a branch or tag name; false fetches every branch even
+	// for a shallow clone.
+	SingleBranch *bool `json:"singleBranch,omitempty"`
+	// Credential authenticates the clone of a private repository. Only its
+	// kind is ever marshalled; see GitCredential.
+	Credential *GitCredential `json:"credential,omitempty"`
 }
 
 func (a *SourceInfo) Value() (driver.Value, error) {
-	return json.Marshal(a)
+	data, err := json.Marshal(a)
+	return string(data), err
 }
 
 func (a *SourceInfo) Scan(value interface{}) error {
@@ -79,11 +254,130 @@ func (a *SourceInfo) Scan(value interface{}) error {
 	return json.Unmarshal(b, &a)
 }
 
+// MinBuildMemoryMB is the smallest memory limit a build may ask for. Below it
+// BuildKit is killed before the first build step runs.
+const MinBuildMemoryMB = 512
+
+// ResourceLimits bounds the CPU and memory of a job's image build. A zero
+// field keeps the builder's default for it.
 type ResourceLimits struct {
 	CPU      float64 `json:"cpu"`
 	MemoryMB int     `json:"memoryMB"`
 }
 
+// Validate rejects negative limits and memory limits too small to build with.
+func (l ResourceLimits) Validate() error {
+	if l.CPU < 0 {
+		return fmt.Errorf("resourceLimits.cpu must not be negative, got %v", l.CPU)
+	}
+	if l.MemoryMB < 0 {
+		return fmt.Errorf("resourceLimits.memoryMB must not be negative, got %d", l.MemoryMB)
+	}
+	if l.MemoryMB > 0 && l.MemoryMB < MinBuildMemoryMB {
+		return fmt.Errorf("resourceLimits.memoryMB %d is below the %d MB a build needs", l.MemoryMB, MinBuildMemoryMB)
+	}
+	return nil
+}
+
+// maxContainerID is the largest UID or GID a container user may have.
+const maxContainerID = 1<<31 - 1
+
+// ContainerUser is the numeric user and group a generated image runs as. The
+// GID defaults to 0, the group OpenShift runs arbitrary UIDs in.
+type ContainerUser struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+// Validate rejects root and IDs outside the range containers support.
+func (u *ContainerUser) Validate() error {
+	if u == nil {
+		return nil
+	}
+	if u.UID <= 0 || u.UID > maxContainerID {
+		return fmt.Errorf("runAs.uid must be between 1 and %d, got %d", maxContainerID, u.UID)
+	}
+	if u.GID < 0 || u.GID > maxContainerID {
+		return fmt.Errorf("runAs.gid must be between 0 and %d, got %d", maxContainerID, u.GID)
+	}
+	return nil
+}
+
+// extraHostNamePattern matches a hostname made of RFC 1123 labels.
+var extraHostNamePattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?(?:\.[A-Za-z0-9](?:[A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+
+// ExtraHosts are "host:ip" entries added to /etc/hosts of the image build, so
+// build steps can reach internal hosts that DNS does not resolve.
+type ExtraHosts []string
+
+// Validate rejects entries that are not a hostname and an IP address joined by
+// a colon. IPv6 addresses follow the first colon, e.g. "mirror:fd00::10".
+func (h ExtraHosts) Validate() error {
+	for _, entry := range h {
+		host, ip, ok := strings.Cut(entry, ":")
+		if !ok || len(host) > 253 || !extraHostNamePattern.MatchString(host) || net.ParseIP(ip) == nil {
+			return fmt.Errorf("extraHosts entry %q must be host:ip", entry)
+		}
+	}
+	return nil
+}
+
+// maxDNSServers is how many resolvers a build may name; resolv.conf ignores
+// any past the third.
+const maxDNSServers = 3
+
+// DNSServers are the resolvers of the image build, so build steps behind
+// split-horizon DNS resolve internal hosts.
+type DNSServers []string
+
+// Validate rejects entries that are not IPv4 or IPv6 addresses.
+func (d DNSServers) Validate() error {
+	if len(d) > maxDNSServers {
+		return fmt.Errorf("dnsServers allows at most %d servers, got %d", maxDNSServers, len(d))
+	}
+	for _, server := range d {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("dnsServers entry %q must be an IP address", server)
+		}
+	}
+	return nil
+}
+
+// buildPlatforms are the os/arch[/variant] targets a build may request.
+var buildPlatforms = map[string]bool{
+	"linux/amd64":    true,
+	"linux/amd64/v2": true,
+	"linux/amd64/v3": true,
+	"linux/amd64/v4": true,
+	"linux/arm64":    true,
+	"linux/arm64/v8": true,
+	"linux/arm/v6":   true,
+	"linux/arm/v7":   true,
+	"linux/386":      true,
+	"linux/ppc64le":  true,
+	"linux/s390x":    true,
+	"linux/riscv64":  true,
+}
+
+// Platforms are the targets of a multi-platform image build, e.g.
+// "linux/amd64" and "linux/arm64". Empty builds for the host platform.
+type Platforms []string
+
+// Validate rejects unknown platforms and duplicates.
+func (p Platforms) Validate() error {
+	seen := make(map[string]bool, len(p))
+	for _, entry := range p {
+		if !buildPlatforms[entry] {
+			return fmt.Errorf("platforms entry %q is not a supported linux/<arch>[/<variant>] platform", entry)
+		}
+		if seen[entry] {
+			return fmt.Errorf("platforms entry %q is listed twice", entry)
+		}
+		seen[entry] = true
+	}
+	return nil
+}
+
 type EnvOverride struct {
 	Scope  string `json:"scope,omitempty"`  // build, runtime, both
 	Secret *bool  `json:"secret,omitempty"` // nil means auto-detect
@@ -112,6 +406,9 @@ type BuildConfig struct {
 	BuildContextDir    string                 `json:"buildContextDir,omitempty"`
 	AppDir             string                 `json:"appDir,omitempty"`
 	ValidationWarnings []string               `json:"validationWarnings,omitempty"`
+	BuildTool          string                 `json:"buildTool,omitempty"`
+	Confidence         float64                `json:"confidence,omitempty"`
+	Signals            []string               `json:"signals,omitempty"`
 	Network            string                 `json:"network,omitempty"`
 	TimeoutSeconds     int                    `json:"timeoutSeconds"`
 	ResourceLimits     ResourceLimits         `json:"resourceLimits"`
@@ -122,10 +419,69 @@ type BuildConfig struct {
 	DockerfileEnv      map[string]string      `json:"dockerfileEnv,omitempty"`
 	CustomDockerfile   string                 `json:"customDockerfile,omitempty"`
 	DockerfileContent  []byte                 `json:"dockerfileContent,omitempty"`
+	UseBuildpacks      bool                   `json:"useBuildpacks,omitempty"`
+	Provenance         bool                   `json:"provenance,omitempty"`
+	Debug              bool                   `json:"debug,omitempty"`
+	WatchPaths         []string               `json:"watchPaths,omitempty"`
+	BuildInfo          *BuildInfoFile         `json:"buildInfo,omitempty"`
+	MovingTag          *MovingTag             `json:"movingTag,omitempty"`
+	Profile            string                 `json:"profile,omitempty"`
+	BuildOpts          map[string]string      `json:"buildOpts,omitempty"`
+	BaseImage          string                 `json:"baseImage,omitempty"`
+	ExtraHosts         ExtraHosts             `json:"extraHosts,omitempty"`
+	Platforms          Platforms              `json:"platforms,omitempty"`
+	// DisableCache builds without the registry build cache, for builds that
+	// must not reuse layers.
+	DisableCache bool `json:"disableCache,omitempty"`
+	// The command overrides replace single detected commands of an
+	// auto-build; empty fields keep the detected command.
+	PrebuildCommandOverride string `json:"prebuildCommandOverride,omitempty"`
+	BuildCommandOverride    string `json:"buildCommandOverride,omitempty"`
+	RunCommandOverride      string `json:"runCommandOverride,omitempty"`
+	// DockerfilePath names the repository Dockerfile to build, such as
+	// Dockerfile.prod or deploy/Dockerfile. It is looked up in the working
+	// directory first and then in the repository root. Empty means
+	// "Dockerfile".
+	DockerfilePath string `json:"dockerfilePath,omitempty"`
+	// UsesRepoDockerfile is set once the build is known to use a Dockerfile
+	// from the repository, which the builder must not replace with a
+	// generated one.
+	UsesRepoDockerfile bool `json:"usesRepoDockerfile,omitempty"`
+	// RefTag also tags the image with the job's branch or tag name, e.g.
+	// project:feature-x for feature/x, so deployers can follow a branch.
+	RefTag bool `json:"refTag,omitempty"`
+	// Registries are further repositories the built image is pushed to.
+	Registries RegistryTargets `json:"registries,omitempty"`
+	// RunAs makes generated Dockerfiles run the app as this user.
+	RunAs *ContainerUser `json:"runAs,omitempty"`
+	// Shell runs the steps of generated Dockerfiles: "sh" or "bash".
+	Shell string `json:"shell,omitempty"`
+	// RuntimeOnly lists env keys that never reach the build, whatever their
+	// detected scope or envOverrides say.
+	RuntimeOnly []string `json:"runtimeOnly,omitempty"`
+	// DNSServers replace the builder's BUILD_DNS_SERVERS for this job.
+	DNSServers DNSServers `json:"dnsServers,omitempty"`
+}
+
+// MovingTag asks the builder to also point a mutable tag of the project's
+// image repository (default "latest") at each successful build, next to the
+// immutable per-build tag.
+type MovingTag struct {
+	Name string `json:"name,omitempty"`
+}
+
+// BuildInfoFile asks the builder to write commit, ref, build time and image tag
+// into the build context so the image can report its own build. Format is
+// "json" (default, written to version.json) or "env" (KEY=value lines,
+// written to BUILD_INFO). Path is relative to the build context.
+type BuildInfoFile struct {
+	Path   string `json:"path,omitempty"`
+	Format string `json:"format,omitempty"`
 }
 
 func (a *BuildConfig) Value() (driver.Value, error) {
-	return json.Marshal(a)
+	data, err := json.Marshal(a)
+	return string(data), err
 }
 
 func (a *BuildConfig) Scan(value interface{}) error {
@@ -160,6 +516,28 @@ func (a BuildConfig) CustomDockerfileBytes() []byte {
 	return []byte(a.CustomDockerfile)
 }
 
+// DockerfileName is the repository Dockerfile the build looks for.
+func (a BuildConfig) DockerfileName() string {
+	if name := strings.TrimSpace(a.DockerfilePath); name != "" {
+		return filepath.Clean(name)
+	}
+	return "Dockerfile"
+}
+
+// ValidateDockerfilePath checks that dockerfilePath is a relative path that
+// stays inside the repository.
+func (a BuildConfig) ValidateDockerfilePath() error {
+	name := strings.TrimSpace(a.DockerfilePath)
+	if name == "" {
+		return nil
+	}
+	cleaned := filepath.Clean(name)
+	if filepath.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) || strings.ContainsAny(cleaned, "\r\n") {
+		return fmt.Errorf("dockerfilePath %q must be a file path inside the repository", a.DockerfilePath)
+	}
+	return nil
+}
+
 type BuildJob struct {
 	ID             string            `json:"id"`
 	ProjectID      string            `json:"projectId"`
@@ -176,120 +554,511 @@ type BuildJob struct {
 	RetryCount     int               `json:"retryCount"`
 	LogPath        string            `json:"logPath"`
 	LastCheckpoint string            `json:"lastCheckpoint"`
+	CallbackURL    string            `json:"callbackUrl,omitempty"`
 	CreatedAt      time.Time         `json:"createdAt"`
 	UpdatedAt      time.Time         `json:"updatedAt"`
+	// BuildCache is not a job column: the worker sets it for the callback and
+	// GetJobBuildCacheStats loads it, while GetJob leaves it nil.
+	BuildCache *BuildCacheStats `json:"buildCache,omitempty"`
 }
 
 func (s *Storage) CreateJob(job *BuildJob) error {
+	return s.insertJob(job, jobstate.Pending)
+}
+
+// CreateSkippedJob records a job that will never run, with status skipped
+// from the start so a dispatcher cannot claim it in between.
+func (s *Storage) CreateSkippedJob(job *BuildJob) error {
+	return s.insertJob(job, jobstate.Skipped)
+}
+
+func (s *Storage) insertJob(job *BuildJob, status jobstate.Status) error {
 	job.BuildConfig.NormalizePhaseAliases()
 	job.CreatedAt = time.Now()
 	job.UpdatedAt = time.Now()
-	job.Status = "pending"
+	job.Status = string(status)
+	// A job that starts out finished never clones, so its credential is not
+	// stored.
+	var credential string
+	var err error
+	if jobstate.IsTerminal(status) {
+		job.FinishedAt = sql.NullTime{Time: job.CreatedAt, Valid: true}
+	} else if credential, err = encodeGitCredential(job.SourceInfo.Credential); err != nil {
+		return err
+	}
 
-	_, err := s.db.Exec(`
-		INSERT INTO build_jobs (id, project_id, user_id, source_type, source_info, build_config, status, image_tag, started_at, finished_at, exit_code, retry_count, log_path, last_checkpoint, created_at, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, job.ID, job.ProjectID, job.UserID, job.SourceType, &job.SourceInfo, &job.BuildConfig, job.Status, job.ImageTag, job.StartedAt, job.FinishedAt, job.ExitCode, job.RetryCount, job.LogPath, job.LastCheckpoint, job.CreatedAt, job.UpdatedAt)
+	_, err = s.exec(`
+		INSERT INTO build_jobs (`+jobColumns+`, git_credential)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, job.ID, job.ProjectID, job.UserID, job.SourceType, &job.SourceInfo, &job.BuildConfig, job.Status, job.ImageTag, job.StartedAt, job.FinishedAt, job.ExitCode, job.RetryCount, job.LogPath, job.LastCheckpoint, job.CallbackURL, job.CreatedAt, job.UpdatedAt, credential)
 
 	return err
 }
 
-func (s *Storage) GetJob(id string) (*BuildJob, error) {
-	job := &BuildJob{}
+const jobColumns = `id, project_id, user_id, source_type, source_info, build_config, status, image_tag, started_at, finished_at, exit_code, retry_count, log_path, last_checkpoint, callback_url, created_at, updated_at`
 
-	err := s.db.QueryRow(`
-		SELECT id, project_id, user_id, source_type, source_info, build_config, status, image_tag, started_at, finished_at, exit_code, retry_count, log_path, last_checkpoint, created_at, updated_at
-		FROM build_jobs WHERE id = ?
-	`, id).Scan(&job.ID, &job.ProjectID, &job.UserID, &job.SourceType, &job.SourceInfo, &job.BuildConfig, &job.Status, &job.ImageTag, &job.StartedAt, &job.FinishedAt, &job.ExitCode, &job.RetryCount, &job.LogPath, &job.LastCheckpoint, &job.CreatedAt, &job.UpdatedAt)
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(row rowScanner) (*BuildJob, error) {
+	job := &BuildJob{}
+	var callbackURL sql.NullString
+	err := row.Scan(&job.ID, &job.ProjectID, &job.UserID, &job.SourceType, &job.SourceInfo, &job.BuildConfig, &job.Status, &job.ImageTag, &job.StartedAt, &job.FinishedAt, &job.ExitCode, &job.RetryCount, &job.LogPath, &job.LastCheckpoint, &callbackURL, &job.CreatedAt, &job.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
+	job.CallbackURL = callbackURL.String
 	job.Env = cloneStringMap(job.BuildConfig.Env)
-
 	return job, nil
 }
 
+func (s *Storage) GetJob(id string) (*BuildJob, error) {
+	return scanJob(s.queryRow(`
+		SELECT `+jobColumns+`
+		FROM build_jobs WHERE id = ?
+	`, id))
+}
+
 func (s *Storage) GetPendingJob() (*BuildJob, error) {
-	job := &BuildJob{}
+	return scanJob(s.queryRow(`
+		SELECT `+jobColumns+`
+		FROM build_jobs
+		WHERE status = 'pending' AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+		ORDER BY created_at ASC LIMIT 1
+	`, time.Now()))
+}
+
+// ErrNoPendingJob is returned by ClaimNextPendingJob when there is no job to
+// claim.
+var ErrNoPendingJob = errors.New("no pending job")
+
+// ClaimNextPendingJob moves the next job to dispatch to claimed and returns
+// it, in a single UPDATE so that of several dispatchers racing for a job
+// exactly one gets it. Jobs of users in excludeUserIDs are skipped. Jobs
+// whose project was last built on another instance are left for that
+// instance until they were created before overdueBefore; overdue jobs are
+// then claimed first, in FIFO order, so affinity never starves a project.
+// After them come jobs of projects last built on instanceID. An empty
+// instanceID disables affinity.
+// The claim records lease; see ClaimLease.
+// It returns ErrNoPendingJob when no job is ready, including when a
+// concurrent claim took the job first.
+func (s *Storage) ClaimNextPendingJob(excludeUserIDs []string, instanceID string, overdueBefore time.Time, lease ClaimLease) (*BuildJob, error) {
+	selection, args := s.pendingJobQuery("id", excludeUserIDs, instanceID, overdueBefore)
+	query := `UPDATE build_jobs SET status = ?, claimed_by = ?, lease_expires_at = ?, updated_at = ?
+		WHERE status = 'pending' AND id = (` + selection + `)
+		RETURNING ` + jobColumns
+	args = append([]interface{}{string(jobstate.Claimed), lease.Owner, lease.expiresAt(), time.Now()}, args...)
 
-	err := s.db.QueryRow(`
-		SELECT id, project_id, user_id, source_type, source_info, build_config, status, image_tag, started_at, finished_at, exit_code, retry_count, log_path, last_checkpoint, created_at, updated_at
-		FROM build_jobs WHERE status = 'pending' ORDER BY created_at ASC LIMIT 1
-	`).Scan(&job.ID, &job.ProjectID, &job.UserID, &job.SourceType, &job.SourceInfo, &job.BuildConfig, &job.Status, &job.ImageTag, &job.StartedAt, &job.FinishedAt, &job.ExitCode, &job.RetryCount, &job.LogPath, &job.LastCheckpoint, &job.CreatedAt, &job.UpdatedAt)
+	var job *BuildJob
+	err := retryBusy(func() error {
+		var err error
+		job, err = scanJob(s.queryRow(query, args...))
+		return err
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoPendingJob
+	}
+	return job, err
+}
+
+// ClaimLease names the instance claiming a job and the time its claim lapses
+// unless renewed with RenewClaimLease. Jobs whose lease has lapsed are
+// requeued by RequeueExpiredClaims, so the jobs of an instance that stopped
+// are not left in progress in a database other instances share. The zero
+// lease never lapses.
+type ClaimLease struct {
+	Owner     string
+	ExpiresAt time.Time
+}
+
+func (l ClaimLease) expiresAt() interface{} {
+	if l.ExpiresAt.IsZero() {
+		return nil
+	}
+	return l.ExpiresAt
+}
+
+// RenewClaimLease extends the lease of an in-progress job owner claimed to
+// expiresAt. It reports false when the job is no longer in progress under
+// owner's claim, e.g. because the lease lapsed and the job was requeued.
+func (s *Storage) RenewClaimLease(id, owner string, expiresAt time.Time) (bool, error) {
+	result, err := s.exec(`
+		UPDATE build_jobs SET lease_expires_at = ?
+		WHERE id = ? AND claimed_by = ? AND status IN ('claimed', 'building', 'cancelling')
+	`, expiresAt, id, owner)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	job.Env = cloneStringMap(job.BuildConfig.Env)
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
 
-	return job, nil
+// RequeueExpiredClaims puts claimed and building jobs whose lease lapsed
+// before now back on the queue, and cancels cancelling ones, as
+// ResetInProgressJobs does on startup. It returns how many jobs it moved.
+func (s *Storage) RequeueExpiredClaims(now time.Time) (int64, error) {
+	result, err := s.exec(`
+		UPDATE build_jobs
+		SET status = 'pending', claimed_by = '', lease_expires_at = NULL, started_at = NULL, updated_at = ?
+		WHERE status IN ('claimed', 'building') AND lease_expires_at < ?
+	`, now, now)
+	if err != nil {
+		return 0, err
+	}
+	requeued, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	result, err = s.exec(`
+		UPDATE build_jobs
+		SET status = 'canceled', lease_expires_at = NULL, git_credential = '', updated_at = ?, finished_at = ?
+		WHERE status = 'cancelling' AND lease_expires_at < ?
+	`, now, now, now)
+	if err != nil {
+		return requeued, err
+	}
+	canceled, err := result.RowsAffected()
+	return requeued + canceled, err
 }
 
-func (s *Storage) GetPendingJobExcludingUsers(excludeUserIDs []string) (*BuildJob, error) {
-	job := &BuildJob{}
+// pendingJobQuery selects columns of the next job to dispatch: the first job
+// in pendingJobOrder that passes pendingJobFilter.
+func (s *Storage) pendingJobQuery(columns string, excludeUserIDs []string, instanceID string, overdueBefore time.Time) (string, []interface{}) {
+	filter, args := pendingJobFilter(excludeUserIDs, instanceID, overdueBefore)
+	order, orderArgs := s.pendingJobOrder(instanceID, overdueBefore)
+	query := `SELECT ` + columns + ` FROM build_jobs WHERE ` + filter + ` ORDER BY ` + order + ` LIMIT 1`
+	return query, append(args, orderArgs...)
+}
 
-	baseQuery := `
-		SELECT id, project_id, user_id, source_type, source_info, build_config, status, image_tag, started_at, finished_at, exit_code, retry_count, log_path, last_checkpoint, created_at, updated_at
-		FROM build_jobs
-		WHERE status = 'pending' AND TRIM(IFNULL(user_id, '')) != ''
-	`
-	var args []interface{}
+// pendingJobFilter matches the jobs ready to dispatch: pending jobs past
+// their retry backoff, of users not in excludeUserIDs and without a build in
+// progress on any instance sharing the database. A build whose claim has
+// lapsed no longer counts as in progress. When instanceID is set, jobs whose
+// project was last built on another instance are left to it until they were
+// created before overdueBefore.
+func pendingJobFilter(excludeUserIDs []string, instanceID string, overdueBefore time.Time) (string, []interface{}) {
+	now := time.Now()
+	filter := `status = 'pending'
+		AND (next_attempt_at IS NULL OR next_attempt_at <= ?)
+		AND NOT EXISTS (
+			SELECT 1 FROM build_jobs AS running
+			WHERE running.user_id = build_jobs.user_id
+				AND running.status IN ('claimed', 'building', 'cancelling')
+				AND (running.lease_expires_at IS NULL OR running.lease_expires_at > ?)
+		)`
+	args := []interface{}{now, now}
 
 	if len(excludeUserIDs) > 0 {
-		baseQuery += " AND user_id NOT IN ("
+		filter += " AND user_id NOT IN ("
 		for i, id := range excludeUserIDs {
 			if i > 0 {
-				baseQuery += ", "
+				filter += ", "
 			}
-			baseQuery += "?"
+			filter += "?"
 			args = append(args, id)
 		}
-		baseQuery += ")"
+		filter += ")"
 	}
 
-	baseQuery += " ORDER BY created_at ASC LIMIT 1"
+	if instanceID == "" {
+		return filter, args
+	}
+	filter += `
+		AND (
+			created_at <= ?
+			OR NOT EXISTS (
+				SELECT 1 FROM project_affinity AS a
+				WHERE a.project_id = build_jobs.project_id AND a.instance_id != ?
+			)
+		)`
+	return filter, append(args, overdueBefore, instanceID)
+}
 
-	err := s.db.QueryRow(baseQuery, args...).Scan(&job.ID, &job.ProjectID, &job.UserID, &job.SourceType, &job.SourceInfo, &job.BuildConfig, &job.Status, &job.ImageTag, &job.StartedAt, &job.FinishedAt, &job.ExitCode, &job.RetryCount, &job.LogPath, &job.LastCheckpoint, &job.CreatedAt, &job.UpdatedAt)
-	if err != nil {
-		return nil, err
+// pendingJobOrder is the dispatch order: oldest first, with rows created at
+// the same time in insertion order. When instanceID is set, overdue jobs come
+// first and then jobs of projects last built on instanceID.
+func (s *Storage) pendingJobOrder(instanceID string, overdueBefore time.Time) (string, []interface{}) {
+	fifo := `created_at ASC, ` + s.dialect.insertOrder("build_jobs") + ` ASC`
+	if instanceID == "" {
+		return fifo, nil
 	}
-	job.Env = cloneStringMap(job.BuildConfig.Env)
+	order := `
+			created_at <= ? DESC,
+			(created_at > ? AND EXISTS (
+				SELECT 1 FROM project_affinity AS a
+				WHERE a.project_id = build_jobs.project_id AND a.instance_id = ?
+			)) DESC,
+			` + fifo
+	return order, []interface{}{overdueBefore, overdueBefore, instanceID}
+}
 
-	return job, nil
+// RecordProjectBuildInstance remembers which instance last built a project.
+func (s *Storage) RecordProjectBuildInstance(projectID, instanceID string) error {
+	_, err := s.exec(`
+		INSERT INTO project_affinity (project_id, instance_id, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(project_id) DO UPDATE SET instance_id = excluded.instance_id, updated_at = excluded.updated_at
+	`, projectID, instanceID, time.Now())
+	return err
+}
+
+// QueuePosition returns the 1-based position of a job in dispatch order,
+// given the same user exclusions and affinity as ClaimNextPendingJob. It
+// returns 0 when the job is not ready to dispatch: not pending, waiting out a
+// retry backoff, or held back by those filters.
+func (s *Storage) QueuePosition(id string, excludeUserIDs []string, instanceID string, overdueBefore time.Time) (int, error) {
+	order, args := s.pendingJobOrder(instanceID, overdueBefore)
+	filter, filterArgs := pendingJobFilter(excludeUserIDs, instanceID, overdueBefore)
+	args = append(append(args, filterArgs...), id)
+
+	var position int
+	err := s.queryRow(`
+		SELECT position FROM (
+			SELECT id, ROW_NUMBER() OVER (ORDER BY `+order+`) AS position
+			FROM build_jobs WHERE `+filter+`
+		) AS queue
+		WHERE id = ?
+	`, args...).Scan(&position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return position, err
 }
 
 func (s *Storage) UpdateJobStatus(id, status string) error {
-	_, err := s.db.Exec(`UPDATE build_jobs SET status = ?, updated_at = ? WHERE id = ?`, status, time.Now(), id)
+	_, err := s.exec(`UPDATE build_jobs SET status = ?, updated_at = ? WHERE id = ?`, status, time.Now(), id)
 	return err
 }
 
+// TransitionJob applies event to the job while it is still in current. The
+// next status comes from the jobstate machine, so an illegal move returns an
+// error wrapping jobstate.ErrIllegalTransition without touching the row.
+func (s *Storage) TransitionJob(id string, current jobstate.Status, event jobstate.Event) (bool, error) {
+	return s.transitionJob(id, current, event, nil)
+}
+
+// TransitionClaimedJob is TransitionJob for the worker building the job: it
+// applies only while owner still holds the job's claim, so a worker whose
+// lease lapsed cannot finish a job another instance has claimed since.
+func (s *Storage) TransitionClaimedJob(id, owner string, current jobstate.Status, event jobstate.Event) (bool, error) {
+	return s.transitionJob(id, current, event, &owner)
+}
+
+func (s *Storage) transitionJob(id string, current jobstate.Status, event jobstate.Event, owner *string) (bool, error) {
+	next, err := jobstate.Transition(current, event)
+	if err != nil {
+		return false, err
+	}
+
+	// started_at and finished_at bracket the build so durations can be
+	// reported; a job back in the queue starts over. A job that can no longer
+	// run again no longer needs its git credential; a failed one keeps it
+	// until the manager decides against a retry.
+	now := time.Now()
+	timestamps := ""
+	args := []interface{}{string(next), now}
+	switch {
+	case next == jobstate.Building:
+		timestamps = `, started_at = ?`
+		args = append(args, now)
+	case next == jobstate.Pending:
+		timestamps = `, started_at = NULL, finished_at = NULL`
+	case next == jobstate.Failed:
+		timestamps = `, finished_at = ?`
+		args = append(args, now)
+	case jobstate.IsTerminal(next):
+		timestamps = `, finished_at = ?, git_credential = ''`
+		args = append(args, now)
+	}
+	args = append(args, id, string(current))
+	condition := ""
+	if owner != nil {
+		condition = ` AND COALESCE(claimed_by, '') = ?`
+		args = append(args, *owner)
+	}
+
+	result, err := s.exec(`UPDATE build_jobs SET status = ?, updated_at = ?`+timestamps+` WHERE id = ? AND status = ?`+condition, args...)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
 func (s *Storage) UpdateJobLogPath(id, logPath string) error {
-	_, err := s.db.Exec(`UPDATE build_jobs SET log_path = ?, updated_at = ? WHERE id = ?`, logPath, time.Now(), id)
+	_, err := s.exec(`UPDATE build_jobs SET log_path = ?, updated_at = ? WHERE id = ?`, logPath, time.Now(), id)
 	return err
 }
 
 func (s *Storage) UpdateJobImageTag(id, imageTag string) error {
-	_, err := s.db.Exec(`UPDATE build_jobs SET image_tag = ?, updated_at = ? WHERE id = ?`, imageTag, time.Now(), id)
+	_, err := s.exec(`UPDATE build_jobs SET image_tag = ?, updated_at = ? WHERE id = ?`, imageTag, time.Now(), id)
+	return err
+}
+
+// UpdateJobCheckpoint records the last build phase the job completed, which a
+// resumed build continues after. An empty checkpoint starts it over.
+func (s *Storage) UpdateJobCheckpoint(id, checkpoint string) error {
+	_, err := s.exec(`UPDATE build_jobs SET last_checkpoint = ?, updated_at = ? WHERE id = ?`, checkpoint, time.Now(), id)
+	return err
+}
+
+func (s *Storage) UpdateJobProvenance(id string, provenance []byte) error {
+	_, err := s.exec(`UPDATE build_jobs SET provenance = ?, updated_at = ? WHERE id = ?`, provenance, time.Now(), id)
+	return err
+}
+
+// GetJobProvenance returns the stored provenance statement, which is empty when
+// the job did not opt in or has not finished. It returns sql.ErrNoRows for an
+// unknown job.
+func (s *Storage) GetJobProvenance(id string) ([]byte, error) {
+	var provenance []byte
+	err := s.queryRow(`SELECT provenance FROM build_jobs WHERE id = ?`, id).Scan(&provenance)
+	return provenance, err
+}
+
+func (s *Storage) UpdateJobContextHash(id, hash string) error {
+	_, err := s.exec(`UPDATE build_jobs SET context_hash = ?, updated_at = ? WHERE id = ?`, hash, time.Now(), id)
+	return err
+}
+
+// FindImageByContextHash returns the image tag of the most recent successful
+// job, other than excludeID, whose build context hashed to hash. It returns
+// sql.ErrNoRows when there is none.
+func (s *Storage) FindImageByContextHash(hash, excludeID string) (string, error) {
+	var imageTag string
+	err := s.queryRow(`
+		SELECT image_tag FROM build_jobs
+		WHERE context_hash = ? AND id != ? AND status = 'success' AND COALESCE(image_tag, '') != ''
+		ORDER BY updated_at DESC LIMIT 1
+	`, hash, excludeID).Scan(&imageTag)
+	return imageTag, err
+}
+
+// DebugSession describes a failed job's workspace kept for inspection until
+// ExpiresAt. Command is the redacted build command that failed.
+type DebugSession struct {
+	JobID     string    `json:"-"`
+	Workspace string    `json:"workspace"`
+	Command   string    `json:"command,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (s *Storage) SetJobDebugSession(id string, session DebugSession) error {
+	_, err := s.exec(`UPDATE build_jobs SET debug_workspace = ?, debug_command = ?, debug_expires_at = ?, updated_at = ? WHERE id = ?`, session.Workspace, session.Command, session.ExpiresAt.UTC(), time.Now(), id)
+	return err
+}
+
+// GetJobDebugSession returns nil when the job has no retained workspace.
+func (s *Storage) GetJobDebugSession(id string) (*DebugSession, error) {
+	session := &DebugSession{JobID: id}
+	var expiresAt sql.NullTime
+	err := s.queryRow(`SELECT COALESCE(debug_workspace, ''), COALESCE(debug_command, ''), debug_expires_at FROM build_jobs WHERE id = ?`, id).Scan(&session.Workspace, &session.Command, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if session.Workspace == "" {
+		return nil, nil
+	}
+	session.ExpiresAt = expiresAt.Time
+	return session, nil
+}
+
+// ExpiredDebugSessions lists retained workspaces whose TTL ended before now.
+func (s *Storage) ExpiredDebugSessions(now time.Time) ([]DebugSession, error) {
+	rows, err := s.query(`
+		SELECT id, debug_workspace, COALESCE(debug_command, ''), debug_expires_at FROM build_jobs
+		WHERE COALESCE(debug_workspace, '') != '' AND debug_expires_at <= ?
+	`, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []DebugSession
+	for rows.Next() {
+		var session DebugSession
+		if err := rows.Scan(&session.JobID, &session.Workspace, &session.Command, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (s *Storage) ClearJobDebugSession(id string) error {
+	_, err := s.exec(`UPDATE build_jobs SET debug_workspace = '', debug_command = '', debug_expires_at = NULL, updated_at = ? WHERE id = ?`, time.Now(), id)
 	return err
 }
 
 func (s *Storage) UpdateJobBuildConfig(id string, buildConfig *BuildConfig) error {
 	buildConfig.NormalizePhaseAliases()
-	_, err := s.db.Exec(`UPDATE build_jobs SET build_config = ?, updated_at = ? WHERE id = ?`, buildConfig, time.Now(), id)
+	_, err := s.exec(`UPDATE build_jobs SET build_config = ?, updated_at = ? WHERE id = ?`, buildConfig, time.Now(), id)
 	return err
 }
 
-func (s *Storage) IncrementJobRetryCount(id string) error {
-	_, err := s.db.Exec(`UPDATE build_jobs SET retry_count = retry_count + 1, updated_at = ? WHERE id = ?`, time.Now(), id)
+// ScheduleJobRetry puts a failed job back on the queue with its retry count
+// incremented. The job is not handed out again before notBefore.
+func (s *Storage) ScheduleJobRetry(id string, notBefore time.Time) (bool, error) {
+	if _, err := jobstate.Transition(jobstate.Failed, jobstate.Retry); err != nil {
+		return false, err
+	}
+	result, err := s.exec(`
+		UPDATE build_jobs
+		SET status = ?, retry_count = retry_count + 1, next_attempt_at = ?, started_at = NULL, finished_at = NULL, updated_at = ?
+		WHERE id = ? AND status = ?
+	`, string(jobstate.Pending), notBefore, time.Now(), id, string(jobstate.Failed))
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// ClearJobGitCredential drops the job's git credential once it will not be
+// cloned again.
+func (s *Storage) ClearJobGitCredential(id string) error {
+	_, err := s.exec(`UPDATE build_jobs SET git_credential = '', updated_at = ? WHERE id = ?`, time.Now(), id)
 	return err
 }
 
+// ClearExpiredGitCredentials drops the git credentials of failed jobs that
+// finished before finishedBefore, once they can no longer be resumed, and
+// returns how many it dropped.
+func (s *Storage) ClearExpiredGitCredentials(finishedBefore time.Time) (int64, error) {
+	result, err := s.exec(`
+		UPDATE build_jobs SET git_credential = '', updated_at = ?
+		WHERE status = 'failed' AND COALESCE(git_credential, '') != '' AND finished_at < ?
+	`, time.Now(), finishedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 func (s *Storage) ResetInProgressJobs() error {
-	_, err := s.db.Exec(`UPDATE build_jobs SET status = 'pending' WHERE status = 'claimed' OR status = 'building'`)
+	if _, err := s.exec(`UPDATE build_jobs SET status = 'pending' WHERE status = 'claimed' OR status = 'building'`); err != nil {
+		return err
+	}
+	// A job being cancelled when the builder stopped has no worker left to
+	// finish the cancellation, so it is canceled rather than requeued.
+	now := time.Now()
+	_, err := s.exec(`UPDATE build_jobs SET status = 'canceled', updated_at = ?, finished_at = ?, git_credential = '' WHERE status = 'cancelling'`, now, now)
 	return err
 }
 
 func (s *Storage) ResetDatabase() error {
-	_, err := s.db.Exec(`DELETE FROM build_jobs`)
+	_, err := s.exec(`DELETE FROM build_jobs`)
 	return err
 }
 
@@ -1,10 +1,18 @@
 package storage
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"hubfly-builder/internal/jobstate"
 )
 
 func TestBuildJobUnmarshalAcceptsFractionalCPU(t *testing.T) {
@@ -80,3 +88,815 @@ func TestBuildConfigCustomDockerfileAcceptsPlainJSONText(t *testing.T) {
 		t.Fatalf("expected custom Dockerfile bytes to contain request content, got %q", content)
 	}
 }
+
+func TestCreateJobPersistsCallbackURL(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	job := &BuildJob{
+		ID:          "build_callback",
+		ProjectID:   "proj",
+		UserID:      "user",
+		CallbackURL: "https://tenant.example.com/callback",
+	}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+
+	loaded, err := store.GetJob(job.ID)
+	if err != nil {
+		t.Fatalf("failed to load job: %v", err)
+	}
+	if loaded.CallbackURL != job.CallbackURL {
+		t.Fatalf("expected callback URL %q, got %q", job.CallbackURL, loaded.CallbackURL)
+	}
+}
+
+func TestQueuePositionOrdersPendingJobs(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	ids := []string{"build_a", "build_b", "build_c", "build_d"}
+	for _, id := range ids {
+		if err := store.CreateJob(&BuildJob{ID: id, ProjectID: "proj", UserID: "user_" + id}); err != nil {
+			t.Fatalf("failed to create job %s: %v", id, err)
+		}
+	}
+	if err := store.UpdateJobStatus("build_a", "building"); err != nil {
+		t.Fatalf("failed to update status: %v", err)
+	}
+
+	for id, want := range map[string]int{
+		"build_a":       0,
+		"build_b":       1,
+		"build_c":       2,
+		"build_d":       3,
+		"build_missing": 0,
+	} {
+		got, err := store.QueuePosition(id, nil, "", time.Time{})
+		if err != nil {
+			t.Fatalf("QueuePosition(%s) returned error: %v", id, err)
+		}
+		if got != want {
+			t.Fatalf("expected queue position %d for %s, got %d", want, id, got)
+		}
+	}
+
+	if err := store.UpdateJobStatus("build_b", "success"); err != nil {
+		t.Fatalf("failed to update status: %v", err)
+	}
+	if got, _ := store.QueuePosition("build_d", nil, "", time.Time{}); got != 2 {
+		t.Fatalf("expected build_d to move up to position 2, got %d", got)
+	}
+}
+
+func TestQueuePositionMatchesClaimEligibility(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	for _, job := range []*BuildJob{
+		{ID: "build_backoff", ProjectID: "proj_a", UserID: "user_1"},
+		{ID: "build_busy_user", ProjectID: "proj_b", UserID: "user_2"},
+		{ID: "build_elsewhere", ProjectID: "proj_c", UserID: "user_3"},
+		{ID: "build_local", ProjectID: "proj_d", UserID: "user_4"},
+		{ID: "build_other", ProjectID: "proj_e", UserID: "user_5"},
+	} {
+		if err := store.CreateJob(job); err != nil {
+			t.Fatalf("failed to create job %s: %v", job.ID, err)
+		}
+	}
+	if err := store.UpdateJobStatus("build_backoff", "failed"); err != nil {
+		t.Fatalf("failed to update status: %v", err)
+	}
+	if ok, err := store.ScheduleJobRetry("build_backoff", time.Now().Add(time.Hour)); err != nil || !ok {
+		t.Fatalf("failed to schedule retry: %v", err)
+	}
+	for project, instance := range map[string]string{"proj_c": "builder-b", "proj_d": "builder-a"} {
+		if err := store.RecordProjectBuildInstance(project, instance); err != nil {
+			t.Fatalf("failed to record affinity: %v", err)
+		}
+	}
+
+	overdueBefore := time.Now().Add(-time.Hour)
+	want := map[string]int{
+		"build_backoff":   0,
+		"build_busy_user": 0,
+		"build_elsewhere": 0,
+		"build_local":     1,
+		"build_other":     2,
+	}
+	for id, position := range want {
+		got, err := store.QueuePosition(id, []string{"user_2"}, "builder-a", overdueBefore)
+		if err != nil || got != position {
+			t.Fatalf("expected queue position %d for %s, got %d (err %v)", position, id, got, err)
+		}
+	}
+	claimed, err := store.ClaimNextPendingJob([]string{"user_2"}, "builder-a", overdueBefore, ClaimLease{})
+	if err != nil || claimed.ID != "build_local" {
+		t.Fatalf("expected the job at position 1 to be claimed, got %+v (err %v)", claimed, err)
+	}
+}
+
+func TestJobProvenanceRoundTrip(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := store.CreateJob(&BuildJob{ID: "build_prov", ProjectID: "proj", UserID: "user"}); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+
+	if got, err := store.GetJobProvenance("build_prov"); err != nil || len(got) != 0 {
+		t.Fatalf("expected no provenance before the build finishes, got %q (err=%v)", got, err)
+	}
+	want := []byte(`{"_type":"https://in-toto.io/Statement/v1"}`)
+	if err := store.UpdateJobProvenance("build_prov", want); err != nil {
+		t.Fatalf("failed to store provenance: %v", err)
+	}
+	got, err := store.GetJobProvenance("build_prov")
+	if err != nil {
+		t.Fatalf("failed to load provenance: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("expected provenance %s, got %s", want, got)
+	}
+
+	if _, err := store.GetJobProvenance("build_missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows for unknown job, got %v", err)
+	}
+}
+
+func TestScheduleJobRetryHoldsJobUntilNextAttempt(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	job := &BuildJob{ID: "build_retry", ProjectID: "proj", UserID: "user"}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	if ok, err := store.TransitionJob(job.ID, jobstate.Pending, jobstate.Fail); err != nil || !ok {
+		t.Fatalf("failed to fail job: %v", err)
+	}
+
+	if ok, err := store.ScheduleJobRetry(job.ID, time.Now().Add(time.Hour)); err != nil || !ok {
+		t.Fatalf("failed to schedule retry: %v", err)
+	}
+	if claimed, err := store.ClaimNextPendingJob(nil, "", time.Time{}, ClaimLease{}); !errors.Is(err, ErrNoPendingJob) {
+		t.Fatalf("expected no job before the next attempt, got %+v (err %v)", claimed, err)
+	}
+
+	if ok, err := store.TransitionJob(job.ID, jobstate.Pending, jobstate.Fail); err != nil || !ok {
+		t.Fatalf("failed to fail job again: %v", err)
+	}
+	if ok, err := store.ScheduleJobRetry(job.ID, time.Now().Add(-time.Second)); err != nil || !ok {
+		t.Fatalf("failed to schedule second retry: %v", err)
+	}
+	claimed, err := store.ClaimNextPendingJob(nil, "", time.Time{}, ClaimLease{})
+	if err != nil || claimed.ID != job.ID || claimed.RetryCount != 2 || claimed.Status != string(jobstate.Claimed) {
+		t.Fatalf("expected the job to be handed out after its backoff, got %+v (err %v)", claimed, err)
+	}
+}
+
+func TestClaimNextPendingJobPrefersLocalProject(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	for _, job := range []*BuildJob{
+		{ID: "build_unpinned", ProjectID: "proj_new", UserID: "user_1"},
+		{ID: "build_remote", ProjectID: "proj_remote", UserID: "user_2"},
+		{ID: "build_local", ProjectID: "proj_local", UserID: "user_3"},
+	} {
+		if err := store.CreateJob(job); err != nil {
+			t.Fatalf("failed to create job %s: %v", job.ID, err)
+		}
+	}
+	if err := store.RecordProjectBuildInstance("proj_local", "builder-a"); err != nil {
+		t.Fatalf("failed to record affinity: %v", err)
+	}
+	if err := store.RecordProjectBuildInstance("proj_remote", "builder-b"); err != nil {
+		t.Fatalf("failed to record affinity: %v", err)
+	}
+
+	notOverdue := time.Now().Add(-time.Hour)
+	job, err := store.ClaimNextPendingJob(nil, "builder-a", notOverdue, ClaimLease{})
+	if err != nil {
+		t.Fatalf("ClaimNextPendingJob returned error: %v", err)
+	}
+	if job.ID != "build_local" {
+		t.Fatalf("expected locally built project to be preferred, got %s", job.ID)
+	}
+
+	job, err = store.ClaimNextPendingJob([]string{"user_3"}, "builder-a", notOverdue, ClaimLease{})
+	if err != nil {
+		t.Fatalf("ClaimNextPendingJob returned error: %v", err)
+	}
+	if job.ID != "build_unpinned" {
+		t.Fatalf("expected unpinned project when the local one is excluded, got %s", job.ID)
+	}
+}
+
+func TestClaimNextPendingJobFallsBackWhenPreferredInstanceIsBusy(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := store.CreateJob(&BuildJob{ID: "build_remote", ProjectID: "proj_remote", UserID: "user_1"}); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	if err := store.CreateJob(&BuildJob{ID: "build_local", ProjectID: "proj_local", UserID: "user_2"}); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	if err := store.RecordProjectBuildInstance("proj_remote", "builder-b"); err != nil {
+		t.Fatalf("failed to record affinity: %v", err)
+	}
+	if err := store.RecordProjectBuildInstance("proj_local", "builder-a"); err != nil {
+		t.Fatalf("failed to record affinity: %v", err)
+	}
+
+	if _, err := store.ClaimNextPendingJob([]string{"user_2"}, "builder-a", time.Now().Add(-time.Hour), ClaimLease{}); !errors.Is(err, ErrNoPendingJob) {
+		t.Fatalf("expected a job pinned elsewhere to wait for its instance, got %v", err)
+	}
+
+	job, err := store.ClaimNextPendingJob(nil, "builder-a", time.Now().Add(time.Hour), ClaimLease{})
+	if err != nil {
+		t.Fatalf("ClaimNextPendingJob returned error: %v", err)
+	}
+	if job.ID != "build_remote" {
+		t.Fatalf("expected the overdue job pinned elsewhere to be claimed first, got %s", job.ID)
+	}
+}
+
+func TestNewStorageMigratesLegacySchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.sqlite")
+	legacy, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open legacy db: %v", err)
+	}
+	if err := createTables(legacy, sqliteDialect{}); err != nil {
+		t.Fatalf("failed to create legacy schema: %v", err)
+	}
+	if _, err := legacy.Exec(`INSERT INTO build_jobs (id, project_id, user_id, source_type, source_info, build_config, status, image_tag, retry_count, log_path, last_checkpoint, created_at, updated_at) VALUES ('old', 'p', 'u', 'git', '{}', '{}', 'success', '', 0, '', '', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`); err != nil {
+		t.Fatalf("failed to seed legacy job: %v", err)
+	}
+	legacy.Close()
+
+	store, err := NewStorage(dbPath)
+	if err != nil {
+		t.Fatalf("failed to open migrated storage: %v", err)
+	}
+	job, err := store.GetJob("old")
+	if err != nil {
+		t.Fatalf("failed to read legacy job after migration: %v", err)
+	}
+	if job.CallbackURL != "" {
+		t.Fatalf("expected empty callback URL for legacy job, got %q", job.CallbackURL)
+	}
+}
+
+func TestCreateSkippedJobIsNeverClaimable(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	job := &BuildJob{
+		ID:         "build_skipped",
+		ProjectID:  "proj",
+		UserID:     "user",
+		SourceInfo: SourceInfo{Credential: &GitCredential{Token: "secret-token"}},
+	}
+	if err := store.CreateSkippedJob(job); err != nil {
+		t.Fatalf("failed to create skipped job: %v", err)
+	}
+
+	if claimed, err := store.ClaimNextPendingJob(nil, "", time.Time{}, ClaimLease{}); !errors.Is(err, ErrNoPendingJob) {
+		t.Fatalf("expected no claimable job, got %+v (err %v)", claimed, err)
+	}
+	stored, err := store.GetJob("build_skipped")
+	if err != nil || stored.Status != string(jobstate.Skipped) || !stored.FinishedAt.Valid {
+		t.Fatalf("expected a finished skipped job, got %+v (err %v)", stored, err)
+	}
+	if credential, err := store.GetJobGitCredential("build_skipped"); err != nil || credential != nil {
+		t.Fatalf("expected no stored credential for a skipped job, got %+v (err %v)", credential, err)
+	}
+}
+
+func TestResetInProgressJobsClearsCredentialOfCanceledJobs(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	job := &BuildJob{ID: "build_stopping", ProjectID: "proj", UserID: "user", SourceInfo: SourceInfo{Credential: &GitCredential{Token: "secret-token"}}}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	if err := store.UpdateJobStatus(job.ID, string(jobstate.Cancelling)); err != nil {
+		t.Fatalf("failed to update status: %v", err)
+	}
+
+	if err := store.ResetInProgressJobs(); err != nil {
+		t.Fatalf("failed to reset in-progress jobs: %v", err)
+	}
+	if stored, err := store.GetJob(job.ID); err != nil || stored.Status != string(jobstate.Canceled) {
+		t.Fatalf("expected the job to be canceled, got %+v (err %v)", stored, err)
+	}
+	if credential, err := store.GetJobGitCredential(job.ID); err != nil || credential != nil {
+		t.Fatalf("expected the credential to be cleared, got %+v (err %v)", credential, err)
+	}
+}
+
+func TestRequeueExpiredClaimsRecoversJobsOfAStoppedInstance(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	now := time.Now()
+	for _, id := range []string{"build_lapsed", "build_renewed", "build_stopping"} {
+		if err := store.CreateJob(&BuildJob{ID: id, ProjectID: "proj", UserID: id, SourceInfo: SourceInfo{Credential: &GitCredential{Token: "secret-token"}}}); err != nil {
+			t.Fatalf("failed to create job: %v", err)
+		}
+		if _, err := store.ClaimNextPendingJob(nil, "", time.Time{}, ClaimLease{Owner: "builder-a", ExpiresAt: now.Add(time.Minute)}); err != nil {
+			t.Fatalf("failed to claim job: %v", err)
+		}
+	}
+	if err := store.UpdateJobStatus("build_stopping", string(jobstate.Cancelling)); err != nil {
+		t.Fatalf("failed to update status: %v", err)
+	}
+	if renewed, err := store.RenewClaimLease("build_renewed", "builder-a", now.Add(3*time.Minute)); err != nil || !renewed {
+		t.Fatalf("expected the lease to be renewed, got %v (err %v)", renewed, err)
+	}
+	if renewed, err := store.RenewClaimLease("build_lapsed", "builder-b", now.Add(3*time.Minute)); err != nil || renewed {
+		t.Fatalf("expected another instance not to renew the lease, got %v (err %v)", renewed, err)
+	}
+
+	moved, err := store.RequeueExpiredClaims(now.Add(2 * time.Minute))
+	if err != nil || moved != 2 {
+		t.Fatalf("expected two expired claims to be moved, got %d (err %v)", moved, err)
+	}
+	for id, want := range map[string]jobstate.Status{
+		"build_lapsed":   jobstate.Pending,
+		"build_renewed":  jobstate.Claimed,
+		"build_stopping": jobstate.Canceled,
+	} {
+		if stored, err := store.GetJob(id); err != nil || stored.Status != string(want) {
+			t.Errorf("expected %s to be %s, got %+v (err %v)", id, want, stored, err)
+		}
+	}
+	if credential, err := store.GetJobGitCredential("build_stopping"); err != nil || credential != nil {
+		t.Fatalf("expected the canceled job's credential to be cleared, got %+v (err %v)", credential, err)
+	}
+	if renewed, err := store.RenewClaimLease("build_lapsed", "builder-a", now.Add(3*time.Minute)); err != nil || renewed {
+		t.Fatalf("expected a requeued job's lease not to be renewed, got %v (err %v)", renewed, err)
+	}
+}
+
+func TestClaimNextPendingJobSkipsUsersBuildingOnAnyInstance(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	for _, id := range []string{"build_first", "build_second"} {
+		if err := store.CreateJob(&BuildJob{ID: id, ProjectID: "proj", UserID: "user"}); err != nil {
+			t.Fatalf("failed to create job: %v", err)
+		}
+	}
+	now := time.Now()
+	if _, err := store.ClaimNextPendingJob(nil, "", time.Time{}, ClaimLease{Owner: "builder-b", ExpiresAt: now.Add(time.Second)}); err != nil {
+		t.Fatalf("failed to claim job: %v", err)
+	}
+
+	// builder-a knows nothing of builder-b's build but still leaves the
+	// user's next job alone while builder-b holds its claim.
+	if claimed, err := store.ClaimNextPendingJob(nil, "", time.Time{}, ClaimLease{Owner: "builder-a"}); !errors.Is(err, ErrNoPendingJob) {
+		t.Fatalf("expected the user's next job to wait for the running build, got %+v (err %v)", claimed, err)
+	}
+	if _, err := store.exec(`UPDATE build_jobs SET lease_expires_at = ? WHERE id = ?`, now.Add(-time.Second), "build_first"); err != nil {
+		t.Fatalf("failed to expire lease: %v", err)
+	}
+	claimed, err := store.ClaimNextPendingJob(nil, "", time.Time{}, ClaimLease{Owner: "builder-a"})
+	if err != nil || claimed.ID != "build_second" {
+		t.Fatalf("expected the user's next job once the running build's claim lapsed, got %+v (err %v)", claimed, err)
+	}
+}
+
+func TestTransitionClaimedJobRequiresTheClaim(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := store.CreateJob(&BuildJob{ID: "build_reclaimed", ProjectID: "proj", UserID: "user"}); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	now := time.Now()
+	if _, err := store.ClaimNextPendingJob(nil, "", time.Time{}, ClaimLease{Owner: "builder-a", ExpiresAt: now.Add(-time.Second)}); err != nil {
+		t.Fatalf("failed to claim job: %v", err)
+	}
+	if _, err := store.RequeueExpiredClaims(now); err != nil {
+		t.Fatalf("failed to requeue expired claims: %v", err)
+	}
+	if _, err := store.ClaimNextPendingJob(nil, "", time.Time{}, ClaimLease{Owner: "builder-b", ExpiresAt: now.Add(time.Minute)}); err != nil {
+		t.Fatalf("failed to reclaim job: %v", err)
+	}
+
+	if ok, err := store.TransitionClaimedJob("build_reclaimed", "builder-a", jobstate.Claimed, jobstate.Fail); err != nil || ok {
+		t.Fatalf("expected the stale claimant's transition to be refused, got %v (err %v)", ok, err)
+	}
+	if ok, err := store.TransitionClaimedJob("build_reclaimed", "builder-b", jobstate.Claimed, jobstate.Start); err != nil || !ok {
+		t.Fatalf("expected the current claimant's transition to apply, got %v (err %v)", ok, err)
+	}
+}
+
+func TestTransitionJobRejectsIllegalEvents(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := store.CreateJob(&BuildJob{ID: "build_fsm", ProjectID: "proj", UserID: "user"}); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+
+	if ok, err := store.TransitionJob("build_fsm", jobstate.Pending, jobstate.Succeed); !errors.Is(err, jobstate.ErrIllegalTransition) || ok {
+		t.Fatalf("expected pending -> succeed to be illegal, got ok=%t err=%v", ok, err)
+	}
+	job, err := store.GetJob("build_fsm")
+	if err != nil {
+		t.Fatalf("failed to load job: %v", err)
+	}
+	if job.Status != "pending" {
+		t.Fatalf("expected an illegal event to leave the job pending, got %q", job.Status)
+	}
+
+	if ok, err := store.TransitionJob("build_fsm", jobstate.Pending, jobstate.Claim); err != nil || !ok {
+		t.Fatalf("expected pending -> claimed to succeed, got ok=%t err=%v", ok, err)
+	}
+	job, err = store.GetJob("build_fsm")
+	if err != nil {
+		t.Fatalf("failed to load job: %v", err)
+	}
+	if job.Status != "claimed" {
+		t.Fatalf("expected claimed, got %q", job.Status)
+	}
+}
+
+func queryPragma(t *testing.T, store *Storage, name string) string {
+	t.Helper()
+	var value string
+	if err := store.db.QueryRow("PRAGMA " + name).Scan(&value); err != nil {
+		t.Fatalf("failed to read PRAGMA %s: %v", name, err)
+	}
+	return value
+}
+
+func TestNewStorageAppliesDefaultPragmas(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	for pragma, want := range map[string]string{
+		"journal_mode": "wal",
+		"synchronous":  "1",
+		"busy_timeout": "5000",
+		"foreign_keys": "1",
+	} {
+		if got := queryPragma(t, store, pragma); got != want {
+			t.Fatalf("expected PRAGMA %s = %s, got %s", pragma, want, got)
+		}
+	}
+}
+
+func TestNewStorageWithOptionsAppliesConfiguredPragmas(t *testing.T) {
+	store, err := NewStorageWithOptions(filepath.Join(t.TempDir(), "jobs.sqlite"), Options{
+		JournalMode: "delete",
+		Synchronous: "FULL",
+		BusyTimeout: 250 * time.Millisecond,
+		ForeignKeys: false,
+	})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	for pragma, want := range map[string]string{
+		"journal_mode": "delete",
+		"synchronous":  "2",
+		"busy_timeout": "250",
+		"foreign_keys": "0",
+	} {
+		if got := queryPragma(t, store, pragma); got != want {
+			t.Fatalf("expected PRAGMA %s = %s, got %s", pragma, want, got)
+		}
+	}
+}
+
+func TestNewStorageWithOptionsRejectsUnknownPragmaValues(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "jobs.sqlite")
+	if _, err := NewStorageWithOptions(dbPath, Options{JournalMode: "fast"}); err == nil {
+		t.Fatal("expected an unknown journal mode to be rejected")
+	}
+	if _, err := NewStorageWithOptions(dbPath, Options{Synchronous: "sometimes"}); err == nil {
+		t.Fatal("expected an unknown synchronous level to be rejected")
+	}
+}
+
+func TestConcurrentWritersWaitForTheDatabaseLock(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	const writers = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("build_concurrent_%d", i)
+			if err := store.CreateJob(&BuildJob{ID: id, ProjectID: "proj", UserID: "user"}); err != nil {
+				errs <- err
+				return
+			}
+			for _, status := range []string{"claimed", "building", "success"} {
+				if err := store.UpdateJobStatus(id, status); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("expected concurrent writes to succeed, got %v", err)
+	}
+}
+
+func TestGitCredentialIsStoredApartFromTheJob(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	job := &BuildJob{
+		ID:        "build_private",
+		ProjectID: "proj",
+		UserID:    "user",
+		SourceInfo: SourceInfo{
+			GitRepository: "https://github.com/acme/private.git",
+			Credential:    &GitCredential{Username: "ci", Token: "ghp_secret123"},
+		},
+	}
+	if err := store.CreateJob(job); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+
+	loaded, err := store.GetJob(job.ID)
+	if err != nil {
+		t.Fatalf("failed to load job: %v", err)
+	}
+	encoded, err := json.Marshal(loaded)
+	if err != nil {
+		t.Fatalf("failed to encode job: %v", err)
+	}
+	if strings.Contains(string(encoded), "ghp_secret123") || !strings.Contains(string(encoded), `"credential":{"type":"token","username":"ci"}`) {
+		t.Fatalf("expected only the credential kind in the job, got %s", encoded)
+	}
+	credential, err := store.GetJobGitCredential(job.ID)
+	if err != nil || credential == nil || credential.Token != "ghp_secret123" || credential.Username != "ci" {
+		t.Fatalf("expected the stored credential, got %+v (err %v)", credential, err)
+	}
+
+	for _, event := range []jobstate.Event{jobstate.Claim, jobstate.Start, jobstate.Succeed} {
+		current := jobstate.Status(loaded.Status)
+		if ok, err := store.TransitionJob(job.ID, current, event); err != nil || !ok {
+			t.Fatalf("failed to apply %s: %v", event, err)
+		}
+		loaded.Status = mustTransition(t, current, event)
+	}
+	if credential, err := store.GetJobGitCredential(job.ID); err != nil || credential != nil {
+		t.Fatalf("expected the credential to be cleared once the job finished, got %+v (err %v)", credential, err)
+	}
+}
+
+func mustTransition(t *testing.T, current jobstate.Status, event jobstate.Event) string {
+	t.Helper()
+	next, err := jobstate.Transition(current, event)
+	if err != nil {
+		t.Fatalf("illegal transition: %v", err)
+	}
+	return string(next)
+}
+
+func TestNextBuildNumberIsSequentialUnderConcurrency(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	const callers = 20
+	numbers := make(chan int64, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			number, err := store.NextBuildNumber("proj")
+			if err != nil {
+				t.Errorf("NextBuildNumber returned error: %v", err)
+				return
+			}
+			numbers <- number
+		}()
+	}
+	wg.Wait()
+	close(numbers)
+
+	seen := make(map[int64]bool)
+	for number := range numbers {
+		if seen[number] {
+			t.Fatalf("build number %d was handed out twice", number)
+		}
+		seen[number] = true
+	}
+	for number := int64(1); number <= callers; number++ {
+		if !seen[number] {
+			t.Fatalf("expected build numbers 1..%d, missing %d (got %v)", callers, number, seen)
+		}
+	}
+	if number, err := store.NextBuildNumber("other"); err != nil || number != 1 {
+		t.Fatalf("expected another project to start at 1, got %d (err %v)", number, err)
+	}
+}
+
+func TestAssignJobBuildNumberKeepsTheFirstNumber(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	for _, id := range []string{"build_1", "build_2"} {
+		if err := store.CreateJob(&BuildJob{ID: id, ProjectID: "proj", UserID: "user"}); err != nil {
+			t.Fatalf("failed to create job %s: %v", id, err)
+		}
+	}
+
+	for _, want := range []struct {
+		id     string
+		number int64
+	}{{"build_1", 1}, {"build_2", 2}, {"build_1", 1}} {
+		if number, err := store.AssignJobBuildNumber(want.id); err != nil || number != want.number {
+			t.Fatalf("expected %s to get build number %d, got %d (err %v)", want.id, want.number, number, err)
+		}
+	}
+}
+
+func TestExtraHostsValidate(t *testing.T) {
+	if err := (ExtraHosts{"mirror.internal:10.0.0.5", "registry:fd00::10"}).Validate(); err != nil {
+		t.Fatalf("expected valid extra hosts, got %v", err)
+	}
+	for _, entry := range []string{
+		"mirror.internal",
+		"mirror.internal:",
+		":10.0.0.5",
+		"mirror.internal:not-an-ip",
+		"bad_host:10.0.0.5",
+		"-mirror:10.0.0.5",
+		"mirror internal:10.0.0.5",
+		"mirror:10.0.0.5\n127.0.0.1 evil",
+	} {
+		if err := (ExtraHosts{entry}).Validate(); err == nil {
+			t.Errorf("expected %q to be rejected", entry)
+		}
+	}
+}
+
+func TestDNSServersValidate(t *testing.T) {
+	if err := (DNSServers{"10.0.0.2", "fd00::53"}).Validate(); err != nil {
+		t.Fatalf("expected valid DNS servers, got %v", err)
+	}
+	for _, servers := range []DNSServers{
+		{"dns.internal"},
+		{"10.0.0.256"},
+		{"10.0.0.2:53"},
+		{""},
+		{"1.1.1.1", "8.8.8.8", "9.9.9.9", "10.0.0.2"},
+	} {
+		if err := servers.Validate(); err == nil {
+			t.Errorf("expected %q to be rejected", servers)
+		}
+	}
+}
+
+func TestClaimNextPendingJobClaimsEachJobOnce(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "jobs.sqlite")
+	// Two handles on one file stand in for two builder instances.
+	var stores []*Storage
+	for i := 0; i < 2; i++ {
+		store, err := NewStorage(dbPath)
+		if err != nil {
+			t.Fatalf("failed to create storage: %v", err)
+		}
+		stores = append(stores, store)
+	}
+	const jobs = 20
+	for i := 0; i < jobs; i++ {
+		if err := stores[0].CreateJob(&BuildJob{ID: fmt.Sprintf("build_%02d", i), ProjectID: "proj", UserID: fmt.Sprintf("user_%d", i)}); err != nil {
+			t.Fatalf("failed to create job: %v", err)
+		}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		claimed = map[string]int{}
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(store *Storage) {
+			defer wg.Done()
+			for {
+				job, err := store.ClaimNextPendingJob(nil, "", time.Time{}, ClaimLease{})
+				if errors.Is(err, ErrNoPendingJob) {
+					return
+				}
+				if err != nil {
+					t.Errorf("claim failed: %v", err)
+					return
+				}
+				mu.Lock()
+				claimed[job.ID]++
+				mu.Unlock()
+			}
+		}(stores[i%2])
+	}
+	wg.Wait()
+
+	if len(claimed) != jobs {
+		t.Fatalf("expected all %d jobs to be claimed, got %d", jobs, len(claimed))
+	}
+	for id, count := range claimed {
+		if count != 1 {
+			t.Fatalf("expected %s to be claimed once, got %d", id, count)
+		}
+		if job, err := stores[0].GetJob(id); err != nil || job.Status != string(jobstate.Claimed) {
+			t.Fatalf("expected %s to be stored as claimed, got %+v (err %v)", id, job, err)
+		}
+	}
+}
+
+func TestPlatformsValidate(t *testing.T) {
+	if err := (Platforms{"linux/amd64", "linux/arm64", "linux/arm/v7"}).Validate(); err != nil {
+		t.Fatalf("expected valid platforms, got %v", err)
+	}
+	for _, platforms := range []Platforms{
+		{"amd64"},
+		{"windows/amd64"},
+		{"linux/sparc"},
+		{"linux/arm64/v9"},
+		{"linux/amd64,linux/arm64"},
+		{"linux/amd64", "linux/amd64"},
+	} {
+		if err := platforms.Validate(); err == nil {
+			t.Errorf("expected %q to be rejected", platforms)
+		}
+	}
+}
+
+func TestRegistryTargetsValidate(t *testing.T) {
+	valid := RegistryTargets{{Repository: "ghcr.io/acme/api"}, {Repository: "registry.internal:5000/api", Optional: true}, {Repository: "localhost/api"}}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("expected valid registries, got %v", err)
+	}
+	for _, targets := range []RegistryTargets{
+		{{Repository: "acme/api"}},
+		{{Repository: "ghcr.io"}},
+		{{Repository: "ghcr.io/acme/api:latest"}},
+		{{Repository: "ghcr.io/acme/api@sha256:abc"}},
+		{{Repository: "ghcr.io/Acme/api"}},
+		{{Repository: "ghcr.io/acme/api"}, {Repository: "ghcr.io/acme/api", Optional: true}},
+	} {
+		if err := targets.Validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", targets)
+		}
+	}
+}
+
+func TestValidateDockerfilePath(t *testing.T) {
+	for _, path := range []string{"", "Dockerfile.prod", "deploy/Dockerfile", "./docker/app.Dockerfile"} {
+		if err := (BuildConfig{DockerfilePath: path}).ValidateDockerfilePath(); err != nil {
+			t.Errorf("expected %q to be accepted, got %v", path, err)
+		}
+	}
+	for _, path := range []string{"/etc/Dockerfile", "..", "../Dockerfile", "deploy/../../Dockerfile", ".", "Dockerfile\nRUN id"} {
+		if err := (BuildConfig{DockerfilePath: path}).ValidateDockerfilePath(); err == nil {
+			t.Errorf("expected %q to be rejected", path)
+		}
+	}
+	if got := (BuildConfig{}).DockerfileName(); got != "Dockerfile" {
+		t.Fatalf("expected the default Dockerfile name, got %q", got)
+	}
+}
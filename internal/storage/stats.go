@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+// maxStatsDurationSamples bounds how many of the most recent finished builds
+// feed the duration percentiles.
+const maxStatsDurationSamples = 1000
+
+// buildDurationSeconds is the SQL expression for a finished job's build time.
+func (s *Storage) buildDurationSeconds() string {
+	return s.dialect.durationSeconds("started_at", "finished_at")
+}
+
+// ProjectStats summarises the builds of one project created since Since.
+// Durations cover successful and failed builds that recorded both start and
+// finish times.
+type ProjectStats struct {
+	ProjectID          string            `json:"projectId"`
+	Since              time.Time         `json:"since"`
+	Total              int               `json:"total"`
+	StatusCounts       map[string]int    `json:"statusCounts"`
+	SuccessRate        float64           `json:"successRate"`
+	DurationSamples    int               `json:"durationSamples"`
+	AvgDurationSeconds float64           `json:"avgDurationSeconds"`
+	P50DurationSeconds float64           `json:"p50DurationSeconds"`
+	P95DurationSeconds float64           `json:"p95DurationSeconds"`
+	LastBuild          *ProjectLastBuild `json:"lastBuild,omitempty"`
+}
+
+// ProjectLastBuild is the most recently created job in the stats window.
+type ProjectLastBuild struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	ImageTag   string     `json:"imageTag,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// ProjectStats aggregates the project's jobs created at or after since. When
+// userID is set only that user's jobs are counted.
+func (s *Storage) ProjectStats(projectID, userID string, since time.Time) (*ProjectStats, error) {
+	// created_at is stored as local-time text, so the bound must be too for
+	// the indexed comparison to hold.
+	since = since.Local()
+	where := `project_id = ? AND created_at >= ?`
+	args := []interface{}{projectID, since}
+	if userID != "" {
+		where += ` AND user_id = ?`
+		args = append(args, userID)
+	}
+
+	stats := &ProjectStats{ProjectID: projectID, Since: since, StatusCounts: map[string]int{}}
+	rows, err := s.query(`SELECT status, COUNT(*) FROM build_jobs WHERE `+where+` GROUP BY status`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var (
+			status string
+			count  int
+		)
+		if err := rows.Scan(&status, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stats.StatusCounts[status] = count
+		stats.Total += count
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if finished := stats.StatusCounts["success"] + stats.StatusCounts["failed"]; finished > 0 {
+		stats.SuccessRate = float64(stats.StatusCounts["success"]) / float64(finished)
+	}
+
+	timed := where + ` AND status IN ('success', 'failed') AND started_at IS NOT NULL AND finished_at IS NOT NULL`
+	var average sql.NullFloat64
+	if err := s.queryRow(`SELECT COUNT(*), AVG(`+s.buildDurationSeconds()+`) FROM build_jobs WHERE `+timed, args...).Scan(&stats.DurationSamples, &average); err != nil {
+		return nil, err
+	}
+	stats.AvgDurationSeconds = roundSeconds(average.Float64)
+
+	durations, err := s.recentBuildDurations(timed, args)
+	if err != nil {
+		return nil, err
+	}
+	stats.P50DurationSeconds = percentile(durations, 0.50)
+	stats.P95DurationSeconds = percentile(durations, 0.95)
+
+	last := &ProjectLastBuild{}
+	var finishedAt sql.NullTime
+	err = s.queryRow(`SELECT id, status, image_tag, created_at, finished_at FROM build_jobs WHERE `+where+` ORDER BY created_at DESC LIMIT 1`, args...).
+		Scan(&last.ID, &last.Status, &last.ImageTag, &last.CreatedAt, &finishedAt)
+	switch {
+	case err == nil:
+		if finishedAt.Valid {
+			last.FinishedAt = &finishedAt.Time
+		}
+		stats.LastBuild = last
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (s *Storage) recentBuildDurations(where string, args []interface{}) ([]float64, error) {
+	rows, err := s.query(`SELECT `+s.buildDurationSeconds()+` FROM build_jobs WHERE `+where+` ORDER BY created_at DESC LIMIT ?`, append(args, maxStatsDurationSamples)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var durations []float64
+	for rows.Next() {
+		var duration sql.NullFloat64
+		if err := rows.Scan(&duration); err != nil {
+			return nil, err
+		}
+		if duration.Valid {
+			durations = append(durations, duration.Float64)
+		}
+	}
+	return durations, rows.Err()
+}
+
+// percentile uses the nearest-rank method on a sorted copy of values.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return roundSeconds(sorted[rank])
+}
+
+// roundSeconds trims the float noise julianday arithmetic leaves behind.
+func roundSeconds(seconds float64) float64 {
+	return math.Round(seconds*1000) / 1000
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"hubfly-builder/internal/jobstate"
+)
+
+func seedStatsJob(t *testing.T, store *Storage, id, projectID, status string, created time.Time, duration time.Duration) {
+	t.Helper()
+	if err := store.CreateJob(&BuildJob{ID: id, ProjectID: projectID, UserID: "user", ImageTag: "img:" + id}); err != nil {
+		t.Fatalf("failed to create job %s: %v", id, err)
+	}
+	var started, finished interface{}
+	if duration > 0 {
+		started = created.Add(time.Second)
+		finished = created.Add(time.Second + duration)
+	}
+	if _, err := store.db.Exec(`UPDATE build_jobs SET status = ?, image_tag = ?, created_at = ?, started_at = ?, finished_at = ? WHERE id = ?`,
+		status, "img:"+id, created, started, finished, id); err != nil {
+		t.Fatalf("failed to seed job %s: %v", id, err)
+	}
+}
+
+func TestProjectStatsAggregatesStatusesAndDurations(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	base := time.Now().Add(-time.Hour)
+
+	seedStatsJob(t, store, "build_old", "proj", "success", base.Add(-48*time.Hour), 500*time.Second)
+	seedStatsJob(t, store, "build_1", "proj", "success", base, 10*time.Second)
+	seedStatsJob(t, store, "build_2", "proj", "success", base.Add(time.Minute), 20*time.Second)
+	seedStatsJob(t, store, "build_3", "proj", "failed", base.Add(2*time.Minute), 30*time.Second)
+	seedStatsJob(t, store, "build_4", "proj", "success", base.Add(3*time.Minute), 100*time.Second)
+	seedStatsJob(t, store, "build_5", "proj", "pending", base.Add(4*time.Minute), 0)
+	seedStatsJob(t, store, "build_other", "other", "failed", base, 5*time.Second)
+
+	stats, err := store.ProjectStats("proj", "", base.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("ProjectStats returned error: %v", err)
+	}
+
+	if stats.Total != 5 {
+		t.Fatalf("expected 5 jobs in the window, got %d", stats.Total)
+	}
+	if stats.StatusCounts["success"] != 3 || stats.StatusCounts["failed"] != 1 || stats.StatusCounts["pending"] != 1 {
+		t.Fatalf("unexpected status counts: %v", stats.StatusCounts)
+	}
+	if stats.SuccessRate != 0.75 {
+		t.Fatalf("expected success rate 0.75, got %v", stats.SuccessRate)
+	}
+	if stats.DurationSamples != 4 {
+		t.Fatalf("expected 4 timed builds, got %d", stats.DurationSamples)
+	}
+	if stats.AvgDurationSeconds != 40 {
+		t.Fatalf("expected 40s average, got %v", stats.AvgDurationSeconds)
+	}
+	if stats.P50DurationSeconds != 20 || stats.P95DurationSeconds != 100 {
+		t.Fatalf("expected p50=20 p95=100, got p50=%v p95=%v", stats.P50DurationSeconds, stats.P95DurationSeconds)
+	}
+	if stats.LastBuild == nil || stats.LastBuild.ID != "build_5" || stats.LastBuild.Status != "pending" || stats.LastBuild.FinishedAt != nil {
+		t.Fatalf("expected the pending build_5 as last build, got %+v", stats.LastBuild)
+	}
+}
+
+func TestProjectStatsScopesToUserAndHandlesEmptyWindow(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	seedStatsJob(t, store, "build_1", "proj", "success", time.Now().Add(-time.Minute), 10*time.Second)
+
+	stats, err := store.ProjectStats("proj", "someone-else", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("ProjectStats returned error: %v", err)
+	}
+	if stats.Total != 0 || stats.LastBuild != nil || stats.SuccessRate != 0 || stats.AvgDurationSeconds != 0 {
+		t.Fatalf("expected empty stats for another user, got %+v", stats)
+	}
+}
+
+func TestTransitionJobRecordsBuildTimes(t *testing.T) {
+	store, err := NewStorage(filepath.Join(t.TempDir(), "jobs.sqlite"))
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	if err := store.CreateJob(&BuildJob{ID: "build_times", ProjectID: "proj", UserID: "user"}); err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	for _, step := range []struct {
+		from  jobstate.Status
+		event jobstate.Event
+	}{
+		{jobstate.Pending, jobstate.Claim},
+		{jobstate.Claimed, jobstate.Start},
+		{jobstate.Building, jobstate.Succeed},
+	} {
+		if ok, err := store.TransitionJob("build_times", step.from, step.event); err != nil || !ok {
+			t.Fatalf("transition %s from %s failed: ok=%t err=%v", step.event, step.from, ok, err)
+		}
+	}
+
+	job, err := store.GetJob("build_times")
+	if err != nil {
+		t.Fatalf("failed to load job: %v", err)
+	}
+	if !job.StartedAt.Valid || !job.FinishedAt.Valid || job.FinishedAt.Time.Before(job.StartedAt.Time) {
+		t.Fatalf("expected start and finish times, got started=%v finished=%v", job.StartedAt, job.FinishedAt)
+	}
+}